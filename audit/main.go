@@ -0,0 +1,80 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/varfrog/quicpubsub/pkg/audit"
+	"log"
+	"os"
+)
+
+// Represents configuration needed to run this app.
+type runConfig struct {
+	Help    bool   // Prints usage and exists if true
+	LogPath string // Path to the audit trail, as given to the server with -audit-log
+}
+
+func main() {
+	config, err := parseFlagsIntoConfig()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if config.Help {
+		flag.PrintDefaults()
+		os.Exit(0)
+	}
+
+	if err := validateRunConfig(config); err != nil {
+		log.Fatal(err)
+	}
+
+	if err := verify(config.LogPath); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// parseFlagsIntoConfig gets a config needed to run this app.
+func parseFlagsIntoConfig() (runConfig, error) {
+	var (
+		help    bool
+		logPath string
+	)
+
+	flag.BoolVar(&help, "help", false, "Print usage information")
+	flag.StringVar(&logPath, "log", "audit.log", "Path to the audit trail that the server writes with -audit-log. "+
+		"Its rotated files next to it are verified too, oldest first")
+	flag.Parse()
+
+	return runConfig{
+		Help:    help,
+		LogPath: logPath,
+	}, nil
+}
+
+func validateRunConfig(config runConfig) error {
+	if config.LogPath == "" {
+		return errors.New("specify the audit trail with flag -log")
+	}
+	return nil
+}
+
+// verify verifies the hash chain of the audit trail at logPath and prints what it has verified, including the hash
+// of the last record, which is worth keeping elsewhere as records removed from the end of the trail go unnoticed.
+func verify(logPath string) error {
+	summary, err := audit.Verify(logPath)
+	if err != nil {
+		return errors.Wrap(err, "audit.Verify")
+	}
+	for _, file := range summary.Files {
+		fmt.Printf("Verified %s\n", file)
+	}
+	if summary.FirstSeq > 1 {
+		fmt.Printf("The trail starts at record %d, the files of the records before it have been removed\n",
+			summary.FirstSeq)
+	}
+	fmt.Printf("The hash chain of %d records is intact, last record %d has hash %s\n", summary.Records,
+		summary.LastSeq, summary.LastHash)
+	return nil
+}
@@ -0,0 +1,381 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"flag"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/varfrog/quicpubsub/pkg/ca"
+	"github.com/varfrog/quicpubsub/pkg/encryption"
+	"github.com/varfrog/quicpubsub/pkg/quichelper"
+	"github.com/varfrog/quicpubsub/pkg/sdk"
+	"github.com/varfrog/quicpubsub/pkg/signing"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// day is the unit of the validity flags.
+const day = time.Hour * 24
+
+// Represents configuration needed to run this app.
+type runConfig struct {
+	Help      bool     // Prints usage and exists if true
+	Dir       string   // Certificate directory, see package ca
+	CAName    string   // Common name of the CA, if it is created
+	CADays    int      // Days the CA is valid for, if it is created
+	Hosts     []string // Host names and IP addresses the server certificate is valid for
+	Client    string   // Name of a client to issue a certificate to, instead of the server
+	Tenant    string   // Tenant of the client, optional
+	URIs      []string // URI identities of the client
+	Emails    []string // Email identities of the client
+	DNSNames  []string // DNS identities of the client
+	Days      int      // Days the issued certificate is valid for
+	Force     bool     // Whether to replace an existing certificate
+	Renew     bool     // Whether to renew expiring certificates instead of issuing one
+	RenewDays int      // Certificates expiring within this many days are renewed
+	PrintPin  bool     // Whether to print the pin of a certificate instead of issuing one
+	Signing   string   // Name of a publisher to generate a message signing key for, instead of issuing a certificate
+	TrustPath string   // Path to the trust store that the public keys of signing keys are added to
+	EncKeyID  string   // ID of a payload encryption key to generate, instead of issuing a certificate
+	EncPath   string   // Path to the file of payload encryption keys that the key is added to
+}
+
+func main() {
+	config, err := parseFlagsIntoConfig()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if config.Help {
+		flag.PrintDefaults()
+		os.Exit(0)
+	}
+
+	if err := validateRunConfig(config); err != nil {
+		log.Fatal(err)
+	}
+
+	switch {
+	case config.Renew:
+		err = renew(config)
+	case config.PrintPin:
+		err = printPin(config)
+	case config.Signing != "":
+		err = addSigningKey(config)
+	case config.EncKeyID != "":
+		err = addEncryptionKey(config)
+	default:
+		err = issue(config)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// parseFlagsIntoConfig gets a config needed to run this app.
+func parseFlagsIntoConfig() (runConfig, error) {
+	var (
+		help        bool
+		dir         string
+		caName      string
+		caDays      int
+		hosts       string
+		client      string
+		tenant      string
+		uris        string
+		emails      string
+		dnsNames    string
+		days        int
+		force       bool
+		renew       bool
+		renewDays   int
+		printPin    bool
+		signingName string
+		trustPath   string
+		encKeyID    string
+		encPath     string
+	)
+
+	flag.BoolVar(&help, "help", false, "Print usage information")
+	flag.StringVar(&dir, "dir", "certs", "Directory of the certificates: the CA in ca.pem and ca.key, the server "+
+		"certificate in cert.pem and private.key, and client certificates in NAME.pem and NAME.key. The CA is "+
+		"created if the directory has none")
+	flag.StringVar(&caName, "ca-name", "QUIC Pub Sub CA", "Common name of the CA, if it is created")
+	flag.IntVar(&caDays, "ca-days", 3650, "Days the CA is valid for, if it is created")
+	flag.StringVar(&hosts, "hosts", "localhost,127.0.0.1,::1",
+		"Comma-separated host names and IP addresses the server certificate is valid for, which clients dial")
+	flag.StringVar(&client, "client", "", "Issue a certificate for mTLS to the client with this name, its identity "+
+		"unless -uri, -email or -dns are given, instead of the server certificate")
+	flag.StringVar(&tenant, "tenant", "", "Tenant of the client, as the organization of its certificate")
+	flag.StringVar(&uris, "uri", "", "Comma-separated URIs of the client, e.g. spiffe://example.com/publisher, "+
+		"the first of which is its identity")
+	flag.StringVar(&emails, "email", "", "Comma-separated emails of the client, the first of which is its identity "+
+		"unless -uri is given")
+	flag.StringVar(&dnsNames, "dns", "", "Comma-separated DNS names of the client, the first of which is its "+
+		"identity unless -uri or -email are given")
+	flag.IntVar(&days, "days", 365, "Days the issued certificate is valid for")
+	flag.BoolVar(&force, "force", false, "Replace the certificate and key if they exist")
+	flag.BoolVar(&renew, "renew", false, "Renew the certificates of -dir issued by its CA, the CA included, which "+
+		"expire within -renew-days, instead of issuing one. Renewed certificates keep their key, subject and validity "+
+		"period")
+	flag.IntVar(&renewDays, "renew-days", 30, "Certificates expiring within this many days are renewed by -renew")
+	flag.BoolVar(&printPin, "print-pin", false, "Print the SHA-256 pin of the public key of the server certificate, "+
+		"or that of -client, which clients accept with -pin-sha256, instead of issuing one")
+	flag.StringVar(&signingName, "signing-key", "", "Generate an ed25519 key in -dir as NAME.signing.key for the "+
+		"publisher with this name to sign messages with, and add its public key to -trust-store under the name, "+
+		"instead of issuing a certificate")
+	flag.StringVar(&trustPath, "trust-store", "trust_store.json", "Path to the JSON file of public keys of signing "+
+		"keys that subscribers verify messages against, created if needed")
+	flag.StringVar(&encKeyID, "encryption-key", "", "Generate an AES-256 key with this ID to encrypt payloads with "+
+		"and add it to -encryption-keys, instead of issuing a certificate")
+	flag.StringVar(&encPath, "encryption-keys", "encryption_keys.json", "Path to the JSON file of payload "+
+		"encryption keys that publishers and subscribers share, created if needed")
+	flag.Parse()
+
+	return runConfig{
+		Help:      help,
+		Dir:       dir,
+		CAName:    caName,
+		CADays:    caDays,
+		Hosts:     quichelper.ParseAddrList(hosts),
+		Client:    client,
+		Tenant:    tenant,
+		URIs:      quichelper.ParseAddrList(uris),
+		Emails:    quichelper.ParseAddrList(emails),
+		DNSNames:  quichelper.ParseAddrList(dnsNames),
+		Days:      days,
+		Force:     force,
+		Renew:     renew,
+		RenewDays: renewDays,
+		PrintPin:  printPin,
+		Signing:   signingName,
+		TrustPath: trustPath,
+		EncKeyID:  encKeyID,
+		EncPath:   encPath,
+	}, nil
+}
+
+func validateRunConfig(config runConfig) error {
+	if config.Renew {
+		if config.RenewDays < 0 {
+			return errors.New("RenewDays < 0")
+		}
+		return nil
+	}
+	if config.Signing != "" {
+		if config.Signing != filepath.Base(config.Signing) || strings.HasPrefix(config.Signing, ".") {
+			return fmt.Errorf("publisher name '%s' cannot name its key file in the certificate directory",
+				config.Signing)
+		}
+		return nil
+	}
+	if config.EncKeyID != "" {
+		return nil
+	}
+	if config.CADays < 1 {
+		return errors.New("CADays < 1")
+	}
+	if config.Days < 1 {
+		return errors.New("Days < 1")
+	}
+	if config.Client == "" {
+		if config.Tenant != "" || len(config.URIs)+len(config.Emails)+len(config.DNSNames) > 0 {
+			return errors.New("-tenant, -uri, -email and -dns apply to client certificates, specify flag -client")
+		}
+		if len(config.Hosts) == 0 {
+			return errors.New("specify the hosts of the server with flag -hosts")
+		}
+		return nil
+	}
+	if config.Client != filepath.Base(config.Client) || strings.HasPrefix(config.Client, ".") ||
+		isReservedName(config.Client) {
+		return fmt.Errorf("client name '%s' cannot name its files in the certificate directory", config.Client)
+	}
+	if config.Tenant != "" {
+		if err := sdk.ValidateTenant(config.Tenant); err != nil {
+			return err
+		}
+	}
+	for _, uri := range config.URIs {
+		if _, err := url.Parse(uri); err != nil {
+			return errors.Wrap(err, "url.Parse")
+		}
+	}
+	return nil
+}
+
+// isReservedName returns whether the files of a client with the name would be those of the CA or the server.
+func isReservedName(name string) bool {
+	for _, file := range []string{ca.CACertFile, ca.CAKeyFile, ca.ServerCertFile, ca.ServerKeyFile} {
+		if strings.TrimSuffix(file, filepath.Ext(file)) == name {
+			return true
+		}
+	}
+	return false
+}
+
+// issue issues the server certificate, or that of config.Client, with the CA of config.Dir, which is created first if
+// the directory has none.
+func issue(config runConfig) error {
+	certAuthority, err := loadOrCreateCA(config)
+	if err != nil {
+		return err
+	}
+
+	certPath := certPathOf(config)
+	request := ca.Request{CommonName: config.Client, Server: config.Client == ""}
+	if request.Server {
+		request.CommonName = "QUIC Pub Sub Server"
+		request.DNSNames, request.IPAddresses = ca.ParseHosts(config.Hosts)
+	} else {
+		request.Organization = config.Tenant
+		request.EmailAddresses = config.Emails
+		request.DNSNames = config.DNSNames
+		for _, uri := range config.URIs {
+			parsed, _ := url.Parse(uri) // Validated
+			request.URIs = append(request.URIs, parsed)
+		}
+	}
+
+	if _, err := os.Stat(certPath); err == nil && !config.Force {
+		return fmt.Errorf("%s exists, specify flag -force to replace it or -renew to renew it", certPath)
+	}
+	pair, err := certAuthority.Issue(request, time.Duration(config.Days)*day, time.Now())
+	if err != nil {
+		return errors.Wrap(err, "Issue")
+	}
+	if err := ca.SaveKeyPair(certPath, ca.KeyPathOf(certPath), pair); err != nil {
+		return errors.Wrap(err, "SaveKeyPair")
+	}
+
+	fmt.Printf("Issued %s with key %s: %s, expires %s\n", certPath, ca.KeyPathOf(certPath),
+		pair.Cert.Subject.String(), pair.Cert.NotAfter.Format(time.RFC3339))
+	return nil
+}
+
+// printPin prints the pin of the server certificate, or that of config.Client.
+func printPin(config runConfig) error {
+	certPath := certPathOf(config)
+	pair, err := ca.LoadKeyPair(certPath, ca.KeyPathOf(certPath))
+	if err != nil {
+		return errors.Wrap(err, "ca.LoadKeyPair")
+	}
+	fmt.Println(ca.PinSHA256(pair.Cert))
+	return nil
+}
+
+// certPathOf returns the path of the server certificate, or that of config.Client.
+func certPathOf(config runConfig) string {
+	if config.Client == "" {
+		return filepath.Join(config.Dir, ca.ServerCertFile)
+	}
+	return filepath.Join(config.Dir, config.Client+".pem")
+}
+
+// loadOrCreateCA returns the CA of config.Dir, and creates it if the directory has none.
+func loadOrCreateCA(config runConfig) (*ca.CA, error) {
+	if _, err := os.Stat(filepath.Join(config.Dir, ca.CACertFile)); !errors.Is(err, os.ErrNotExist) {
+		certAuthority, err := ca.Load(config.Dir)
+		if err != nil {
+			return nil, errors.Wrap(err, "ca.Load")
+		}
+		return certAuthority, nil
+	}
+
+	if err := os.MkdirAll(config.Dir, 0755); err != nil {
+		return nil, errors.Wrap(err, "os.MkdirAll")
+	}
+	certAuthority, err := ca.New(config.CAName, time.Duration(config.CADays)*day, time.Now())
+	if err != nil {
+		return nil, errors.Wrap(err, "ca.New")
+	}
+	if err := certAuthority.Save(config.Dir); err != nil {
+		return nil, errors.Wrap(err, "Save")
+	}
+	fmt.Printf("Created the CA %s, expires %s\n", filepath.Join(config.Dir, ca.CACertFile),
+		certAuthority.Cert().NotAfter.Format(time.RFC3339))
+	return certAuthority, nil
+}
+
+// renew renews the certificates of config.Dir that expire within config.RenewDays.
+func renew(config runConfig) error {
+	certAuthority, err := ca.Load(config.Dir)
+	if err != nil {
+		return errors.Wrap(err, "ca.Load")
+	}
+	renewed, err := certAuthority.RenewExpiring(config.Dir, time.Duration(config.RenewDays)*day, time.Now())
+	for _, path := range renewed {
+		fmt.Printf("Renewed %s\n", path)
+	}
+	if err != nil {
+		return errors.Wrap(err, "RenewExpiring")
+	}
+	if len(renewed) == 0 {
+		fmt.Printf("No certificates expire within %d days\n", config.RenewDays)
+	}
+	return nil
+}
+
+// addSigningKey generates the signing key of config.Signing and adds its public key to the trust store, which is
+// created if it does not exist.
+func addSigningKey(config runConfig) error {
+	store, err := signing.LoadTrustStore(config.TrustPath)
+	if errors.Is(err, os.ErrNotExist) {
+		store, err = signing.TrustStore{}, nil
+	}
+	if err != nil {
+		return errors.Wrap(err, "signing.LoadTrustStore")
+	}
+	if _, ok := store[config.Signing]; ok {
+		return fmt.Errorf("signer '%s' is already in %s", config.Signing, config.TrustPath)
+	}
+	keyPath := filepath.Join(config.Dir, config.Signing+".signing.key")
+	if _, err := os.Stat(keyPath); err == nil {
+		return fmt.Errorf("%s exists", keyPath)
+	}
+
+	key, err := signing.NewKey()
+	if err != nil {
+		return errors.Wrap(err, "signing.NewKey")
+	}
+	if err := os.MkdirAll(config.Dir, 0755); err != nil {
+		return errors.Wrap(err, "os.MkdirAll")
+	}
+	if err := signing.SaveKey(keyPath, key); err != nil {
+		return errors.Wrap(err, "signing.SaveKey")
+	}
+	store[config.Signing] = key.Public().(ed25519.PublicKey)
+	if err := signing.SaveTrustStore(config.TrustPath, store); err != nil {
+		return errors.Wrap(err, "signing.SaveTrustStore")
+	}
+	fmt.Printf("Generated the signing key %s, added to %s as '%s'\n", keyPath, config.TrustPath, config.Signing)
+	return nil
+}
+
+// addEncryptionKey generates the payload encryption key of config.EncKeyID and adds it to the file of keys, which is
+// created if it does not exist.
+func addEncryptionKey(config runConfig) error {
+	keys, err := encryption.LoadKeys(config.EncPath)
+	if errors.Is(err, os.ErrNotExist) {
+		keys, err = encryption.Keys{}, nil
+	}
+	if err != nil {
+		return errors.Wrap(err, "encryption.LoadKeys")
+	}
+	if _, ok := keys[config.EncKeyID]; ok {
+		return fmt.Errorf("key '%s' already exists", config.EncKeyID)
+	}
+	keys[config.EncKeyID], err = encryption.NewKey()
+	if err != nil {
+		return errors.Wrap(err, "encryption.NewKey")
+	}
+	if err := encryption.SaveKeys(config.EncPath, keys); err != nil {
+		return errors.Wrap(err, "encryption.SaveKeys")
+	}
+	fmt.Printf("Generated the encryption key '%s' in %s\n", config.EncKeyID, config.EncPath)
+	return nil
+}
@@ -0,0 +1,429 @@
+// Package audit writes an append-only audit trail of a server as JSON lines, and verifies it.
+//
+// Each record holds the SHA-256 hash of the record before it and its own hash, which makes up a hash chain across
+// the records and the files the trail is rotated into. A record that is changed, inserted or removed breaks the chain
+// from there on, see Verify. Removing records from the end of the trail keeps the chain intact, so the last hash is
+// worth keeping elsewhere.
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Events of records.
+const (
+	EventConnected            = "connected"             // A publisher or subscriber was added
+	EventDisconnected         = "disconnected"          // A publisher or subscriber was removed
+	EventRefused              = "refused"               // A connection was refused before it was served
+	EventAuthenticated        = "authenticated"         // A client authenticated with a token
+	EventAuthenticationFailed = "authentication_failed" // A client failed to authenticate
+	EventAccessDenied         = "access_denied"         // A client was denied connecting, publishing or subscribing
+	EventAdmin                = "admin"                 // An administrative action, see Record.Action
+)
+
+// hashField precedes the hash of a record, which is the last field of its line.
+const hashField = `,"hash":"`
+
+// rotatedSeqDigits is the number of digits of the sequence number in the names of rotated files, so that their
+// names sort in the order of the records.
+const rotatedSeqDigits = 12
+
+// ErrBrokenChain is returned by Verify if the hash chain of the records is broken.
+var ErrBrokenChain = errors.New("audit trail hash chain is broken")
+
+// Record is a line of the audit trail. Fields that do not apply to the event are empty.
+type Record struct {
+	Seq        uint64    `json:"seq"`  // Number of the record, starting at 1, set by Log
+	Time       time.Time `json:"time"` // Set by Log
+	Event      string    `json:"event"`
+	Role       string    `json:"role,omitempty"`        // Role of the client, see the sdk.ALPN* constants
+	ID         string    `json:"id,omitempty"`          // ID of the publisher or subscriber
+	Identity   string    `json:"identity,omitempty"`    // Identity of the client, from its certificate or token
+	Tenant     string    `json:"tenant,omitempty"`      // Tenant of the client
+	RemoteAddr string    `json:"remote_addr,omitempty"` // Address of the client
+	Action     string    `json:"action,omitempty"`      // What was denied, or the administrative action
+	Key        string    `json:"key,omitempty"`         // Message key that was denied
+	Reason     string    `json:"reason,omitempty"`      // Why the client was refused, or details of the action
+	Prev       string    `json:"prev"`                  // Hash of the previous record, empty for the first, set by Log
+}
+
+// Config configures a Log.
+type Config struct {
+	Path     string // Path to the file records are appended to
+	MaxBytes int64  // Size after which the file is rotated, never rotated if 0
+	MaxFiles int    // Max number of rotated files to keep, the oldest are removed beyond it, all are kept if 0
+}
+
+// Log appends records to the audit trail. It is safe for concurrent use. A nil Log records nothing, so that callers
+// need not check whether auditing is on.
+type Log struct {
+	config Config
+	mu     sync.Mutex
+	file   logFile // Nil if closed by a rotation that failed, reopened by the next Record
+	size   int64   // Of the file
+	err    error   // Why records can no longer be appended, once the file ends in a partial one
+	seq    uint64  // Of the last record
+	prev   string  // Hash of the last record
+	now    func() time.Time
+	logger *zap.Logger
+}
+
+// logFile is the file of a Log, an *os.File.
+type logFile interface {
+	Write(b []byte) (int, error)
+	Truncate(size int64) error
+	Sync() error
+	Close() error
+}
+
+// Open opens the audit trail at config.Path, continuing the hash chain of its records, or of the latest rotated file
+// if the file is empty or does not exist. Returns an error if the last record cannot be read to continue the chain.
+func Open(config Config, logger *zap.Logger) (*Log, error) {
+	files, err := Files(config.Path)
+	if err != nil {
+		return nil, errors.Wrap(err, "Files")
+	}
+	l := &Log{config: config, now: time.Now, logger: logger}
+	for i := len(files) - 1; i >= 0; i-- {
+		last, ok, err := lastRecord(files[i])
+		if err != nil {
+			return nil, errors.Wrapf(err, "last record of %s", files[i])
+		}
+		if ok {
+			l.seq, l.prev = last.Seq, last.hash
+			break
+		}
+	}
+	if err := l.openFile(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// Record appends a record of the event, numbering, timing and chaining it. Failures to write are logged, as the
+// events happen regardless. A record written in part is removed, and if it cannot be, nothing is recorded after it.
+func (l *Log) Record(record Record) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.err != nil {
+		l.logger.Error("Cannot record to the audit trail", zap.String("event", record.Event), zap.Error(l.err))
+		return
+	}
+
+	record.Seq = l.seq + 1
+	record.Time = l.now().UTC()
+	record.Prev = l.prev
+	line, hash, err := encode(record)
+	if err != nil {
+		l.logger.Error("Cannot encode an audit record", zap.Error(err))
+		return
+	}
+
+	if l.config.MaxBytes > 0 && l.size > 0 && l.size+int64(len(line)) > l.config.MaxBytes {
+		if err := l.rotate(record.Seq); err != nil {
+			l.logger.Error("Cannot rotate the audit trail", zap.Error(err))
+		}
+	}
+	if l.file == nil {
+		if err := l.openFile(); err != nil {
+			l.logger.Error("Cannot reopen the audit trail", zap.Uint64("seq", record.Seq), zap.Error(err))
+			return
+		}
+	}
+	n, err := l.file.Write(line)
+	if err != nil {
+		l.logger.Error("Cannot write an audit record", zap.Uint64("seq", record.Seq), zap.Error(err))
+		if n > 0 {
+			// A partial line would break the chain
+			if err := l.file.Truncate(l.size); err != nil {
+				l.err = errors.Wrapf(err, "cannot remove partial record %d", record.Seq)
+				l.logger.Error("Cannot remove a partial audit record", zap.Uint64("seq", record.Seq), zap.Error(err))
+			}
+		}
+		return
+	}
+	l.size += int64(n)
+	l.seq, l.prev = record.Seq, hash
+}
+
+// Close flushes the audit trail to disk and closes it.
+func (l *Log) Close() error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file == nil {
+		return nil
+	}
+	if err := l.file.Sync(); err != nil {
+		return errors.Wrap(err, "file.Sync")
+	}
+	return l.file.Close()
+}
+
+// openFile opens config.Path for appending.
+func (l *Log) openFile() error {
+	file, err := os.OpenFile(l.config.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return errors.Wrap(err, "os.OpenFile")
+	}
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return errors.Wrap(err, "file.Stat")
+	}
+	l.file, l.size = file, info.Size()
+	return nil
+}
+
+// rotate renames the file, whose next record is nextSeq, after the first of its records and opens a new one. Then it
+// removes the oldest rotated files beyond config.MaxFiles. If it fails after closing the file, l.file is nil.
+func (l *Log) rotate(nextSeq uint64) error {
+	first, ok, err := firstRecord(l.config.Path)
+	if err != nil {
+		return errors.Wrap(err, "firstRecord")
+	}
+	if !ok {
+		first.Seq = nextSeq
+	}
+	if err := l.file.Sync(); err != nil {
+		return errors.Wrap(err, "file.Sync")
+	}
+	err = l.file.Close()
+	l.file = nil
+	if err != nil {
+		return errors.Wrap(err, "file.Close")
+	}
+	rotatedPath := fmt.Sprintf("%s.%0*d", l.config.Path, rotatedSeqDigits, first.Seq)
+	if err := os.Rename(l.config.Path, rotatedPath); err != nil {
+		return errors.Wrap(err, "os.Rename")
+	}
+	if err := l.openFile(); err != nil {
+		return err
+	}
+
+	if l.config.MaxFiles > 0 {
+		rotated, err := rotatedFiles(l.config.Path)
+		if err != nil {
+			return errors.Wrap(err, "rotatedFiles")
+		}
+		for len(rotated) > l.config.MaxFiles {
+			if err := os.Remove(rotated[0]); err != nil {
+				return errors.Wrap(err, "os.Remove")
+			}
+			rotated = rotated[1:]
+		}
+	}
+	return nil
+}
+
+// Summary describes a verified audit trail.
+type Summary struct {
+	Files    []string // The files of the trail, oldest first
+	Records  int      // Number of records
+	FirstSeq uint64   // Of the first record, greater than 1 if the oldest files have been removed
+	LastSeq  uint64   // Of the last record
+	LastHash string   // Of the last record, which the next record chains to
+}
+
+// Verify checks the hash chain of the audit trail at path: the rotated files, then the file at path. Returns an error
+// wrapping ErrBrokenChain, naming the file and line, at the first record that does not hash to its hash, or is not
+// numbered or chained after the record before it, or that is not the first record of its file as the name of the file
+// tells. If the oldest rotated files have been removed, the chain is verified from the oldest file there is.
+func Verify(path string) (Summary, error) {
+	files, err := Files(path)
+	if err != nil {
+		return Summary{}, errors.Wrap(err, "Files")
+	}
+	if len(files) == 0 {
+		return Summary{}, errors.Wrapf(os.ErrNotExist, "no audit trail at %s", path)
+	}
+
+	summary := Summary{Files: files}
+	for _, file := range files {
+		// Rotated files are named after their first record, and the trail starts at record 1 until it is rotated
+		firstSeq := uint64(1)
+		if file != path {
+			_, _ = fmt.Sscanf(strings.TrimPrefix(file, path+"."), "%d", &firstSeq)
+		}
+		err := eachRecord(file, func(lineNo int, record hashedRecord, err error) error {
+			if err != nil {
+				return errors.Wrapf(ErrBrokenChain, "%s:%d: %v", file, lineNo, err)
+			}
+			if lineNo == 1 && (file != path || summary.Records == 0) && record.Seq != firstSeq {
+				return errors.Wrapf(ErrBrokenChain, "%s:%d: first record is %d, expected %d", file, lineNo,
+					record.Seq, firstSeq)
+			}
+			if summary.Records == 0 {
+				summary.FirstSeq = record.Seq
+				if record.Seq == 1 && record.Prev != "" {
+					return errors.Wrapf(ErrBrokenChain, "%s:%d: first record chains to a previous one", file, lineNo)
+				}
+			} else {
+				if record.Seq != summary.LastSeq+1 {
+					return errors.Wrapf(ErrBrokenChain, "%s:%d: record %d follows record %d", file, lineNo,
+						record.Seq, summary.LastSeq)
+				}
+				if record.Prev != summary.LastHash {
+					return errors.Wrapf(ErrBrokenChain, "%s:%d: record %d does not chain to record %d", file, lineNo,
+						record.Seq, summary.LastSeq)
+				}
+			}
+			summary.Records++
+			summary.LastSeq, summary.LastHash = record.Seq, record.hash
+			return nil
+		})
+		if err != nil {
+			return Summary{}, err
+		}
+	}
+	return summary, nil
+}
+
+// Files returns the files of the audit trail at path that exist: the rotated files, oldest first, then the file at
+// path.
+func Files(path string) ([]string, error) {
+	files, err := rotatedFiles(path)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(path); err == nil {
+		files = append(files, path)
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, errors.Wrap(err, "os.Stat")
+	}
+	return files, nil
+}
+
+// rotatedFiles returns the rotated files of the audit trail at path, oldest first.
+func rotatedFiles(path string) ([]string, error) {
+	matches, err := filepath.Glob(path + ".*")
+	if err != nil {
+		return nil, errors.Wrap(err, "filepath.Glob")
+	}
+	files := matches[:0]
+	for _, match := range matches {
+		suffix := strings.TrimPrefix(match, path+".")
+		if len(suffix) == rotatedSeqDigits && strings.Trim(suffix, "0123456789") == "" {
+			files = append(files, match)
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// hashedRecord is a Record read from a file, with its hash.
+type hashedRecord struct {
+	Record
+	hash string
+}
+
+// encode returns the line of a record, ending with its hash, and the hash, which is of the JSON of the record.
+func encode(record Record) ([]byte, string, error) {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return nil, "", errors.Wrap(err, "json.Marshal")
+	}
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])
+	line := make([]byte, 0, len(data)+len(hashField)+len(hash)+3)
+	line = append(line, data[:len(data)-1]...) // Without the closing brace
+	line = append(line, hashField...)
+	line = append(line, hash...)
+	line = append(line, "\"}\n"...)
+	return line, hash, nil
+}
+
+// decode returns the record of a line, which must hash to the hash the line ends with.
+func decode(line []byte) (hashedRecord, error) {
+	i := bytes.LastIndex(line, []byte(hashField))
+	if i < 0 || !bytes.HasSuffix(line, []byte("\"}")) {
+		return hashedRecord{}, errors.New("no hash")
+	}
+	hash := string(line[i+len(hashField) : len(line)-2])
+	data := append(line[:i:i], '}')
+	sum := sha256.Sum256(data)
+	if hex.EncodeToString(sum[:]) != hash {
+		return hashedRecord{}, errors.New("record does not match its hash")
+	}
+	var record Record
+	if err := json.Unmarshal(data, &record); err != nil {
+		return hashedRecord{}, errors.Wrap(err, "json.Unmarshal")
+	}
+	return hashedRecord{Record: record, hash: hash}, nil
+}
+
+// eachRecord calls fn with each record of the file and the number of its line, or the error decoding it, until fn
+// returns an error, which is returned.
+func eachRecord(path string, fn func(lineNo int, record hashedRecord, err error) error) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return errors.Wrap(err, "os.Open")
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		record, err := decode(scanner.Bytes())
+		if err := fn(lineNo, record, err); err != nil {
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return errors.Wrap(err, "scanner.Err")
+	}
+	return nil
+}
+
+// firstRecord returns the first record of the file, and false if it has none.
+func firstRecord(path string) (hashedRecord, bool, error) {
+	var first hashedRecord
+	found := false
+	stop := errors.New("stop")
+	err := eachRecord(path, func(_ int, record hashedRecord, err error) error {
+		if err != nil {
+			return err
+		}
+		first, found = record, true
+		return stop
+	})
+	if err != nil && err != stop {
+		return hashedRecord{}, false, err
+	}
+	return first, found, nil
+}
+
+// lastRecord returns the last record of the file, and false if it has none.
+func lastRecord(path string) (hashedRecord, bool, error) {
+	var last hashedRecord
+	found := false
+	err := eachRecord(path, func(lineNo int, record hashedRecord, err error) error {
+		if err != nil {
+			return errors.Wrapf(err, "line %d", lineNo)
+		}
+		last, found = record, true
+		return nil
+	})
+	if err != nil {
+		return hashedRecord{}, false, err
+	}
+	return last, found, nil
+}
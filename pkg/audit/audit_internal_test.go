@@ -0,0 +1,79 @@
+package audit
+
+import (
+	. "github.com/onsi/gomega"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"path/filepath"
+	"testing"
+)
+
+// shortFile writes only the first bytes of the next write, and then fails.
+type shortFile struct {
+	logFile
+	fail          bool
+	truncateError error
+}
+
+func (f *shortFile) Write(b []byte) (int, error) {
+	if !f.fail {
+		return f.logFile.Write(b)
+	}
+	f.fail = false
+	n, err := f.logFile.Write(b[:len(b)/2])
+	if err != nil {
+		return n, err
+	}
+	return n, errors.New("no space left on device")
+}
+
+func (f *shortFile) Truncate(size int64) error {
+	if f.truncateError != nil {
+		return f.truncateError
+	}
+	return f.logFile.Truncate(size)
+}
+
+func TestLog_Record_shortWrite(t *testing.T) {
+	g := NewWithT(t)
+
+	path := filepath.Join(t.TempDir(), "audit.log")
+	log, err := Open(Config{Path: path}, zap.NewNop())
+	g.Expect(err).NotTo(HaveOccurred())
+	file := &shortFile{logFile: log.file}
+	log.file = file
+
+	log.Record(Record{Event: EventConnected, ID: "alice"})
+	file.fail = true
+	log.Record(Record{Event: EventConnected, ID: "bob"})
+	log.Record(Record{Event: EventConnected, ID: "carol"})
+	g.Expect(log.Close()).To(Succeed())
+
+	// The partial record of bob is removed, and the chain goes on with carol
+	summary, err := Verify(path)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(summary.Records).To(Equal(2))
+	g.Expect(summary.LastSeq).To(Equal(uint64(2)))
+}
+
+func TestLog_Record_shortWriteNotRemoved(t *testing.T) {
+	g := NewWithT(t)
+
+	path := filepath.Join(t.TempDir(), "audit.log")
+	log, err := Open(Config{Path: path}, zap.NewNop())
+	g.Expect(err).NotTo(HaveOccurred())
+	file := &shortFile{logFile: log.file, truncateError: errors.New("read-only file system")}
+	log.file = file
+
+	log.Record(Record{Event: EventConnected, ID: "alice"})
+	file.fail = true
+	log.Record(Record{Event: EventConnected, ID: "bob"})
+	log.Record(Record{Event: EventConnected, ID: "carol"})
+	g.Expect(log.Close()).To(Succeed())
+
+	// Nothing is recorded after the partial record, which breaks the chain only at the end
+	_, err = Verify(path)
+	g.Expect(err).To(MatchError(ErrBrokenChain))
+	g.Expect(err.Error()).To(ContainSubstring("audit.log:2"))
+	g.Expect(log.seq).To(Equal(uint64(1)))
+}
@@ -0,0 +1,130 @@
+package audit_test
+
+import (
+	"bytes"
+	. "github.com/onsi/gomega"
+	"github.com/varfrog/quicpubsub/pkg/audit"
+	"go.uber.org/zap"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLog_Record(t *testing.T) {
+	g := NewWithT(t)
+
+	path := filepath.Join(t.TempDir(), "audit.log")
+	log, err := audit.Open(audit.Config{Path: path}, zap.NewNop())
+	g.Expect(err).NotTo(HaveOccurred())
+	log.Record(audit.Record{Event: audit.EventConnected, Role: "publisher", ID: "alice", Identity: "alice"})
+	log.Record(audit.Record{Event: audit.EventAccessDenied, Identity: "alice", Action: "publish", Key: "orders.eu"})
+	g.Expect(log.Close()).To(Succeed())
+
+	// Reopened, the chain continues
+	log, err = audit.Open(audit.Config{Path: path}, zap.NewNop())
+	g.Expect(err).NotTo(HaveOccurred())
+	log.Record(audit.Record{Event: audit.EventDisconnected, Role: "publisher", ID: "alice"})
+	g.Expect(log.Close()).To(Succeed())
+
+	summary, err := audit.Verify(path)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(summary.Records).To(Equal(3))
+	g.Expect(summary.FirstSeq).To(Equal(uint64(1)))
+	g.Expect(summary.LastSeq).To(Equal(uint64(3)))
+	g.Expect(summary.LastHash).To(HaveLen(64))
+
+	var nilLog *audit.Log
+	nilLog.Record(audit.Record{Event: audit.EventAdmin}) // Records nothing
+	g.Expect(nilLog.Close()).To(Succeed())
+}
+
+func TestVerify_Tampered(t *testing.T) {
+	for name, tamper := range map[string]func(lines [][]byte) [][]byte{
+		"changed": func(lines [][]byte) [][]byte {
+			lines[1] = bytes.Replace(lines[1], []byte("orders.eu"), []byte("orders.us"), 1)
+			return lines
+		},
+		"removed": func(lines [][]byte) [][]byte {
+			return append(lines[:1], lines[2:]...)
+		},
+		"reordered": func(lines [][]byte) [][]byte {
+			lines[0], lines[1] = lines[1], lines[0]
+			return lines
+		},
+		"first removed": func(lines [][]byte) [][]byte {
+			return lines[1:]
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			path := filepath.Join(t.TempDir(), "audit.log")
+			log, err := audit.Open(audit.Config{Path: path}, zap.NewNop())
+			g.Expect(err).NotTo(HaveOccurred())
+			log.Record(audit.Record{Event: audit.EventAuthenticated, Identity: "alice"})
+			log.Record(audit.Record{Event: audit.EventAccessDenied, Identity: "alice", Key: "orders.eu"})
+			log.Record(audit.Record{Event: audit.EventDisconnected, Identity: "alice"})
+			g.Expect(log.Close()).To(Succeed())
+
+			data, err := os.ReadFile(path)
+			g.Expect(err).NotTo(HaveOccurred())
+			lines := tamper(bytes.SplitAfter(bytes.TrimSuffix(data, []byte("\n")), []byte("\n")))
+			g.Expect(os.WriteFile(path, bytes.Join(lines, nil), 0600)).To(Succeed())
+
+			_, err = audit.Verify(path)
+			g.Expect(err).To(MatchError(audit.ErrBrokenChain))
+		})
+	}
+}
+
+func TestLog_Rotate(t *testing.T) {
+	g := NewWithT(t)
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "audit.log")
+	log, err := audit.Open(audit.Config{Path: path, MaxBytes: 400, MaxFiles: 2}, zap.NewNop())
+	g.Expect(err).NotTo(HaveOccurred())
+	for i := 0; i < 20; i++ {
+		log.Record(audit.Record{Event: audit.EventConnected, Role: "subscriber", ID: "a-subscriber-id"})
+	}
+	g.Expect(log.Close()).To(Succeed())
+
+	files, err := audit.Files(path)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(files).To(HaveLen(3), "two rotated files are kept")
+	g.Expect(files[2]).To(Equal(path))
+
+	// The chain is verified from the oldest record kept, across the files
+	summary, err := audit.Verify(path)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(summary.FirstSeq).To(BeNumerically(">", 1))
+	g.Expect(summary.LastSeq).To(Equal(uint64(20)))
+	g.Expect(summary.Records).To(Equal(int(summary.LastSeq - summary.FirstSeq + 1)))
+
+	// A rotated file removed from the middle breaks the chain
+	g.Expect(os.Remove(files[1])).To(Succeed())
+	_, err = audit.Verify(path)
+	g.Expect(err).To(MatchError(audit.ErrBrokenChain))
+}
+
+func TestLog_RotateFails(t *testing.T) {
+	g := NewWithT(t)
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "audit.log")
+	log, err := audit.Open(audit.Config{Path: path, MaxBytes: 400}, zap.NewNop())
+	g.Expect(err).NotTo(HaveOccurred())
+	// The file cannot be renamed onto a directory, after it is closed to rotate it
+	g.Expect(os.Mkdir(path+".000000000001", 0700)).To(Succeed())
+	for i := 0; i < 10; i++ {
+		log.Record(audit.Record{Event: audit.EventConnected, Role: "subscriber", ID: "a-subscriber-id"})
+	}
+	g.Expect(log.Close()).To(Succeed())
+
+	// The records are appended to the file that could not be rotated
+	g.Expect(os.Remove(path + ".000000000001")).To(Succeed())
+	summary, err := audit.Verify(path)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(summary.Files).To(Equal([]string{path}))
+	g.Expect(summary.LastSeq).To(Equal(uint64(10)))
+}
@@ -0,0 +1,164 @@
+// Package auth issues and verifies the bearer tokens with which clients authenticate to servers.
+//
+// A token is the base64url-encoded JSON of its Claims and the base64url-encoded HMAC-SHA256 of that, signed with
+// the key named by the claims, joined by a dot. Servers hold the keys in a file, see LoadKeys, so that keys can be
+// rotated by adding a new key, issuing tokens with it, and removing the old key once its tokens have expired.
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"os"
+	"strings"
+	"time"
+)
+
+// Roles that tokens allow their clients.
+const (
+	RolePublish   = "publish"
+	RoleSubscribe = "subscribe"
+	RoleAdmin     = "admin" // Calls to the admin API of the server, rather than a connection
+)
+
+// keySize is the size of the keys generated by NewKey.
+const keySize = 32
+
+var (
+	ErrMalformedToken   = errors.New("malformed token")
+	ErrUnknownKey       = errors.New("token signed with an unknown key")
+	ErrInvalidSignature = errors.New("invalid token signature")
+	ErrExpired          = errors.New("token expired")
+)
+
+// Claims are what a token says about its client.
+type Claims struct {
+	KeyID     string   `json:"kid"`   // ID of the key the token is signed with
+	Name      string   `json:"name"`  // Name of the client, its identity
+	Roles     []string `json:"roles"` // Roles the client may connect as, see the Role* constants
+	ExpiresAt int64    `json:"exp"`   // Unix time in seconds after which the token is not accepted
+}
+
+// Expiry returns the time after which the token is not accepted.
+func (c Claims) Expiry() time.Time {
+	return time.Unix(c.ExpiresAt, 0)
+}
+
+// HasRole returns whether the token allows the client to connect as role.
+func (c Claims) HasRole(role string) bool {
+	for _, r := range c.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// Keys are the secrets tokens are signed with, by key ID.
+type Keys map[string][]byte
+
+// NewKey returns a random key.
+func NewKey() ([]byte, error) {
+	key := make([]byte, keySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, errors.Wrap(err, "rand.Read")
+	}
+	return key, nil
+}
+
+// LoadKeys reads keys from a JSON file, an object with key IDs as keys and base64-encoded secrets as values, e.g.
+// {"2024-10": "3q2+7w..."}.
+func LoadKeys(path string) (Keys, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "os.ReadFile")
+	}
+	var encoded map[string]string
+	if err := json.Unmarshal(data, &encoded); err != nil {
+		return nil, errors.Wrap(err, "json.Unmarshal")
+	}
+	keys := make(Keys, len(encoded))
+	for id, secret := range encoded {
+		key, err := base64.StdEncoding.DecodeString(secret)
+		if err != nil {
+			return nil, errors.Wrapf(err, "key '%s'", id)
+		}
+		if len(key) < sha256.Size {
+			return nil, fmt.Errorf("key '%s' is shorter than %d bytes", id, sha256.Size)
+		}
+		keys[id] = key
+	}
+	return keys, nil
+}
+
+// SaveKeys writes keys to a file in the format of LoadKeys, readable by the owner only.
+func SaveKeys(path string, keys Keys) error {
+	encoded := make(map[string]string, len(keys))
+	for id, key := range keys {
+		encoded[id] = base64.StdEncoding.EncodeToString(key)
+	}
+	data, err := json.MarshalIndent(encoded, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "json.MarshalIndent")
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0600); err != nil {
+		return errors.Wrap(err, "os.WriteFile")
+	}
+	return nil
+}
+
+// Sign returns a token with the claims, signed with the key of claims.KeyID.
+func Sign(claims Claims, keys Keys) (string, error) {
+	key, ok := keys[claims.KeyID]
+	if !ok {
+		return "", errors.Wrapf(ErrUnknownKey, "key '%s'", claims.KeyID)
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", errors.Wrap(err, "json.Marshal")
+	}
+	encodedPayload := base64.RawURLEncoding.EncodeToString(payload)
+	return encodedPayload + "." + base64.RawURLEncoding.EncodeToString(signature(encodedPayload, key)), nil
+}
+
+// Verify returns the claims of a token if it is signed with one of keys and has not expired at now.
+func Verify(token string, keys Keys, now time.Time) (Claims, error) {
+	encodedPayload, encodedSignature, ok := strings.Cut(token, ".")
+	if !ok {
+		return Claims{}, ErrMalformedToken
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encodedPayload)
+	if err != nil {
+		return Claims{}, errors.Wrap(ErrMalformedToken, err.Error())
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(encodedSignature)
+	if err != nil {
+		return Claims{}, errors.Wrap(ErrMalformedToken, err.Error())
+	}
+	var claims Claims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return Claims{}, errors.Wrap(ErrMalformedToken, err.Error())
+	}
+
+	key, ok := keys[claims.KeyID]
+	if !ok {
+		return Claims{}, errors.Wrapf(ErrUnknownKey, "key '%s'", claims.KeyID)
+	}
+	if !hmac.Equal(sig, signature(encodedPayload, key)) {
+		return Claims{}, ErrInvalidSignature
+	}
+	if now.After(claims.Expiry()) {
+		return Claims{}, fmt.Errorf("%w at %s", ErrExpired, claims.Expiry().UTC().Format(time.RFC3339))
+	}
+	return claims, nil
+}
+
+func signature(encodedPayload string, key []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(encodedPayload)) // Never fails
+	return mac.Sum(nil)
+}
@@ -0,0 +1,99 @@
+package auth_test
+
+import (
+	. "github.com/onsi/gomega"
+	"github.com/varfrog/quicpubsub/pkg/auth"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSignAndVerify(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	keys := auth.Keys{"k1": []byte(strings.Repeat("a", 32)), "k2": []byte(strings.Repeat("b", 32))}
+	claims := auth.Claims{KeyID: "k2", Name: "nightly-export", Roles: []string{auth.RolePublish}, ExpiresAt: now.Unix() + 60}
+
+	token, err := auth.Sign(claims, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("Valid token", func(t *testing.T) {
+		g := NewWithT(t)
+
+		got, err := auth.Verify(token, keys, now)
+		g.Expect(err).NotTo(HaveOccurred())
+		g.Expect(got).To(Equal(claims))
+		g.Expect(got.HasRole(auth.RolePublish)).To(BeTrue())
+		g.Expect(got.HasRole(auth.RoleSubscribe)).To(BeFalse())
+	})
+
+	t.Run("Expired token", func(t *testing.T) {
+		g := NewWithT(t)
+
+		_, err := auth.Verify(token, keys, now.Add(61*time.Second))
+		g.Expect(err).To(MatchError(auth.ErrExpired))
+	})
+
+	t.Run("Removed key", func(t *testing.T) {
+		g := NewWithT(t)
+
+		_, err := auth.Verify(token, auth.Keys{"k1": keys["k1"]}, now)
+		g.Expect(err).To(MatchError(auth.ErrUnknownKey))
+	})
+
+	t.Run("Replaced key", func(t *testing.T) {
+		g := NewWithT(t)
+
+		_, err := auth.Verify(token, auth.Keys{"k2": keys["k1"]}, now)
+		g.Expect(err).To(MatchError(auth.ErrInvalidSignature))
+	})
+
+	t.Run("Tampered claims", func(t *testing.T) {
+		g := NewWithT(t)
+
+		tampered, err := auth.Sign(auth.Claims{KeyID: "k2", Name: "nightly-export", Roles: []string{auth.RolePublish, auth.RoleSubscribe},
+			ExpiresAt: claims.ExpiresAt}, keys)
+		g.Expect(err).NotTo(HaveOccurred())
+		payload, _, _ := strings.Cut(tampered, ".")
+		_, sig, _ := strings.Cut(token, ".")
+
+		_, err = auth.Verify(payload+"."+sig, keys, now)
+		g.Expect(err).To(MatchError(auth.ErrInvalidSignature))
+	})
+
+	t.Run("Malformed tokens", func(t *testing.T) {
+		g := NewWithT(t)
+
+		for _, malformed := range []string{"", "abc", "a.b", "!!.!!"} {
+			_, err := auth.Verify(malformed, keys, now)
+			g.Expect(err).To(MatchError(auth.ErrMalformedToken), malformed)
+		}
+	})
+
+	t.Run("Unknown signing key", func(t *testing.T) {
+		g := NewWithT(t)
+
+		_, err := auth.Sign(auth.Claims{KeyID: "k3"}, keys)
+		g.Expect(err).To(MatchError(auth.ErrUnknownKey))
+	})
+}
+
+func TestSaveAndLoadKeys(t *testing.T) {
+	g := NewWithT(t)
+
+	key, err := auth.NewKey()
+	g.Expect(err).NotTo(HaveOccurred())
+	keys := auth.Keys{"k1": key}
+	path := filepath.Join(t.TempDir(), "keys.json")
+
+	g.Expect(auth.SaveKeys(path, keys)).To(Succeed())
+	loaded, err := auth.LoadKeys(path)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(loaded).To(Equal(keys))
+
+	g.Expect(auth.SaveKeys(path, auth.Keys{"short": []byte("secret")})).To(Succeed())
+	_, err = auth.LoadKeys(path)
+	g.Expect(err).To(MatchError(ContainSubstring("shorter than 32 bytes")))
+}
@@ -0,0 +1,92 @@
+// Package buffer provides reference-counted byte buffers backed by sync.Pool size classes. A message is read into
+// a buffer once and the same bytes are written to every subscriber, the buffer returning to its pool once the last
+// holder releases it.
+package buffer
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	minClassBits = 8  // The smallest size class is 256 bytes
+	maxClassBits = 20 // The largest size class is 1 MiB, larger buffers are not pooled
+)
+
+// pools holds buffers of each size class, the capacity of class i being 1<<(minClassBits+i) bytes.
+var pools [maxClassBits - minClassBits + 1]sync.Pool
+
+// Buffer is a byte slice with a reference count. It starts with one reference, holders that pass it on to others
+// that may hold it longer call Retain for each of them, and every holder calls Release once done with it. The bytes
+// must not be used after Release.
+type Buffer struct {
+	data  []byte
+	refs  atomic.Int32
+	class int // Index into pools, -1 if the buffer is not pooled
+}
+
+// Get returns a buffer of size bytes with one reference, taken from the pool of the smallest size class that fits.
+// The bytes are not zeroed.
+func Get(size int) *Buffer {
+	class := classOf(size)
+	if class < 0 {
+		return newBuffer(make([]byte, size), -1)
+	}
+
+	b, _ := pools[class].Get().(*Buffer)
+	if b == nil {
+		return newBuffer(make([]byte, size, 1<<(minClassBits+class)), class)
+	}
+	b.data = b.data[:size]
+	b.refs.Store(1)
+	return b
+}
+
+// Wrap returns a buffer of data with one reference, which is not returned to a pool once released.
+func Wrap(data []byte) *Buffer {
+	return newBuffer(data, -1)
+}
+
+// Bytes returns the contents of the buffer, valid until the buffer is released.
+func (b *Buffer) Bytes() []byte {
+	return b.data
+}
+
+// Len returns the size of the buffer.
+func (b *Buffer) Len() int {
+	return len(b.data)
+}
+
+// Retain adds a reference to the buffer for another holder.
+func (b *Buffer) Retain() {
+	if b.refs.Add(1) <= 1 {
+		panic("buffer: Retain of a released buffer")
+	}
+}
+
+// Release drops a reference to the buffer, returning it to its pool once no references are left.
+func (b *Buffer) Release() {
+	refs := b.refs.Add(-1)
+	if refs < 0 {
+		panic("buffer: Release of a released buffer")
+	}
+	if refs == 0 && b.class >= 0 {
+		pools[b.class].Put(b)
+	}
+}
+
+func newBuffer(data []byte, class int) *Buffer {
+	b := &Buffer{data: data, class: class}
+	b.refs.Store(1)
+	return b
+}
+
+// classOf returns the smallest size class that fits size bytes, or -1 if size exceeds the largest one.
+func classOf(size int) int {
+	for class := 0; class <= maxClassBits-minClassBits; class++ {
+		if size <= 1<<(minClassBits+class) {
+			return class
+		}
+	}
+	return -1
+}
@@ -0,0 +1,78 @@
+package buffer_test
+
+import (
+	. "github.com/onsi/gomega"
+	"github.com/varfrog/quicpubsub/pkg/buffer"
+	"testing"
+)
+
+func TestGet(t *testing.T) {
+	g := NewWithT(t)
+
+	for _, size := range []int{0, 1, 256, 257, 1000, 1 << 20, 1<<20 + 1} {
+		b := buffer.Get(size)
+		g.Expect(b.Len()).To(Equal(size))
+		g.Expect(b.Bytes()).To(HaveLen(size))
+		b.Release()
+	}
+}
+
+func TestBuffer_ReusedOnceReleased(t *testing.T) {
+	g := NewWithT(t)
+
+	// sync.Pool may drop buffers at any time, so reuse is likely rather than certain
+	reused := false
+	for i := 0; i < 100 && !reused; i++ {
+		b1 := buffer.Get(300)
+		b1.Bytes()[0] = 42
+		b1.Release()
+
+		b2 := buffer.Get(400) // Same size class as 300
+		reused = b1 == b2
+		g.Expect(b2.Len()).To(Equal(400))
+		b2.Release()
+	}
+	g.Expect(reused).To(BeTrue())
+}
+
+func TestBuffer_NotReusedWhileReferenced(t *testing.T) {
+	g := NewWithT(t)
+
+	b1 := buffer.Get(300)
+	b1.Retain()
+	b1.Release()
+
+	for i := 0; i < 100; i++ {
+		b2 := buffer.Get(300)
+		g.Expect(b2).ToNot(BeIdenticalTo(b1))
+		defer b2.Release()
+	}
+	b1.Release()
+}
+
+func TestBuffer_ReleaseTooManyTimesPanics(t *testing.T) {
+	g := NewWithT(t)
+
+	b := buffer.Wrap([]byte("foo"))
+	b.Release()
+	g.Expect(b.Release).To(Panic())
+	g.Expect(b.Retain).To(Panic())
+}
+
+func BenchmarkGetRelease(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		buf := buffer.Get(1000)
+		buf.Release()
+	}
+}
+
+func BenchmarkMake(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		buf := make([]byte, 1000)
+		sink = buf
+	}
+}
+
+var sink []byte // Keeps the compiler from optimizing allocations away
@@ -0,0 +1,374 @@
+// Package ca is a certificate authority that issues the certificates of servers and clients, and renews them.
+//
+// Certificates are written to a directory in the layout the apps expect: the CA as ca.pem, which clients load
+// with quichelper.GetRootCertPool and servers with -client-ca, the server certificate as cert.pem and private.key,
+// which servers load with -cert and -key, and client certificates as NAME.pem and NAME.key.
+package ca
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"github.com/pkg/errors"
+	"math/big"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Names of the files in a certificate directory.
+const (
+	CACertFile     = "ca.pem"
+	CAKeyFile      = "ca.key"
+	ServerCertFile = "cert.pem"
+	ServerKeyFile  = "private.key"
+)
+
+// clockSkew is how long before they are issued certificates are valid from, so that hosts whose clocks are behind
+// accept them right away.
+const clockSkew = time.Minute * 5
+
+// KeyPair is a certificate and its private key.
+type KeyPair struct {
+	Cert *x509.Certificate
+	Key  crypto.Signer
+}
+
+// Request is what an issued certificate says about its subject.
+type Request struct {
+	CommonName     string
+	Organization   string   // The tenant of a client, optional
+	DNSNames       []string // Subject alternative names
+	IPAddresses    []net.IP
+	EmailAddresses []string
+	URIs           []*url.URL
+	Server         bool // Whether the certificate is of a server, which also presents it to other servers as a client
+}
+
+// CA issues certificates signed with its key.
+type CA struct {
+	pair KeyPair
+}
+
+// New returns a CA with a new key and a self-signed certificate, valid for validity from now.
+func New(commonName string, validity time.Duration, now time.Time) (*CA, error) {
+	key, err := newKey()
+	if err != nil {
+		return nil, err
+	}
+	template, err := newTemplate(pkix.Name{CommonName: commonName}, validity, now)
+	if err != nil {
+		return nil, err
+	}
+	template.IsCA = true
+	template.BasicConstraintsValid = true
+	template.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageCRLSign
+
+	cert, err := createCertificate(template, template, key.Public(), key)
+	if err != nil {
+		return nil, err
+	}
+	return &CA{pair: KeyPair{Cert: cert, Key: key}}, nil
+}
+
+// Load reads the CA of the certificate directory dir.
+func Load(dir string) (*CA, error) {
+	pair, err := LoadKeyPair(filepath.Join(dir, CACertFile), filepath.Join(dir, CAKeyFile))
+	if err != nil {
+		return nil, err
+	}
+	if !pair.Cert.IsCA {
+		return nil, fmt.Errorf("%s is not a CA certificate", CACertFile)
+	}
+	return &CA{pair: pair}, nil
+}
+
+// Save writes the CA to the certificate directory dir.
+func (c *CA) Save(dir string) error {
+	return SaveKeyPair(filepath.Join(dir, CACertFile), filepath.Join(dir, CAKeyFile), c.pair)
+}
+
+// Cert returns the certificate of the CA.
+func (c *CA) Cert() *x509.Certificate {
+	return c.pair.Cert
+}
+
+// Issue returns a certificate with a new key for the subject of the request, valid for validity from now.
+func (c *CA) Issue(request Request, validity time.Duration, now time.Time) (KeyPair, error) {
+	key, err := newKey()
+	if err != nil {
+		return KeyPair{}, err
+	}
+	subject := pkix.Name{CommonName: request.CommonName}
+	if request.Organization != "" {
+		subject.Organization = []string{request.Organization}
+	}
+	template, err := newTemplate(subject, validity, now)
+	if err != nil {
+		return KeyPair{}, err
+	}
+	template.DNSNames = request.DNSNames
+	template.IPAddresses = request.IPAddresses
+	template.EmailAddresses = request.EmailAddresses
+	template.URIs = request.URIs
+	template.KeyUsage = x509.KeyUsageDigitalSignature
+	template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+	if request.Server {
+		template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth}
+	}
+
+	cert, err := createCertificate(template, c.pair.Cert, key.Public(), c.pair.Key)
+	if err != nil {
+		return KeyPair{}, err
+	}
+	return KeyPair{Cert: cert, Key: key}, nil
+}
+
+// Renew returns a certificate like that of pair, with the same key and for as long as the original was valid, from
+// now. Keeping the key keeps the pins of the certificate valid, see quichelper.ServerVerification. The CA renews its
+// own certificate if pair is its own, and the certificates it has issued stay valid, as they are signed by the same
+// key.
+func (c *CA) Renew(pair KeyPair, now time.Time) (KeyPair, error) {
+	if !c.Issued(pair.Cert) {
+		return KeyPair{}, fmt.Errorf("'%s' is not issued by this CA", pair.Cert.Subject.String())
+	}
+	old := pair.Cert
+	template, err := newTemplate(old.Subject, old.NotAfter.Sub(old.NotBefore)-clockSkew, now)
+	if err != nil {
+		return KeyPair{}, err
+	}
+	template.DNSNames = old.DNSNames
+	template.IPAddresses = old.IPAddresses
+	template.EmailAddresses = old.EmailAddresses
+	template.URIs = old.URIs
+	template.KeyUsage = old.KeyUsage
+	template.ExtKeyUsage = old.ExtKeyUsage
+	template.IsCA = old.IsCA
+	template.BasicConstraintsValid = old.BasicConstraintsValid
+	template.SubjectKeyId = old.SubjectKeyId
+
+	parent := c.pair.Cert
+	if old.Equal(c.pair.Cert) {
+		parent = template // Self-signed
+	}
+	cert, err := createCertificate(template, parent, pair.Key.Public(), c.pair.Key)
+	if err != nil {
+		return KeyPair{}, err
+	}
+	renewed := KeyPair{Cert: cert, Key: pair.Key}
+	if old.Equal(c.pair.Cert) {
+		c.pair = renewed
+	}
+	return renewed, nil
+}
+
+// RenewExpiring renews the certificates in the certificate directory dir that the CA has issued, its own first, which
+// expire within the duration from now, see Renew, and returns the paths of those renewed. Other certificates, and
+// those without their key in dir, are skipped.
+func (c *CA) RenewExpiring(dir string, within time.Duration, now time.Time) ([]string, error) {
+	certPaths, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return nil, errors.Wrap(err, "filepath.Glob")
+	}
+	caCertPath := filepath.Join(dir, CACertFile)
+	sort.SliceStable(certPaths, func(i, j int) bool {
+		return certPaths[i] == caCertPath && certPaths[j] != caCertPath
+	})
+
+	var renewed []string
+	for _, certPath := range certPaths {
+		pair, err := LoadKeyPair(certPath, KeyPathOf(certPath))
+		if err != nil || !c.Issued(pair.Cert) || pair.Cert.NotAfter.Sub(now) > within {
+			continue
+		}
+		pair, err = c.Renew(pair, now)
+		if err != nil {
+			return renewed, errors.Wrap(err, certPath)
+		}
+		if err := SaveKeyPair(certPath, KeyPathOf(certPath), pair); err != nil {
+			return renewed, errors.Wrap(err, "SaveKeyPair")
+		}
+		renewed = append(renewed, certPath)
+	}
+	return renewed, nil
+}
+
+// Issued returns whether cert is signed by the CA, which includes its own certificate.
+func (c *CA) Issued(cert *x509.Certificate) bool {
+	return cert.CheckSignatureFrom(c.pair.Cert) == nil
+}
+
+// ParseHosts splits host names and IP addresses for Request.DNSNames and Request.IPAddresses.
+func ParseHosts(hosts []string) (dnsNames []string, ipAddresses []net.IP) {
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			ipAddresses = append(ipAddresses, ip)
+		} else {
+			dnsNames = append(dnsNames, host)
+		}
+	}
+	return dnsNames, ipAddresses
+}
+
+// KeyPathOf returns the path of the key of the certificate at certPath in a certificate directory.
+func KeyPathOf(certPath string) string {
+	dir, file := filepath.Split(certPath)
+	switch file {
+	case CACertFile:
+		return filepath.Join(dir, CAKeyFile)
+	case ServerCertFile:
+		return filepath.Join(dir, ServerKeyFile)
+	default:
+		return filepath.Join(dir, strings.TrimSuffix(file, ".pem")+".key")
+	}
+}
+
+// PinSHA256 returns the base64 SHA-256 hash of the public key of cert, as clients take it with -pin-sha256.
+func PinSHA256(cert *x509.Certificate) string {
+	hash := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return base64.StdEncoding.EncodeToString(hash[:])
+}
+
+// LoadKeyPair reads a certificate and its key from PEM files.
+func LoadKeyPair(certPath string, keyPath string) (KeyPair, error) {
+	certPEM, err := os.ReadFile(certPath)
+	if err != nil {
+		return KeyPair{}, errors.Wrap(err, "os.ReadFile")
+	}
+	block, _ := pem.Decode(certPEM)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return KeyPair{}, fmt.Errorf("no PEM certificate in %s", certPath)
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return KeyPair{}, errors.Wrap(err, "x509.ParseCertificate")
+	}
+
+	keyPEM, err := os.ReadFile(keyPath)
+	if err != nil {
+		return KeyPair{}, errors.Wrap(err, "os.ReadFile")
+	}
+	block, _ = pem.Decode(keyPEM)
+	if block == nil {
+		return KeyPair{}, fmt.Errorf("no PEM key in %s", keyPath)
+	}
+	key, err := parsePrivateKey(block)
+	if err != nil {
+		return KeyPair{}, err
+	}
+	if public, ok := key.Public().(interface{ Equal(crypto.PublicKey) bool }); !ok || !public.Equal(cert.PublicKey) {
+		return KeyPair{}, fmt.Errorf("the key in %s does not match the certificate in %s", keyPath, certPath)
+	}
+	return KeyPair{Cert: cert, Key: key}, nil
+}
+
+// SaveKeyPair writes a certificate and its key to PEM files, the key readable by the owner only. The certificate
+// is written last, so that a server reloading the pair does not see the new certificate with the old key.
+func SaveKeyPair(certPath string, keyPath string, pair KeyPair) error {
+	keyDER, err := x509.MarshalPKCS8PrivateKey(pair.Key)
+	if err != nil {
+		return errors.Wrap(err, "x509.MarshalPKCS8PrivateKey")
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})
+	if err := writeFile(keyPath, keyPEM, 0600); err != nil {
+		return err
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: pair.Cert.Raw})
+	return writeFile(certPath, certPEM, 0644)
+}
+
+// writeFile replaces the file at path atomically, by renaming a temporary file over it.
+func writeFile(path string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return errors.Wrap(err, "os.CreateTemp")
+	}
+	defer os.Remove(tmp.Name()) // Fails once renamed
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return errors.Wrap(err, "Write")
+	}
+	if err := tmp.Close(); err != nil {
+		return errors.Wrap(err, "Close")
+	}
+	if err := os.Chmod(tmp.Name(), perm); err != nil {
+		return errors.Wrap(err, "os.Chmod")
+	}
+	return errors.Wrap(os.Rename(tmp.Name(), path), "os.Rename")
+}
+
+func parsePrivateKey(block *pem.Block) (crypto.Signer, error) {
+	var key any
+	var err error
+	switch block.Type {
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unexpected PEM block %s", block.Type)
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "parse private key")
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported private key %T", key)
+	}
+	return signer, nil
+}
+
+func newKey() (crypto.Signer, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, errors.Wrap(err, "ecdsa.GenerateKey")
+	}
+	return key, nil
+}
+
+// newTemplate returns a template of a certificate with a random serial number, valid for validity from now.
+func newTemplate(subject pkix.Name, validity time.Duration, now time.Time) (*x509.Certificate, error) {
+	if validity <= 0 {
+		return nil, errors.New("validity <= 0")
+	}
+	serialNumber, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, errors.Wrap(err, "rand.Int")
+	}
+	return &x509.Certificate{
+		SerialNumber: serialNumber,
+		Subject:      subject,
+		NotBefore:    now.Add(-clockSkew),
+		NotAfter:     now.Add(validity),
+	}, nil
+}
+
+func createCertificate(
+	template *x509.Certificate,
+	parent *x509.Certificate,
+	pub crypto.PublicKey,
+	key crypto.Signer,
+) (*x509.Certificate, error) {
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, pub, key)
+	if err != nil {
+		return nil, errors.Wrap(err, "x509.CreateCertificate")
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, errors.Wrap(err, "x509.ParseCertificate")
+	}
+	return cert, nil
+}
@@ -0,0 +1,176 @@
+package ca_test
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	. "github.com/onsi/gomega"
+	"github.com/varfrog/quicpubsub/pkg/ca"
+	"github.com/varfrog/quicpubsub/pkg/quichelper"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const day = time.Hour * 24
+
+func TestCA_Issue(t *testing.T) {
+	now := time.Now()
+	dir := t.TempDir()
+
+	certAuthority, err := ca.New("Test CA", day*3650, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := certAuthority.Save(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("Server certificate in the layout of the server and clients", func(t *testing.T) {
+		g := NewWithT(t)
+
+		dnsNames, ips := ca.ParseHosts([]string{"localhost", "127.0.0.1", "::1"})
+		g.Expect(dnsNames).To(Equal([]string{"localhost"}))
+		g.Expect(ips).To(HaveLen(2))
+
+		pair, err := certAuthority.Issue(ca.Request{CommonName: "server", DNSNames: dnsNames, IPAddresses: ips,
+			Server: true}, day*365, now)
+		g.Expect(err).NotTo(HaveOccurred())
+		certPath := filepath.Join(dir, ca.ServerCertFile)
+		g.Expect(ca.SaveKeyPair(certPath, ca.KeyPathOf(certPath), pair)).To(Succeed())
+
+		// As the server loads it with -cert and -key
+		_, err = tls.LoadX509KeyPair(certPath, filepath.Join(dir, ca.ServerKeyFile))
+		g.Expect(err).NotTo(HaveOccurred())
+
+		// As clients verify it
+		roots, err := quichelper.GetRootCertPool(dir)
+		g.Expect(err).NotTo(HaveOccurred())
+		for _, name := range []string{"localhost", "127.0.0.1", "::1"} {
+			_, err = pair.Cert.Verify(x509.VerifyOptions{Roots: roots, DNSName: name, CurrentTime: now})
+			g.Expect(err).NotTo(HaveOccurred(), name)
+		}
+
+		// As other servers verify it as a client
+		_, err = pair.Cert.Verify(x509.VerifyOptions{Roots: roots, CurrentTime: now,
+			KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}})
+		g.Expect(err).NotTo(HaveOccurred())
+	})
+
+	t.Run("Client certificate with identity fields", func(t *testing.T) {
+		g := NewWithT(t)
+
+		uri, _ := url.Parse("spiffe://example.com/alice")
+		pair, err := certAuthority.Issue(ca.Request{CommonName: "alice", Organization: "team-a",
+			URIs: []*url.URL{uri}, EmailAddresses: []string{"alice@example.com"}}, day*365, now)
+		g.Expect(err).NotTo(HaveOccurred())
+		g.Expect(pair.Cert.Subject.CommonName).To(Equal("alice"))
+		g.Expect(pair.Cert.Subject.Organization).To(Equal([]string{"team-a"}))
+		g.Expect(pair.Cert.URIs).To(Equal([]*url.URL{uri}))
+		g.Expect(pair.Cert.EmailAddresses).To(Equal([]string{"alice@example.com"}))
+		g.Expect(pair.Cert.ExtKeyUsage).To(Equal([]x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}))
+
+		certPath := filepath.Join(dir, "alice.pem")
+		g.Expect(ca.KeyPathOf(certPath)).To(Equal(filepath.Join(dir, "alice.key")))
+		g.Expect(ca.SaveKeyPair(certPath, ca.KeyPathOf(certPath), pair)).To(Succeed())
+		info, err := os.Stat(ca.KeyPathOf(certPath))
+		g.Expect(err).NotTo(HaveOccurred())
+		g.Expect(info.Mode().Perm()).To(Equal(os.FileMode(0600)))
+
+		roots, _ := quichelper.LoadCertPool(filepath.Join(dir, ca.CACertFile))
+		_, err = pair.Cert.Verify(x509.VerifyOptions{Roots: roots, CurrentTime: now,
+			KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}})
+		g.Expect(err).NotTo(HaveOccurred())
+		_, err = pair.Cert.Verify(x509.VerifyOptions{Roots: roots, CurrentTime: now})
+		g.Expect(err).To(HaveOccurred(), "not valid for servers")
+	})
+
+	t.Run("Loaded CA", func(t *testing.T) {
+		g := NewWithT(t)
+
+		loaded, err := ca.Load(dir)
+		g.Expect(err).NotTo(HaveOccurred())
+		g.Expect(loaded.Cert().Equal(certAuthority.Cert())).To(BeTrue())
+
+		_, err = ca.Load(t.TempDir())
+		g.Expect(err).To(MatchError(os.ErrNotExist))
+	})
+}
+
+func TestCA_RenewExpiring(t *testing.T) {
+	g := NewWithT(t)
+
+	issuedAt := time.Now().Add(-day * 340)
+	now := time.Now()
+	dir := t.TempDir()
+
+	certAuthority, err := ca.New("Test CA", day*360, issuedAt)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(certAuthority.Save(dir)).To(Succeed())
+
+	issue := func(name string, validity time.Duration) ca.KeyPair {
+		pair, err := certAuthority.Issue(ca.Request{CommonName: name, IPAddresses: []net.IP{net.IPv4(127, 0, 0, 1)},
+			Server: true}, validity, issuedAt)
+		g.Expect(err).NotTo(HaveOccurred())
+		certPath := filepath.Join(dir, name+".pem")
+		g.Expect(ca.SaveKeyPair(certPath, ca.KeyPathOf(certPath), pair)).To(Succeed())
+		return pair
+	}
+	expiring := issue("expiring", day*350)
+	issue("lasting", day*700)
+
+	// A certificate of another CA is skipped
+	other, err := ca.New("Other CA", day*10, issuedAt)
+	g.Expect(err).NotTo(HaveOccurred())
+	foreign, err := other.Issue(ca.Request{CommonName: "foreign"}, day*1, issuedAt)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(ca.SaveKeyPair(filepath.Join(dir, "foreign.pem"), filepath.Join(dir, "foreign.key"), foreign)).
+		To(Succeed())
+
+	renewed, err := certAuthority.RenewExpiring(dir, day*30, now)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(renewed).To(Equal([]string{filepath.Join(dir, ca.CACertFile), filepath.Join(dir, "expiring.pem")}))
+
+	// Renewed with the same key, subject, names and validity period
+	pair, err := ca.LoadKeyPair(filepath.Join(dir, "expiring.pem"), filepath.Join(dir, "expiring.key"))
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(pair.Cert.Subject.CommonName).To(Equal("expiring"))
+	g.Expect(pair.Cert.IPAddresses[0].Equal(net.IPv4(127, 0, 0, 1))).To(BeTrue())
+	g.Expect(pair.Cert.NotAfter.Sub(now)).To(BeNumerically("~", day*350, time.Minute))
+	g.Expect(ca.PinSHA256(pair.Cert)).To(Equal(ca.PinSHA256(expiring.Cert)))
+
+	// The certificates issued before the CA was renewed are valid with the renewed CA
+	roots, err := quichelper.GetRootCertPool(dir)
+	g.Expect(err).NotTo(HaveOccurred())
+	lasting, err := ca.LoadKeyPair(filepath.Join(dir, "lasting.pem"), filepath.Join(dir, "lasting.key"))
+	g.Expect(err).NotTo(HaveOccurred())
+	for _, cert := range []*x509.Certificate{pair.Cert, lasting.Cert} {
+		_, err = cert.Verify(x509.VerifyOptions{Roots: roots, DNSName: "127.0.0.1", CurrentTime: now.Add(day * 60)})
+		g.Expect(err).NotTo(HaveOccurred(), cert.Subject.CommonName)
+	}
+
+	// Nothing expires soon any more
+	renewed, err = certAuthority.RenewExpiring(dir, day*30, now)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(renewed).To(BeEmpty())
+}
+
+func TestLoadKeyPair_MismatchedKey(t *testing.T) {
+	g := NewWithT(t)
+
+	now := time.Now()
+	dir := t.TempDir()
+	certAuthority, err := ca.New("Test CA", day, now)
+	g.Expect(err).NotTo(HaveOccurred())
+	a, err := certAuthority.Issue(ca.Request{CommonName: "a"}, day, now)
+	g.Expect(err).NotTo(HaveOccurred())
+	b, err := certAuthority.Issue(ca.Request{CommonName: "b"}, day, now)
+	g.Expect(err).NotTo(HaveOccurred())
+
+	certPath, keyPath := filepath.Join(dir, "a.pem"), filepath.Join(dir, "a.key")
+	g.Expect(ca.SaveKeyPair(certPath, keyPath, ca.KeyPair{Cert: a.Cert, Key: b.Key})).To(Succeed())
+	_, err = ca.LoadKeyPair(certPath, keyPath)
+	g.Expect(err).To(MatchError(ContainSubstring("does not match")))
+}
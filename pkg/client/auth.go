@@ -0,0 +1,37 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/quic-go/quic-go"
+	"github.com/varfrog/quicpubsub/pkg/quichelper"
+	"github.com/varfrog/quicpubsub/pkg/sdk"
+)
+
+// authenticate presents the token to the server on a stream opened before any other and waits for the server to
+// accept it, see sdk.ErrorCodeUnauthenticated. Returns a RefusedError if the server refuses the token.
+func authenticate(ctx context.Context, conn quic.Connection, token string, maxMessageBytes int) error {
+	stream, err := conn.OpenStreamSync(ctx)
+	if err != nil {
+		return errors.Wrap(err, "OpenStreamSync")
+	}
+	if err := quichelper.SendEvent(stream, sdk.Event{Code: sdk.CodeAuthenticate, Token: token}); err != nil {
+		return errors.Wrap(err, "SendEvent")
+	}
+	if err := stream.Close(); err != nil {
+		return errors.Wrap(err, "stream.Close")
+	}
+
+	event, err := quichelper.ReceiveStreamEvent(stream, uint64(maxMessageBytes))
+	if err != nil {
+		if code, reason, ok := quichelper.RefusalReason(err); ok {
+			return RefusedError{Code: code, Reason: reason}
+		}
+		return errors.Wrap(err, "ReceiveStreamEvent")
+	}
+	if event.Code != sdk.CodeAuthenticated {
+		return fmt.Errorf("unexpected event '%s' in response to the token", event.Code)
+	}
+	return nil
+}
@@ -0,0 +1,47 @@
+// Package client contains the QUIC transports with which publishers and subscribers connect to the server, shared by
+// the publisher and subscriber apps, and by the server when it bridges to another server.
+package client
+
+import (
+	"context"
+	"github.com/varfrog/quicpubsub/pkg/sdk"
+	"time"
+)
+
+//go:generate mockgen -source client.go -destination mocks/client.go
+
+// MessageRecipient describes a recipient to send messages to.
+type MessageRecipient interface {
+	SendMessageToRecipient(message sdk.Message) error
+}
+
+// MessageSender sends messages to a recipient for QUICPublisher.
+type MessageSender interface {
+	// StartLoop sends messages to the recipient while the last value received on sendMessagesCh is true, i.e.
+	// while the server has subscribers. It notifies failCh on failure, and returns once ctx is done.
+	StartLoop(ctx context.Context, recipient MessageRecipient, sendMessagesCh <-chan bool, failCh chan<- error)
+}
+
+// SlowDownHandler is implemented by MessageSenders that back off when the server tells the publisher to slow down,
+// as it exceeds its rate limit.
+type SlowDownHandler interface {
+	// SlowDown is called when the server drops the messages of the publisher for retryAfter, during which messages
+	// should not be sent.
+	SlowDown(retryAfter time.Duration)
+}
+
+// MessageHandler handles messages that QUICSubscriber receives. The payload of a message is only valid during
+// HandleMessage, as its buffer is reused for later messages, so it must be copied to be kept.
+type MessageHandler interface {
+	HandleMessage(message sdk.Message) error
+}
+
+// DemandSource tells QUICSubscriber whether messages are wanted, for subscribers that only want messages at times,
+// e.g. ones that forward messages elsewhere.
+type DemandSource interface {
+	// HasDemand returns whether messages are wanted.
+	HasDemand() bool
+
+	// DemandChanged returns a channel that receives a value whenever HasDemand may have changed.
+	DemandChanged() <-chan struct{}
+}
@@ -0,0 +1,43 @@
+package client
+
+import (
+	"context"
+	"go.uber.org/zap"
+	"time"
+)
+
+// RunWithFailover calls run with each of the server addresses in turn, moving on to the next one whenever run
+// returns, e.g. because the server has gone away, until ctx is done. It waits retryInterval after each round of
+// addresses. With a single address, run is called once.
+func RunWithFailover(
+	ctx context.Context,
+	addrs []string,
+	retryInterval time.Duration,
+	logger *zap.Logger,
+	run func(ctx context.Context, addr string) error,
+) error {
+	if len(addrs) == 1 {
+		return run(ctx, addrs[0])
+	}
+
+	for i := 0; ; i++ {
+		addr := addrs[i%len(addrs)]
+		if err := run(ctx, addr); err != nil {
+			logger.Warn("Connection to the server failed", zap.String("addr", addr), zap.Error(err))
+		}
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		wait := time.Duration(0)
+		if (i+1)%len(addrs) == 0 {
+			wait = retryInterval
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(wait):
+			logger.Info("Failing over to the next server", zap.String("addr", addrs[(i+1)%len(addrs)]))
+		}
+	}
+}
@@ -0,0 +1,210 @@
+// Code generated by MockGen. DO NOT EDIT.
+// Source: client.go
+
+// Package mock_client is a generated GoMock package.
+package mock_client
+
+import (
+	context "context"
+	reflect "reflect"
+	time "time"
+
+	client "github.com/varfrog/quicpubsub/pkg/client"
+	sdk "github.com/varfrog/quicpubsub/pkg/sdk"
+	gomock "go.uber.org/mock/gomock"
+)
+
+// MockMessageRecipient is a mock of MessageRecipient interface.
+type MockMessageRecipient struct {
+	ctrl     *gomock.Controller
+	recorder *MockMessageRecipientMockRecorder
+}
+
+// MockMessageRecipientMockRecorder is the mock recorder for MockMessageRecipient.
+type MockMessageRecipientMockRecorder struct {
+	mock *MockMessageRecipient
+}
+
+// NewMockMessageRecipient creates a new mock instance.
+func NewMockMessageRecipient(ctrl *gomock.Controller) *MockMessageRecipient {
+	mock := &MockMessageRecipient{ctrl: ctrl}
+	mock.recorder = &MockMessageRecipientMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockMessageRecipient) EXPECT() *MockMessageRecipientMockRecorder {
+	return m.recorder
+}
+
+// SendMessageToRecipient mocks base method.
+func (m *MockMessageRecipient) SendMessageToRecipient(message sdk.Message) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SendMessageToRecipient", message)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SendMessageToRecipient indicates an expected call of SendMessageToRecipient.
+func (mr *MockMessageRecipientMockRecorder) SendMessageToRecipient(message interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SendMessageToRecipient", reflect.TypeOf((*MockMessageRecipient)(nil).SendMessageToRecipient), message)
+}
+
+// MockMessageSender is a mock of MessageSender interface.
+type MockMessageSender struct {
+	ctrl     *gomock.Controller
+	recorder *MockMessageSenderMockRecorder
+}
+
+// MockMessageSenderMockRecorder is the mock recorder for MockMessageSender.
+type MockMessageSenderMockRecorder struct {
+	mock *MockMessageSender
+}
+
+// NewMockMessageSender creates a new mock instance.
+func NewMockMessageSender(ctrl *gomock.Controller) *MockMessageSender {
+	mock := &MockMessageSender{ctrl: ctrl}
+	mock.recorder = &MockMessageSenderMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockMessageSender) EXPECT() *MockMessageSenderMockRecorder {
+	return m.recorder
+}
+
+// StartLoop mocks base method.
+func (m *MockMessageSender) StartLoop(ctx context.Context, recipient client.MessageRecipient, sendMessagesCh <-chan bool, failCh chan<- error) {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "StartLoop", ctx, recipient, sendMessagesCh, failCh)
+}
+
+// StartLoop indicates an expected call of StartLoop.
+func (mr *MockMessageSenderMockRecorder) StartLoop(ctx, recipient, sendMessagesCh, failCh interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "StartLoop", reflect.TypeOf((*MockMessageSender)(nil).StartLoop), ctx, recipient, sendMessagesCh, failCh)
+}
+
+// MockSlowDownHandler is a mock of SlowDownHandler interface.
+type MockSlowDownHandler struct {
+	ctrl     *gomock.Controller
+	recorder *MockSlowDownHandlerMockRecorder
+}
+
+// MockSlowDownHandlerMockRecorder is the mock recorder for MockSlowDownHandler.
+type MockSlowDownHandlerMockRecorder struct {
+	mock *MockSlowDownHandler
+}
+
+// NewMockSlowDownHandler creates a new mock instance.
+func NewMockSlowDownHandler(ctrl *gomock.Controller) *MockSlowDownHandler {
+	mock := &MockSlowDownHandler{ctrl: ctrl}
+	mock.recorder = &MockSlowDownHandlerMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockSlowDownHandler) EXPECT() *MockSlowDownHandlerMockRecorder {
+	return m.recorder
+}
+
+// SlowDown mocks base method.
+func (m *MockSlowDownHandler) SlowDown(retryAfter time.Duration) {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "SlowDown", retryAfter)
+}
+
+// SlowDown indicates an expected call of SlowDown.
+func (mr *MockSlowDownHandlerMockRecorder) SlowDown(retryAfter interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SlowDown", reflect.TypeOf((*MockSlowDownHandler)(nil).SlowDown), retryAfter)
+}
+
+// MockMessageHandler is a mock of MessageHandler interface.
+type MockMessageHandler struct {
+	ctrl     *gomock.Controller
+	recorder *MockMessageHandlerMockRecorder
+}
+
+// MockMessageHandlerMockRecorder is the mock recorder for MockMessageHandler.
+type MockMessageHandlerMockRecorder struct {
+	mock *MockMessageHandler
+}
+
+// NewMockMessageHandler creates a new mock instance.
+func NewMockMessageHandler(ctrl *gomock.Controller) *MockMessageHandler {
+	mock := &MockMessageHandler{ctrl: ctrl}
+	mock.recorder = &MockMessageHandlerMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockMessageHandler) EXPECT() *MockMessageHandlerMockRecorder {
+	return m.recorder
+}
+
+// HandleMessage mocks base method.
+func (m *MockMessageHandler) HandleMessage(message sdk.Message) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "HandleMessage", message)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// HandleMessage indicates an expected call of HandleMessage.
+func (mr *MockMessageHandlerMockRecorder) HandleMessage(message interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "HandleMessage", reflect.TypeOf((*MockMessageHandler)(nil).HandleMessage), message)
+}
+
+// MockDemandSource is a mock of DemandSource interface.
+type MockDemandSource struct {
+	ctrl     *gomock.Controller
+	recorder *MockDemandSourceMockRecorder
+}
+
+// MockDemandSourceMockRecorder is the mock recorder for MockDemandSource.
+type MockDemandSourceMockRecorder struct {
+	mock *MockDemandSource
+}
+
+// NewMockDemandSource creates a new mock instance.
+func NewMockDemandSource(ctrl *gomock.Controller) *MockDemandSource {
+	mock := &MockDemandSource{ctrl: ctrl}
+	mock.recorder = &MockDemandSourceMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockDemandSource) EXPECT() *MockDemandSourceMockRecorder {
+	return m.recorder
+}
+
+// DemandChanged mocks base method.
+func (m *MockDemandSource) DemandChanged() <-chan struct{} {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DemandChanged")
+	ret0, _ := ret[0].(<-chan struct{})
+	return ret0
+}
+
+// DemandChanged indicates an expected call of DemandChanged.
+func (mr *MockDemandSourceMockRecorder) DemandChanged() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DemandChanged", reflect.TypeOf((*MockDemandSource)(nil).DemandChanged))
+}
+
+// HasDemand mocks base method.
+func (m *MockDemandSource) HasDemand() bool {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "HasDemand")
+	ret0, _ := ret[0].(bool)
+	return ret0
+}
+
+// HasDemand indicates an expected call of HasDemand.
+func (mr *MockDemandSourceMockRecorder) HasDemand() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "HasDemand", reflect.TypeOf((*MockDemandSource)(nil).HasDemand))
+}
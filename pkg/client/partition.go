@@ -0,0 +1,173 @@
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/quic-go/quic-go"
+	"github.com/varfrog/quicpubsub/pkg/partition"
+	"github.com/varfrog/quicpubsub/pkg/quichelper"
+	"github.com/varfrog/quicpubsub/pkg/sdk"
+	"go.uber.org/zap"
+	"io"
+	"time"
+)
+
+// maxPartitionMapBytes limits the size of a partition map received from a server.
+const maxPartitionMapBytes = 1 << 20
+
+// alertNoApplicationProtocol is the TLS alert with which a server that is not partitioned refuses
+// sdk.ALPNPartitionMap.
+const alertNoApplicationProtocol = 120
+
+// maxRedirects is the number of redirects in a row after which RunPartitioned fetches the partition map again
+// rather than following further redirects, as servers that redirect in a loop disagree about the map.
+const maxRedirects = 3
+
+// RedirectError is returned by QUICPublisher.Run and QUICSubscriber.Run when the server does not own the partition
+// of the key.
+type RedirectError struct {
+	Addr string // Address of the server that owns the partition
+}
+
+func (e RedirectError) Error() string {
+	return fmt.Sprintf("redirected to %s, the owner of the partition", e.Addr)
+}
+
+// redirectError returns a RedirectError if the server closed the connection with sdk.ErrorCodeWrongPartition.
+// The connection must be closed.
+func redirectError(conn quic.Connection) error {
+	if addr, ok := quichelper.RedirectAddr(context.Cause(conn.Context())); ok {
+		return RedirectError{Addr: addr}
+	}
+	return nil
+}
+
+// FetchPartitionMap gets the partition map from the server at addr, which can be any server of a partitioned
+// cluster.
+func FetchPartitionMap(ctx context.Context, addr string, tlsConfig *tls.Config) (partition.Map, error) {
+	tlsConfig = tlsConfig.Clone()
+	tlsConfig.NextProtos = []string{sdk.ALPNPartitionMap}
+
+	conn, err := quichelper.DialAddr(ctx, addr, tlsConfig, &quic.Config{})
+	if err != nil {
+		return partition.Map{}, errors.Wrap(err, "quichelper.DialAddr")
+	}
+	defer func() {
+		_ = conn.CloseWithError(sdk.ErrorCodeNoError, "got the partition map") // Never fails
+	}()
+
+	stream, err := conn.AcceptUniStream(ctx)
+	if err != nil {
+		return partition.Map{}, errors.Wrap(err, "AcceptUniStream")
+	}
+	data, err := io.ReadAll(io.LimitReader(stream, maxPartitionMapBytes))
+	if err != nil {
+		return partition.Map{}, errors.Wrap(err, "io.ReadAll")
+	}
+
+	var m partition.Map
+	if err := json.Unmarshal(data, &m); err != nil {
+		return partition.Map{}, quichelper.UnmarshalError{Data: data, Err: err}
+	}
+	if err := m.Validate(); err != nil {
+		return partition.Map{}, errors.Wrap(err, "invalid partition map")
+	}
+	return m, nil
+}
+
+// RunPartitioned calls run with the address of the server that owns the partition of key, which it learns from
+// the partition map of the first of the server addresses that responds, until ctx is done. If the server redirects
+// to another one, run is called with that one right away. Whenever run returns otherwise, e.g. because the server
+// has gone away, the map is fetched again after retryInterval.
+func RunPartitioned(
+	ctx context.Context,
+	addrs []string,
+	key string,
+	tlsConfig *tls.Config,
+	retryInterval time.Duration,
+	logger *zap.Logger,
+	run func(ctx context.Context, addr string) error,
+) error {
+	var (
+		addr      string // Owner of the partition, empty until the map is fetched
+		redirects int
+	)
+	for ctx.Err() == nil {
+		if addr == "" {
+			m, err := fetchPartitionMapFromAny(ctx, addrs, tlsConfig, logger)
+			if err != nil {
+				logger.Warn("Cannot get the partition map", zap.Error(err))
+				sleep(ctx, retryInterval)
+				continue
+			}
+			addr = m.Owner(key)
+			logger.Info("Got the partition map",
+				zap.String("key", key),
+				zap.Int("partition", partition.Of(key, m.Partitions)),
+				zap.String("owner", addr))
+		}
+
+		err := run(ctx, addr)
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		var redirectErr RedirectError
+		if errors.As(err, &redirectErr) && redirects < maxRedirects {
+			logger.Info("Redirected to the owner of the partition", zap.String("from", addr), zap.String("to", redirectErr.Addr))
+			addr = redirectErr.Addr
+			redirects++
+			continue
+		}
+		if err != nil {
+			logger.Warn("Connection to the server failed", zap.String("addr", addr), zap.Error(err))
+		}
+
+		// The owner may have gone away and the partitions moved elsewhere
+		addr = ""
+		redirects = 0
+		sleep(ctx, retryInterval)
+	}
+	return nil
+}
+
+// fetchPartitionMapFromAny gets the partition map from the first of addrs that responds. A server that is not
+// partitioned owns every key, so its map has only that server.
+func fetchPartitionMapFromAny(
+	ctx context.Context,
+	addrs []string,
+	tlsConfig *tls.Config,
+	logger *zap.Logger,
+) (partition.Map, error) {
+	var lastErr error
+	for _, addr := range addrs {
+		m, err := FetchPartitionMap(ctx, addr, tlsConfig)
+		if err == nil {
+			return m, nil
+		}
+		if isUnpartitioned(err) {
+			return partition.NewMap([]string{addr}, 1, partition.DefaultVirtualNodes)
+		}
+		logger.Debug("FetchPartitionMap", zap.String("addr", addr), zap.Error(err))
+		lastErr = err
+	}
+	return partition.Map{}, errors.Wrap(lastErr, "no server returned the partition map")
+}
+
+// isUnpartitioned returns whether err is caused by the server refusing sdk.ALPNPartitionMap as it is not partitioned.
+func isUnpartitioned(err error) bool {
+	var transportErr *quic.TransportError
+	return errors.As(err, &transportErr) && transportErr.Remote &&
+		transportErr.ErrorCode == quic.TransportErrorCode(0x100+alertNoApplicationProtocol)
+}
+
+// sleep waits for d or until ctx is done.
+func sleep(ctx context.Context, d time.Duration) {
+	select {
+	case <-ctx.Done():
+	case <-time.After(d):
+	}
+}
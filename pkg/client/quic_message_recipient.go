@@ -0,0 +1,128 @@
+package client
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/quic-go/quic-go"
+	"github.com/varfrog/quicpubsub/pkg/encryption"
+	"github.com/varfrog/quicpubsub/pkg/quichelper"
+	"github.com/varfrog/quicpubsub/pkg/sdk"
+	"github.com/varfrog/quicpubsub/pkg/signing"
+	"github.com/varfrog/quicpubsub/pkg/tracing"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// QUICMessageRecipient implements MessageRecipient.
+type QUICMessageRecipient struct {
+	stream    quic.SendStream
+	key       string
+	encrypter *encryption.Encrypter
+	signer    *signing.Signer
+	tracer    *tracing.Tracer
+	idPrefix  string        // Random, which the sequence number of a message is appended to for its ID
+	sent      atomic.Uint64 // Sequence number of the last message
+}
+
+var _ MessageRecipient = (*QUICMessageRecipient)(nil)
+
+// NewQUICMessageRecipient is the constructor for QUICMessageRecipient. Messages without an sdk.HeaderMessageID get a
+// unique one, so that bridged servers drop the copies that reach them over several paths. If key is not empty, it is
+// set as the sdk.HeaderKey of messages that do not have a key. If encrypter is not nil, payloads are encrypted with it,
+// after the key is set. If signer is not nil, messages are signed with it, after they are encrypted, so that
+// subscribers verify them before decrypting them. If tracer is not nil, a span is recorded per message, continuing the
+// trace of the sdk.HeaderTraceparent of the message if it has one, and the message is sent with the trace context of
+// the span.
+func NewQUICMessageRecipient(
+	stream quic.SendStream,
+	key string,
+	encrypter *encryption.Encrypter,
+	signer *signing.Signer,
+	tracer *tracing.Tracer,
+) *QUICMessageRecipient {
+	return &QUICMessageRecipient{
+		stream:    stream,
+		key:       key,
+		encrypter: encrypter,
+		signer:    signer,
+		tracer:    tracer,
+		idPrefix:  newMessageIDPrefix(),
+	}
+}
+
+func (s *QUICMessageRecipient) SendMessageToRecipient(message sdk.Message) error {
+	span := s.startSpan(message)
+	err := s.send(span, message)
+	span.SetError(err)
+	span.End()
+	return err
+}
+
+func (s *QUICMessageRecipient) send(span *tracing.Span, message sdk.Message) error {
+	if message.Headers[sdk.HeaderMessageID] == "" {
+		message = withHeader(message, sdk.HeaderMessageID, s.idPrefix+strconv.FormatUint(s.sent.Add(1), 36))
+	}
+	if s.key != "" && message.Headers[sdk.HeaderKey] == "" {
+		message = withHeader(message, sdk.HeaderKey, s.key)
+	}
+	if span != nil {
+		message = withHeader(message, sdk.HeaderTraceparent, span.Context().Traceparent())
+		span.SetAttribute(tracing.AttributeMessageKey, message.Headers[sdk.HeaderKey])
+	}
+	if s.encrypter != nil {
+		var err error
+		if message, err = s.encrypter.Encrypt(message); err != nil {
+			return errors.Wrap(err, "Encrypt")
+		}
+	}
+	if s.signer != nil {
+		message = s.signer.Sign(message, time.Now())
+	}
+
+	frame, err := quichelper.EncodeMessage(message)
+	if err != nil {
+		return errors.Wrap(err, "EncodeMessage")
+	}
+	span.SetIntAttribute(tracing.AttributeMessageSize, int64(len(frame)))
+
+	writtenBytes, err := s.stream.Write(frame)
+	if err != nil {
+		return errors.Wrap(err, "stream.Write")
+	}
+	if writtenBytes < len(frame) {
+		return fmt.Errorf("written only %d bytes, message length is %d bytes", writtenBytes, len(frame))
+	}
+	return nil
+}
+
+// startSpan starts the span of publishing the message, nil if messages are not traced.
+func (s *QUICMessageRecipient) startSpan(message sdk.Message) *tracing.Span {
+	if s.tracer == nil {
+		return nil
+	}
+	if parent, ok := tracing.FromHeaders(message.Headers); ok {
+		return s.tracer.Start(tracing.SpanPublish, tracing.SpanKindProducer, parent)
+	}
+	return s.tracer.StartTrace(tracing.SpanPublish, tracing.SpanKindProducer)
+}
+
+// withHeader returns a copy of message with the header set, leaving the headers of message intact.
+func withHeader(message sdk.Message, name, value string) sdk.Message {
+	headers := make(map[string]string, len(message.Headers)+1)
+	for k, v := range message.Headers {
+		headers[k] = v
+	}
+	headers[name] = value
+	message.Headers = headers
+	return message
+}
+
+// newMessageIDPrefix returns a random prefix of message IDs, which makes them unique among publishers.
+func newMessageIDPrefix() string {
+	b := make([]byte, 9)
+	_, _ = rand.Read(b) // Never fails on supported platforms
+	return base64.RawURLEncoding.EncodeToString(b) + "-"
+}
@@ -0,0 +1,337 @@
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"github.com/chzyer/logex"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	"github.com/quic-go/quic-go"
+	"github.com/varfrog/quicpubsub/pkg/encryption"
+	"github.com/varfrog/quicpubsub/pkg/quichelper"
+	"github.com/varfrog/quicpubsub/pkg/sdk"
+	"github.com/varfrog/quicpubsub/pkg/signing"
+	"github.com/varfrog/quicpubsub/pkg/tracing"
+	"go.uber.org/zap"
+	"sync/atomic"
+	"time"
+)
+
+type QUICPublisherConfig struct {
+	UUID            uuid.UUID
+	TLSConfig       *tls.Config
+	ServerAddr      string // Server address "host:port", the host is resolved when dialing
+	MaxMessageBytes int
+	Key             string                // Key of the messages for partitioned servers, optional
+	Token           string                // Bearer token to authenticate with, for servers that require one, optional
+	Encrypter       *encryption.Encrypter // Encrypts the payloads of the messages, optional
+	Signer          *signing.Signer       // Signs the messages, after encrypting them, optional
+	Tracer          *tracing.Tracer       // Records a span per message and sends its trace context, optional
+}
+
+// QUICPublisher connects to the server and publishes messages from a MessageSender while the server has subscribers.
+type QUICPublisher struct {
+	config         QUICPublisherConfig
+	quicConfig     quic.Config
+	messageSender  MessageSender
+	pinger         *quichelper.Pinger
+	pingMonitor    atomic.Pointer[quichelper.PingMonitor] // Of the current connection
+	remoteBrokerID atomic.Value                           // string, the ID of the server, once it has sent an event
+	logger         *zap.Logger
+}
+
+func NewQUICPublisher(
+	config QUICPublisherConfig,
+	quicConfig quic.Config,
+	messageSender MessageSender,
+	pinger *quichelper.Pinger,
+	logger *zap.Logger,
+) *QUICPublisher {
+	return &QUICPublisher{
+		config:        config,
+		quicConfig:    quicConfig,
+		messageSender: messageSender,
+		pinger:        pinger,
+		logger:        logger,
+	}
+}
+
+// RemoteBrokerID returns the ID of the server, or an empty string if the server has not told it yet. The server
+// tells it with its first event, i.e. before the first value is sent to the MessageSender.
+func (s *QUICPublisher) RemoteBrokerID() string {
+	id, _ := s.remoteBrokerID.Load().(string)
+	return id
+}
+
+// PingStats returns the quality of the current connection to the server as measured by pings, or of the last one
+// once it is closed. It is zero until Run connects.
+func (s *QUICPublisher) PingStats() quichelper.PingStats {
+	if monitor := s.pingMonitor.Load(); monitor != nil {
+		return monitor.Stats()
+	}
+	return quichelper.PingStats{}
+}
+
+// Run connects to the server and publishes messages until ctx is done, the server goes away or the connection
+// fails. Returns a RedirectError if the server does not own the partition of the key, and a RefusedError if the
+// server refused the connection.
+func (s *QUICPublisher) Run(ctx context.Context) error {
+	// Connect to the server
+	s.logger.Info("Connecting to the server", zap.String("addr", s.config.ServerAddr))
+	conn, err := quichelper.DialAddr(ctx, s.config.ServerAddr, s.config.TLSConfig, &s.quicConfig)
+	if err != nil {
+		return errors.Wrap(err, "quichelper.DialAddr")
+	}
+	s.logger.Info("Connected to the server")
+	if s.config.Token != "" {
+		if err := authenticate(ctx, conn, s.config.Token, s.config.MaxMessageBytes); err != nil {
+			_ = conn.CloseWithError(sdk.ErrorCodeNoError, "authentication failed") // Never fails
+			return errors.Wrap(err, "authenticate")
+		}
+		s.logger.Info("Authenticated")
+	}
+
+	// Create a cancel function for cancelling goroutines created here without cancelling the passed-in ctx
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// Create streams asynchronously and pass them onto the channels once they become available
+	var (
+		eventStreamCh   = make(chan quic.ReceiveStream, 1) // For events from the server
+		messageStreamCh = make(chan quic.SendStream, 1)    // For messages to the server
+		pingStreamCh    = make(chan quic.Stream, 1)
+	)
+	go func() {
+		stream, err := conn.AcceptUniStream(ctx) // Blocking call
+		if err != nil {
+			s.logger.Error("AcceptUniStream", zap.Error(err))
+			cancel()
+			return
+		}
+		s.logger.Info("Event stream ready")
+		eventStreamCh <- stream
+	}()
+	go func() {
+		stream, err := conn.OpenUniStream() // Blocking call
+		if err != nil {
+			s.logger.Error("OpenUniStream", zap.Error(err))
+			cancel()
+			return
+		}
+		s.logger.Info("Message sending stream ready")
+		messageStreamCh <- stream
+
+		// The key stream is opened after the message stream so that the server accepts them in this order
+		if s.config.Key != "" {
+			s.sendKey(conn)
+		}
+	}()
+	go func() {
+		stream, err := conn.OpenStream() // Blocking call
+		if err != nil {
+			s.logger.Error("OpenStream", zap.Error(err))
+			cancel()
+			return
+		}
+		s.logger.Info("Ping stream ready")
+		pingStreamCh <- stream
+	}()
+
+	closeMessageStream := s.serve(ctx, cancel, eventStreamCh, messageStreamCh)
+
+	// Start pinging the server
+	monitor := quichelper.NewLoggingMonitor(s.pinger, s.logger)
+	s.pingMonitor.Store(monitor)
+	go quichelper.SendPings(ctx, s.pinger, monitor, pingStreamCh, cancel, s.logger)
+
+	// Run until we're done
+	<-ctx.Done()
+	logex.Info("Shutting down")
+
+	// Close the message stream once the last message is written, and the connection, so that the server
+	// removes this publisher right away
+	closeMessageStream()
+	if err := conn.CloseWithError(sdk.ErrorCodeNoError, "publisher shutting down"); err != nil {
+		s.logger.Warn("CloseWithError", zap.Error(err))
+	}
+
+	return closeError(conn)
+}
+
+// serve listens for events and sends messages on the streams once they become available, until ctx is done, and
+// calls cancel if either fails. Returns a function that waits until the last message is written once ctx is done,
+// and closes the message stream.
+func (s *QUICPublisher) serve(
+	ctx context.Context,
+	cancel context.CancelFunc,
+	eventStreamCh <-chan quic.ReceiveStream,
+	messageStreamCh <-chan quic.SendStream,
+) func() {
+	var (
+		sendMessagesCh    = make(chan bool)  // stops and resumes message sending
+		sendMessageFailCh = make(chan error) // receives message sending failures
+	)
+
+	// Listen for events
+	go func() {
+		var stream quic.ReceiveStream
+		select {
+		case <-ctx.Done():
+			return
+		case stream = <-eventStreamCh: // Wait for the stream to be available
+		}
+		s.logger.Info("Event stream ready, listening for events")
+		if err := s.listenForEvents(ctx, stream, sendMessagesCh); err != nil {
+			s.logger.Error("listenForEvents", zap.Error(err))
+		}
+		cancel()
+	}()
+
+	// Send messages
+	var (
+		messageStream quic.SendStream
+		senderDone    = make(chan struct{}) // Closed once messages are no longer sent
+	)
+	go func() {
+		defer close(senderDone)
+		select {
+		case <-ctx.Done():
+			return
+		case messageStream = <-messageStreamCh: // Wait until the stream becomes available
+		}
+		s.logger.Info("Message stream ready, listening for events")
+		s.messageSender.StartLoop(
+			ctx,
+			NewQUICMessageRecipient(messageStream, s.config.Key, s.config.Encrypter, s.config.Signer, s.config.Tracer),
+			sendMessagesCh,
+			sendMessageFailCh)
+	}()
+
+	// Monitor for message sending failures
+	go s.monitorMsgSendingFailures(ctx, sendMessageFailCh, cancel)
+
+	return func() {
+		<-senderDone
+		if messageStream != nil {
+			if err := messageStream.Close(); err != nil {
+				s.logger.Warn("Close the message stream", zap.Error(err))
+			}
+		}
+	}
+}
+
+// listenForEvents continuously receives events like sdk.CodeExistsSubscriber and toggles message
+// sending via channel sendMessagesCh. Messages are sent while subscribers exist, unless an operator has paused
+// the publisher. Returns nil when the server is going away or the connection is closed.
+func (s *QUICPublisher) listenForEvents(
+	ctx context.Context,
+	eventStream quic.ReceiveStream,
+	sendMessagesCh chan<- bool,
+) error {
+	var (
+		decoder                  = quichelper.NewEventDecoder(eventStream)
+		existsSubscriber, paused bool
+	)
+	for {
+		select {
+		case <-ctx.Done():
+			s.logger.Debug("Stopping receiving messages as context is cancelled")
+			return nil
+		default:
+			event, err := decoder.Decode()
+			if err != nil {
+				var unmarshallErr quichelper.UnmarshalError
+				if errors.As(err, &unmarshallErr) {
+					s.logger.Info("Got corrupt event, ignoring", zap.ByteString("event_body", unmarshallErr.Data))
+					continue
+				} else if errors.Is(err, quichelper.ErrNetworkTimeout) {
+					s.logger.Info("Server timeout, stopping listening for events")
+					return nil
+				} else if code, ok := quichelper.ApplicationErrorCode(err); ok {
+					s.logger.Info("Connection closed, stopping listening for events", zap.Uint64("error_code", uint64(code)))
+					return nil
+				} else {
+					return errors.Wrap(err, "Decode")
+				}
+			}
+			s.logger.Info("Got event from the server", zap.String("event", event.Code))
+			if event.BrokerID != "" {
+				s.remoteBrokerID.Store(event.BrokerID)
+			}
+			switch event.Code {
+			case sdk.CodeExistsSubscriber:
+				existsSubscriber = true
+				s.toggleSending(ctx, sendMessagesCh, !paused)
+			case sdk.CodeNoSubscribers:
+				existsSubscriber = false
+				s.toggleSending(ctx, sendMessagesCh, false)
+			case sdk.CodePaused:
+				s.logger.Warn("Paused by the server, not sending messages until resumed")
+				paused = true
+				s.toggleSending(ctx, sendMessagesCh, false)
+			case sdk.CodeResumed:
+				paused = false
+				s.toggleSending(ctx, sendMessagesCh, existsSubscriber)
+			case sdk.CodeSlowDown:
+				retryAfter := time.Duration(event.RetryAfterMs) * time.Millisecond
+				s.logger.Warn("Server asks to slow down, messages are dropped", zap.Duration("retry_after", retryAfter))
+				if handler, ok := s.messageSender.(SlowDownHandler); ok {
+					handler.SlowDown(retryAfter)
+				}
+			case sdk.CodeQuotaExceeded:
+				retryAfter := time.Duration(event.RetryAfterMs) * time.Millisecond
+				s.logger.Warn("Tenant exceeds its quota, messages are dropped",
+					zap.String("quota", event.Quota), zap.Duration("retry_after", retryAfter))
+				if handler, ok := s.messageSender.(SlowDownHandler); ok && retryAfter > 0 {
+					handler.SlowDown(retryAfter)
+				}
+			case sdk.CodeAccessDenied:
+				s.logger.Warn("Access denied, messages with the key are dropped", zap.String("key", event.Key))
+			case sdk.CodeGoingAway:
+				s.logger.Info("Server is going away, shutting down")
+				return nil
+			}
+		}
+	}
+}
+
+// sendKey opens a stream to the server and sends sdk.CodeKey with the key on it, so that a partitioned server that
+// does not own the partition of the key redirects right away rather than on the first message.
+func (s *QUICPublisher) sendKey(conn quic.Connection) {
+	stream, err := conn.OpenUniStream()
+	if err != nil {
+		s.logger.Warn("OpenUniStream", zap.Error(err))
+		return
+	}
+	if err := quichelper.SendEvent(stream, sdk.Event{Code: sdk.CodeKey, Key: s.config.Key}); err != nil {
+		s.logger.Debug("Cannot send the key", zap.Error(err))
+	}
+}
+
+// toggleSending sends val on sendMessagesCh unless ctx is done, in which case messages are no longer sent anyway.
+func (s *QUICPublisher) toggleSending(ctx context.Context, sendMessagesCh chan<- bool, val bool) {
+	select {
+	case <-ctx.Done():
+	case sendMessagesCh <- val:
+	}
+}
+
+// monitorMsgSendingFailures waits for an error on channel sendMessageFailCh,
+// upon receiving an error, it calls cancel.
+func (s *QUICPublisher) monitorMsgSendingFailures(
+	ctx context.Context,
+	sendMessageFailCh <-chan error,
+	cancel context.CancelFunc,
+) {
+	for {
+		select {
+		case <-ctx.Done():
+			s.logger.Info("Stopping monitoring for message sending errors, context cancelled")
+			return
+		case err := <-sendMessageFailCh:
+			s.logger.Error("Failure sending a message", zap.Error(err))
+			cancel()
+			return
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package client
+
+import (
+	"context"
+	"github.com/chzyer/logex"
+	"github.com/pkg/errors"
+	"github.com/quic-go/quic-go"
+	"github.com/varfrog/quicpubsub/pkg/quichelper"
+	"github.com/varfrog/quicpubsub/pkg/sdk"
+	"go.uber.org/zap"
+)
+
+// QUICPubSub connects to the server once as both a publisher and a subscriber, see sdk.ALPNPubSub. It publishes the
+// messages of a QUICPublisher and passes the messages it receives to a QUICSubscriber, which are only used for their
+// configs and handlers: the connection is dialed with the config of the publisher, whose TLSConfig offers
+// sdk.ALPNPubSub. The server does not take the key nor the demand of a pubsub connection, so neither the
+// publisher nor the subscriber may have a key, and a DemandSource of the subscriber is not used.
+type QUICPubSub struct {
+	publisher  *QUICPublisher
+	subscriber *QUICSubscriber
+	logger     *zap.Logger
+}
+
+func NewQUICPubSub(publisher *QUICPublisher, subscriber *QUICSubscriber, logger *zap.Logger) *QUICPubSub {
+	return &QUICPubSub{
+		publisher:  publisher,
+		subscriber: subscriber,
+		logger:     logger,
+	}
+}
+
+// Run connects to the server, publishes messages and receives messages until ctx is done, the server goes away or
+// the connection fails. Returns a RefusedError if the server refused the connection.
+func (s *QUICPubSub) Run(ctx context.Context) error {
+	if s.publisher.config.Key != "" || s.subscriber.config.Key != "" {
+		return errors.New("pubsub connections do not support keys")
+	}
+
+	// Connect to the server
+	config := s.publisher.config
+	s.logger.Info("Connecting to the server", zap.String("addr", config.ServerAddr))
+	conn, err := quichelper.DialAddr(ctx, config.ServerAddr, config.TLSConfig, &s.publisher.quicConfig)
+	if err != nil {
+		return errors.Wrap(err, "quichelper.DialAddr")
+	}
+	s.logger.Info("Connected to the server")
+	if config.Token != "" {
+		if err := authenticate(ctx, conn, config.Token, config.MaxMessageBytes); err != nil {
+			_ = conn.CloseWithError(sdk.ErrorCodeNoError, "authentication failed") // Never fails
+			return errors.Wrap(err, "authenticate")
+		}
+		s.logger.Info("Authenticated")
+	}
+
+	// Create a cancel function for cancelling goroutines created here without cancelling the passed-in ctx
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// Create streams asynchronously and pass them onto the channels once they become available
+	var (
+		eventStreamCh      = make(chan quic.ReceiveStream, 1) // For events from the server to the publisher
+		messageStreamCh    = make(chan quic.SendStream, 1)    // For messages of the publisher to the server
+		subscriberStreamCh = make(chan quic.ReceiveStream, 1) // For messages from the server to the subscriber
+		pingStreamCh       = make(chan quic.Stream, 1)
+	)
+	go func() {
+		// The server opens the event stream first, and the message stream of the subscriber second
+		stream, err := conn.AcceptUniStream(ctx) // Blocking call
+		if err != nil {
+			s.logger.Error("AcceptUniStream", zap.Error(err))
+			cancel()
+			return
+		}
+		s.logger.Info("Event stream ready")
+		eventStreamCh <- stream
+
+		stream, err = conn.AcceptUniStream(ctx) // Blocking call
+		if err != nil {
+			s.logger.Error("AcceptUniStream", zap.Error(err))
+			cancel()
+			return
+		}
+		s.logger.Info("Message stream ready")
+		subscriberStreamCh <- stream
+	}()
+	go func() {
+		stream, err := conn.OpenUniStream() // Blocking call
+		if err != nil {
+			s.logger.Error("OpenUniStream", zap.Error(err))
+			cancel()
+			return
+		}
+		s.logger.Info("Message sending stream ready")
+		messageStreamCh <- stream
+	}()
+	go func() {
+		stream, err := conn.OpenStream() // Blocking call
+		if err != nil {
+			s.logger.Error("OpenStream", zap.Error(err))
+			cancel()
+			return
+		}
+		s.logger.Info("Ping stream ready")
+		pingStreamCh <- stream
+	}()
+
+	closeMessageStream := s.publisher.serve(ctx, cancel, eventStreamCh, messageStreamCh)
+	s.subscriber.serve(ctx, cancel, subscriberStreamCh, nil)
+
+	// Start pinging the server, on a single ping stream for both roles
+	monitor := quichelper.NewLoggingMonitor(s.publisher.pinger, s.logger)
+	s.publisher.pingMonitor.Store(monitor)
+	s.subscriber.pingMonitor.Store(monitor)
+	go quichelper.SendPings(ctx, s.publisher.pinger, monitor, pingStreamCh, cancel, s.logger)
+
+	// Run until we're done
+	<-ctx.Done()
+	logex.Info("Shutting down")
+
+	// Close the message stream once the last message is written, and the connection, so that the server
+	// removes the publisher and the subscriber right away
+	closeMessageStream()
+	if err := conn.CloseWithError(sdk.ErrorCodeNoError, "pubsub client shutting down"); err != nil {
+		s.logger.Warn("CloseWithError", zap.Error(err))
+	}
+
+	return closeError(conn)
+}
@@ -0,0 +1,381 @@
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"github.com/chzyer/logex"
+	"github.com/pkg/errors"
+	"github.com/quic-go/quic-go"
+	"github.com/varfrog/quicpubsub/pkg/encryption"
+	"github.com/varfrog/quicpubsub/pkg/quichelper"
+	"github.com/varfrog/quicpubsub/pkg/sdk"
+	"github.com/varfrog/quicpubsub/pkg/signing"
+	"github.com/varfrog/quicpubsub/pkg/tracing"
+	"go.uber.org/zap"
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+type QUICSubscriberConfig struct {
+	TLSConfig       *tls.Config
+	ServerAddr      string // Server address "host:port", the host is resolved when dialing
+	MaxMessageBytes int
+	Key             string // Key of the wanted messages for partitioned servers, optional
+	Token           string // Bearer token to authenticate with, for servers that require one, optional
+
+	// BrokerID is the ID of the server that the subscriber bridges messages to, told to the server with the demand,
+	// so that the server does not count that demand as demand of its own when bridging to that server. Optional.
+	BrokerID string
+
+	// TrustStore holds the keys of the publishers whose signatures of messages are verified, see package signing.
+	// Verified messages have their sdk.Message.Signer set. Signatures are not verified if nil.
+	TrustStore signing.TrustStore
+
+	// RejectUnverified drops the messages that fail verification against TrustStore, rather than passing them on
+	// without a signer. Unsigned messages fail verification too.
+	RejectUnverified bool
+
+	// MaxSignatureAge is how long after signing a message its signature is valid, see signing.TrustStore.Verify.
+	// Signatures do not expire if 0.
+	MaxSignatureAge time.Duration
+
+	// EncryptionKeys are the keys that encrypted payloads are decrypted with, by key ID, see package encryption.
+	// Messages encrypted with a key that is not among them are dropped.
+	EncryptionKeys encryption.Keys
+
+	// Tracer records a span of every message that has a sampled trace context, see package tracing. Optional.
+	Tracer *tracing.Tracer
+}
+
+// QUICSubscriber connects to the server and passes the messages it receives to a MessageHandler.
+type QUICSubscriber struct {
+	config         QUICSubscriberConfig
+	quicConfig     quic.Config
+	messageHandler MessageHandler
+	demandSource   DemandSource
+	pinger         *quichelper.Pinger
+	pingMonitor    atomic.Pointer[quichelper.PingMonitor] // Of the current connection
+	logger         *zap.Logger
+}
+
+// NewQUICSubscriber is the constructor for QUICSubscriber. demandSource is optional, without one the subscriber
+// always wants messages.
+func NewQUICSubscriber(
+	config QUICSubscriberConfig,
+	quicConfig quic.Config,
+	messageHandler MessageHandler,
+	demandSource DemandSource,
+	pinger *quichelper.Pinger,
+	logger *zap.Logger,
+) *QUICSubscriber {
+	return &QUICSubscriber{
+		config:         config,
+		quicConfig:     quicConfig,
+		messageHandler: messageHandler,
+		demandSource:   demandSource,
+		pinger:         pinger,
+		logger:         logger,
+	}
+}
+
+// PingStats returns the quality of the current connection to the server as measured by pings, or of the last one
+// once it is closed. It is zero until Run connects.
+func (s *QUICSubscriber) PingStats() quichelper.PingStats {
+	if monitor := s.pingMonitor.Load(); monitor != nil {
+		return monitor.Stats()
+	}
+	return quichelper.PingStats{}
+}
+
+// Run connects to the server and receives messages until ctx is done, the server goes away or the connection
+// fails. Returns a RedirectError if the server does not own the partition of the key, and a RefusedError if the
+// server refused the connection.
+func (s *QUICSubscriber) Run(ctx context.Context) error {
+	// Connect to the server
+	s.logger.Info("Connecting to the server", zap.String("addr", s.config.ServerAddr))
+	conn, err := quichelper.DialAddr(ctx, s.config.ServerAddr, s.config.TLSConfig, &s.quicConfig)
+	if err != nil {
+		return errors.Wrap(err, "quichelper.DialAddr")
+	}
+	s.logger.Info("Connected to the server")
+	if s.config.Token != "" {
+		if err := authenticate(ctx, conn, s.config.Token, s.config.MaxMessageBytes); err != nil {
+			_ = conn.CloseWithError(sdk.ErrorCodeNoError, "authentication failed") // Never fails
+			return errors.Wrap(err, "authenticate")
+		}
+		s.logger.Info("Authenticated")
+	}
+
+	// Create a cancel function for cancelling goroutines created here without cancelling the passed-in ctx
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// Create streams for sending messages and receiving events. The calls to open and accept streams
+	// are blocking, don't depend on the order these streams are opened or accepted on the peer, so do this
+	// in goroutines, and send ready-to-use streams on channels.
+	var (
+		messagesStreamCh = make(chan quic.ReceiveStream, 1)
+		eventStreamCh    = make(chan quic.ReceiveStream, 1)
+		pingStreamCh     = make(chan quic.Stream, 1)
+	)
+	go func() {
+		// The server opens the message stream first, and the event stream second
+		stream, err := conn.AcceptUniStream(ctx) // Blocking call
+		if err != nil {
+			s.logger.Error("AcceptUniStream", zap.Error(err))
+			cancel()
+			return
+		}
+		s.logger.Info("Message stream ready")
+		messagesStreamCh <- stream
+
+		stream, err = conn.AcceptUniStream(ctx) // Blocking call
+		if err != nil {
+			if ctx.Err() == nil {
+				s.logger.Warn("AcceptUniStream", zap.Error(err))
+			}
+			return
+		}
+		s.logger.Info("Event stream ready")
+		eventStreamCh <- stream
+	}()
+	go func() {
+		stream, err := conn.OpenStream() // Blocking call
+		if err != nil {
+			s.logger.Error("OpenStream", zap.Error(err))
+			cancel()
+			return
+		}
+		s.logger.Info("Ping stream ready")
+		pingStreamCh <- stream
+	}()
+
+	// Start pinging the server
+	monitor := quichelper.NewLoggingMonitor(s.pinger, s.logger)
+	s.pingMonitor.Store(monitor)
+	go quichelper.SendPings(ctx, s.pinger, monitor, pingStreamCh, cancel, s.logger)
+
+	s.serve(ctx, cancel, messagesStreamCh, eventStreamCh)
+
+	// Tell the server which messages are wanted and whether they are wanted
+	if s.config.Key != "" || s.demandSource != nil {
+		go s.sendDemand(ctx, conn)
+	}
+
+	// Run until we're done
+	<-ctx.Done()
+	logex.Info("Shutting down")
+
+	// Close the connection so that the server removes this subscriber right away
+	if err := conn.CloseWithError(sdk.ErrorCodeNoError, "subscriber shutting down"); err != nil {
+		s.logger.Warn("CloseWithError", zap.Error(err))
+	}
+
+	return closeError(conn)
+}
+
+// serve receives messages and events on the streams once they become available, until ctx is done, and calls cancel
+// once the message stream ends. eventStreamCh is nil if the server sends no events.
+func (s *QUICSubscriber) serve(
+	ctx context.Context,
+	cancel context.CancelFunc,
+	messagesStreamCh <-chan quic.ReceiveStream,
+	eventStreamCh <-chan quic.ReceiveStream,
+) {
+	// Receive messages from the server
+	go func() {
+		var stream quic.ReceiveStream
+		select {
+		case <-ctx.Done():
+			return
+		case stream = <-messagesStreamCh: // Wait until the stream becomes available
+		}
+		s.logger.Info("Receiving messages")
+		if err := s.listenForMessages(ctx, stream); err != nil {
+			s.logger.Error("listenForMessages", zap.Error(err))
+		}
+		cancel()
+	}()
+
+	// Receive events from the server
+	if eventStreamCh == nil {
+		return
+	}
+	go func() {
+		var stream quic.ReceiveStream
+		select {
+		case <-ctx.Done():
+			return
+		case stream = <-eventStreamCh:
+		}
+		s.listenForEvents(ctx, stream)
+	}()
+}
+
+// listenForEvents logs events from the server until ctx is done or the stream fails. Once the server is going away
+// it closes the message stream, which ends listenForMessages.
+func (s *QUICSubscriber) listenForEvents(ctx context.Context, stream quic.ReceiveStream) {
+	decoder := quichelper.NewEventDecoder(stream)
+	for ctx.Err() == nil {
+		event, err := decoder.Decode()
+		if err != nil {
+			var unmarshallErr quichelper.UnmarshalError
+			if errors.As(err, &unmarshallErr) {
+				s.logger.Info("Got corrupt event, ignoring", zap.ByteString("event_body", unmarshallErr.Data))
+				continue
+			}
+			s.logger.Debug("Stopping listening for events", zap.Error(err))
+			return
+		}
+
+		switch event.Code {
+		case sdk.CodeGoingAway:
+			s.logger.Info("Server is going away, receiving the remaining messages")
+		case sdk.CodeQuotaExceeded:
+			s.logger.Warn("Tenant exceeds its quota, the server refuses the subscriber", zap.String("quota", event.Quota))
+		case sdk.CodeAccessDenied:
+			s.logger.Warn("Access denied, no messages with the key are received", zap.String("key", event.Key))
+		default:
+			s.logger.Info("Got event from the server", zap.String("event", event.Code))
+		}
+	}
+}
+
+// listenForMessages continuously reads the given stream and passes the messages it receives to the MessageHandler.
+// If a key is configured, messages with other keys, which a partitioned server sends for the other keys of its
+// partitions, are skipped. Returns nil once the server closes the stream or the connection.
+func (s *QUICSubscriber) listenForMessages(ctx context.Context, stream quic.ReceiveStream) error {
+	for {
+		select {
+		case <-ctx.Done():
+			s.logger.Info("Stopping receiving messages as context is cancelled")
+			return nil
+		default:
+			message, buf, err := quichelper.ReadMessage(stream, s.config.MaxMessageBytes)
+			if err != nil {
+				var unmarshallErr quichelper.UnmarshalError
+				if errors.As(err, &unmarshallErr) {
+					s.logger.Info("Got corrupt message, ignoring", zap.Error(err))
+					continue
+				}
+				if errors.Is(err, quichelper.ErrNetworkTimeout) {
+					s.logger.Info("Server timeout, stopping listening for events")
+					return nil
+				}
+				if errors.Is(err, io.EOF) {
+					s.logger.Info("Server closed the message stream")
+					return nil
+				}
+				if code, ok := quichelper.ApplicationErrorCode(err); ok {
+					s.logger.Info("Connection closed, stopping receiving messages", zap.Uint64("error_code", uint64(code)))
+					return nil
+				}
+				return errors.Wrap(err, "ReadMessage")
+			}
+			if s.config.Key != "" && message.Headers[sdk.HeaderKey] != s.config.Key {
+				buf.Release()
+				continue
+			}
+			err = s.handleMessage(message)
+			buf.Release()
+			if err != nil {
+				return errors.Wrap(err, "handleMessage")
+			}
+		}
+	}
+}
+
+// handleMessage verifies and decrypts the message, if configured, and passes it to the MessageHandler, or drops it if
+// it fails either. If the message is traced, a span of it continues the trace.
+func (s *QUICSubscriber) handleMessage(message sdk.Message) error {
+	var span *tracing.Span
+	if s.config.Tracer != nil {
+		if parent, ok := tracing.FromHeaders(message.Headers); ok {
+			span = s.config.Tracer.Start(tracing.SpanConsume, tracing.SpanKindConsumer, parent)
+			span.SetAttribute(tracing.AttributeMessageKey, message.Headers[sdk.HeaderKey])
+			span.SetIntAttribute(tracing.AttributeMessageSize, int64(len(message.Payload)))
+			defer span.End()
+		}
+	}
+
+	if s.config.TrustStore != nil && !s.verify(&message) {
+		span.SetError(errors.New("rejected unverified message"))
+		return nil
+	}
+	message, err := s.config.EncryptionKeys.Decrypt(message)
+	if err != nil {
+		s.logger.Warn("Dropping message that cannot be decrypted", zap.Error(err))
+		span.SetError(err)
+		return nil
+	}
+	err = s.messageHandler.HandleMessage(message)
+	span.SetError(err)
+	if err != nil {
+		return errors.Wrap(err, "HandleMessage")
+	}
+	return nil
+}
+
+// verify verifies the signature of message against the trust store, setting its signer if it is valid, and returns
+// whether to pass the message on.
+func (s *QUICSubscriber) verify(message *sdk.Message) bool {
+	signer, err := s.config.TrustStore.Verify(*message, time.Now(), s.config.MaxSignatureAge)
+	if err == nil {
+		message.Signer = signer
+		return true
+	}
+	if s.config.RejectUnverified {
+		s.logger.Warn("Rejecting unverified message", zap.Error(err))
+		return false
+	}
+	s.logger.Warn("Passing on unverified message", zap.Error(err))
+	return true
+}
+
+// sendDemand opens a stream to the server and sends sdk.CodeKey with the key, if configured, followed by
+// sdk.CodeExistsSubscriber or sdk.CodeNoSubscribers whenever the demand of the DemandSource changes, starting with
+// the current demand.
+func (s *QUICSubscriber) sendDemand(ctx context.Context, conn quic.Connection) {
+	stream, err := conn.OpenUniStream()
+	if err != nil {
+		s.logger.Warn("OpenUniStream", zap.Error(err))
+		return
+	}
+
+	if s.config.Key != "" {
+		if err := quichelper.SendEvent(stream, sdk.Event{Code: sdk.CodeKey, Key: s.config.Key}); err != nil {
+			s.logger.Debug("Stopping sending demand", zap.Error(err))
+			return
+		}
+	}
+	if s.demandSource == nil {
+		return // Always wants messages
+	}
+
+	var (
+		hasDemand = s.demandSource.HasDemand()
+		sent      = false
+	)
+	for {
+		if !sent || hasDemand != s.demandSource.HasDemand() {
+			hasDemand = s.demandSource.HasDemand()
+			event := sdk.Event{Code: sdk.CodeNoSubscribers, BrokerID: s.config.BrokerID}
+			if hasDemand {
+				event.Code = sdk.CodeExistsSubscriber
+			}
+			if err := quichelper.SendEvent(stream, event); err != nil {
+				s.logger.Debug("Stopping sending demand", zap.Error(err))
+				return
+			}
+			s.logger.Debug("Sent demand to the server", zap.Bool("has_demand", hasDemand))
+			sent = true
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.demandSource.DemandChanged():
+		}
+	}
+}
@@ -0,0 +1,28 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"github.com/quic-go/quic-go"
+	"github.com/varfrog/quicpubsub/pkg/quichelper"
+)
+
+// RefusedError is returned by QUICPublisher.Run and QUICSubscriber.Run when the server refused the connection,
+// e.g. as it is at its connection limit.
+type RefusedError struct {
+	Code   quic.ApplicationErrorCode // One of the codes of quichelper.RefusalReason
+	Reason string
+}
+
+func (e RefusedError) Error() string {
+	return fmt.Sprintf("the server refused the connection (error code 0x%x): %s", uint64(e.Code), e.Reason)
+}
+
+// closeError returns a RefusedError or a RedirectError if the server closed the connection for either reason.
+// The connection must be closed.
+func closeError(conn quic.Connection) error {
+	if code, reason, ok := quichelper.RefusalReason(context.Cause(conn.Context())); ok {
+		return RefusedError{Code: code, Reason: reason}
+	}
+	return redirectError(conn)
+}
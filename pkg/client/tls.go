@@ -0,0 +1,105 @@
+package client
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"github.com/pkg/errors"
+	"github.com/varfrog/quicpubsub/pkg/quichelper"
+	"github.com/varfrog/quicpubsub/pkg/sdk"
+	"os"
+)
+
+// TLSOptions are how a client verifies the certificates of servers and which certificate it presents, as the
+// publisher and subscriber apps take them from their flags.
+type TLSOptions struct {
+	TLSCertsDir    string // Path to a directory containing TLS certificates
+	Tenant         string // Tenant to connect as, the default tenant if empty
+	ClientCertPath string // Path to the client certificate presented to the server, none if empty
+	ClientKeyPath  string // Path to the private key of the client certificate
+	ServerName     string // Name the server certificate must be valid for, the host of the server address if empty
+	CABundlePath   string // Path to a PEM bundle of CAs that issue server certificates, ca.pem in TLSCertsDir if empty
+	SystemRoots    bool   // Whether the CAs of the system issue server certificates too
+	PinnedSPKI     string // Comma-separated base64 SHA-256 hashes of server public keys, replace CAs if set
+}
+
+// Validate returns an error if the options are not valid, e.g. if TLSCertsDir is needed but does not exist.
+func (o TLSOptions) Validate() error {
+	if _, err := quichelper.ParseSPKIPins(o.PinnedSPKI); err != nil {
+		return errors.Wrap(err, "PinnedSPKI")
+	}
+	if o.usesCertsDirCA() {
+		if _, err := os.Stat(o.TLSCertsDir); errors.Is(err, os.ErrNotExist) {
+			return errors.New("cannot stat the TLS certs dir, change the working dir to the project root or specify flag -cert-path")
+		}
+	}
+	if (o.ClientCertPath == "") != (o.ClientKeyPath == "") {
+		return errors.New("specify both flags -client-cert and -client-key or neither")
+	}
+	if o.Tenant != "" {
+		if err := sdk.ValidateTenant(o.Tenant); err != nil {
+			return errors.Wrap(err, "Tenant")
+		}
+	}
+	return nil
+}
+
+// BuildTLSConfig returns the TLS config for connecting to servers in the role, e.g. sdk.ALPNPublisher, as a client
+// of Tenant, verifying server certificates as configured and presenting the client certificate if configured.
+// The options must be valid, see Validate.
+func (o TLSOptions) BuildTLSConfig(role string) (*tls.Config, error) {
+	verification, err := o.serverVerification()
+	if err != nil {
+		return nil, errors.Wrap(err, "serverVerification")
+	}
+
+	tlsConfig := &tls.Config{
+		ServerName: o.ServerName,
+		NextProtos: []string{sdk.TenantALPN(role, o.Tenant)},
+	}
+	verification.Apply(tlsConfig)
+	if o.ClientCertPath != "" {
+		cert, err := tls.LoadX509KeyPair(o.ClientCertPath, o.ClientKeyPath)
+		if err != nil {
+			return nil, errors.Wrap(err, "tls.LoadX509KeyPair")
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
+
+// usesCertsDirCA returns whether server certificates are verified against ca.pem in TLSCertsDir.
+func (o TLSOptions) usesCertsDirCA() bool {
+	return o.PinnedSPKI == "" && o.CABundlePath == "" && !o.SystemRoots
+}
+
+// serverVerification returns how server certificates are verified: against the pinned public keys if any,
+// otherwise against the CAs of the bundle and of the system.
+func (o TLSOptions) serverVerification() (quichelper.ServerVerification, error) {
+	pins, _ := quichelper.ParseSPKIPins(o.PinnedSPKI) // Validated
+	if len(pins) > 0 {
+		return quichelper.ServerVerification{PinnedSPKIHashes: pins}, nil
+	}
+
+	if o.usesCertsDirCA() {
+		rootCAs, err := quichelper.GetRootCertPool(o.TLSCertsDir)
+		if err != nil {
+			return quichelper.ServerVerification{}, errors.Wrap(err, "GetRootCertPool")
+		}
+		return quichelper.ServerVerification{RootCAs: rootCAs}, nil
+	}
+
+	rootCAs := x509.NewCertPool()
+	if o.SystemRoots {
+		systemCAs, err := x509.SystemCertPool()
+		if err != nil {
+			return quichelper.ServerVerification{}, errors.Wrap(err, "x509.SystemCertPool")
+		}
+		rootCAs = systemCAs
+	}
+	if o.CABundlePath != "" {
+		if err := quichelper.AppendCertsFromFile(rootCAs, o.CABundlePath); err != nil {
+			return quichelper.ServerVerification{}, errors.Wrap(err, "AppendCertsFromFile")
+		}
+	}
+	return quichelper.ServerVerification{RootCAs: rootCAs}, nil
+}
@@ -0,0 +1,193 @@
+// Package encryption encrypts the payloads of messages at publishers and decrypts them at subscribers, so that
+// servers, and whoever operates them, cannot read them.
+//
+// Payloads are encrypted with AES-256-GCM under a key that publishers and subscribers share, named by a key ID which
+// is sent in the sdk.HeaderEncryptionKey header. Keys are rotated by adding a key under a new ID to the subscribers,
+// switching publishers over to it and, once no messages encrypted with it are in flight, removing the old key.
+// Headers, the key of the message included, are not encrypted, as servers route by them.
+package encryption
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/varfrog/quicpubsub/pkg/sdk"
+	"os"
+)
+
+const (
+	// KeySize is the size of keys in bytes, for AES-256.
+	KeySize = 32
+
+	// Overhead is by how many bytes an encrypted payload is larger than the plaintext: a nonce and an
+	// authentication tag.
+	Overhead = nonceSize + tagSize
+
+	nonceSize = 12
+	tagSize   = 16
+)
+
+// additionalDataContext is prepended to the data authenticated along with payloads, so that ciphertexts cannot be
+// passed off as anything else.
+const additionalDataContext = "quicpubsub message encryption v1"
+
+var (
+	ErrUnknownKey = errors.New("message encrypted with an unknown key")
+	ErrDecryption = errors.New("cannot decrypt message")
+)
+
+// Keys are the keys payloads are encrypted with, by key ID.
+type Keys map[string][]byte
+
+// NewKey returns a random key.
+func NewKey() ([]byte, error) {
+	key := make([]byte, KeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, errors.Wrap(err, "rand.Read")
+	}
+	return key, nil
+}
+
+// LoadKeys reads keys from a JSON file, an object with key IDs as keys and base64-encoded keys as values, e.g.
+// {"2024-10": "3q2+7w..."}.
+func LoadKeys(path string) (Keys, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "os.ReadFile")
+	}
+	var encoded map[string]string
+	if err := json.Unmarshal(data, &encoded); err != nil {
+		return nil, errors.Wrap(err, "json.Unmarshal")
+	}
+	keys := make(Keys, len(encoded))
+	for id, secret := range encoded {
+		key, err := base64.StdEncoding.DecodeString(secret)
+		if err != nil {
+			return nil, errors.Wrapf(err, "key '%s'", id)
+		}
+		if len(key) != KeySize {
+			return nil, fmt.Errorf("key '%s' is not %d bytes", id, KeySize)
+		}
+		keys[id] = key
+	}
+	return keys, nil
+}
+
+// SaveKeys writes keys to a file in the format of LoadKeys, readable by the owner only.
+func SaveKeys(path string, keys Keys) error {
+	encoded := make(map[string]string, len(keys))
+	for id, key := range keys {
+		encoded[id] = base64.StdEncoding.EncodeToString(key)
+	}
+	data, err := json.MarshalIndent(encoded, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "json.MarshalIndent")
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0600); err != nil {
+		return errors.Wrap(err, "os.WriteFile")
+	}
+	return nil
+}
+
+// Decrypt returns a copy of message with its payload decrypted with the key it names, and without the
+// sdk.HeaderEncryptionKey header. Messages that are not encrypted are returned as they are.
+func (k Keys) Decrypt(message sdk.Message) (sdk.Message, error) {
+	keyID, ok := message.Headers[sdk.HeaderEncryptionKey]
+	if !ok {
+		return message, nil
+	}
+	key, ok := k[keyID]
+	if !ok {
+		return sdk.Message{}, errors.Wrapf(ErrUnknownKey, "key ID '%s'", keyID)
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return sdk.Message{}, errors.Wrapf(err, "key ID '%s'", keyID)
+	}
+	if len(message.Payload) < Overhead {
+		return sdk.Message{}, errors.Wrapf(ErrDecryption, "key ID '%s': payload shorter than %d bytes", keyID,
+			Overhead)
+	}
+	nonce, ciphertext := message.Payload[:nonceSize], message.Payload[nonceSize:]
+	payload, err := aead.Open(nil, nonce, ciphertext, additionalData(keyID, message))
+	if err != nil {
+		return sdk.Message{}, errors.Wrapf(ErrDecryption, "key ID '%s'", keyID)
+	}
+
+	headers := make(map[string]string, len(message.Headers))
+	for k, v := range message.Headers {
+		headers[k] = v
+	}
+	delete(headers, sdk.HeaderEncryptionKey)
+	message.Headers = headers
+	message.Payload = payload
+	return message, nil
+}
+
+// Encrypter encrypts payloads with a key.
+type Encrypter struct {
+	keyID string
+	aead  cipher.AEAD
+}
+
+// NewEncrypter is the constructor for Encrypter, which encrypts with the key of keys with keyID.
+func NewEncrypter(keys Keys, keyID string) (*Encrypter, error) {
+	key, ok := keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("no key '%s'", keyID)
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	return &Encrypter{keyID: keyID, aead: aead}, nil
+}
+
+// Encrypt returns a copy of message with its payload encrypted and the ID of the key set as its
+// sdk.HeaderEncryptionKey. The key of the message is authenticated too, so that the payload cannot be moved to
+// another key. The headers of message are left intact.
+func (e *Encrypter) Encrypt(message sdk.Message) (sdk.Message, error) {
+	headers := make(map[string]string, len(message.Headers)+1)
+	for k, v := range message.Headers {
+		headers[k] = v
+	}
+	headers[sdk.HeaderEncryptionKey] = e.keyID
+	message.Headers = headers
+
+	payload := make([]byte, nonceSize, Overhead+len(message.Payload))
+	if _, err := rand.Read(payload); err != nil {
+		return sdk.Message{}, errors.Wrap(err, "rand.Read")
+	}
+	message.Payload = e.aead.Seal(payload, payload, message.Payload, additionalData(e.keyID, message))
+	return message, nil
+}
+
+// newAEAD returns AES-256-GCM with key.
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, errors.Wrap(err, "aes.NewCipher")
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, errors.Wrap(err, "cipher.NewGCM")
+	}
+	return aead, nil
+}
+
+// additionalData returns what is authenticated along with the payload of a message: the key ID and the key of the
+// message, each prefixed by its length.
+func additionalData(keyID string, message sdk.Message) []byte {
+	data := make([]byte, 0, len(additionalDataContext)+8+len(keyID)+len(message.Headers[sdk.HeaderKey]))
+	data = append(data, additionalDataContext...)
+	for _, field := range []string{keyID, message.Headers[sdk.HeaderKey]} {
+		data = binary.BigEndian.AppendUint32(data, uint32(len(field)))
+		data = append(data, field...)
+	}
+	return data
+}
@@ -0,0 +1,114 @@
+package encryption_test
+
+import (
+	. "github.com/onsi/gomega"
+	"github.com/varfrog/quicpubsub/pkg/encryption"
+	"github.com/varfrog/quicpubsub/pkg/sdk"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestKeys_Decrypt(t *testing.T) {
+	oldKey, err := encryption.NewKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	newKey, err := encryption.NewKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := encryption.Keys{"old": oldKey, "new": newKey}
+	message := sdk.Message{Headers: map[string]string{sdk.HeaderKey: "order-1"}, Payload: []byte("card 4111")}
+
+	encrypt := func(g *WithT, keyID string) sdk.Message {
+		encrypter, err := encryption.NewEncrypter(keys, keyID)
+		g.Expect(err).NotTo(HaveOccurred())
+		encrypted, err := encrypter.Encrypt(message)
+		g.Expect(err).NotTo(HaveOccurred())
+		return encrypted
+	}
+
+	t.Run("Encrypted with either key", func(t *testing.T) {
+		g := NewWithT(t)
+
+		for _, keyID := range []string{"old", "new"} {
+			encrypted := encrypt(g, keyID)
+			g.Expect(encrypted.Headers).To(HaveKeyWithValue(sdk.HeaderEncryptionKey, keyID))
+			g.Expect(encrypted.Headers).To(HaveKeyWithValue(sdk.HeaderKey, "order-1"))
+			g.Expect(encrypted.Payload).To(HaveLen(len(message.Payload) + encryption.Overhead))
+			g.Expect(string(encrypted.Payload)).NotTo(ContainSubstring("card"))
+
+			decrypted, err := keys.Decrypt(encrypted)
+			g.Expect(err).NotTo(HaveOccurred())
+			g.Expect(decrypted).To(Equal(message))
+		}
+		g.Expect(message.Headers).NotTo(HaveKey(sdk.HeaderEncryptionKey), "the original is left intact")
+	})
+
+	t.Run("Not encrypted", func(t *testing.T) {
+		g := NewWithT(t)
+
+		decrypted, err := keys.Decrypt(message)
+		g.Expect(err).NotTo(HaveOccurred())
+		g.Expect(decrypted).To(Equal(message))
+	})
+
+	t.Run("Missing key", func(t *testing.T) {
+		g := NewWithT(t)
+
+		encrypted := encrypt(g, "new")
+		_, err := encryption.Keys{"old": oldKey}.Decrypt(encrypted)
+		g.Expect(err).To(MatchError(encryption.ErrUnknownKey))
+		g.Expect(err).To(MatchError(ContainSubstring("'new'")))
+
+		var none encryption.Keys
+		_, err = none.Decrypt(encrypted)
+		g.Expect(err).To(MatchError(encryption.ErrUnknownKey))
+	})
+
+	t.Run("Tampered messages", func(t *testing.T) {
+		for name, tamper := range map[string]func(m *sdk.Message){
+			"payload":   func(m *sdk.Message) { m.Payload[len(m.Payload)-1] ^= 1 },
+			"truncated": func(m *sdk.Message) { m.Payload = m.Payload[:encryption.Overhead-1] },
+			"key":       func(m *sdk.Message) { m.Headers[sdk.HeaderKey] = "order-2" },
+			"key ID":    func(m *sdk.Message) { m.Headers[sdk.HeaderEncryptionKey] = "old" },
+		} {
+			t.Run(name, func(t *testing.T) {
+				g := NewWithT(t)
+
+				encrypted := encrypt(g, "new")
+				tamper(&encrypted)
+				_, err := keys.Decrypt(encrypted)
+				g.Expect(err).To(MatchError(encryption.ErrDecryption))
+			})
+		}
+	})
+}
+
+func TestNewEncrypter_UnknownKey(t *testing.T) {
+	g := NewWithT(t)
+
+	_, err := encryption.NewEncrypter(encryption.Keys{}, "new")
+	g.Expect(err).To(MatchError(ContainSubstring("no key 'new'")))
+}
+
+func TestSaveKeys(t *testing.T) {
+	g := NewWithT(t)
+
+	path := filepath.Join(t.TempDir(), "encryption_keys.json")
+	key, err := encryption.NewKey()
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(encryption.SaveKeys(path, encryption.Keys{"2024-10": key})).To(Succeed())
+	info, err := os.Stat(path)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(info.Mode().Perm()).To(Equal(os.FileMode(0600)))
+
+	keys, err := encryption.LoadKeys(path)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(keys).To(Equal(encryption.Keys{"2024-10": key}))
+
+	g.Expect(os.WriteFile(path, []byte(`{"2024-10": "c2hvcnQ="}`), 0600)).To(Succeed())
+	_, err = encryption.LoadKeys(path)
+	g.Expect(err).To(MatchError(ContainSubstring("is not 32 bytes")))
+}
@@ -0,0 +1,290 @@
+// Package metrics keeps counters, gauges and histograms, and exposes them in the Prometheus text format, see
+// https://prometheus.io/docs/instrumenting/exposition_formats/.
+//
+// Metrics are updated with atomic operations, so they can be updated on hot paths. The methods of nil metrics do
+// nothing, so that code can be instrumented whether or not metrics are kept.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+// ContentType is the content type of the Prometheus text format.
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// metric is a metric of a Registry.
+type metric interface {
+	write(w *bufio.Writer, name string)
+}
+
+// registered is a metric with its name, help and type.
+type registered struct {
+	name   string
+	help   string
+	kind   string // counter, gauge or histogram
+	metric metric
+}
+
+// Registry holds metrics by name, and writes them in the Prometheus text format. It is an http.Handler.
+type Registry struct {
+	mu      sync.Mutex
+	metrics map[string]registered
+}
+
+var _ http.Handler = (*Registry)(nil)
+
+// NewRegistry is the constructor for Registry.
+func NewRegistry() *Registry {
+	return &Registry{metrics: make(map[string]registered)}
+}
+
+// Counter registers a counter, a count that only goes up. Names of counters end in "_total" by convention. Panics if
+// the name is taken, as names are fixed in code.
+func (r *Registry) Counter(name string, help string) *Counter {
+	counter := &Counter{}
+	r.register(name, help, "counter", counter)
+	return counter
+}
+
+// Gauge registers a gauge, a value that goes up and down.
+func (r *Registry) Gauge(name string, help string) *Gauge {
+	gauge := &Gauge{}
+	r.register(name, help, "gauge", gauge)
+	return gauge
+}
+
+// GaugeFunc registers a gauge whose value fn returns whenever the metrics are written.
+func (r *Registry) GaugeFunc(name string, help string, fn func() float64) {
+	r.register(name, help, "gauge", gaugeFunc(fn))
+}
+
+// Histogram registers a histogram of observations in buckets with the given upper bounds, in increasing order.
+// A bucket for all observations is added, see ExponentialBuckets.
+func (r *Registry) Histogram(name string, help string, buckets []float64) *Histogram {
+	histogram := &Histogram{
+		buckets: append([]float64(nil), buckets...),
+		counts:  make([]atomic.Uint64, len(buckets)+1),
+	}
+	r.register(name, help, "histogram", histogram)
+	return histogram
+}
+
+func (r *Registry) register(name string, help string, kind string, m metric) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.metrics[name]; ok {
+		panic(fmt.Sprintf("metric '%s' is already registered", name))
+	}
+	r.metrics[name] = registered{name: name, help: help, kind: kind, metric: m}
+}
+
+// WriteTo writes the metrics in the Prometheus text format, sorted by name.
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	metrics := make([]registered, 0, len(r.metrics))
+	for _, m := range r.metrics {
+		metrics = append(metrics, m)
+	}
+	r.mu.Unlock()
+	sort.Slice(metrics, func(i, j int) bool {
+		return metrics[i].name < metrics[j].name
+	})
+
+	counter := &countingWriter{w: w}
+	buf := bufio.NewWriter(counter)
+	for _, m := range metrics {
+		fmt.Fprintf(buf, "# HELP %s %s\n", m.name, escapeHelp(m.help))
+		fmt.Fprintf(buf, "# TYPE %s %s\n", m.name, m.kind)
+		m.metric.write(buf, m.name)
+	}
+	err := buf.Flush()
+	return counter.n, err
+}
+
+// ServeHTTP serves the metrics in the Prometheus text format.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", ContentType)
+	_, _ = r.WriteTo(w) // Fails only if the client has gone
+}
+
+// Counter is a count that only goes up.
+type Counter struct {
+	value atomic.Uint64
+}
+
+// Inc adds one to the counter.
+func (c *Counter) Inc() {
+	if c != nil {
+		c.value.Add(1)
+	}
+}
+
+// Add adds n to the counter.
+func (c *Counter) Add(n uint64) {
+	if c != nil {
+		c.value.Add(n)
+	}
+}
+
+// Value returns the count.
+func (c *Counter) Value() uint64 {
+	if c == nil {
+		return 0
+	}
+	return c.value.Load()
+}
+
+func (c *Counter) write(w *bufio.Writer, name string) {
+	fmt.Fprintf(w, "%s %d\n", name, c.Value())
+}
+
+// Gauge is a value that goes up and down.
+type Gauge struct {
+	bits atomic.Uint64 // Of the float64 value
+}
+
+// Set sets the value of the gauge.
+func (g *Gauge) Set(value float64) {
+	if g != nil {
+		g.bits.Store(math.Float64bits(value))
+	}
+}
+
+// Add adds delta, which may be negative, to the value of the gauge.
+func (g *Gauge) Add(delta float64) {
+	if g != nil {
+		addFloat(&g.bits, delta)
+	}
+}
+
+// Value returns the value of the gauge.
+func (g *Gauge) Value() float64 {
+	if g == nil {
+		return 0
+	}
+	return math.Float64frombits(g.bits.Load())
+}
+
+func (g *Gauge) write(w *bufio.Writer, name string) {
+	fmt.Fprintf(w, "%s %s\n", name, formatFloat(g.Value()))
+}
+
+// gaugeFunc is a gauge whose value is returned by a function.
+type gaugeFunc func() float64
+
+func (f gaugeFunc) write(w *bufio.Writer, name string) {
+	fmt.Fprintf(w, "%s %s\n", name, formatFloat(f()))
+}
+
+// Histogram counts observations in buckets by their value, and sums them.
+type Histogram struct {
+	buckets []float64       // Upper bounds
+	counts  []atomic.Uint64 // Of the observations in each bucket, not cumulative, the last for those above all bounds
+	sumBits atomic.Uint64   // Of the float64 sum of the observations
+}
+
+// ExponentialBuckets returns count bucket upper bounds for Registry.Histogram, the first being start and each
+// following one factor times the one before it.
+func ExponentialBuckets(start float64, factor float64, count int) []float64 {
+	buckets := make([]float64, count)
+	for i := range buckets {
+		buckets[i] = start
+		start *= factor
+	}
+	return buckets
+}
+
+// Observe adds an observation.
+func (h *Histogram) Observe(value float64) {
+	if h == nil {
+		return
+	}
+	i := sort.SearchFloat64s(h.buckets, value) // The first bucket whose upper bound is at least value
+	h.counts[i].Add(1)
+	addFloat(&h.sumBits, value)
+}
+
+// Count returns the number of observations.
+func (h *Histogram) Count() uint64 {
+	if h == nil {
+		return 0
+	}
+	var count uint64
+	for i := range h.counts {
+		count += h.counts[i].Load()
+	}
+	return count
+}
+
+func (h *Histogram) write(w *bufio.Writer, name string) {
+	// Buckets are cumulative in the text format, and the count is that of the last bucket, so that they agree
+	var cumulative uint64
+	for i, bound := range h.buckets {
+		cumulative += h.counts[i].Load()
+		fmt.Fprintf(w, "%s_bucket{le=\"%s\"} %d\n", name, formatFloat(bound), cumulative)
+	}
+	cumulative += h.counts[len(h.buckets)].Load()
+	fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", name, cumulative)
+	fmt.Fprintf(w, "%s_sum %s\n", name, formatFloat(math.Float64frombits(h.sumBits.Load())))
+	fmt.Fprintf(w, "%s_count %d\n", name, cumulative)
+}
+
+// addFloat adds delta to the float64 whose bits are stored in bits.
+func addFloat(bits *atomic.Uint64, delta float64) {
+	for {
+		old := bits.Load()
+		if bits.CompareAndSwap(old, math.Float64bits(math.Float64frombits(old)+delta)) {
+			return
+		}
+	}
+}
+
+// formatFloat formats a value as the text format expects, in the shortest form that parses back to it.
+func formatFloat(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	case math.IsNaN(value):
+		return "NaN"
+	default:
+		return strconv.FormatFloat(value, 'g', -1, 64)
+	}
+}
+
+// escapeHelp escapes backslashes and line feeds in help text, as the text format requires.
+func escapeHelp(help string) string {
+	var escaped []byte
+	for i := 0; i < len(help); i++ {
+		switch help[i] {
+		case '\\':
+			escaped = append(escaped, '\\', '\\')
+		case '\n':
+			escaped = append(escaped, '\\', 'n')
+		default:
+			escaped = append(escaped, help[i])
+		}
+	}
+	return string(escaped)
+}
+
+// countingWriter counts the bytes written to w.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
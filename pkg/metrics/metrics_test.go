@@ -0,0 +1,106 @@
+package metrics_test
+
+import (
+	"bytes"
+	. "github.com/onsi/gomega"
+	"github.com/varfrog/quicpubsub/pkg/metrics"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+func TestRegistry_WriteTo(t *testing.T) {
+	g := NewWithT(t)
+
+	registry := metrics.NewRegistry()
+	messages := registry.Counter("messages_total", "Messages received")
+	queued := registry.Gauge("queued", "Messages queued")
+	registry.GaugeFunc("publishers", "Connected publishers", func() float64 { return 3 })
+	size := registry.Histogram("message_size_bytes", "Size of messages\nin bytes", []float64{64, 256})
+
+	messages.Add(2)
+	messages.Inc()
+	queued.Set(5)
+	queued.Add(-1.5)
+	for _, value := range []float64{10, 64, 100, 1000} {
+		size.Observe(value)
+	}
+
+	var buf bytes.Buffer
+	n, err := registry.WriteTo(&buf)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(n).To(Equal(int64(buf.Len())))
+	g.Expect(buf.String()).To(Equal(`# HELP message_size_bytes Size of messages\nin bytes
+# TYPE message_size_bytes histogram
+message_size_bytes_bucket{le="64"} 2
+message_size_bytes_bucket{le="256"} 3
+message_size_bytes_bucket{le="+Inf"} 4
+message_size_bytes_sum 1174
+message_size_bytes_count 4
+# HELP messages_total Messages received
+# TYPE messages_total counter
+messages_total 3
+# HELP publishers Connected publishers
+# TYPE publishers gauge
+publishers 3
+# HELP queued Messages queued
+# TYPE queued gauge
+queued 3.5
+`))
+}
+
+func TestRegistry_ServeHTTP(t *testing.T) {
+	g := NewWithT(t)
+
+	registry := metrics.NewRegistry()
+	registry.Counter("messages_total", "Messages received").Inc()
+
+	recorder := httptest.NewRecorder()
+	registry.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	g.Expect(recorder.Code).To(Equal(http.StatusOK))
+	g.Expect(recorder.Header().Get("Content-Type")).To(Equal(metrics.ContentType))
+	g.Expect(recorder.Body.String()).To(ContainSubstring("\nmessages_total 1\n"))
+}
+
+func TestRegistry_DuplicateName(t *testing.T) {
+	g := NewWithT(t)
+
+	registry := metrics.NewRegistry()
+	registry.Counter("messages_total", "Messages received")
+	g.Expect(func() { registry.Gauge("messages_total", "Messages") }).To(Panic())
+}
+
+func TestHistogram_Concurrent(t *testing.T) {
+	g := NewWithT(t)
+
+	histogram := metrics.NewRegistry().Histogram("latency_seconds", "Latency", metrics.ExponentialBuckets(0.001, 10, 3))
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				histogram.Observe(0.5)
+			}
+		}()
+	}
+	wg.Wait()
+	g.Expect(histogram.Count()).To(Equal(uint64(8000)))
+}
+
+func TestNilMetrics(t *testing.T) {
+	g := NewWithT(t)
+
+	var (
+		counter   *metrics.Counter
+		gauge     *metrics.Gauge
+		histogram *metrics.Histogram
+	)
+	counter.Inc()
+	gauge.Set(1)
+	histogram.Observe(1)
+	g.Expect(counter.Value()).To(BeZero())
+	g.Expect(gauge.Value()).To(BeZero())
+	g.Expect(histogram.Count()).To(BeZero())
+}
@@ -0,0 +1,103 @@
+// Package partition shards messages across servers. A message key hashes onto one of a fixed number of partitions,
+// and partitions are assigned to servers via consistent hashing, so that adding or removing a server only moves
+// the partitions of that server.
+package partition
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strconv"
+)
+
+// DefaultVirtualNodes is the number of points each server gets on the hash ring. More points spread partitions
+// more evenly across servers.
+const DefaultVirtualNodes = 100
+
+// Map tells which server owns each partition. Servers send it to clients, which connect to the owner of the
+// partition of their key.
+type Map struct {
+	Partitions int      `json:"partitions"`
+	Owners     []string `json:"owners"` // Client address "host:port" of the owner of each partition, by partition
+}
+
+// NewMap assigns partitions to nodes, given by their client addresses, via a hash ring on which each node gets
+// virtualNodes points. A partition is owned by the node of the first point at or after the hash of the partition.
+// The assignment depends only on the set of nodes, not on their order.
+func NewMap(nodes []string, partitions int, virtualNodes int) (Map, error) {
+	if len(nodes) == 0 {
+		return Map{}, fmt.Errorf("no nodes")
+	}
+	if partitions < 1 {
+		return Map{}, fmt.Errorf("partitions < 1")
+	}
+	if virtualNodes < 1 {
+		return Map{}, fmt.Errorf("virtualNodes < 1")
+	}
+
+	type point struct {
+		hash uint64
+		node string
+	}
+	ring := make([]point, 0, len(nodes)*virtualNodes)
+	for _, node := range nodes {
+		for i := 0; i < virtualNodes; i++ {
+			ring = append(ring, point{hash: hash64(node + "#" + strconv.Itoa(i)), node: node})
+		}
+	}
+	sort.Slice(ring, func(i, j int) bool {
+		if ring[i].hash != ring[j].hash {
+			return ring[i].hash < ring[j].hash
+		}
+		return ring[i].node < ring[j].node // Deterministic on collisions
+	})
+
+	owners := make([]string, partitions)
+	for p := range owners {
+		h := hash64("partition#" + strconv.Itoa(p))
+		i := sort.Search(len(ring), func(i int) bool { return ring[i].hash >= h })
+		if i == len(ring) {
+			i = 0 // Wrap around the ring
+		}
+		owners[p] = ring[i].node
+	}
+
+	return Map{Partitions: partitions, Owners: owners}, nil
+}
+
+// Validate checks that the map has an owner for each partition, e.g. after receiving it.
+func (m Map) Validate() error {
+	if m.Partitions < 1 {
+		return fmt.Errorf("partitions < 1")
+	}
+	if len(m.Owners) != m.Partitions {
+		return fmt.Errorf("%d owners for %d partitions", len(m.Owners), m.Partitions)
+	}
+	for p, owner := range m.Owners {
+		if owner == "" {
+			return fmt.Errorf("partition %d has no owner", p)
+		}
+	}
+	return nil
+}
+
+// Owner returns the client address of the owner of the partition of key.
+func (m Map) Owner(key string) string {
+	return m.Owners[Of(key, m.Partitions)]
+}
+
+// Of returns the partition of key among the given number of partitions.
+func Of(key string, partitions int) int {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key)) // Never fails
+	return int(h.Sum32() % uint32(partitions))
+}
+
+// hash64 places a node or a partition on the ring. Unlike FNV, SHA-256 spreads strings that differ only in their
+// last characters, like the addresses of servers on one host, all over the ring.
+func hash64(s string) uint64 {
+	sum := sha256.Sum256([]byte(s))
+	return binary.BigEndian.Uint64(sum[:8])
+}
@@ -0,0 +1,111 @@
+package partition_test
+
+import (
+	. "github.com/onsi/gomega"
+	"github.com/varfrog/quicpubsub/pkg/partition"
+	"strconv"
+	"testing"
+)
+
+func TestOf(t *testing.T) {
+	g := NewWithT(t)
+
+	for i := 0; i < 1000; i++ {
+		key := "key-" + strconv.Itoa(i)
+		p := partition.Of(key, 16)
+		g.Expect(p).To(BeNumerically(">=", 0))
+		g.Expect(p).To(BeNumerically("<", 16))
+		g.Expect(partition.Of(key, 16)).To(Equal(p), "the same key always hashes onto the same partition")
+	}
+}
+
+func TestNewMap_IndependentOfNodeOrder(t *testing.T) {
+	g := NewWithT(t)
+
+	m1, err := partition.NewMap([]string{"a:5000", "b:5000", "c:5000"}, 64, partition.DefaultVirtualNodes)
+	g.Expect(err).ToNot(HaveOccurred())
+	m2, err := partition.NewMap([]string{"c:5000", "a:5000", "b:5000"}, 64, partition.DefaultVirtualNodes)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	g.Expect(m1).To(Equal(m2))
+	g.Expect(m1.Validate()).To(Succeed())
+}
+
+func TestNewMap_SpreadsPartitions(t *testing.T) {
+	g := NewWithT(t)
+
+	nodes := []string{"a:5000", "b:5000", "c:5000", "d:5000"}
+	m, err := partition.NewMap(nodes, 1024, partition.DefaultVirtualNodes)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	counts := make(map[string]int)
+	for _, owner := range m.Owners {
+		counts[owner]++
+	}
+	for _, node := range nodes {
+		// An even share is 256
+		g.Expect(counts[node]).To(BeNumerically(">", 128), node)
+		g.Expect(counts[node]).To(BeNumerically("<", 384), node)
+	}
+}
+
+func TestNewMap_SpreadsPartitionsAcrossSimilarAddresses(t *testing.T) {
+	g := NewWithT(t)
+
+	nodes := []string{"127.0.0.1:6000", "127.0.0.1:6001"}
+	m, err := partition.NewMap(nodes, 1024, partition.DefaultVirtualNodes)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	counts := make(map[string]int)
+	for _, owner := range m.Owners {
+		counts[owner]++
+	}
+	for _, node := range nodes {
+		// An even share is 512
+		g.Expect(counts[node]).To(BeNumerically(">", 256), node)
+	}
+}
+
+func TestNewMap_RemovingNodeMovesOnlyItsPartitions(t *testing.T) {
+	g := NewWithT(t)
+
+	before, err := partition.NewMap([]string{"a:5000", "b:5000", "c:5000"}, 256, partition.DefaultVirtualNodes)
+	g.Expect(err).ToNot(HaveOccurred())
+	after, err := partition.NewMap([]string{"a:5000", "c:5000"}, 256, partition.DefaultVirtualNodes)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	for p := range before.Owners {
+		if before.Owners[p] != "b:5000" {
+			g.Expect(after.Owners[p]).To(Equal(before.Owners[p]), "partition %d", p)
+		}
+	}
+}
+
+func TestNewMap_InvalidArguments(t *testing.T) {
+	g := NewWithT(t)
+
+	_, err := partition.NewMap(nil, 16, partition.DefaultVirtualNodes)
+	g.Expect(err).To(HaveOccurred())
+	_, err = partition.NewMap([]string{"a:5000"}, 0, partition.DefaultVirtualNodes)
+	g.Expect(err).To(HaveOccurred())
+	_, err = partition.NewMap([]string{"a:5000"}, 16, 0)
+	g.Expect(err).To(HaveOccurred())
+}
+
+func TestMap_Validate(t *testing.T) {
+	g := NewWithT(t)
+
+	g.Expect(partition.Map{}.Validate()).ToNot(Succeed())
+	g.Expect(partition.Map{Partitions: 2, Owners: []string{"a:5000"}}.Validate()).ToNot(Succeed())
+	g.Expect(partition.Map{Partitions: 2, Owners: []string{"a:5000", ""}}.Validate()).ToNot(Succeed())
+	g.Expect(partition.Map{Partitions: 2, Owners: []string{"a:5000", "b:5000"}}.Validate()).To(Succeed())
+}
+
+func TestMap_Owner(t *testing.T) {
+	g := NewWithT(t)
+
+	m, err := partition.NewMap([]string{"a:5000", "b:5000"}, 8, partition.DefaultVirtualNodes)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	g.Expect(m.Owner("orders")).To(Equal(m.Owners[partition.Of("orders", 8)]))
+}
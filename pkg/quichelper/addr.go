@@ -0,0 +1,77 @@
+package quichelper
+
+import (
+	"flag"
+	"fmt"
+	"github.com/pkg/errors"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+)
+
+// ParseAddrList splits a comma-separated list of addresses, e.g. "127.0.0.1:5000,[::1]:5000", ignoring
+// whitespace and empty entries.
+func ParseAddrList(list string) []string {
+	var addrs []string
+	for _, addr := range strings.Split(list, ",") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			addrs = append(addrs, addr)
+		}
+	}
+	return addrs
+}
+
+// ValidateAddr checks that addr is in the form "host:port" with a port in the range 1-65535. The host may be
+// an IPv4 address, an IPv6 address in square brackets, a hostname, or empty to mean all interfaces.
+// Hostnames are not resolved here.
+func ValidateAddr(addr string) error {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return errors.Wrapf(err, "address '%s'", addr)
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port < 1 || port > 65535 {
+		return fmt.Errorf("address '%s': invalid port '%s'", addr, portStr)
+	}
+	if _, err := netip.ParseAddr(host); host != "" && err != nil && !isValidHostname(host) {
+		return fmt.Errorf("address '%s': invalid host '%s'", addr, host)
+	}
+	return nil
+}
+
+// ApplyDeprecatedPortFlag maps the port flag portFlag, which predates the address flag addrFlag, onto the address
+// 127.0.0.1:<port> that it meant, if the port flag is set and the address flag is not. Returns a warning about the
+// deprecated flag if it is set, or an empty string. Call after the flags are parsed.
+func ApplyDeprecatedPortFlag(flags *flag.FlagSet, portFlag, addrFlag string, addr *string) string {
+	set := make(map[string]bool)
+	flags.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+	if !set[portFlag] {
+		return ""
+	}
+	if set[addrFlag] {
+		return fmt.Sprintf("Flag -%s is deprecated and ignored as -%s is set", portFlag, addrFlag)
+	}
+	*addr = net.JoinHostPort("127.0.0.1", flags.Lookup(portFlag).Value.String())
+	return fmt.Sprintf("Flag -%s is deprecated, use -%s %s", portFlag, addrFlag, *addr)
+}
+
+// isValidHostname checks that the host consists of dot-separated labels of letters, digits and hyphens.
+func isValidHostname(host string) bool {
+	if len(host) > 253 {
+		return false
+	}
+	for _, label := range strings.Split(strings.TrimSuffix(host, "."), ".") {
+		if len(label) < 1 || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for _, r := range label {
+			if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-') {
+				return false
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,87 @@
+package quichelper_test
+
+import (
+	"flag"
+	. "github.com/onsi/gomega"
+	"github.com/varfrog/quicpubsub/pkg/quichelper"
+	"testing"
+)
+
+func TestParseAddrList(t *testing.T) {
+	g := NewWithT(t)
+
+	g.Expect(quichelper.ParseAddrList("127.0.0.1:5000, [::1]:5000,,")).To(Equal([]string{"127.0.0.1:5000", "[::1]:5000"}))
+	g.Expect(quichelper.ParseAddrList("")).To(BeEmpty())
+}
+
+func TestValidateAddr(t *testing.T) {
+	t.Run("Valid addresses", func(t *testing.T) {
+		g := NewWithT(t)
+
+		for _, addr := range []string{
+			"127.0.0.1:5000",
+			"[::1]:5000",
+			"[fe80::1%eth0]:5000",
+			":5000",
+			"localhost:5000",
+			"broker-1.example.com:65535",
+		} {
+			g.Expect(quichelper.ValidateAddr(addr)).To(Succeed(), addr)
+		}
+	})
+
+	t.Run("Invalid addresses", func(t *testing.T) {
+		g := NewWithT(t)
+
+		for _, addr := range []string{
+			"",
+			"127.0.0.1",
+			"::1:5000",
+			"127.0.0.1:0",
+			"127.0.0.1:65536",
+			"127.0.0.1:port",
+			"-broker:5000",
+			"bro ker:5000",
+		} {
+			g.Expect(quichelper.ValidateAddr(addr)).ToNot(Succeed(), addr)
+		}
+	})
+}
+
+func TestApplyDeprecatedPortFlag(t *testing.T) {
+	parse := func(args ...string) (*flag.FlagSet, *string) {
+		flags := flag.NewFlagSet("server", flag.ContinueOnError)
+		addr := flags.String("pub-in-addr", "127.0.0.1:5000", "")
+		flags.Int("pub-in-port", 5000, "")
+		if err := flags.Parse(args); err != nil {
+			t.Fatal(err)
+		}
+		return flags, addr
+	}
+
+	t.Run("Port flag is not set", func(t *testing.T) {
+		g := NewWithT(t)
+
+		flags, addr := parse("-pub-in-addr", ":6000")
+		g.Expect(quichelper.ApplyDeprecatedPortFlag(flags, "pub-in-port", "pub-in-addr", addr)).To(BeEmpty())
+		g.Expect(*addr).To(Equal(":6000"))
+	})
+
+	t.Run("Port flag is set", func(t *testing.T) {
+		g := NewWithT(t)
+
+		flags, addr := parse("-pub-in-port", "6000")
+		g.Expect(quichelper.ApplyDeprecatedPortFlag(flags, "pub-in-port", "pub-in-addr", addr)).
+			To(Equal("Flag -pub-in-port is deprecated, use -pub-in-addr 127.0.0.1:6000"))
+		g.Expect(*addr).To(Equal("127.0.0.1:6000"))
+	})
+
+	t.Run("Both flags are set", func(t *testing.T) {
+		g := NewWithT(t)
+
+		flags, addr := parse("-pub-in-port", "6000", "-pub-in-addr", ":7000")
+		g.Expect(quichelper.ApplyDeprecatedPortFlag(flags, "pub-in-port", "pub-in-addr", addr)).
+			To(Equal("Flag -pub-in-port is deprecated and ignored as -pub-in-addr is set"))
+		g.Expect(*addr).To(Equal(":7000"))
+	})
+}
@@ -0,0 +1,149 @@
+package quichelper
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// maxSuspectDelay bounds the time from the last heartbeat until SuspectAt, which the tail of the distribution of a
+// high Threshold would put beyond what a time.Duration holds.
+const maxSuspectDelay = time.Hour * 24
+
+// FailureDetectorConfig configures a FailureDetector.
+type FailureDetectorConfig struct {
+	Threshold       float64       // Phi at which the peer is suspected, e.g. 8 to wrongly suspect once in 10^8 times
+	Window          int           // Number of the last intervals between heartbeats to estimate their distribution from
+	MinStdDev       time.Duration // Floor of the standard deviation, which steady heartbeats bring close to 0
+	AcceptablePause time.Duration // Lateness of a heartbeat on top of the usual, e.g. as a lost packet is resent
+}
+
+// FailureDetector is a phi accrual failure detector, see "The φ Accrual Failure Detector" by Hayashibara et al.
+// Rather than suspecting a peer once a fixed timeout passes without a heartbeat, it estimates the normal
+// distribution of the intervals between the last heartbeats, and measures suspicion as phi = -log10(P), P being the
+// probability that the next heartbeat comes later than it has so far. So it suspects a peer on a steady link soon,
+// while it bears with a peer on a link of varying latency. It is safe for concurrent use.
+type FailureDetector struct {
+	config        FailureDetectorConfig
+	firstInterval time.Duration // Expected before the intervals are measured
+	suspectY      float64       // Standard deviations beyond the mean at which Phi reaches the threshold
+	now           func() time.Time
+
+	mu         sync.Mutex
+	last       time.Time // Of the last heartbeat, or when the detector was created
+	heartbeats uint64
+	intervals  []time.Duration
+	next       int // Index in intervals of the next interval, once the window is full
+}
+
+// NewFailureDetector is the constructor for FailureDetector. Until the first intervals are measured, they are
+// assumed to be about firstInterval, e.g. PingerConfig.Interval.
+func NewFailureDetector(config FailureDetectorConfig, firstInterval time.Duration) *FailureDetector {
+	return newFailureDetector(config, firstInterval, time.Now)
+}
+
+func newFailureDetector(config FailureDetectorConfig, firstInterval time.Duration, now func() time.Time) *FailureDetector {
+	if config.Window < 1 {
+		config.Window = 1
+	}
+	return &FailureDetector{
+		config:        config,
+		firstInterval: firstInterval,
+		suspectY:      deviations(config.Threshold),
+		now:           now,
+		last:          now(),
+		intervals:     make([]time.Duration, 0, config.Window),
+	}
+}
+
+// Heartbeat records a heartbeat of the peer, e.g. a ping. The first one only starts the first interval, as the
+// time before it depends on when the peer connected rather than on how often it sends heartbeats.
+func (d *FailureDetector) Heartbeat() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	now := d.now()
+	if d.heartbeats > 0 {
+		d.add(now.Sub(d.last))
+	}
+	d.heartbeats++
+	d.last = now
+}
+
+// Phi returns the suspicion of the peer now: close to 0 right after a heartbeat, growing as the next one is overdue.
+func (d *FailureDetector) Phi() float64 {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	mean, stdDev := d.distribution()
+	y := float64(d.now().Sub(d.last)-mean) / float64(stdDev)
+	// 1 - F(y) of the standard normal distribution
+	return -math.Log10(math.Erfc(y/math.Sqrt2) / 2)
+}
+
+// Suspected tells whether Phi has reached FailureDetectorConfig.Threshold.
+func (d *FailureDetector) Suspected() bool {
+	return d.Phi() >= d.config.Threshold
+}
+
+// SuspectAt returns the time at which the peer is suspected unless a heartbeat comes first, for the deadlines of
+// reading heartbeats. It is at most maxSuspectDelay after the last heartbeat.
+func (d *FailureDetector) SuspectAt() time.Time {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	mean, stdDev := d.distribution()
+	delay := float64(mean) + d.suspectY*float64(stdDev)
+	if delay > float64(maxSuspectDelay) {
+		delay = float64(maxSuspectDelay)
+	}
+	return d.last.Add(time.Duration(delay))
+}
+
+// deviations returns y where 1 - F(y) = 10^-threshold for the standard normal distribution, i.e. where phi reaches
+// the threshold. It bisects phi rather than taking math.Erfcinv(2 * 10^-threshold), which is +Inf from a threshold of
+// about 16 on, as Erfcinv takes the 1 - x that rounds to 1. Beyond a threshold of about 300, where 1 - F(y) is too
+// small for a float64, it is about 37.5.
+func deviations(threshold float64) float64 {
+	low, high := -40.0, 40.0
+	for i := 0; i < 100; i++ {
+		y := (low + high) / 2
+		if -math.Log10(math.Erfc(y/math.Sqrt2)/2) < threshold {
+			low = y
+		} else {
+			high = y
+		}
+	}
+	return high
+}
+
+// add adds an interval to the window, replacing the oldest once the window is full.
+func (d *FailureDetector) add(interval time.Duration) {
+	if len(d.intervals) < d.config.Window {
+		d.intervals = append(d.intervals, interval)
+		return
+	}
+	d.intervals[d.next] = interval
+	d.next = (d.next + 1) % d.config.Window
+}
+
+// distribution returns the mean interval between heartbeats, AcceptablePause included, and the standard deviation,
+// at least MinStdDev. Until an interval is measured, they are the first interval and a quarter of it.
+func (d *FailureDetector) distribution() (time.Duration, time.Duration) {
+	mean, stdDev := d.firstInterval, d.firstInterval/4
+	if n := float64(len(d.intervals)); n > 0 {
+		var sum, squares float64
+		for _, interval := range d.intervals {
+			sum += float64(interval)
+		}
+		m := sum / n
+		for _, interval := range d.intervals {
+			squares += (float64(interval) - m) * (float64(interval) - m)
+		}
+		mean, stdDev = time.Duration(m), time.Duration(math.Sqrt(squares/n))
+	}
+	if stdDev < d.config.MinStdDev {
+		stdDev = d.config.MinStdDev
+	}
+	if stdDev <= 0 {
+		stdDev = 1
+	}
+	return mean + d.config.AcceptablePause, stdDev
+}
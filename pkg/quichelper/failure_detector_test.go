@@ -0,0 +1,116 @@
+package quichelper
+
+import (
+	. "github.com/onsi/gomega"
+	"testing"
+	"time"
+)
+
+// simulatedDetector is a FailureDetector on a simulated clock.
+type simulatedDetector struct {
+	now time.Time
+	*FailureDetector
+}
+
+func newSimulatedDetector(config FailureDetectorConfig, firstInterval time.Duration) *simulatedDetector {
+	d := &simulatedDetector{now: time.Unix(1700000000, 0)}
+	d.FailureDetector = newFailureDetector(config, firstInterval, func() time.Time { return d.now })
+	return d
+}
+
+// heartbeats records heartbeats after each of the intervals.
+func (d *simulatedDetector) heartbeats(intervals ...time.Duration) {
+	for _, interval := range intervals {
+		d.now = d.now.Add(interval)
+		d.Heartbeat()
+	}
+}
+
+// suspectAfter returns the time from the last heartbeat until the peer is suspected.
+func (d *simulatedDetector) suspectAfter() time.Duration {
+	return d.SuspectAt().Sub(d.last)
+}
+
+func repeat(interval time.Duration, n int) []time.Duration {
+	intervals := make([]time.Duration, n)
+	for i := range intervals {
+		intervals[i] = interval
+	}
+	return intervals
+}
+
+func TestFailureDetector(t *testing.T) {
+	config := FailureDetectorConfig{Threshold: 8, Window: 10, MinStdDev: time.Millisecond * 10}
+
+	t.Run("Suspects the peer as the next heartbeat is overdue", func(t *testing.T) {
+		g := NewWithT(t)
+
+		d := newSimulatedDetector(config, time.Second)
+		d.heartbeats(time.Second, time.Millisecond*900, time.Millisecond*1100, time.Millisecond*900, time.Millisecond*1100)
+		g.Expect(d.Phi()).To(BeNumerically("<", 0.01), "right after a heartbeat")
+		g.Expect(d.Suspected()).To(BeFalse())
+
+		d.now = d.now.Add(time.Second)
+		g.Expect(d.Phi()).To(BeNumerically("~", 0.3, 0.01), "the mean interval makes it a coin toss")
+		d.now = d.now.Add(time.Millisecond * 200)
+		g.Expect(d.Phi()).To(BeNumerically("~", 1.64, 0.01), "two standard deviations late")
+		g.Expect(d.Suspected()).To(BeFalse())
+
+		g.Expect(d.suspectAfter()).To(BeNumerically("~", time.Millisecond*1561, time.Millisecond),
+			"5.61 standard deviations late, which happens once in 10^8 times")
+		d.now = d.SuspectAt()
+		g.Expect(d.Phi()).To(BeNumerically("~", config.Threshold, 0.01))
+		d.now = d.now.Add(time.Millisecond)
+		g.Expect(d.Suspected()).To(BeTrue())
+
+		d.heartbeats(time.Millisecond) // The peer was only late
+		g.Expect(d.Suspected()).To(BeFalse())
+	})
+
+	t.Run("Adapts to how regular the heartbeats are", func(t *testing.T) {
+		g := NewWithT(t)
+
+		steady := newSimulatedDetector(config, time.Second)
+		steady.heartbeats(repeat(time.Millisecond*100, 11)...)
+		g.Expect(steady.suspectAfter()).To(BeNumerically("~", time.Millisecond*156, time.Millisecond),
+			"within the minimal standard deviation of the interval")
+
+		jittery := newSimulatedDetector(config, time.Second)
+		for i := 0; i < 6; i++ {
+			jittery.heartbeats(time.Millisecond*50, time.Millisecond*150)
+		}
+		g.Expect(jittery.suspectAfter()).To(BeNumerically("~", time.Millisecond*381, time.Millisecond),
+			"a standard deviation of 50ms")
+
+		// Intervals older than the window are forgotten
+		jittery.heartbeats(repeat(time.Millisecond*100, 10)...)
+		g.Expect(jittery.suspectAfter()).To(Equal(steady.suspectAfter()))
+	})
+
+	t.Run("Expects the first interval until it measures any", func(t *testing.T) {
+		g := NewWithT(t)
+
+		d := newSimulatedDetector(FailureDetectorConfig{Threshold: 8, AcceptablePause: time.Second}, time.Second*4)
+		start := d.now
+		g.Expect(d.SuspectAt().Sub(start)).To(BeNumerically("~", time.Millisecond*10612, time.Millisecond),
+			"the interval and the pause, plus 5.61 quarters of the interval")
+
+		// The time until the first heartbeat depends on when the peer connected, so it is no interval
+		d.heartbeats(time.Millisecond * 100)
+		g.Expect(d.suspectAfter()).To(BeNumerically("~", time.Millisecond*10612, time.Millisecond))
+		d.heartbeats(time.Second * 4)
+		g.Expect(d.suspectAfter()).To(BeNumerically("~", time.Second*5, time.Millisecond),
+			"a single interval deviates from none, and MinStdDev is 0")
+	})
+	t.Run("Suspects the peer at most maxSuspectDelay after the last heartbeat", func(t *testing.T) {
+		g := NewWithT(t)
+
+		high := newSimulatedDetector(FailureDetectorConfig{Threshold: 100}, time.Second)
+		g.Expect(high.suspectAfter()).To(BeNumerically("~", time.Millisecond*6318, time.Millisecond),
+			"21.27 quarters of the interval late")
+
+		// A long interval puts the time beyond what a time.Duration holds
+		beyond := newSimulatedDetector(FailureDetectorConfig{Threshold: 100}, time.Hour*1000000)
+		g.Expect(beyond.suspectAfter()).To(Equal(maxSuspectDelay))
+	})
+}
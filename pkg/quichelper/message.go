@@ -0,0 +1,127 @@
+package quichelper
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/varfrog/quicpubsub/pkg/buffer"
+	"github.com/varfrog/quicpubsub/pkg/sdk"
+	"io"
+	"net"
+)
+
+// frameHeaderBytes is the size of the frame header: the lengths of the message headers and of the payload.
+const frameHeaderBytes = 8
+
+// EncodeMessage encodes a message into a frame: a 4-byte big-endian length of the JSON-encoded message headers,
+// a 4-byte big-endian length of the payload, the message headers (omitted if there are none), and the payload.
+func EncodeMessage(message sdk.Message) ([]byte, error) {
+	var headers []byte
+	if len(message.Headers) > 0 {
+		var err error
+		if headers, err = json.Marshal(message.Headers); err != nil {
+			return nil, errors.Wrap(err, "json.Marshal")
+		}
+	}
+
+	frame := make([]byte, frameHeaderBytes, frameHeaderBytes+len(headers)+len(message.Payload))
+	binary.BigEndian.PutUint32(frame[0:4], uint32(len(headers)))
+	binary.BigEndian.PutUint32(frame[4:8], uint32(len(message.Payload)))
+	frame = append(frame, headers...)
+	frame = append(frame, message.Payload...)
+
+	return frame, nil
+}
+
+// DecodeMessage decodes a frame made by EncodeMessage.
+func DecodeMessage(frame []byte) (sdk.Message, error) {
+	if len(frame) < frameHeaderBytes {
+		return sdk.Message{}, fmt.Errorf("frame of %d bytes is too short", len(frame))
+	}
+	headersLen := uint64(binary.BigEndian.Uint32(frame[0:4]))
+	payloadLen := uint64(binary.BigEndian.Uint32(frame[4:8]))
+	if frameHeaderBytes+headersLen+payloadLen != uint64(len(frame)) {
+		return sdk.Message{}, fmt.Errorf(
+			"frame of %d bytes does not fit %d bytes of headers and %d bytes of payload",
+			len(frame), headersLen, payloadLen)
+	}
+
+	var message sdk.Message
+	if headersLen > 0 {
+		if err := json.Unmarshal(frame[frameHeaderBytes:frameHeaderBytes+headersLen], &message.Headers); err != nil {
+			return sdk.Message{}, UnmarshalError{Data: frame, Err: err}
+		}
+	}
+	message.Payload = frame[frameHeaderBytes+headersLen:]
+
+	return message, nil
+}
+
+// ReadFrame reads a single frame made by EncodeMessage from the stream into a pooled buffer, without decoding it.
+// The caller releases the buffer once done with it.
+// Returns an error if the frame is larger than maxMessageBytes.
+// Returns io.EOF if the stream ended before a frame started, and ErrNetworkTimeout on timeout.
+func ReadFrame(stream io.Reader, maxMessageBytes int) (*buffer.Buffer, error) {
+	// The header is read into a pooled buffer too, as a local array would escape to the heap via io.Reader
+	header := buffer.Get(frameHeaderBytes)
+	defer header.Release()
+	if _, err := io.ReadFull(stream, header.Bytes()); err != nil {
+		return nil, wrapReadError(err)
+	}
+
+	headerBytes := header.Bytes()
+	frameLen := frameHeaderBytes + uint64(binary.BigEndian.Uint32(headerBytes[0:4])) + uint64(binary.BigEndian.Uint32(headerBytes[4:8]))
+	if frameLen > uint64(maxMessageBytes) {
+		return nil, fmt.Errorf("message of %d bytes exceeds the limit of %d bytes", frameLen, maxMessageBytes)
+	}
+
+	frame := buffer.Get(int(frameLen))
+	copy(frame.Bytes(), headerBytes)
+	if _, err := io.ReadFull(stream, frame.Bytes()[frameHeaderBytes:]); err != nil {
+		frame.Release()
+		return nil, wrapReadError(err)
+	}
+
+	return frame, nil
+}
+
+// ReadMessage reads and decodes a single message from the stream, see ReadFrame. The payload of the message refers
+// to the returned buffer, which the caller releases once done with the message.
+func ReadMessage(stream io.Reader, maxMessageBytes int) (sdk.Message, *buffer.Buffer, error) {
+	frame, err := ReadFrame(stream, maxMessageBytes)
+	if err != nil {
+		return sdk.Message{}, nil, err
+	}
+	message, err := DecodeMessage(frame.Bytes())
+	if err != nil {
+		frame.Release()
+		return sdk.Message{}, nil, copyUnmarshalError(err)
+	}
+	return message, frame, nil
+}
+
+// copyUnmarshalError copies the data of an UnmarshalError, which may refer to a buffer that is about to be released.
+func copyUnmarshalError(err error) error {
+	var unmarshalErr UnmarshalError
+	if errors.As(err, &unmarshalErr) {
+		unmarshalErr.Data = append([]byte(nil), unmarshalErr.Data...)
+		return unmarshalErr
+	}
+	return err
+}
+
+// wrapReadError returns ErrNetworkTimeout on timeout, io.EOF as is, and wraps other errors.
+func wrapReadError(err error) error {
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return ErrNetworkTimeout
+	}
+	if errors.Is(err, io.EOF) {
+		return io.EOF
+	}
+	if errors.Is(err, io.ErrUnexpectedEOF) {
+		return errors.Wrap(err, "stream ended in the middle of a message")
+	}
+	return errors.Wrap(err, "stream.Read")
+}
@@ -0,0 +1,112 @@
+package quichelper_test
+
+import (
+	"bytes"
+	. "github.com/onsi/gomega"
+	"github.com/varfrog/quicpubsub/pkg/quichelper"
+	"github.com/varfrog/quicpubsub/pkg/sdk"
+	"io"
+	"testing"
+)
+
+func TestEncodeMessage(t *testing.T) {
+	t.Run("Encodes and decodes a message with headers", func(t *testing.T) {
+		g := NewWithT(t)
+
+		message := sdk.Message{
+			Headers: map[string]string{sdk.HeaderOrigin: "broker-1"},
+			Payload: []byte("hello"),
+		}
+
+		frame, err := quichelper.EncodeMessage(message)
+		g.Expect(err).To(BeNil())
+
+		decoded, err := quichelper.DecodeMessage(frame)
+		g.Expect(err).To(BeNil())
+		g.Expect(decoded).To(Equal(message))
+	})
+
+	t.Run("Encodes a message without headers", func(t *testing.T) {
+		g := NewWithT(t)
+
+		frame, err := quichelper.EncodeMessage(sdk.Message{Payload: []byte("hello")})
+		g.Expect(err).To(BeNil())
+		g.Expect(frame).To(HaveLen(8 + len("hello")))
+
+		decoded, err := quichelper.DecodeMessage(frame)
+		g.Expect(err).To(BeNil())
+		g.Expect(decoded.Headers).To(BeEmpty())
+		g.Expect(decoded.Payload).To(Equal([]byte("hello")))
+	})
+}
+
+func TestDecodeMessage_Corrupt(t *testing.T) {
+	g := NewWithT(t)
+
+	frame, _ := quichelper.EncodeMessage(sdk.Message{Payload: []byte("hello")})
+
+	_, err := quichelper.DecodeMessage(frame[:len(frame)-1])
+	g.Expect(err).To(HaveOccurred())
+
+	_, err = quichelper.DecodeMessage([]byte{1, 2})
+	g.Expect(err).To(HaveOccurred())
+}
+
+func TestReadMessage(t *testing.T) {
+	t.Run("Reads consecutive messages", func(t *testing.T) {
+		g := NewWithT(t)
+
+		frame1, _ := quichelper.EncodeMessage(sdk.Message{Payload: []byte("first")})
+		frame2, _ := quichelper.EncodeMessage(sdk.Message{Payload: []byte("second")})
+		stream := bytes.NewReader(append(frame1, frame2...))
+
+		message, buf, err := quichelper.ReadMessage(stream, 100)
+		g.Expect(err).To(BeNil())
+		g.Expect(message.Payload).To(Equal([]byte("first")))
+		buf.Release()
+
+		message, buf, err = quichelper.ReadMessage(stream, 100)
+		g.Expect(err).To(BeNil())
+		g.Expect(message.Payload).To(Equal([]byte("second")))
+		buf.Release()
+
+		_, _, err = quichelper.ReadMessage(stream, 100)
+		g.Expect(err).To(Equal(io.EOF))
+	})
+
+	t.Run("Rejects messages exceeding the limit", func(t *testing.T) {
+		g := NewWithT(t)
+
+		frame, _ := quichelper.EncodeMessage(sdk.Message{Payload: []byte("hello")})
+
+		_, err := quichelper.ReadFrame(bytes.NewReader(frame), len(frame)-1)
+		g.Expect(err).To(HaveOccurred())
+	})
+
+	t.Run("Fails on a truncated message", func(t *testing.T) {
+		g := NewWithT(t)
+
+		frame, _ := quichelper.EncodeMessage(sdk.Message{Payload: []byte("hello")})
+
+		_, err := quichelper.ReadFrame(bytes.NewReader(frame[:len(frame)-1]), 100)
+		g.Expect(err).To(HaveOccurred())
+		g.Expect(err).ToNot(Equal(io.EOF))
+	})
+}
+
+// BenchmarkReadFrame measures reading frames into pooled buffers, which allocates nothing once the pools are warm.
+func BenchmarkReadFrame(b *testing.B) {
+	frame, _ := quichelper.EncodeMessage(sdk.Message{Payload: make([]byte, 900)})
+	stream := bytes.NewReader(frame)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		stream.Reset(frame)
+		buf, err := quichelper.ReadFrame(stream, 1000)
+		if err != nil {
+			b.Fatal(err)
+		}
+		buf.Release()
+	}
+}
@@ -17,9 +17,9 @@ func (m *MockStreamForSendingPings) StreamID() quic.StreamID {
 	return 123
 }
 
-func (m *MockStreamForSendingPings) Read([]byte) (n int, err error) {
+func (m *MockStreamForSendingPings) Read(p []byte) (n int, err error) {
 	atomic.AddUint32(&m.TimesReadCalled, 1)
-	return 1, nil
+	return len(p), nil
 }
 
 func (m *MockStreamForSendingPings) CancelRead(quic.StreamErrorCode) {
@@ -30,9 +30,9 @@ func (m *MockStreamForSendingPings) SetReadDeadline(time.Time) error {
 	return nil
 }
 
-func (m *MockStreamForSendingPings) Write([]byte) (n int, err error) {
+func (m *MockStreamForSendingPings) Write(p []byte) (n int, err error) {
 	atomic.AddUint32(&m.TimesWriteCalled, 1)
-	return 1, nil
+	return len(p), nil
 }
 
 func (m *MockStreamForSendingPings) Close() error {
@@ -66,10 +66,10 @@ func (m *MockStreamForReceivingPings) StreamID() quic.StreamID {
 	return 123
 }
 
-func (m *MockStreamForReceivingPings) Read([]byte) (n int, err error) {
+func (m *MockStreamForReceivingPings) Read(p []byte) (n int, err error) {
 	atomic.AddUint32(&m.TimesReadCalled, 1)
 	time.Sleep(m.SleepBeforeReads)
-	return 1, nil
+	return len(p), nil
 }
 
 func (m *MockStreamForReceivingPings) CancelRead(quic.StreamErrorCode) {
@@ -80,9 +80,9 @@ func (m *MockStreamForReceivingPings) SetReadDeadline(time.Time) error {
 	return nil
 }
 
-func (m *MockStreamForReceivingPings) Write([]byte) (n int, err error) {
+func (m *MockStreamForReceivingPings) Write(p []byte) (n int, err error) {
 	atomic.AddUint32(&m.TimesWriteCalled, 1)
-	return 1, nil
+	return len(p), nil
 }
 
 func (m *MockStreamForReceivingPings) Close() error {
@@ -8,10 +8,11 @@ import (
 )
 
 // SendPings is a helper for code deduplication in the connectors to send ping requests,
-// write logs and running cancel() on failure.
+// write logs and running cancel() on failure. monitor is optional, see Pinger.SendPings.
 func SendPings(
 	ctx context.Context,
 	pinger *Pinger,
+	monitor *PingMonitor,
 	streamCh <-chan quic.Stream,
 	cancel func(),
 	logger *zap.Logger,
@@ -22,9 +23,11 @@ func SendPings(
 			return
 		case stream := <-streamCh:
 			logger.Info("Starting to send pings")
-			if err := pinger.SendPings(ctx, stream); err != nil {
+			if err := pinger.SendPings(ctx, stream, monitor); err != nil {
 				if errors.Is(err, ErrNetworkTimeout) {
 					logger.Info("Got timeout from server when pinging, server possibly down, will shut down")
+				} else if code, ok := ApplicationErrorCode(err); ok {
+					logger.Info("Connection closed, stopping pinging", zap.Uint64("error_code", uint64(code)))
 				} else {
 					logger.Error("SendPings", zap.Error(err))
 				}
@@ -38,10 +41,12 @@ func SendPings(
 // ReceiveAndRespondToPings is a helper for code deduplication in the server. It:
 // 1. waits for a ping stream to become available
 // 2. once the stream is available starts receiving and responding to ping results
-// 3. calls cancel() on failure
+// 3. calls cancel() on failure, counting timeouts in PingerConfig.Timeouts
+// monitor is optional, see Pinger.AcceptPings.
 func ReceiveAndRespondToPings(
 	ctx context.Context,
 	pinger *Pinger,
+	monitor *PingMonitor,
 	streamCh <-chan quic.Stream,
 	cancel func(),
 	logMessageOnTimeout string,
@@ -53,9 +58,12 @@ func ReceiveAndRespondToPings(
 			return
 		case stream := <-streamCh:
 			logger.Info("Accepting pings")
-			if err := pinger.AcceptPings(ctx, stream); err != nil {
+			if err := pinger.AcceptPings(ctx, stream, monitor); err != nil {
 				if errors.Is(err, ErrNetworkTimeout) {
 					logger.Info(logMessageOnTimeout)
+					pinger.config.Timeouts.Inc()
+				} else if code, ok := ApplicationErrorCode(err); ok {
+					logger.Info("Connection closed, stopping accepting pings", zap.Uint64("error_code", uint64(code)))
 				} else {
 					logger.Error("AcceptPings", zap.Error(err))
 				}
@@ -65,3 +73,23 @@ func ReceiveAndRespondToPings(
 		}
 	}
 }
+
+// NewLoggingMonitor returns a monitor of the pinger, see Pinger.NewMonitor, that logs when the connection degrades
+// and when it recovers.
+func NewLoggingMonitor(pinger *Pinger, logger *zap.Logger) *PingMonitor {
+	return pinger.NewMonitor(
+		func(stats PingStats) {
+			logger.Warn("Connection degraded", pingStatsFields(stats)...)
+		},
+		func(stats PingStats) {
+			logger.Info("Connection recovered", pingStatsFields(stats)...)
+		})
+}
+
+func pingStatsFields(stats PingStats) []zap.Field {
+	return []zap.Field{
+		zap.Duration("rtt", stats.RTT),
+		zap.Duration("jitter", stats.Jitter),
+		zap.Float64("loss", stats.Loss),
+	}
+}
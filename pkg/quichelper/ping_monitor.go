@@ -0,0 +1,210 @@
+package quichelper
+
+import (
+	"encoding/binary"
+	"sync"
+	"time"
+)
+
+// pingFrameBytes is the size of a ping frame, see pingFrame.
+const pingFrameBytes = 40
+
+// pingFrame is what the peers write to the ping stream: five big-endian 8-byte fields. Each frame is a ping of its
+// own and acknowledges the last ping received from the peer, so that both peers measure round trips on their own
+// clocks: a round trip takes from Timestamp until the echo arrives, less the EchoDelay that the peer held it for.
+type pingFrame struct {
+	Seq           uint64        // Of this ping, counting from 1
+	Timestamp     time.Duration // Of sending this ping, on the clock of the sender
+	EchoSeq       uint64        // Of the last ping received from the peer, 0 if none
+	EchoTimestamp time.Duration // Timestamp of that ping, on the clock of the peer
+	EchoDelay     time.Duration // Between receiving that ping and sending this one
+}
+
+func (f pingFrame) marshal(b []byte) {
+	binary.BigEndian.PutUint64(b[0:8], f.Seq)
+	binary.BigEndian.PutUint64(b[8:16], uint64(f.Timestamp))
+	binary.BigEndian.PutUint64(b[16:24], f.EchoSeq)
+	binary.BigEndian.PutUint64(b[24:32], uint64(f.EchoTimestamp))
+	binary.BigEndian.PutUint64(b[32:40], uint64(f.EchoDelay))
+}
+
+func unmarshalPingFrame(b []byte) pingFrame {
+	return pingFrame{
+		Seq:           binary.BigEndian.Uint64(b[0:8]),
+		Timestamp:     time.Duration(binary.BigEndian.Uint64(b[8:16])),
+		EchoSeq:       binary.BigEndian.Uint64(b[16:24]),
+		EchoTimestamp: time.Duration(binary.BigEndian.Uint64(b[24:32])),
+		EchoDelay:     time.Duration(binary.BigEndian.Uint64(b[32:40])),
+	}
+}
+
+// PingStats describe the quality of a connection, measured by its pings over a moving window of round trips.
+type PingStats struct {
+	Sent     uint64        // Pings sent on the connection
+	Received uint64        // Pings received from the peer
+	Samples  int           // Round trips in the window, up to PingerConfig.Window
+	RTT      time.Duration // Mean round-trip time of the pings in the window that were not lost
+	Jitter   time.Duration // Mean difference between the round-trip times of consecutive pings in the window
+	Loss     float64       // Fraction of the pings in the window that were lost, see PingerConfig.LossRTT
+	Degraded bool          // Whether the connection is degraded, see PingerConfig.DegradedRTT and DegradedLoss
+}
+
+// pingSample is a round trip in the window of a PingMonitor.
+type pingSample struct {
+	rtt  time.Duration
+	lost bool
+}
+
+// PingMonitor measures the quality of a single connection from the pings that a Pinger exchanges on it, and calls
+// back when the connection degrades and when it recovers. It is safe for concurrent use.
+type PingMonitor struct {
+	config      PingerConfig
+	onDegraded  func(PingStats)
+	onRecovered func(PingStats)
+	now         func() time.Time
+	start       time.Time // Timestamps are durations since
+
+	mu         sync.Mutex
+	sent       uint64
+	received   uint64
+	last       pingFrame // Last ping received from the peer, echoed by the next ping sent
+	receivedAt time.Time // Of last
+	acked      uint64    // Seq of the last ping of ours that the peer acknowledged
+	samples    []pingSample
+	next       int // Index in samples of the next round trip, once the window is full
+	degraded   bool
+}
+
+// NewMonitor returns a PingMonitor for a connection that the Pinger pings on, see SendPings and AcceptPings.
+// onDegraded and onRecovered are optional and called with the stats as the connection degrades and recovers.
+func (s *Pinger) NewMonitor(onDegraded func(PingStats), onRecovered func(PingStats)) *PingMonitor {
+	return newPingMonitor(s.config, onDegraded, onRecovered, time.Now)
+}
+
+func newPingMonitor(
+	config PingerConfig,
+	onDegraded func(PingStats),
+	onRecovered func(PingStats),
+	now func() time.Time,
+) *PingMonitor {
+	if config.Window < 1 {
+		config.Window = 1
+	}
+	return &PingMonitor{
+		config:      config,
+		onDegraded:  onDegraded,
+		onRecovered: onRecovered,
+		now:         now,
+		start:       now(),
+		samples:     make([]pingSample, 0, config.Window),
+	}
+}
+
+// Stats returns the stats of the connection so far.
+func (m *PingMonitor) Stats() PingStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.stats()
+}
+
+// nextPing returns the next ping to send, which acknowledges the last ping received.
+func (m *PingMonitor) nextPing() pingFrame {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := m.now()
+	m.sent++
+	frame := pingFrame{Seq: m.sent, Timestamp: now.Sub(m.start)}
+	if m.last.Seq != 0 {
+		frame.EchoSeq = m.last.Seq
+		frame.EchoTimestamp = m.last.Timestamp
+		frame.EchoDelay = now.Sub(m.receivedAt)
+	}
+	return frame
+}
+
+// record records a ping received from the peer and the round trip that it acknowledges, if any.
+func (m *PingMonitor) record(frame pingFrame) {
+	m.mu.Lock()
+	now := m.now()
+	m.received++
+	m.last, m.receivedAt = frame, now
+	if frame.EchoSeq <= m.acked { // Acknowledged already, or nothing
+		m.mu.Unlock()
+		return
+	}
+	// The peer acknowledges the last ping it received, so pings skipped over never made it in time
+	for seq := m.acked + 1; seq < frame.EchoSeq; seq++ {
+		m.add(pingSample{lost: true})
+	}
+	m.acked = frame.EchoSeq
+	rtt := now.Sub(m.start) - frame.EchoTimestamp - frame.EchoDelay
+	if rtt < 0 {
+		rtt = 0 // The peer measured its delay on a coarser clock
+	}
+	// Frames are never dropped by the stream, only delayed, so late round trips count as lost
+	m.add(pingSample{rtt: rtt, lost: m.config.LossRTT > 0 && rtt > m.config.LossRTT})
+
+	stats := m.stats()
+	changed := stats.Degraded != m.degraded
+	m.degraded = stats.Degraded
+	m.mu.Unlock()
+
+	if changed && stats.Degraded {
+		m.config.Degradations.Inc()
+		if m.onDegraded != nil {
+			m.onDegraded(stats)
+		}
+	} else if changed && !stats.Degraded && m.onRecovered != nil {
+		m.onRecovered(stats)
+	}
+}
+
+// add adds a round trip to the window, replacing the oldest once the window is full.
+func (m *PingMonitor) add(sample pingSample) {
+	if len(m.samples) < m.config.Window {
+		m.samples = append(m.samples, sample)
+		return
+	}
+	m.samples[m.next] = sample
+	m.next = (m.next + 1) % m.config.Window
+}
+
+func (m *PingMonitor) stats() PingStats {
+	stats := PingStats{Sent: m.sent, Received: m.received, Samples: len(m.samples)}
+	if len(m.samples) == 0 {
+		return stats
+	}
+
+	var (
+		lost, measured int
+		sum, deltas    time.Duration
+		prev           *pingSample
+	)
+	for i := range m.samples {
+		sample := &m.samples[(m.next+i)%len(m.samples)] // Oldest first
+		if sample.lost {
+			lost++
+			continue
+		}
+		sum += sample.rtt
+		if prev != nil {
+			delta := sample.rtt - prev.rtt
+			if delta < 0 {
+				delta = -delta
+			}
+			deltas += delta
+		}
+		measured++
+		prev = sample
+	}
+	stats.Loss = float64(lost) / float64(len(m.samples))
+	if measured > 0 {
+		stats.RTT = sum / time.Duration(measured)
+	}
+	if measured > 1 {
+		stats.Jitter = deltas / time.Duration(measured-1)
+	}
+	stats.Degraded = m.config.DegradedRTT > 0 && stats.RTT > m.config.DegradedRTT ||
+		m.config.DegradedLoss > 0 && stats.Loss > m.config.DegradedLoss
+	return stats
+}
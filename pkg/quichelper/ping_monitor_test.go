@@ -0,0 +1,123 @@
+package quichelper
+
+import (
+	. "github.com/onsi/gomega"
+	"testing"
+	"time"
+)
+
+// simulatedPeers are the ping monitors of a client and of a server that exchange pings on a simulated clock.
+type simulatedPeers struct {
+	now    time.Time
+	client *PingMonitor
+	server *PingMonitor
+}
+
+func newSimulatedPeers(config PingerConfig, onDegraded func(PingStats), onRecovered func(PingStats)) *simulatedPeers {
+	p := &simulatedPeers{now: time.Unix(1700000000, 0)}
+	clock := func() time.Time { return p.now }
+	p.client = newPingMonitor(config, onDegraded, onRecovered, clock)
+	p.now = p.now.Add(time.Hour) // The clocks of the peers need not agree
+	p.server = newPingMonitor(config, nil, nil, clock)
+	return p
+}
+
+// ping has the client ping the server, which responds right away, taking rtt there and back.
+func (p *simulatedPeers) ping(rtt time.Duration) {
+	ping := p.client.nextPing()
+	p.now = p.now.Add(rtt / 2)
+	p.server.record(ping)
+	response := p.server.nextPing()
+	p.now = p.now.Add(rtt / 2)
+	p.client.record(response)
+}
+
+func TestPingFrame(t *testing.T) {
+	g := NewWithT(t)
+
+	frame := pingFrame{Seq: 2, Timestamp: time.Second, EchoSeq: 1, EchoTimestamp: time.Hour, EchoDelay: time.Millisecond}
+	var b [pingFrameBytes]byte
+	frame.marshal(b[:])
+	g.Expect(unmarshalPingFrame(b[:])).To(Equal(frame))
+}
+
+func TestPingMonitor(t *testing.T) {
+	config := PingerConfig{Window: 4, LossRTT: time.Millisecond * 100, DegradedRTT: time.Millisecond * 50, DegradedLoss: 0.3}
+
+	t.Run("Measures the round-trip time, jitter and loss over the window", func(t *testing.T) {
+		g := NewWithT(t)
+
+		var degraded, recovered []PingStats
+		peers := newSimulatedPeers(config,
+			func(stats PingStats) { degraded = append(degraded, stats) },
+			func(stats PingStats) { recovered = append(recovered, stats) })
+		g.Expect(peers.client.Stats()).To(Equal(PingStats{}))
+
+		for _, rtt := range []time.Duration{10, 20, 10, 20} {
+			peers.ping(rtt * time.Millisecond)
+		}
+		stats := peers.client.Stats()
+		g.Expect(stats).To(Equal(PingStats{
+			Sent:     4,
+			Received: 4,
+			Samples:  4,
+			RTT:      time.Millisecond * 15,
+			Jitter:   time.Millisecond * 10,
+		}))
+
+		// A late round trip counts as lost
+		peers.ping(time.Millisecond * 200)
+		stats = peers.client.Stats()
+		g.Expect(stats.Samples).To(Equal(4), "the oldest round trip has left the window")
+		g.Expect(stats.Loss).To(Equal(0.25))
+		g.Expect(stats.RTT).To(Equal(time.Duration(50) * time.Millisecond / 3))
+		g.Expect(stats.Degraded).To(BeFalse())
+		g.Expect(degraded).To(BeEmpty())
+
+		peers.ping(time.Millisecond * 200)
+		g.Expect(degraded).To(HaveLen(1))
+		g.Expect(degraded[0].Loss).To(Equal(0.5))
+		g.Expect(degraded[0].Degraded).To(BeTrue())
+		peers.ping(time.Millisecond * 60)
+		g.Expect(degraded).To(HaveLen(1), "called once as the connection degrades")
+
+		for i := 0; i < 3; i++ {
+			peers.ping(time.Millisecond * 60)
+		}
+		g.Expect(peers.client.Stats().Degraded).To(BeTrue(), "slow though nothing is lost")
+		g.Expect(recovered).To(BeEmpty())
+
+		peers.ping(time.Millisecond * 10)
+		g.Expect(recovered).To(HaveLen(1))
+		g.Expect(recovered[0].Degraded).To(BeFalse())
+		g.Expect(recovered[0].RTT).To(Equal(time.Microsecond * 47500))
+	})
+
+	t.Run("Measures the round trips of the responses, less the time the peer held them", func(t *testing.T) {
+		g := NewWithT(t)
+
+		peers := newSimulatedPeers(config, nil, nil)
+		for i := 0; i < 3; i++ {
+			peers.ping(time.Millisecond * 30)
+			peers.now = peers.now.Add(time.Second) // The interval of the client
+		}
+		stats := peers.server.Stats()
+		g.Expect(stats.Samples).To(Equal(2), "the last response is yet to be acknowledged")
+		g.Expect(stats.RTT).To(Equal(time.Millisecond * 30))
+		g.Expect(stats.Loss).To(BeZero())
+	})
+
+	t.Run("Counts the pings that the peer skipped over as lost", func(t *testing.T) {
+		g := NewWithT(t)
+
+		peers := newSimulatedPeers(config, nil, nil)
+		peers.client.nextPing() // Not received by the server
+		peers.ping(time.Millisecond * 10)
+
+		stats := peers.client.Stats()
+		g.Expect(stats.Sent).To(Equal(uint64(2)))
+		g.Expect(stats.Samples).To(Equal(2))
+		g.Expect(stats.Loss).To(Equal(0.5))
+		g.Expect(stats.RTT).To(Equal(time.Millisecond * 10))
+	})
+}
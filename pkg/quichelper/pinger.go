@@ -4,14 +4,24 @@ import (
 	"context"
 	"github.com/pkg/errors"
 	"github.com/quic-go/quic-go"
+	"github.com/varfrog/quicpubsub/pkg/metrics"
 	"go.uber.org/zap"
+	"io"
 	"net"
 	"time"
 )
 
 type PingerConfig struct {
-	Interval time.Duration // Repeat pings at this interval
-	Timeout  time.Duration // Ping request read/write deadline exceeding which the peer is considered dead
+	Interval        time.Duration         // Repeat pings at this interval
+	FailureDetector FailureDetectorConfig // Tells when the peer is considered dead, from how often its pings came so far
+	Timeouts        *metrics.Counter      // Counts the peers that time out in ReceiveAndRespondToPings, optional
+
+	// The quality of a connection, see PingMonitor
+	Window       int              // Number of the last round trips that PingStats are computed over
+	LossRTT      time.Duration    // Round trips that take longer count as lost, none do if 0
+	DegradedRTT  time.Duration    // Mean round-trip time above which the connection is degraded, ignored if 0
+	DegradedLoss float64          // Fraction of lost pings above which the connection is degraded, ignored if 0
+	Degradations *metrics.Counter // Counts the connections that degrade, optional
 }
 
 // Pinger is used to accept or send continuous ping requests to another peer.
@@ -23,7 +33,16 @@ type Pinger struct {
 func NewDefaultPingerConfig() PingerConfig {
 	return PingerConfig{
 		Interval: time.Second * 5,
-		Timeout:  time.Second * 10,
+		FailureDetector: FailureDetectorConfig{
+			Threshold:       8,
+			Window:          100,
+			MinStdDev:       time.Millisecond * 200,
+			AcceptablePause: time.Second * 2,
+		},
+		Window:       20,
+		LossRTT:      time.Second * 2,
+		DegradedRTT:  time.Millisecond * 500,
+		DegradedLoss: 0.1,
 	}
 }
 
@@ -34,21 +53,26 @@ func NewPinger(config PingerConfig, logger *zap.Logger) *Pinger {
 
 // SendPings continuously writes ping requests to the given stream at an interval, and reads responses.
 // It serves both as a keep-alive request and also so that the peer knows the other peer is gone.
-// Returns quichelper.ErrNetworkTimeout when ending takes longer than the timeout or when no incoming ping comes
-// in for longer than the timeout.
-func (s *Pinger) SendPings(ctx context.Context, stream quic.Stream) error {
+// Returns quichelper.ErrNetworkTimeout when the response to a ping is so late that the failure detector, see
+// PingerConfig.FailureDetector, suspects that the peer is dead, or when sending takes that long.
+// The monitor, see NewMonitor, measures the connection, and may be nil if nobody is interested.
+func (s *Pinger) SendPings(ctx context.Context, stream quic.Stream, monitor *PingMonitor) error {
+	if monitor == nil {
+		monitor = s.NewMonitor(nil, nil)
+	}
+	detector := NewFailureDetector(s.config.FailureDetector, s.config.Interval)
 	for {
 		select {
 		case <-ctx.Done():
 			s.logger.Debug("Stopping sending pings, context cancelled")
 			return nil
 		case <-time.After(s.config.Interval):
-			if err := s.sendPing(stream); err != nil {
+			if err := s.sendPing(stream, monitor, detector); err != nil {
 				return errors.Wrap(err, "sendPing")
 			}
 			s.logger.Debug("Sent ping")
 
-			if err := s.waitForPing(stream); err != nil {
+			if err := s.waitForPing(stream, monitor, detector); err != nil {
 				return errors.Wrap(err, "waitForPing")
 			}
 			s.logger.Debug("Got response to ping")
@@ -57,8 +81,14 @@ func (s *Pinger) SendPings(ctx context.Context, stream quic.Stream) error {
 }
 
 // AcceptPings accepts ping requests from a peer and to each responds with a its own ping request.
-// Returns ErrNetworkTimeout when no requests arrive within the timeout or if sending exceeds the timeout.
-func (s *Pinger) AcceptPings(ctx context.Context, stream quic.Stream) error {
+// Returns ErrNetworkTimeout when the next request is so late that the failure detector, see
+// PingerConfig.FailureDetector, suspects that the peer is dead, or when sending takes that long.
+// The monitor, see NewMonitor, measures the connection, and may be nil if nobody is interested.
+func (s *Pinger) AcceptPings(ctx context.Context, stream quic.Stream, monitor *PingMonitor) error {
+	if monitor == nil {
+		monitor = s.NewMonitor(nil, nil)
+	}
+	detector := NewFailureDetector(s.config.FailureDetector, s.config.Interval)
 	for {
 		select {
 		case <-ctx.Done():
@@ -66,13 +96,13 @@ func (s *Pinger) AcceptPings(ctx context.Context, stream quic.Stream) error {
 			return nil
 		default:
 			// Accept a ping message
-			if err := s.waitForPing(stream); err != nil {
+			if err := s.waitForPing(stream, monitor, detector); err != nil {
 				return errors.Wrap(err, "waitForPing")
 			}
 			s.logger.Debug("Got ping")
 
 			// Respond
-			if err := s.sendPing(stream); err != nil {
+			if err := s.sendPing(stream, monitor, detector); err != nil {
 				return errors.Wrap(err, "sendPing")
 			}
 			s.logger.Debug("Responded to ping")
@@ -80,14 +110,16 @@ func (s *Pinger) AcceptPings(ctx context.Context, stream quic.Stream) error {
 	}
 }
 
-// sendPing sends a ping request on the given stream with a timeout.
+// sendPing sends a ping request on the given stream until the detector suspects the peer.
 // It returns ErrNetworkTimeout on time out.
-func (s *Pinger) sendPing(stream quic.Stream) error {
-	if err := stream.SetWriteDeadline(time.Now().Add(s.config.Timeout)); err != nil {
+func (s *Pinger) sendPing(stream quic.Stream, monitor *PingMonitor, detector *FailureDetector) error {
+	if err := stream.SetWriteDeadline(detector.SuspectAt()); err != nil {
 		return errors.Wrap(err, "SetWriteDeadline")
 	}
 
-	_, err := stream.Write([]byte{'1'})
+	var frame [pingFrameBytes]byte
+	monitor.nextPing().marshal(frame[:])
+	_, err := stream.Write(frame[:])
 	if err != nil {
 		var netErr net.Error
 		if errors.As(err, &netErr) && netErr.Timeout() {
@@ -99,14 +131,16 @@ func (s *Pinger) sendPing(stream quic.Stream) error {
 	return nil
 }
 
-// waitForPing waits on stream for a ping response with a timeout.
+// waitForPing waits on stream for a ping response until the detector suspects the peer, and records it in the monitor
+// and as a heartbeat in the detector.
 // Returns ErrNetworkTimeout on timeout.
-func (s *Pinger) waitForPing(stream quic.Stream) error {
-	if err := stream.SetReadDeadline(time.Now().Add(s.config.Timeout)); err != nil {
+func (s *Pinger) waitForPing(stream quic.Stream, monitor *PingMonitor, detector *FailureDetector) error {
+	if err := stream.SetReadDeadline(detector.SuspectAt()); err != nil {
 		return errors.Wrap(err, "SetReadDeadline")
 	}
 
-	_, err := stream.Read(make([]byte, 1))
+	var frame [pingFrameBytes]byte
+	_, err := io.ReadFull(stream, frame[:])
 	if err != nil {
 		var netErr net.Error
 		if errors.As(err, &netErr) && netErr.Timeout() {
@@ -115,5 +149,7 @@ func (s *Pinger) waitForPing(stream quic.Stream) error {
 			return errors.Wrap(err, "stream.Read")
 		}
 	}
+	detector.Heartbeat()
+	monitor.record(unmarshalPingFrame(frame[:]))
 	return nil
 }
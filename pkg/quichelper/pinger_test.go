@@ -2,11 +2,15 @@ package quichelper_test
 
 import (
 	"context"
+	"errors"
 	. "github.com/onsi/gomega"
+	"github.com/quic-go/quic-go"
 	"github.com/stretchr/testify/assert"
 	"github.com/varfrog/quicpubsub/pkg/quichelper"
 	"github.com/varfrog/quicpubsub/pkg/quichelper/mocks"
 	"go.uber.org/zap"
+	"net"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -14,8 +18,8 @@ import (
 func TestPinger(t *testing.T) {
 	pingInterval := time.Millisecond * 50
 	pinger := quichelper.NewPinger(quichelper.PingerConfig{
-		Interval: pingInterval,
-		Timeout:  time.Second, // Not important here
+		Interval:        pingInterval,
+		FailureDetector: quichelper.FailureDetectorConfig{Threshold: 8}, // Not important here, the mocks have no deadlines
 	}, zap.NewNop())
 
 	t.Run("Sends a number of pings and responds to them", func(t *testing.T) {
@@ -26,7 +30,7 @@ func TestPinger(t *testing.T) {
 		defer cancel()
 
 		stream := &mocks.MockStreamForSendingPings{}
-		g.Expect(pinger.SendPings(ctx, stream)).To(Succeed())
+		g.Expect(pinger.SendPings(ctx, stream, nil)).To(Succeed())
 
 		assert.GreaterOrEqual(t, stream.TimesWriteCalled, uint32(5))
 		assert.GreaterOrEqual(t, stream.TimesReadCalled, uint32(5))
@@ -42,8 +46,9 @@ func TestPinger(t *testing.T) {
 		stream := &mocks.MockStreamForReceivingPings{
 			SleepBeforeReads: pingInterval,
 		}
+		monitor := pinger.NewMonitor(nil, nil)
 		go func() {
-			g.Expect(pinger.AcceptPings(ctx, stream)).To(Succeed())
+			g.Expect(pinger.AcceptPings(ctx, stream, monitor)).To(Succeed())
 		}()
 
 		// Wait for some time for this test to finish sending pings and the Pinger to accept pings
@@ -51,5 +56,93 @@ func TestPinger(t *testing.T) {
 
 		assert.GreaterOrEqual(t, stream.TimesWriteCalled, uint32(5))
 		assert.GreaterOrEqual(t, stream.TimesReadCalled, uint32(5))
+		assert.GreaterOrEqual(t, monitor.Stats().Received, uint64(5))
+		assert.GreaterOrEqual(t, monitor.Stats().Sent, uint64(5))
 	})
 }
+
+func TestPinger_MeasuresRoundTrips(t *testing.T) {
+	g := NewWithT(t)
+
+	latency := time.Millisecond * 5
+	pinger := quichelper.NewPinger(quichelper.PingerConfig{
+		Interval:        time.Millisecond * 20,
+		FailureDetector: quichelper.FailureDetectorConfig{Threshold: 8, AcceptablePause: time.Second},
+		Window:          10,
+		LossRTT:         time.Second,
+		DegradedRTT:     time.Millisecond, // Exceeded by the latency
+	}, zap.NewNop())
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*300)
+	defer cancel()
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	var degraded atomic.Int32
+	onDegraded := func(quichelper.PingStats) { degraded.Add(1) }
+	sender := pinger.NewMonitor(onDegraded, nil)
+	accepter := pinger.NewMonitor(onDegraded, nil)
+	go func() {
+		_ = pinger.AcceptPings(ctx, &pipeStream{Conn: server, latency: latency}, accepter)
+	}()
+	g.Expect(pinger.SendPings(ctx, &pipeStream{Conn: client, latency: latency}, sender)).To(Succeed())
+
+	// Both peers measure the latency of the pipe there and back, though the sender holds the responses for
+	// the interval before acknowledging them
+	for _, stats := range []quichelper.PingStats{sender.Stats(), accepter.Stats()} {
+		g.Expect(stats.Received).To(BeNumerically(">=", 5))
+		g.Expect(stats.RTT).To(BeNumerically(">=", latency*2))
+		g.Expect(stats.RTT).To(BeNumerically("<", latency*10))
+		g.Expect(stats.Loss).To(BeZero())
+		g.Expect(stats.Degraded).To(BeTrue())
+	}
+	g.Expect(degraded.Load()).To(BeEquivalentTo(2), "once for each peer")
+}
+
+func TestPinger_DetectsThatPingsStopped(t *testing.T) {
+	g := NewWithT(t)
+
+	pinger := quichelper.NewPinger(quichelper.PingerConfig{
+		Interval: time.Millisecond * 20,
+		FailureDetector: quichelper.FailureDetectorConfig{
+			Threshold:       8,
+			MinStdDev:       time.Millisecond * 10,
+			AcceptablePause: time.Millisecond * 50,
+		},
+	}, zap.NewNop())
+
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	sendFor := time.Millisecond * 300
+	sendCtx, stopSending := context.WithTimeout(context.Background(), sendFor)
+	defer stopSending()
+	go func() {
+		_ = pinger.SendPings(sendCtx, &pipeStream{Conn: client}, nil)
+	}()
+
+	// The pings come every 20ms or so, which the detector learns, so it gives up on the peer soon after they stop
+	start := time.Now()
+	err := pinger.AcceptPings(context.Background(), &pipeStream{Conn: server}, nil)
+	g.Expect(errors.Is(err, quichelper.ErrNetworkTimeout)).To(BeTrue(), "%v", err)
+	g.Expect(time.Since(start)).To(BeNumerically(">=", sendFor), "not given up on while pinging")
+	g.Expect(time.Since(start)).To(BeNumerically("<", sendFor+time.Millisecond*500))
+}
+
+// pipeStream is a quic.Stream over an end of a net.Pipe, which delays the writes by latency.
+type pipeStream struct {
+	net.Conn
+	latency time.Duration
+}
+
+func (s *pipeStream) Write(p []byte) (int, error) {
+	time.Sleep(s.latency)
+	return s.Conn.Write(p)
+}
+
+func (s *pipeStream) StreamID() quic.StreamID          { return 0 }
+func (s *pipeStream) CancelRead(quic.StreamErrorCode)  {}
+func (s *pipeStream) CancelWrite(quic.StreamErrorCode) {}
+func (s *pipeStream) Context() context.Context         { return context.Background() }
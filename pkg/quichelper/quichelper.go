@@ -5,6 +5,8 @@ package quichelper
 import (
 	"encoding/json"
 	"github.com/pkg/errors"
+	"github.com/quic-go/quic-go"
+	"github.com/varfrog/quicpubsub/pkg/buffer"
 	"github.com/varfrog/quicpubsub/pkg/sdk"
 	"io"
 	"net"
@@ -14,7 +16,10 @@ import (
 // Returns UnmarshalError if the event is corrupt.
 // Returns ErrNetworkTimeout on timeout.
 func ReceiveEvent(stream io.Reader, maxMessageBytes uint64) (sdk.Event, error) {
-	msg := make([]byte, maxMessageBytes)
+	buf := buffer.Get(int(maxMessageBytes))
+	defer buf.Release()
+
+	msg := buf.Bytes()
 	n, err := stream.Read(msg)
 	if err != nil {
 		var netErr net.Error
@@ -25,10 +30,112 @@ func ReceiveEvent(stream io.Reader, maxMessageBytes uint64) (sdk.Event, error) {
 	}
 	msg = msg[0:n] // Trim to the number of bytes it read
 
+	var event sdk.Event
+	if err := json.Unmarshal(msg, &event); err != nil {
+		return sdk.Event{}, &UnmarshalError{Data: append([]byte(nil), msg...), Err: err} // msg is released on return
+	}
+
+	return event, nil
+}
+
+// ReceiveStreamEvent reads an event that is all the sender writes to the stream before closing it, up to
+// maxMessageBytes. Unlike ReceiveEvent, it does not fail if the end of the stream comes along with the event.
+// Returns UnmarshalError if the event is corrupt.
+// Returns ErrNetworkTimeout on timeout.
+func ReceiveStreamEvent(stream io.Reader, maxMessageBytes uint64) (sdk.Event, error) {
+	msg, err := io.ReadAll(io.LimitReader(stream, int64(maxMessageBytes)))
+	if err != nil {
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			return sdk.Event{}, ErrNetworkTimeout
+		}
+		return sdk.Event{}, errors.Wrap(err, "io.ReadAll")
+	}
+
 	var event sdk.Event
 	if err := json.Unmarshal(msg, &event); err != nil {
 		return sdk.Event{}, &UnmarshalError{Data: msg, Err: err}
 	}
+	return event, nil
+}
+
+// EventDecoder decodes the events that the peer writes to a stream one after another. Unlike ReceiveEvent, it does
+// not depend on each read returning a single whole event: several events may arrive in one read, and an event may
+// be split across reads.
+type EventDecoder struct {
+	decoder *json.Decoder
+}
 
+func NewEventDecoder(stream io.Reader) *EventDecoder {
+	return &EventDecoder{decoder: json.NewDecoder(stream)}
+}
+
+// Decode returns the next event on the stream.
+// Returns UnmarshalError if the event is valid JSON but not an event, after which the next event can be decoded.
+// Returns ErrNetworkTimeout on timeout.
+func (d *EventDecoder) Decode() (sdk.Event, error) {
+	var raw json.RawMessage
+	if err := d.decoder.Decode(&raw); err != nil {
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			return sdk.Event{}, ErrNetworkTimeout
+		}
+		return sdk.Event{}, errors.Wrap(err, "decoder.Decode")
+	}
+
+	var event sdk.Event
+	if err := json.Unmarshal(raw, &event); err != nil {
+		return sdk.Event{}, UnmarshalError{Data: raw, Err: err}
+	}
 	return event, nil
 }
+
+// SendEvent marshals the event and writes it to the given stream.
+func SendEvent(stream io.Writer, event sdk.Event) error {
+	eventBytes, err := json.Marshal(event)
+	if err != nil {
+		return errors.Wrap(err, "json.Marshal")
+	}
+	if _, err := stream.Write(eventBytes); err != nil {
+		return errors.Wrap(err, "stream.Write")
+	}
+	return nil
+}
+
+// ApplicationErrorCode returns the application error code (see the sdk.ErrorCode* constants) if err is caused by
+// either peer closing the connection with one.
+func ApplicationErrorCode(err error) (quic.ApplicationErrorCode, bool) {
+	var appErr *quic.ApplicationError
+	if errors.As(err, &appErr) {
+		return appErr.ErrorCode, true
+	}
+	return 0, false
+}
+
+// RedirectAddr returns the address of the server to connect to instead if err is caused by the server closing
+// the connection with sdk.ErrorCodeWrongPartition.
+func RedirectAddr(err error) (string, bool) {
+	var appErr *quic.ApplicationError
+	if errors.As(err, &appErr) && appErr.Remote && appErr.ErrorCode == sdk.ErrorCodeWrongPartition {
+		return appErr.ErrorMessage, true
+	}
+	return "", false
+}
+
+// RefusalReason returns the reason the server gave for refusing the connection if err is caused by the server
+// closing the connection with sdk.ErrorCodeConnectionLimit, sdk.ErrorCodeHandshakeRateLimit,
+// sdk.ErrorCodeUnknownTenant, sdk.ErrorCodeQuotaExceeded, sdk.ErrorCodeUnauthenticated or sdk.ErrorCodeAccessDenied.
+func RefusalReason(err error) (quic.ApplicationErrorCode, string, bool) {
+	var appErr *quic.ApplicationError
+	if !errors.As(err, &appErr) || !appErr.Remote {
+		return 0, "", false
+	}
+	switch appErr.ErrorCode {
+	case sdk.ErrorCodeConnectionLimit, sdk.ErrorCodeHandshakeRateLimit, sdk.ErrorCodeUnknownTenant,
+		sdk.ErrorCodeQuotaExceeded, sdk.ErrorCodeUnauthenticated, sdk.ErrorCodeAccessDenied,
+		sdk.ErrorCodeDuplicateIdentity:
+		return appErr.ErrorCode, appErr.ErrorMessage, true
+	default:
+		return 0, "", false
+	}
+}
@@ -4,10 +4,14 @@ import (
 	"bytes"
 	"encoding/json"
 	"errors"
+	"fmt"
 	. "github.com/onsi/gomega"
+	"github.com/quic-go/quic-go"
 	"github.com/varfrog/quicpubsub/pkg/quichelper"
 	"github.com/varfrog/quicpubsub/pkg/sdk"
+	"io"
 	"testing"
+	"testing/iotest"
 )
 
 func TestReceiveEvent(t *testing.T) {
@@ -46,3 +50,120 @@ func TestReceiveEvent(t *testing.T) {
 		}
 	})
 }
+
+func TestEventDecoder(t *testing.T) {
+	t.Run("Events that arrive in one read", func(t *testing.T) {
+		g := NewWithT(t)
+
+		var stream bytes.Buffer
+		g.Expect(quichelper.SendEvent(&stream, sdk.Event{Code: sdk.CodeExistsSubscriber, BrokerID: "b1"})).To(Succeed())
+		g.Expect(quichelper.SendEvent(&stream, sdk.Event{Code: sdk.CodePaused})).To(Succeed())
+
+		decoder := quichelper.NewEventDecoder(&stream)
+		g.Expect(decoder.Decode()).To(Equal(sdk.Event{Code: sdk.CodeExistsSubscriber, BrokerID: "b1"}))
+		g.Expect(decoder.Decode()).To(Equal(sdk.Event{Code: sdk.CodePaused}))
+		_, err := decoder.Decode()
+		g.Expect(errors.Is(err, io.EOF)).To(BeTrue())
+	})
+
+	t.Run("An event split across reads", func(t *testing.T) {
+		g := NewWithT(t)
+
+		msg, _ := json.Marshal(sdk.Event{Code: sdk.CodeSlowDown, RetryAfterMs: 100})
+		decoder := quichelper.NewEventDecoder(iotest.OneByteReader(bytes.NewReader(msg)))
+		g.Expect(decoder.Decode()).To(Equal(sdk.Event{Code: sdk.CodeSlowDown, RetryAfterMs: 100}))
+	})
+
+	t.Run("Corrupt event", func(t *testing.T) {
+		g := NewWithT(t)
+
+		decoder := quichelper.NewEventDecoder(bytes.NewReader([]byte(`{"code":1}{"code":"paused"}`)))
+		_, err := decoder.Decode()
+		var unmarshalErr quichelper.UnmarshalError
+		g.Expect(errors.As(err, &unmarshalErr)).To(BeTrue())
+		g.Expect(unmarshalErr.Data).To(Equal([]byte(`{"code":1}`)))
+
+		// The events after it are decoded still
+		g.Expect(decoder.Decode()).To(Equal(sdk.Event{Code: sdk.CodePaused}))
+	})
+}
+
+func TestRedirectAddr(t *testing.T) {
+	g := NewWithT(t)
+
+	addr, ok := quichelper.RedirectAddr(fmt.Errorf("read: %w", &quic.ApplicationError{
+		Remote:       true,
+		ErrorCode:    sdk.ErrorCodeWrongPartition,
+		ErrorMessage: "10.0.0.2:5000",
+	}))
+	g.Expect(ok).To(BeTrue())
+	g.Expect(addr).To(Equal("10.0.0.2:5000"))
+
+	_, ok = quichelper.RedirectAddr(&quic.ApplicationError{Remote: true, ErrorCode: sdk.ErrorCodeGoingAway})
+	g.Expect(ok).To(BeFalse())
+
+	_, ok = quichelper.RedirectAddr(&quic.ApplicationError{ErrorCode: sdk.ErrorCodeWrongPartition}) // Closed locally
+	g.Expect(ok).To(BeFalse())
+}
+
+func TestReceiveStreamEvent(t *testing.T) {
+	g := NewWithT(t)
+
+	msg, _ := json.Marshal(sdk.Event{Code: sdk.CodeAuthenticate, Token: "token"})
+
+	// The whole stream is read, even if it arrives in pieces along with its end
+	event, err := quichelper.ReceiveStreamEvent(iotest.DataErrReader(iotest.HalfReader(bytes.NewReader(msg))), 1000)
+	g.Expect(err).To(BeNil())
+	g.Expect(event).To(Equal(sdk.Event{Code: sdk.CodeAuthenticate, Token: "token"}))
+
+	_, err = quichelper.ReceiveStreamEvent(bytes.NewReader(msg), uint64(len(msg)-1))
+	var unmarshalErr *quichelper.UnmarshalError
+	g.Expect(errors.As(err, &unmarshalErr)).To(BeTrue())
+}
+
+func TestRefusalReason(t *testing.T) {
+	g := NewWithT(t)
+
+	code, reason, ok := quichelper.RefusalReason(fmt.Errorf("read: %w", &quic.ApplicationError{
+		Remote:       true,
+		ErrorCode:    sdk.ErrorCodeConnectionLimit,
+		ErrorMessage: "the server is at its connection limit",
+	}))
+	g.Expect(ok).To(BeTrue())
+	g.Expect(code).To(BeEquivalentTo(sdk.ErrorCodeConnectionLimit))
+	g.Expect(reason).To(Equal("the server is at its connection limit"))
+
+	code, _, ok = quichelper.RefusalReason(&quic.ApplicationError{Remote: true, ErrorCode: sdk.ErrorCodeHandshakeRateLimit})
+	g.Expect(ok).To(BeTrue())
+	g.Expect(code).To(BeEquivalentTo(sdk.ErrorCodeHandshakeRateLimit))
+
+	code, reason, ok = quichelper.RefusalReason(&quic.ApplicationError{
+		Remote:       true,
+		ErrorCode:    sdk.ErrorCodeQuotaExceeded,
+		ErrorMessage: "subscriptions quota exceeded",
+	})
+	g.Expect(ok).To(BeTrue())
+	g.Expect(code).To(BeEquivalentTo(sdk.ErrorCodeQuotaExceeded))
+	g.Expect(reason).To(Equal("subscriptions quota exceeded"))
+
+	code, reason, ok = quichelper.RefusalReason(&quic.ApplicationError{
+		Remote:       true,
+		ErrorCode:    sdk.ErrorCodeUnauthenticated,
+		ErrorMessage: "unauthenticated: token expired",
+	})
+	g.Expect(ok).To(BeTrue())
+	g.Expect(code).To(BeEquivalentTo(sdk.ErrorCodeUnauthenticated))
+	g.Expect(reason).To(Equal("unauthenticated: token expired"))
+
+	code, reason, ok = quichelper.RefusalReason(&quic.ApplicationError{
+		Remote:       true,
+		ErrorCode:    sdk.ErrorCodeAccessDenied,
+		ErrorMessage: "'alice' may not publish",
+	})
+	g.Expect(ok).To(BeTrue())
+	g.Expect(code).To(BeEquivalentTo(sdk.ErrorCodeAccessDenied))
+	g.Expect(reason).To(Equal("'alice' may not publish"))
+
+	_, _, ok = quichelper.RefusalReason(&quic.ApplicationError{Remote: true, ErrorCode: sdk.ErrorCodeGoingAway})
+	g.Expect(ok).To(BeFalse())
+}
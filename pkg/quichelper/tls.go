@@ -8,25 +8,56 @@ import (
 	"path"
 )
 
+// GetRootCertPool returns a pool of the CAs in ca.pem of certDirPath, which may be a bundle of several.
 func GetRootCertPool(certDirPath string) (*x509.CertPool, error) {
-	caCertPath := path.Join(certDirPath, "ca.pem")
-	caCertRaw, err := os.ReadFile(caCertPath)
+	certPool, err := LoadCertPool(path.Join(certDirPath, "ca.pem"))
 	if err != nil {
-		return nil, errors.Wrap(err, "os.ReadFile(caCertPath)")
+		return nil, errors.Wrap(err, "LoadCertPool")
 	}
+	return certPool, nil
+}
 
-	p, _ := pem.Decode(caCertRaw)
-	if p.Type != "CERTIFICATE" {
-		return nil, errors.Wrap(err, "ca.pem Type != CERTIFICATE")
+// LoadCertPool returns a pool of the certificates of a PEM bundle, e.g. of the CAs that client certificates
+// are verified against.
+func LoadCertPool(bundlePath string) (*x509.CertPool, error) {
+	certPool := x509.NewCertPool()
+	if err := AppendCertsFromFile(certPool, bundlePath); err != nil {
+		return nil, err
 	}
+	return certPool, nil
+}
 
-	caCert, err := x509.ParseCertificate(p.Bytes)
+// AppendCertsFromFile adds the certificates of a PEM bundle to certPool, e.g. to the system pool. Unlike
+// x509.CertPool.AppendCertsFromPEM, it fails if the bundle has no certificates, blocks other than certificates, or
+// certificates that cannot be parsed.
+func AppendCertsFromFile(certPool *x509.CertPool, bundlePath string) error {
+	bundle, err := os.ReadFile(bundlePath)
 	if err != nil {
-		panic(err)
+		return errors.Wrap(err, "os.ReadFile")
 	}
 
-	certPool := x509.NewCertPool()
-	certPool.AddCert(caCert)
+	var certs []*x509.Certificate
+	for rest := bundle; ; {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			return errors.Errorf("%s: PEM block %d is a %s, not a CERTIFICATE", bundlePath, len(certs)+1, block.Type)
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return errors.Wrapf(err, "%s: certificate %d", bundlePath, len(certs)+1)
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return errors.Errorf("no PEM certificates in %s", bundlePath)
+	}
 
-	return certPool, nil
+	for _, cert := range certs {
+		certPool.AddCert(cert)
+	}
+	return nil
 }
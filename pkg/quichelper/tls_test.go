@@ -0,0 +1,152 @@
+package quichelper_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	. "github.com/onsi/gomega"
+	"github.com/varfrog/quicpubsub/pkg/quichelper"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLoadCertPool(t *testing.T) {
+	t.Run("Bundle of several CAs", func(t *testing.T) {
+		g := NewWithT(t)
+
+		caA, _ := newTestCert(t, "CA A", nil, nil, nil)
+		caB, _ := newTestCert(t, "CA B", nil, nil, nil)
+		path := writeTestFile(t, "ca.pem", append(pemCert(caA), pemCert(caB)...))
+
+		certPool, err := quichelper.LoadCertPool(path)
+		g.Expect(err).NotTo(HaveOccurred())
+		g.Expect(certPool.Equal(newCertPool(caA, caB))).To(BeTrue())
+	})
+
+	t.Run("No PEM block", func(t *testing.T) {
+		g := NewWithT(t)
+
+		_, err := quichelper.LoadCertPool(writeTestFile(t, "ca.pem", []byte("not a certificate")))
+		g.Expect(err).To(MatchError(ContainSubstring("no PEM certificates")))
+	})
+
+	t.Run("Block other than a certificate", func(t *testing.T) {
+		g := NewWithT(t)
+
+		ca, _ := newTestCert(t, "CA", nil, nil, nil)
+		key := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: []byte{1}})
+		_, err := quichelper.LoadCertPool(writeTestFile(t, "ca.pem", append(pemCert(ca), key...)))
+		g.Expect(err).To(MatchError(ContainSubstring("PEM block 2 is a PRIVATE KEY")))
+	})
+
+	t.Run("Certificate that cannot be parsed", func(t *testing.T) {
+		g := NewWithT(t)
+
+		cert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: []byte{1, 2, 3}})
+		_, err := quichelper.LoadCertPool(writeTestFile(t, "ca.pem", cert))
+		g.Expect(err).To(MatchError(ContainSubstring("certificate 1")))
+	})
+
+	t.Run("Missing file", func(t *testing.T) {
+		g := NewWithT(t)
+
+		_, err := quichelper.LoadCertPool(filepath.Join(t.TempDir(), "ca.pem"))
+		g.Expect(err).To(MatchError(os.ErrNotExist))
+	})
+}
+
+func TestGetRootCertPool(t *testing.T) {
+	g := NewWithT(t)
+
+	ca, _ := newTestCert(t, "CA", nil, nil, nil)
+	path := writeTestFile(t, "ca.pem", pemCert(ca))
+
+	certPool, err := quichelper.GetRootCertPool(filepath.Dir(path))
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(certPool.Equal(newCertPool(ca))).To(BeTrue())
+
+	_, err = quichelper.GetRootCertPool(t.TempDir())
+	g.Expect(err).To(HaveOccurred())
+}
+
+func TestAppendCertsFromFile(t *testing.T) {
+	g := NewWithT(t)
+
+	caA, _ := newTestCert(t, "CA A", nil, nil, nil)
+	caB, _ := newTestCert(t, "CA B", nil, nil, nil)
+	certPool := newCertPool(caA)
+
+	g.Expect(quichelper.AppendCertsFromFile(certPool, writeTestFile(t, "ca.pem", pemCert(caB)))).To(Succeed())
+	g.Expect(certPool.Equal(newCertPool(caA, caB))).To(BeTrue())
+}
+
+// testCertValidity is the validity of test certificates unless given.
+var testCertValidity = [2]time.Time{time.Now().Add(-time.Hour), time.Now().Add(time.Hour)}
+
+// newTestCert returns a certificate valid for the given names and its key. The certificate is a CA if dnsNames is
+// nil, and self-signed if issuer is nil.
+func newTestCert(
+	t *testing.T,
+	commonName string,
+	dnsNames []string,
+	issuer *x509.Certificate,
+	issuerKey *ecdsa.PrivateKey,
+	validity ...time.Time,
+) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(validity) == 0 {
+		validity = testCertValidity[:]
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: commonName},
+		DNSNames:              dnsNames,
+		NotBefore:             validity[0],
+		NotAfter:              validity[1],
+		IsCA:                  dnsNames == nil,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	if issuer == nil {
+		issuer, issuerKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, issuer, &key.PublicKey, issuerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+func pemCert(cert *x509.Certificate) []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+}
+
+func newCertPool(certs ...*x509.Certificate) *x509.CertPool {
+	certPool := x509.NewCertPool()
+	for _, cert := range certs {
+		certPool.AddCert(cert)
+	}
+	return certPool
+}
+
+func writeTestFile(t *testing.T, name string, data []byte) string {
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
@@ -0,0 +1,172 @@
+package quichelper
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/quic-go/quic-go"
+	"net"
+	"strings"
+)
+
+// CertificateErrorReason tells why the certificate of a server failed verification.
+type CertificateErrorReason string
+
+const (
+	CertificateUnknownAuthority CertificateErrorReason = "signed by an unknown authority"
+	CertificateNameMismatch     CertificateErrorReason = "not valid for the server name"
+	CertificateExpired          CertificateErrorReason = "expired or not yet valid"
+	CertificatePinMismatch      CertificateErrorReason = "public key matches no pin"
+	CertificateInvalid          CertificateErrorReason = "invalid"
+)
+
+// CertificateError is returned by DialAddr when the certificate of the server fails verification.
+type CertificateError struct {
+	Reason     CertificateErrorReason
+	ServerName string // The name the certificate was verified against
+	Err        error  // The error of crypto/x509, if any
+}
+
+func (e *CertificateError) Error() string {
+	msg := fmt.Sprintf("server certificate for '%s': %s", e.ServerName, e.Reason)
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	return msg
+}
+
+func (e *CertificateError) Unwrap() error {
+	return e.Err
+}
+
+// ServerVerification is how clients verify the certificate of the server they connect to.
+type ServerVerification struct {
+	RootCAs *x509.CertPool // CAs the certificate must be issued by, the system ones if nil
+
+	// PinnedSPKIHashes are SHA-256 hashes of public keys (of the SubjectPublicKeyInfo of certificates). If any are
+	// given, the certificate of the server must have one of these public keys instead of being issued by RootCAs.
+	PinnedSPKIHashes [][]byte
+}
+
+// Apply makes config verify servers as v says. The verification of crypto/tls is replaced by
+// config.VerifyConnection, as quic-go reports its errors as plain strings, while DialAddr returns the errors of
+// VerifyConnection as a CertificateError.
+func (v ServerVerification) Apply(config *tls.Config) {
+	config.InsecureSkipVerify = true // Verified by VerifyConnection
+	config.VerifyConnection = v.verify
+}
+
+// verify verifies the certificate of the server against state.ServerName, which DialAddr sets to the name
+// the certificate is expected to be valid for.
+func (v ServerVerification) verify(state tls.ConnectionState) error {
+	if len(state.PeerCertificates) == 0 {
+		return &CertificateError{Reason: CertificateInvalid, ServerName: state.ServerName,
+			Err: errors.New("no certificate")}
+	}
+	leaf := state.PeerCertificates[0]
+
+	if len(v.PinnedSPKIHashes) > 0 {
+		hash := sha256.Sum256(leaf.RawSubjectPublicKeyInfo)
+		for _, pin := range v.PinnedSPKIHashes {
+			if bytes.Equal(pin, hash[:]) {
+				return nil
+			}
+		}
+		return &CertificateError{Reason: CertificatePinMismatch, ServerName: state.ServerName,
+			Err: fmt.Errorf("sha256/%s", base64.StdEncoding.EncodeToString(hash[:]))}
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, cert := range state.PeerCertificates[1:] {
+		intermediates.AddCert(cert)
+	}
+	_, err := leaf.Verify(x509.VerifyOptions{
+		Roots:         v.RootCAs,
+		Intermediates: intermediates,
+		DNSName:       state.ServerName,
+	})
+	if err != nil {
+		return &CertificateError{Reason: certificateErrorReason(err), ServerName: state.ServerName, Err: err}
+	}
+	return nil
+}
+
+// certificateErrorReason classifies an error of x509.Certificate.Verify.
+func certificateErrorReason(err error) CertificateErrorReason {
+	var (
+		unknownAuthorityErr x509.UnknownAuthorityError
+		hostnameErr         x509.HostnameError
+		invalidErr          x509.CertificateInvalidError
+	)
+	switch {
+	case errors.As(err, &unknownAuthorityErr):
+		return CertificateUnknownAuthority
+	case errors.As(err, &hostnameErr):
+		return CertificateNameMismatch
+	case errors.As(err, &invalidErr) && invalidErr.Reason == x509.Expired:
+		return CertificateExpired
+	default:
+		return CertificateInvalid
+	}
+}
+
+// ParseSPKIPins parses comma-separated base64 SHA-256 hashes of public keys, optionally prefixed by "sha256/" as
+// in HTTP public key pinning, e.g. as printed by "ca -print-pin".
+func ParseSPKIPins(pins string) ([][]byte, error) {
+	var hashes [][]byte
+	for _, pin := range strings.Split(pins, ",") {
+		pin = strings.TrimPrefix(strings.TrimSpace(pin), "sha256/")
+		if pin == "" {
+			continue
+		}
+		hash, err := base64.StdEncoding.DecodeString(pin)
+		if err != nil {
+			return nil, errors.Wrapf(err, "pin '%s'", pin)
+		}
+		if len(hash) != sha256.Size {
+			return nil, fmt.Errorf("pin '%s' is not a SHA-256 hash", pin)
+		}
+		hashes = append(hashes, hash)
+	}
+	return hashes, nil
+}
+
+// DialAddr dials the server at addr like quic.DialAddr. The server name that the certificate of the server is
+// verified against, and that is sent via SNI, is tlsConfig.ServerName, or the host of addr if not set. If
+// the certificate of the server fails the verification of a ServerVerification, a *CertificateError is returned.
+func DialAddr(ctx context.Context, addr string, tlsConfig *tls.Config, quicConfig *quic.Config) (quic.Connection, error) {
+	tlsConfig = tlsConfig.Clone()
+	if tlsConfig.ServerName == "" {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, errors.Wrap(err, "net.SplitHostPort")
+		}
+		tlsConfig.ServerName = host
+	}
+
+	// crypto/tls leaves IP addresses out of the server name of the connection state, as they are not sent via SNI
+	var verifyErr error
+	if verify := tlsConfig.VerifyConnection; verify != nil {
+		serverName := tlsConfig.ServerName
+		tlsConfig.VerifyConnection = func(state tls.ConnectionState) error {
+			state.ServerName = serverName
+			verifyErr = verify(state)
+			return verifyErr
+		}
+	}
+
+	conn, err := quic.DialAddr(ctx, addr, tlsConfig, quicConfig)
+	if err != nil {
+		var certErr *CertificateError
+		if errors.As(verifyErr, &certErr) {
+			return nil, certErr
+		}
+		return nil, err
+	}
+	return conn, nil
+}
@@ -0,0 +1,168 @@
+package quichelper_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	. "github.com/onsi/gomega"
+	"github.com/quic-go/quic-go"
+	"github.com/varfrog/quicpubsub/pkg/quichelper"
+	"testing"
+	"time"
+)
+
+func TestServerVerification(t *testing.T) {
+	ca, caKey := newTestCert(t, "CA", nil, nil, nil)
+	otherCA, otherCAKey := newTestCert(t, "Other CA", nil, nil, nil)
+	cert, _ := newTestCert(t, "Server", []string{"broker.example.com"}, ca, caKey)
+
+	verify := func(verification quichelper.ServerVerification, serverName string, certs ...*x509.Certificate) error {
+		config := &tls.Config{}
+		verification.Apply(config)
+		return config.VerifyConnection(tls.ConnectionState{ServerName: serverName, PeerCertificates: certs})
+	}
+	expectReason := func(g *WithT, err error, reason quichelper.CertificateErrorReason) {
+		var certErr *quichelper.CertificateError
+		g.Expect(err).To(BeAssignableToTypeOf(certErr))
+		g.Expect(err.(*quichelper.CertificateError).Reason).To(Equal(reason))
+	}
+
+	t.Run("Valid certificate", func(t *testing.T) {
+		g := NewWithT(t)
+
+		g.Expect(verify(quichelper.ServerVerification{RootCAs: newCertPool(ca)}, "broker.example.com", cert)).
+			To(Succeed())
+	})
+
+	t.Run("Intermediate CA sent by the server", func(t *testing.T) {
+		g := NewWithT(t)
+
+		intermediate, intermediateKey := newTestCert(t, "Intermediate CA", nil, ca, caKey)
+		leaf, _ := newTestCert(t, "Server", []string{"broker.example.com"}, intermediate, intermediateKey)
+		g.Expect(verify(quichelper.ServerVerification{RootCAs: newCertPool(ca)}, "broker.example.com", leaf, intermediate)).
+			To(Succeed())
+	})
+
+	t.Run("Unknown authority", func(t *testing.T) {
+		g := NewWithT(t)
+
+		err := verify(quichelper.ServerVerification{RootCAs: newCertPool(otherCA)}, "broker.example.com", cert)
+		expectReason(g, err, quichelper.CertificateUnknownAuthority)
+		g.Expect(err.Error()).To(ContainSubstring("broker.example.com"))
+	})
+
+	t.Run("Name mismatch", func(t *testing.T) {
+		g := NewWithT(t)
+
+		err := verify(quichelper.ServerVerification{RootCAs: newCertPool(ca)}, "other.example.com", cert)
+		expectReason(g, err, quichelper.CertificateNameMismatch)
+	})
+
+	t.Run("Expired", func(t *testing.T) {
+		g := NewWithT(t)
+
+		expired, _ := newTestCert(t, "Server", []string{"broker.example.com"}, otherCA, otherCAKey,
+			time.Now().Add(-2*time.Hour), time.Now().Add(-time.Hour))
+		err := verify(quichelper.ServerVerification{RootCAs: newCertPool(otherCA)}, "broker.example.com", expired)
+		expectReason(g, err, quichelper.CertificateExpired)
+	})
+
+	t.Run("No certificate", func(t *testing.T) {
+		g := NewWithT(t)
+
+		err := verify(quichelper.ServerVerification{RootCAs: newCertPool(ca)}, "broker.example.com")
+		expectReason(g, err, quichelper.CertificateInvalid)
+	})
+
+	t.Run("Pinned public key", func(t *testing.T) {
+		g := NewWithT(t)
+
+		hash := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+		// Pins replace the CAs, and the name is not verified
+		g.Expect(verify(quichelper.ServerVerification{PinnedSPKIHashes: [][]byte{{1}, hash[:]}}, "other.example.com", cert)).
+			To(Succeed())
+	})
+
+	t.Run("Pin mismatch", func(t *testing.T) {
+		g := NewWithT(t)
+
+		hash := sha256.Sum256(ca.RawSubjectPublicKeyInfo)
+		err := verify(quichelper.ServerVerification{PinnedSPKIHashes: [][]byte{hash[:]}}, "broker.example.com", cert)
+		expectReason(g, err, quichelper.CertificatePinMismatch)
+	})
+}
+
+func TestParseSPKIPins(t *testing.T) {
+	g := NewWithT(t)
+
+	hashA := sha256.Sum256([]byte("a"))
+	hashB := sha256.Sum256([]byte("b"))
+	pins := base64.StdEncoding.EncodeToString(hashA[:]) + ", sha256/" + base64.StdEncoding.EncodeToString(hashB[:])
+
+	hashes, err := quichelper.ParseSPKIPins(pins)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(hashes).To(Equal([][]byte{hashA[:], hashB[:]}))
+
+	hashes, err = quichelper.ParseSPKIPins("")
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(hashes).To(BeEmpty())
+
+	_, err = quichelper.ParseSPKIPins("not base64!")
+	g.Expect(err).To(HaveOccurred())
+	_, err = quichelper.ParseSPKIPins(base64.StdEncoding.EncodeToString([]byte("short")))
+	g.Expect(err).To(MatchError(ContainSubstring("not a SHA-256 hash")))
+}
+
+func TestDialAddr(t *testing.T) {
+	ca, caKey := newTestCert(t, "CA", nil, nil, nil)
+	cert, key := newTestCert(t, "Server", []string{"broker.example.com"}, ca, caKey)
+
+	listener, err := quic.ListenAddr("127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{cert.Raw}, PrivateKey: key}},
+		NextProtos:   []string{"test"},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept(context.Background())
+			if err != nil {
+				return
+			}
+			_ = conn.CloseWithError(0, "")
+		}
+	}()
+
+	dial := func(serverName string) error {
+		config := &tls.Config{ServerName: serverName, NextProtos: []string{"test"}}
+		quichelper.ServerVerification{RootCAs: newCertPool(ca)}.Apply(config)
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		conn, err := quichelper.DialAddr(ctx, listener.Addr().String(), config, nil)
+		if err == nil {
+			_ = conn.CloseWithError(0, "")
+		}
+		return err
+	}
+
+	t.Run("Server name", func(t *testing.T) {
+		g := NewWithT(t)
+
+		g.Expect(dial("broker.example.com")).To(Succeed())
+	})
+
+	t.Run("Host of the address by default", func(t *testing.T) {
+		g := NewWithT(t)
+
+		err := dial("")
+		var certErr *quichelper.CertificateError
+		g.Expect(errors.As(err, &certErr)).To(BeTrue(), "%v", err)
+		g.Expect(certErr.Reason).To(Equal(quichelper.CertificateNameMismatch))
+		g.Expect(certErr.ServerName).To(Equal("127.0.0.1"))
+	})
+}
@@ -0,0 +1,44 @@
+package sdk
+
+// Message is what publishers send and subscribers receive.
+type Message struct {
+	Headers map[string]string // Metadata, see the Header* constants
+	Payload []byte
+
+	// Signer is the ID of the publisher whose signature of the message the subscriber has verified, see package
+	// signing, empty if the subscriber does not verify signatures or the message is not verified. It is not sent.
+	Signer string
+}
+
+// Message header names.
+const (
+	// HeaderOrigin is the ID of the broker at which the message entered a federation of bridged brokers.
+	HeaderOrigin = "origin"
+
+	// HeaderVia is a comma-separated list of IDs of the brokers that the message has been bridged through.
+	HeaderVia = "via"
+
+	// HeaderMessageID is the ID of the message, unique among the messages of its publisher. Bridged brokers drop the
+	// copies of a message with the same HeaderOrigin and ID that reach them over several paths.
+	HeaderMessageID = "msg_id"
+
+	// HeaderKey is the key of the message. On partitioned servers, the key decides which server owns the message.
+	HeaderKey = "key"
+
+	// HeaderSigner is the ID of the publisher that signed the message, whose public key verifies HeaderSignature.
+	HeaderSigner = "signer"
+
+	// HeaderSignedAt is when the message was signed, in Unix milliseconds.
+	HeaderSignedAt = "signed_at"
+
+	// HeaderSignature is the base64-encoded ed25519 signature of the message by HeaderSigner, see package signing.
+	HeaderSignature = "signature"
+
+	// HeaderEncryptionKey is the ID of the key the payload is encrypted with, see package encryption. Messages without
+	// it are not encrypted.
+	HeaderEncryptionKey = "enc_key"
+
+	// HeaderTraceparent is the trace context of the message in the format of the W3C Trace Context traceparent
+	// header, see package tracing.
+	HeaderTraceparent = "traceparent"
+)
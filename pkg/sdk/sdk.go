@@ -4,8 +4,111 @@ package sdk
 const (
 	CodeExistsSubscriber = "exists_subscriber"
 	CodeNoSubscribers    = "no_subscribers"
+	CodeGoingAway        = "going_away"     // The server is shutting down, clients should finish up and disconnect
+	CodeKey              = "key"            // Sent by a client to tell the key of the messages it publishes or wants
+	CodeSlowDown         = "slow_down"      // The publisher exceeds its rate limit, its messages are dropped for a while
+	CodeQuotaExceeded    = "quota_exceeded" // The tenant of the client exceeds a quota, see the Quota* constants
+	CodeAuthenticate     = "authenticate"   // Sent by a client to present its token, see ErrorCodeUnauthenticated
+	CodeAuthenticated    = "authenticated"  // The server has accepted the token of the client
+	CodeAccessDenied     = "access_denied"  // The client may not publish or subscribe to the Key of the event
+	CodePaused           = "paused"         // An operator has paused the publisher, its messages are dropped
+	CodeResumed          = "resumed"        // An operator has resumed the publisher after CodePaused
+)
+
+// ALPN protocol names with which clients declare their role when connecting to the server.
+// A server listening on a single port uses the negotiated protocol to tell publishers and subscribers apart.
+// Clients of a tenant append the tenant to the role, see TenantALPN.
+const (
+	// ALPNPublisher is declared by publishers. A publisher may open a second unidirectional stream, after the message
+	// stream, on which it sends a CodeKey event to tell the key of its messages to partitioned servers.
+	ALPNPublisher = "quicpubsub-pub"
+
+	// ALPNSubscriber is declared by subscribers. The server opens the message stream first and the event stream
+	// second. A subscriber may open a unidirectional stream on which it sends CodeExistsSubscriber or
+	// CodeNoSubscribers events to tell whether it wants messages, e.g. when it forwards them to another server,
+	// and a CodeKey event to tell the key of the messages it wants from partitioned servers.
+	ALPNSubscriber = "quicpubsub-sub"
+
+	// ALPNPubSub is declared by clients that both publish and subscribe on one connection. The server opens
+	// the event stream first and the message stream second, so the client accepts them in that order.
+	// Events for both roles are sent on the event stream.
+	ALPNPubSub = "quicpubsub-pubsub"
+
+	// ALPNPeer is declared by servers of a cluster connecting to each other.
+	ALPNPeer = "quicpubsub-peer"
+
+	// ALPNPartitionMap is declared by clients that fetch the partition map of partitioned servers. The server
+	// opens a unidirectional stream, writes the map as JSON and closes the stream.
+	ALPNPartitionMap = "quicpubsub-partitions"
+)
+
+// Application error codes with which peers close connections.
+const (
+	// ErrorCodeNoError is used when a peer closes the connection because it is done.
+	ErrorCodeNoError = 0x0
+
+	// ErrorCodeUnknownRole is used when the client did not declare a known role via ALPN.
+	ErrorCodeUnknownRole = 0x1
+
+	// ErrorCodeGoingAway is used when the server shuts down.
+	ErrorCodeGoingAway = 0x2
+
+	// ErrorCodeWrongPartition is used when the client's key belongs to a partition that the server does not own.
+	// The error message is the address of the owner, to which the client should connect instead.
+	ErrorCodeWrongPartition = 0x3
+
+	// ErrorCodeConnectionLimit is used when the server refuses a connection as it is at its limit of connections,
+	// either in total or from the client's IP address. The error message tells which.
+	ErrorCodeConnectionLimit = 0x4
+
+	// ErrorCodeHandshakeRateLimit is used when the server refuses a connection as clients connect too fast.
+	ErrorCodeHandshakeRateLimit = 0x5
+
+	// ErrorCodeUnknownTenant is used when the server refuses a connection as it does not know the client's tenant,
+	// or the tenant does not match the client's certificate.
+	ErrorCodeUnknownTenant = 0x6
+
+	// ErrorCodeQuotaExceeded is used when the server refuses a connection as it would exceed a quota of the tenant.
+	// The error message tells which quota.
+	ErrorCodeQuotaExceeded = 0x7
+
+	// ErrorCodeUnauthenticated is used when the server requires clients to authenticate with a token and refuses
+	// the client's, e.g. as it has expired or does not allow the client's role. The error message tells why.
+	// Such clients open a bidirectional stream before any other, send a CodeAuthenticate event with their token on
+	// it and wait for a CodeAuthenticated event in response.
+	ErrorCodeUnauthenticated = 0x8
+
+	// ErrorCodeAccessDenied is used when the server refuses a connection as the access control list does not allow
+	// the client to publish or subscribe to anything. The error message tells why.
+	ErrorCodeAccessDenied = 0x9
+
+	// ErrorCodeSlowConsumer is used when the server disconnects a subscriber as writing a message to it takes too
+	// long, i.e. the subscriber does not read its messages as fast as they are published.
+	ErrorCodeSlowConsumer = 0xa
+
+	// ErrorCodeDuplicateIdentity is used when the server refuses a connection as a publisher or subscriber of the
+	// client's identity, as of its certificate or token, is connected already. The client may retry once the other
+	// connection is gone.
+	ErrorCodeDuplicateIdentity = 0xb
+
+	// ErrorCodeInternal is used when the server fails to serve a connection for a reason of its own. The error message
+	// tells why.
+	ErrorCodeInternal = 0xc
+)
+
+// Tenant quotas, named in CodeQuotaExceeded events.
+const (
+	QuotaConnections   = "connections"   // Live connections of the tenant
+	QuotaMessageRate   = "message_rate"  // Messages per second published by the tenant
+	QuotaStoredBytes   = "stored_bytes"  // Bytes of messages of the tenant held by the server until delivered
+	QuotaSubscriptions = "subscriptions" // Subscribers of the tenant
 )
 
 type Event struct {
-	Code string `json:"code"`
+	Code         string `json:"code"`
+	BrokerID     string `json:"broker_id,omitempty"`      // ID of the server that sent the event, used to bridge servers
+	Key          string `json:"key,omitempty"`            // Message key, with CodeKey and CodeAccessDenied
+	RetryAfterMs int64  `json:"retry_after_ms,omitempty"` // Milliseconds for which not to publish, with CodeSlowDown and CodeQuotaExceeded
+	Quota        string `json:"quota,omitempty"`          // Which quota is exceeded, with CodeQuotaExceeded
+	Token        string `json:"token,omitempty"`          // Bearer token of the client, with CodeAuthenticate
 }
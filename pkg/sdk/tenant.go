@@ -0,0 +1,39 @@
+package sdk
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// tenantSeparator separates the role from the tenant in the ALPN protocol name of a client of a tenant.
+const tenantSeparator = "@"
+
+// tenantPattern is what tenant names look like, short enough to fit an ALPN protocol name with the role.
+var tenantPattern = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9._-]{0,63}$`)
+
+// TenantALPN returns the ALPN protocol name with which a client declares both its role, one of ALPNPublisher,
+// ALPNSubscriber and ALPNPubSub, and its tenant, e.g. "quicpubsub-pub@team-a". Clients of the default tenant
+// declare just the role.
+func TenantALPN(role string, tenant string) string {
+	if tenant == "" {
+		return role
+	}
+	return role + tenantSeparator + tenant
+}
+
+// ParseTenantALPN splits a protocol name made by TenantALPN into the role and the tenant, which is empty for
+// the default tenant.
+func ParseTenantALPN(protocol string) (role string, tenant string) {
+	role, tenant, _ = strings.Cut(protocol, tenantSeparator)
+	return role, tenant
+}
+
+// ValidateTenant checks that a tenant name is non-empty and made of letters, digits, dots, dashes and
+// underscores, starting with a letter or digit, up to 64 characters.
+func ValidateTenant(tenant string) error {
+	if !tenantPattern.MatchString(tenant) {
+		return fmt.Errorf("invalid tenant '%s', expected up to 64 letters, digits, '.', '-' or '_'", tenant)
+	}
+	return nil
+}
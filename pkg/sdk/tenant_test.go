@@ -0,0 +1,32 @@
+package sdk_test
+
+import (
+	. "github.com/onsi/gomega"
+	"github.com/varfrog/quicpubsub/pkg/sdk"
+	"testing"
+)
+
+func TestTenantALPN(t *testing.T) {
+	g := NewWithT(t)
+
+	g.Expect(sdk.TenantALPN(sdk.ALPNPublisher, "")).To(Equal(sdk.ALPNPublisher))
+
+	role, tenant := sdk.ParseTenantALPN(sdk.TenantALPN(sdk.ALPNSubscriber, "team-a"))
+	g.Expect(role).To(Equal(sdk.ALPNSubscriber))
+	g.Expect(tenant).To(Equal("team-a"))
+
+	role, tenant = sdk.ParseTenantALPN(sdk.ALPNPubSub)
+	g.Expect(role).To(Equal(sdk.ALPNPubSub))
+	g.Expect(tenant).To(BeEmpty())
+}
+
+func TestValidateTenant(t *testing.T) {
+	g := NewWithT(t)
+
+	g.Expect(sdk.ValidateTenant("team-a")).To(Succeed())
+	g.Expect(sdk.ValidateTenant("Team_1.prod")).To(Succeed())
+
+	for _, invalid := range []string{"", "-team", "team a", "team@a", string(make([]byte, 65))} {
+		g.Expect(sdk.ValidateTenant(invalid)).ToNot(Succeed(), invalid)
+	}
+}
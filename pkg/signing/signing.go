@@ -0,0 +1,205 @@
+// Package signing signs messages at publishers and verifies them at subscribers, so that subscribers can trust
+// that a message comes from a given publisher even if a server it passes through is compromised.
+//
+// A publisher signs the payload and the critical headers of a message with its ed25519 key, and sets the signature
+// and its signer ID as headers, see sdk.HeaderSigner. Servers pass the headers on untouched, and
+// subscribers verify the signature against the public key of the signer in their TrustStore.
+package signing
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/varfrog/quicpubsub/pkg/sdk"
+	"os"
+	"strconv"
+	"time"
+)
+
+// MaxClockSkew is how far after the time of verifying a message may have been signed, as clocks differ.
+const MaxClockSkew = time.Minute
+
+// signatureContext is prepended to what is signed, so that signatures of messages cannot be used for anything else.
+const signatureContext = "quicpubsub message signature v1"
+
+var (
+	ErrUnsigned         = errors.New("message is not signed")
+	ErrUnknownSigner    = errors.New("message signed by an unknown signer")
+	ErrInvalidSignature = errors.New("invalid message signature")
+	ErrStaleSignature   = errors.New("message signature is too old or from the future")
+)
+
+// Signer signs messages with the key of a publisher.
+type Signer struct {
+	id  string
+	key ed25519.PrivateKey
+}
+
+// NewSigner is the constructor for Signer. id is how subscribers know the publisher, see TrustStore.
+func NewSigner(id string, key ed25519.PrivateKey) *Signer {
+	return &Signer{id: id, key: key}
+}
+
+// LoadSigner returns a Signer with the key of the file at keyPath, see SaveKey.
+func LoadSigner(id string, keyPath string) (*Signer, error) {
+	key, err := LoadKey(keyPath)
+	if err != nil {
+		return nil, err
+	}
+	return NewSigner(id, key), nil
+}
+
+// Sign returns a copy of message, signed at now. The headers of message are left intact.
+func (s *Signer) Sign(message sdk.Message, now time.Time) sdk.Message {
+	headers := make(map[string]string, len(message.Headers)+3)
+	for k, v := range message.Headers {
+		headers[k] = v
+	}
+	headers[sdk.HeaderSigner] = s.id
+	headers[sdk.HeaderSignedAt] = strconv.FormatInt(now.UnixMilli(), 10)
+	message.Headers = headers
+	message.Headers[sdk.HeaderSignature] = base64.StdEncoding.EncodeToString(ed25519.Sign(s.key, signedData(message)))
+	return message
+}
+
+// TrustStore holds the public keys of the publishers whose signatures subscribers accept, by signer ID.
+type TrustStore map[string]ed25519.PublicKey
+
+// LoadTrustStore reads a TrustStore from a JSON file, an object with signer IDs as keys and base64-encoded public
+// keys as values, e.g. {"orders-service": "Lq8cWyF0..."}.
+func LoadTrustStore(path string) (TrustStore, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "os.ReadFile")
+	}
+	var encoded map[string]string
+	if err := json.Unmarshal(data, &encoded); err != nil {
+		return nil, errors.Wrap(err, "json.Unmarshal")
+	}
+	store := make(TrustStore, len(encoded))
+	for id, publicKey := range encoded {
+		key, err := base64.StdEncoding.DecodeString(publicKey)
+		if err != nil {
+			return nil, errors.Wrapf(err, "signer '%s'", id)
+		}
+		if len(key) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("public key of signer '%s' is not %d bytes", id, ed25519.PublicKeySize)
+		}
+		store[id] = key
+	}
+	return store, nil
+}
+
+// SaveTrustStore writes a TrustStore to a file in the format of LoadTrustStore.
+func SaveTrustStore(path string, store TrustStore) error {
+	encoded := make(map[string]string, len(store))
+	for id, key := range store {
+		encoded[id] = base64.StdEncoding.EncodeToString(key)
+	}
+	data, err := json.MarshalIndent(encoded, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "json.MarshalIndent")
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0644); err != nil {
+		return errors.Wrap(err, "os.WriteFile")
+	}
+	return nil
+}
+
+// Verify returns the ID of the signer of message if its signature is valid for the public key of the signer, and it
+// was signed at most maxAge before now, with no limit if 0, and at most MaxClockSkew after now.
+func (t TrustStore) Verify(message sdk.Message, now time.Time, maxAge time.Duration) (string, error) {
+	signer, ok := message.Headers[sdk.HeaderSigner]
+	encodedSignature, signed := message.Headers[sdk.HeaderSignature]
+	if !ok || !signed {
+		return "", ErrUnsigned
+	}
+	key, ok := t[signer]
+	if !ok {
+		return "", errors.Wrapf(ErrUnknownSigner, "signer '%s'", signer)
+	}
+	signature, err := base64.StdEncoding.DecodeString(encodedSignature)
+	if err != nil || !ed25519.Verify(key, signedData(message), signature) {
+		return "", errors.Wrapf(ErrInvalidSignature, "signer '%s'", signer)
+	}
+	signedAtMilli, err := strconv.ParseInt(message.Headers[sdk.HeaderSignedAt], 10, 64)
+	if err != nil {
+		return "", errors.Wrapf(ErrInvalidSignature, "signer '%s': signing time", signer)
+	}
+	signedAt := time.UnixMilli(signedAtMilli)
+	if signedAt.After(now.Add(MaxClockSkew)) || (maxAge > 0 && now.Sub(signedAt) > maxAge) {
+		return "", errors.Wrapf(ErrStaleSignature, "signer '%s', signed at %s", signer,
+			signedAt.UTC().Format(time.RFC3339))
+	}
+	return signer, nil
+}
+
+// signedData returns what is signed of a message: the signer, when it was signed, the key and the payload, each
+// prefixed by its length so that no two messages have the same data.
+func signedData(message sdk.Message) []byte {
+	fields := [][]byte{
+		[]byte(message.Headers[sdk.HeaderSigner]),
+		[]byte(message.Headers[sdk.HeaderSignedAt]),
+		[]byte(message.Headers[sdk.HeaderKey]),
+		message.Payload,
+	}
+	size := len(signatureContext)
+	for _, field := range fields {
+		size += 4 + len(field)
+	}
+	data := make([]byte, 0, size)
+	data = append(data, signatureContext...)
+	for _, field := range fields {
+		data = binary.BigEndian.AppendUint32(data, uint32(len(field)))
+		data = append(data, field...)
+	}
+	return data
+}
+
+// NewKey returns a new private key.
+func NewKey() (ed25519.PrivateKey, error) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, errors.Wrap(err, "ed25519.GenerateKey")
+	}
+	return key, nil
+}
+
+// LoadKey reads a private key from a PEM file, see SaveKey.
+func LoadKey(path string) (ed25519.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "os.ReadFile")
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "PRIVATE KEY" {
+		return nil, fmt.Errorf("no PEM private key in %s", path)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, errors.Wrap(err, "x509.ParsePKCS8PrivateKey")
+	}
+	ed25519Key, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("the key in %s is not an ed25519 key", path)
+	}
+	return ed25519Key, nil
+}
+
+// SaveKey writes a private key to a PEM file, readable by the owner only.
+func SaveKey(path string, key ed25519.PrivateKey) error {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return errors.Wrap(err, "x509.MarshalPKCS8PrivateKey")
+	}
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600); err != nil {
+		return errors.Wrap(err, "os.WriteFile")
+	}
+	return nil
+}
@@ -0,0 +1,148 @@
+package signing_test
+
+import (
+	"crypto/ed25519"
+	. "github.com/onsi/gomega"
+	"github.com/varfrog/quicpubsub/pkg/sdk"
+	"github.com/varfrog/quicpubsub/pkg/signing"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestTrustStore_Verify(t *testing.T) {
+	key, err := signing.NewKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, err := signing.NewKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(1700000000, 0)
+	store := signing.TrustStore{"orders": key.Public().(ed25519.PublicKey)}
+	message := sdk.Message{Headers: map[string]string{sdk.HeaderKey: "order-1"}, Payload: []byte("created")}
+	signed := signing.NewSigner("orders", key).Sign(message, now)
+
+	t.Run("Valid signature", func(t *testing.T) {
+		g := NewWithT(t)
+
+		g.Expect(message.Headers).NotTo(HaveKey(sdk.HeaderSignature), "the original is left intact")
+		signer, err := store.Verify(signed, now, time.Minute)
+		g.Expect(err).NotTo(HaveOccurred())
+		g.Expect(signer).To(Equal("orders"))
+	})
+
+	t.Run("Headers added on the way are not signed", func(t *testing.T) {
+		g := NewWithT(t)
+
+		forwarded := copyMessage(signed)
+		forwarded.Headers[sdk.HeaderOrigin] = "node-1"
+		forwarded.Headers[sdk.HeaderVia] = "node-1,node-2"
+		_, err := store.Verify(forwarded, now, time.Minute)
+		g.Expect(err).NotTo(HaveOccurred())
+	})
+
+	t.Run("Tampered messages", func(t *testing.T) {
+		for name, tamper := range map[string]func(m *sdk.Message){
+			"payload":   func(m *sdk.Message) { m.Payload = []byte("cancelled") },
+			"key":       func(m *sdk.Message) { m.Headers[sdk.HeaderKey] = "order-2" },
+			"signed at": func(m *sdk.Message) { m.Headers[sdk.HeaderSignedAt] = "0" },
+			"signature": func(m *sdk.Message) { m.Headers[sdk.HeaderSignature] = "not base64" },
+		} {
+			t.Run(name, func(t *testing.T) {
+				g := NewWithT(t)
+
+				tampered := copyMessage(signed)
+				tamper(&tampered)
+				_, err := store.Verify(tampered, now, time.Minute)
+				g.Expect(err).To(MatchError(signing.ErrInvalidSignature))
+			})
+		}
+	})
+
+	t.Run("Stale signatures", func(t *testing.T) {
+		for name, signedAt := range map[string]time.Time{
+			"too old":     now.Add(-time.Minute - time.Millisecond),
+			"from future": now.Add(signing.MaxClockSkew + time.Millisecond),
+		} {
+			t.Run(name, func(t *testing.T) {
+				g := NewWithT(t)
+
+				_, err := store.Verify(signing.NewSigner("orders", key).Sign(message, signedAt), now, time.Minute)
+				g.Expect(err).To(MatchError(signing.ErrStaleSignature))
+			})
+		}
+	})
+
+	t.Run("Fresh signatures", func(t *testing.T) {
+		g := NewWithT(t)
+
+		for _, signedAt := range []time.Time{now.Add(-time.Minute), now.Add(signing.MaxClockSkew)} {
+			_, err := store.Verify(signing.NewSigner("orders", key).Sign(message, signedAt), now, time.Minute)
+			g.Expect(err).NotTo(HaveOccurred())
+		}
+		_, err := store.Verify(signing.NewSigner("orders", key).Sign(message, now.Add(-time.Hour)), now, 0)
+		g.Expect(err).NotTo(HaveOccurred(), "no max age")
+	})
+
+	t.Run("Signed with another key", func(t *testing.T) {
+		g := NewWithT(t)
+
+		_, err := store.Verify(signing.NewSigner("orders", otherKey).Sign(message, now), now, time.Minute)
+		g.Expect(err).To(MatchError(signing.ErrInvalidSignature))
+	})
+
+	t.Run("Unknown signer", func(t *testing.T) {
+		g := NewWithT(t)
+
+		_, err := store.Verify(signing.NewSigner("billing", otherKey).Sign(message, now), now, time.Minute)
+		g.Expect(err).To(MatchError(signing.ErrUnknownSigner))
+	})
+
+	t.Run("Unsigned", func(t *testing.T) {
+		g := NewWithT(t)
+
+		_, err := store.Verify(message, now, time.Minute)
+		g.Expect(err).To(MatchError(signing.ErrUnsigned))
+	})
+}
+
+func TestSaveTrustStore(t *testing.T) {
+	g := NewWithT(t)
+
+	dir := t.TempDir()
+	key, err := signing.NewKey()
+	g.Expect(err).NotTo(HaveOccurred())
+
+	keyPath := filepath.Join(dir, "orders.signing.key")
+	g.Expect(signing.SaveKey(keyPath, key)).To(Succeed())
+	info, err := os.Stat(keyPath)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(info.Mode().Perm()).To(Equal(os.FileMode(0600)))
+	signer, err := signing.LoadSigner("orders", keyPath)
+	g.Expect(err).NotTo(HaveOccurred())
+
+	storePath := filepath.Join(dir, "trust_store.json")
+	g.Expect(signing.SaveTrustStore(storePath, signing.TrustStore{"orders": key.Public().(ed25519.PublicKey)})).
+		To(Succeed())
+	store, err := signing.LoadTrustStore(storePath)
+	g.Expect(err).NotTo(HaveOccurred())
+
+	_, err = store.Verify(signer.Sign(sdk.Message{Payload: []byte("created")}, time.Now()), time.Now(), 0)
+	g.Expect(err).NotTo(HaveOccurred())
+
+	g.Expect(os.WriteFile(storePath, []byte(`{"orders": "c2hvcnQ="}`), 0644)).To(Succeed())
+	_, err = signing.LoadTrustStore(storePath)
+	g.Expect(err).To(MatchError(ContainSubstring("is not 32 bytes")))
+}
+
+func copyMessage(message sdk.Message) sdk.Message {
+	headers := make(map[string]string, len(message.Headers))
+	for k, v := range message.Headers {
+		headers[k] = v
+	}
+	message.Headers = headers
+	return message
+}
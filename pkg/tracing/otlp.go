@@ -0,0 +1,201 @@
+package tracing
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// scopeName is the instrumentation scope of the spans.
+const scopeName = "github.com/varfrog/quicpubsub"
+
+// httpExportTimeout is how long an HTTPExporter waits for the collector.
+const httpExportTimeout = 10 * time.Second
+
+// statusCodeError is the OTLP status code of failed spans.
+const statusCodeError = 2
+
+// Exporter sends spans on, e.g. to a file or a collector.
+type Exporter interface {
+	// Export exports a batch of spans encoded as an OTLP ExportTraceServiceRequest in JSON.
+	Export(payload []byte) error
+
+	Close() error
+}
+
+// NewExporter returns an HTTPExporter if target is an http:// or https:// URL, e.g. that of the OTLP/HTTP receiver of
+// a local OpenTelemetry collector, http://127.0.0.1:4318/v1/traces, and a FileExporter of the path target otherwise.
+func NewExporter(target string) (Exporter, error) {
+	if strings.HasPrefix(target, "http://") || strings.HasPrefix(target, "https://") {
+		return NewHTTPExporter(target), nil
+	}
+	exporter, err := NewFileExporter(target)
+	if err != nil {
+		return nil, errors.Wrap(err, "NewFileExporter")
+	}
+	return exporter, nil
+}
+
+// FileExporter appends batches of spans to a file as JSON lines, the format of the file exporter of the OpenTelemetry
+// collector, which its OTLP JSON file receiver reads.
+type FileExporter struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+var _ Exporter = (*FileExporter)(nil)
+
+// NewFileExporter opens the file at path for appending, creating it if needed.
+func NewFileExporter(path string) (*FileExporter, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, errors.Wrap(err, "os.OpenFile")
+	}
+	return &FileExporter{file: file}, nil
+}
+
+func (e *FileExporter) Export(payload []byte) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if _, err := e.file.Write(append(payload, '\n')); err != nil {
+		return errors.Wrap(err, "file.Write")
+	}
+	return nil
+}
+
+func (e *FileExporter) Close() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.file.Close()
+}
+
+// HTTPExporter posts batches of spans to an OTLP/HTTP endpoint in JSON.
+type HTTPExporter struct {
+	url    string
+	client *http.Client
+}
+
+var _ Exporter = (*HTTPExporter)(nil)
+
+// NewHTTPExporter is the constructor for HTTPExporter. url is the full URL of the endpoint, ending in /v1/traces for
+// OTLP/HTTP receivers.
+func NewHTTPExporter(url string) *HTTPExporter {
+	return &HTTPExporter{url: url, client: &http.Client{Timeout: httpExportTimeout}}
+}
+
+func (e *HTTPExporter) Export(payload []byte) error {
+	response, err := e.client.Post(e.url, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		return errors.Wrap(err, "client.Post")
+	}
+	_ = response.Body.Close() // The response tells only about partially rejected spans
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return fmt.Errorf("collector responded with %s", response.Status)
+	}
+	return nil
+}
+
+func (e *HTTPExporter) Close() error {
+	e.client.CloseIdleConnections()
+	return nil
+}
+
+// The types of the JSON encoding of OTLP, see https://opentelemetry.io/docs/specs/otlp/#json-protobuf-encoding.
+// IDs are hex-encoded, and 64-bit integers are strings.
+type (
+	otlpRequest struct {
+		ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+	}
+	otlpResourceSpans struct {
+		Resource   otlpResource     `json:"resource"`
+		ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+	}
+	otlpResource struct {
+		Attributes []otlpAttribute `json:"attributes"`
+	}
+	otlpScopeSpans struct {
+		Scope otlpScope  `json:"scope"`
+		Spans []otlpSpan `json:"spans"`
+	}
+	otlpScope struct {
+		Name string `json:"name"`
+	}
+	otlpSpan struct {
+		TraceID           string          `json:"traceId"`
+		SpanID            string          `json:"spanId"`
+		ParentSpanID      string          `json:"parentSpanId,omitempty"`
+		Name              string          `json:"name"`
+		Kind              SpanKind        `json:"kind"`
+		StartTimeUnixNano string          `json:"startTimeUnixNano"`
+		EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+		Attributes        []otlpAttribute `json:"attributes,omitempty"`
+		Status            otlpStatus      `json:"status"`
+	}
+	otlpAttribute struct {
+		Key   string    `json:"key"`
+		Value otlpValue `json:"value"`
+	}
+	otlpValue struct {
+		StringValue *string `json:"stringValue,omitempty"`
+		IntValue    *string `json:"intValue,omitempty"`
+	}
+	otlpStatus struct {
+		Code    int    `json:"code,omitempty"` // Unset if 0
+		Message string `json:"message,omitempty"`
+	}
+)
+
+// marshalOTLP encodes the spans of the service as an OTLP ExportTraceServiceRequest in JSON.
+func marshalOTLP(serviceName string, spans []*Span) ([]byte, error) {
+	encoded := make([]otlpSpan, 0, len(spans))
+	for _, span := range spans {
+		s := otlpSpan{
+			TraceID:           hex.EncodeToString(span.context.TraceID[:]),
+			SpanID:            hex.EncodeToString(span.context.SpanID[:]),
+			Name:              span.name,
+			Kind:              span.kind,
+			StartTimeUnixNano: strconv.FormatInt(span.start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(span.end.UnixNano(), 10),
+		}
+		if span.parent != (SpanID{}) {
+			s.ParentSpanID = hex.EncodeToString(span.parent[:])
+		}
+		for _, a := range span.attributes {
+			if a.isInt {
+				s.Attributes = append(s.Attributes, intAttribute(a.key, a.intValue))
+			} else {
+				s.Attributes = append(s.Attributes, stringAttribute(a.key, a.stringValue))
+			}
+		}
+		if span.err != nil {
+			s.Status = otlpStatus{Code: statusCodeError, Message: span.err.Error()}
+		}
+		encoded = append(encoded, s)
+	}
+
+	payload, err := json.Marshal(otlpRequest{ResourceSpans: []otlpResourceSpans{{
+		Resource:   otlpResource{Attributes: []otlpAttribute{stringAttribute("service.name", serviceName)}},
+		ScopeSpans: []otlpScopeSpans{{Scope: otlpScope{Name: scopeName}, Spans: encoded}},
+	}}})
+	if err != nil {
+		return nil, errors.Wrap(err, "json.Marshal")
+	}
+	return payload, nil
+}
+
+func stringAttribute(key string, value string) otlpAttribute {
+	return otlpAttribute{Key: key, Value: otlpValue{StringValue: &value}}
+}
+
+func intAttribute(key string, value int64) otlpAttribute {
+	encoded := strconv.FormatInt(value, 10)
+	return otlpAttribute{Key: key, Value: otlpValue{IntValue: &encoded}}
+}
@@ -0,0 +1,364 @@
+// Package tracing records spans of messages on their way from publishers through servers to subscribers, and
+// exports them as OTLP JSON, so that where the time of a slow message went can be seen in a tracing backend.
+//
+// The trace context of a message is sent in its sdk.HeaderTraceparent header, in the format of the traceparent header
+// of W3C Trace Context, see https://www.w3.org/TR/trace-context/. A publisher starts a trace with a span per message
+// it publishes. Servers pass messages on untouched, so the spans of servers and subscribers are children of the span
+// of the publisher.
+//
+// The methods of a nil Tracer and of nil spans do nothing, so that code can be instrumented whether or not it traces.
+package tracing
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"github.com/pkg/errors"
+	"github.com/varfrog/quicpubsub/pkg/sdk"
+	"go.uber.org/zap"
+	"math/rand"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Names of the spans of a message.
+const (
+	SpanPublish = "publish" // The publisher encodes and sends the message
+	SpanReceive = "receive" // The server admits the message and hands it over for delivery
+	SpanRoute   = "route"   // The server delivers the message to all its subscribers
+	SpanWrite   = "write"   // The server writes the message to a subscriber
+	SpanConsume = "consume" // The subscriber verifies, decrypts and handles the message
+)
+
+// Attributes of spans.
+const (
+	AttributeMessageKey  = "quicpubsub.message.key"
+	AttributeMessageSize = "quicpubsub.message.size" // In bytes, as encoded
+	AttributeTenant      = "quicpubsub.tenant"
+	AttributePublisher   = "quicpubsub.publisher"  // Identity of the publisher
+	AttributeSubscriber  = "quicpubsub.subscriber" // ID of the subscriber
+)
+
+// SpanKind is the kind of a span, with the values of OTLP.
+type SpanKind int
+
+const (
+	SpanKindInternal SpanKind = 1
+	SpanKindServer   SpanKind = 2
+	SpanKindClient   SpanKind = 3
+	SpanKindProducer SpanKind = 4
+	SpanKindConsumer SpanKind = 5
+)
+
+const (
+	// traceparentVersion is the version of the traceparent format that is sent. Later versions are parsed as this
+	// one, as W3C Trace Context requires.
+	traceparentVersion = "00"
+
+	// flagSampled is the trace flag that tells that the spans of the trace are recorded.
+	flagSampled = 0x01
+)
+
+const (
+	queueSize      = 4096        // Spans queued for export, after which spans are dropped
+	maxBatchSpans  = 512         // Spans per export
+	exportInterval = time.Second // How often queued spans are exported
+)
+
+var ErrInvalidTraceparent = errors.New("invalid traceparent")
+
+// TraceID identifies a trace, all the spans of a message.
+type TraceID [16]byte
+
+// SpanID identifies a span within a trace.
+type SpanID [8]byte
+
+// SpanContext is what is propagated of a span: its trace, its ID and whether the trace is sampled.
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	Sampled bool
+}
+
+// IsValid returns whether the IDs are set, as the zero IDs are invalid.
+func (c SpanContext) IsValid() bool {
+	return c.TraceID != TraceID{} && c.SpanID != SpanID{}
+}
+
+// Traceparent returns the context in the format of the traceparent header, e.g.
+// 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01.
+func (c SpanContext) Traceparent() string {
+	flags := "00"
+	if c.Sampled {
+		flags = "01"
+	}
+	return traceparentVersion + "-" + hex.EncodeToString(c.TraceID[:]) + "-" + hex.EncodeToString(c.SpanID[:]) +
+		"-" + flags
+}
+
+// ParseTraceparent parses a traceparent header, see SpanContext.Traceparent.
+func ParseTraceparent(value string) (SpanContext, error) {
+	parts := strings.Split(value, "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || (parts[0] == traceparentVersion && len(parts) != 4) {
+		return SpanContext{}, errors.Wrapf(ErrInvalidTraceparent, "'%s'", value)
+	}
+	var (
+		c     SpanContext
+		flags [1]byte
+	)
+	if err := decodeHex(c.TraceID[:], parts[1]); err != nil {
+		return SpanContext{}, errors.Wrapf(err, "trace ID of '%s'", value)
+	}
+	if err := decodeHex(c.SpanID[:], parts[2]); err != nil {
+		return SpanContext{}, errors.Wrapf(err, "span ID of '%s'", value)
+	}
+	if err := decodeHex(flags[:], parts[3]); err != nil {
+		return SpanContext{}, errors.Wrapf(err, "flags of '%s'", value)
+	}
+	if !c.IsValid() {
+		return SpanContext{}, errors.Wrapf(ErrInvalidTraceparent, "zero ID in '%s'", value)
+	}
+	c.Sampled = flags[0]&flagSampled != 0
+	return c, nil
+}
+
+// FromHeaders returns the trace context of a message, if it has a valid one.
+func FromHeaders(headers map[string]string) (SpanContext, bool) {
+	value, ok := headers[sdk.HeaderTraceparent]
+	if !ok {
+		return SpanContext{}, false
+	}
+	c, err := ParseTraceparent(value)
+	return c, err == nil
+}
+
+// decodeHex decodes lowercase hex of exactly the length of dst, as traceparent requires.
+func decodeHex(dst []byte, s string) error {
+	if len(s) != hex.EncodedLen(len(dst)) || strings.ToLower(s) != s {
+		return ErrInvalidTraceparent
+	}
+	if _, err := hex.Decode(dst, []byte(s)); err != nil {
+		return ErrInvalidTraceparent
+	}
+	return nil
+}
+
+// Tracer starts spans and exports them in batches once they end. It is safe for concurrent use.
+type Tracer struct {
+	serviceName string
+	exporter    Exporter
+	queue       chan *Span
+	dropped     atomic.Uint64 // Spans dropped since the last export as the queue was full
+	stopCh      chan struct{}
+	stopOnce    sync.Once
+	done        chan struct{} // Closed once the last spans are exported
+	now         func() time.Time
+	logger      *zap.Logger
+}
+
+// NewTracer is the constructor for Tracer. serviceName tells the spans of publishers, servers and subscribers apart,
+// e.g. "quicpubsub-server". Spans are exported until Close.
+func NewTracer(serviceName string, exporter Exporter, logger *zap.Logger) *Tracer {
+	t := &Tracer{
+		serviceName: serviceName,
+		exporter:    exporter,
+		queue:       make(chan *Span, queueSize),
+		stopCh:      make(chan struct{}),
+		done:        make(chan struct{}),
+		now:         time.Now,
+		logger:      logger,
+	}
+	go t.exportLoop()
+	return t
+}
+
+// StartTrace starts a sampled trace with a root span.
+func (t *Tracer) StartTrace(name string, kind SpanKind) *Span {
+	if t == nil {
+		return nil
+	}
+	return t.newSpan(name, kind, SpanContext{TraceID: newTraceID(), Sampled: true}, SpanID{}, t.now())
+}
+
+// Start starts a span that continues the trace of parent, e.g. of a message, now. Returns nil, a span that records
+// nothing, if parent is invalid or not sampled.
+func (t *Tracer) Start(name string, kind SpanKind, parent SpanContext) *Span {
+	if t == nil {
+		return nil
+	}
+	return t.StartAt(name, kind, parent, t.now())
+}
+
+// StartAt is Start with the time at which the span started.
+func (t *Tracer) StartAt(name string, kind SpanKind, parent SpanContext, start time.Time) *Span {
+	if t == nil || !parent.IsValid() || !parent.Sampled {
+		return nil
+	}
+	return t.newSpan(name, kind, parent, parent.SpanID, start)
+}
+
+func (t *Tracer) newSpan(name string, kind SpanKind, c SpanContext, parent SpanID, start time.Time) *Span {
+	c.SpanID = newSpanID()
+	return &Span{tracer: t, name: name, kind: kind, context: c, parent: parent, start: start}
+}
+
+// Close exports the spans that have ended and closes the exporter. Spans that end afterwards are dropped.
+func (t *Tracer) Close() error {
+	if t == nil {
+		return nil
+	}
+	t.stopOnce.Do(func() {
+		close(t.stopCh)
+	})
+	<-t.done
+	if err := t.exporter.Close(); err != nil {
+		return errors.Wrap(err, "exporter.Close")
+	}
+	return nil
+}
+
+// enqueue queues an ended span for export, or drops it if the exporter cannot keep up, rather than hold up messages.
+func (t *Tracer) enqueue(span *Span) {
+	select {
+	case t.queue <- span:
+	default:
+		t.dropped.Add(1)
+	}
+}
+
+// exportLoop exports the queued spans whenever a batch is full or exportInterval has passed, until Close.
+func (t *Tracer) exportLoop() {
+	defer close(t.done)
+	ticker := time.NewTicker(exportInterval)
+	defer ticker.Stop()
+
+	batch := make([]*Span, 0, maxBatchSpans)
+	for {
+		select {
+		case <-t.stopCh:
+			for {
+				select {
+				case span := <-t.queue:
+					batch = append(batch, span)
+					if len(batch) == maxBatchSpans {
+						batch = t.export(batch)
+					}
+				default:
+					t.export(batch)
+					return
+				}
+			}
+		case span := <-t.queue:
+			batch = append(batch, span)
+			if len(batch) == maxBatchSpans {
+				batch = t.export(batch)
+			}
+		case <-ticker.C:
+			batch = t.export(batch)
+		}
+	}
+}
+
+// export exports the batch, logging failures as spans are not worth retrying, and returns the batch emptied.
+func (t *Tracer) export(batch []*Span) []*Span {
+	if dropped := t.dropped.Swap(0); dropped > 0 {
+		t.logger.Warn("Dropped spans as the exporter cannot keep up", zap.Uint64("spans", dropped))
+	}
+	if len(batch) == 0 {
+		return batch
+	}
+	payload, err := marshalOTLP(t.serviceName, batch)
+	if err != nil {
+		t.logger.Error("Cannot encode spans", zap.Error(err))
+	} else if err := t.exporter.Export(payload); err != nil {
+		t.logger.Warn("Cannot export spans", zap.Int("spans", len(batch)), zap.Error(err))
+	}
+	for i := range batch {
+		batch[i] = nil // Not kept alive until overwritten
+	}
+	return batch[:0]
+}
+
+// Span is an operation on a message. A span is not safe for concurrent use, other than starting children of it.
+type Span struct {
+	tracer     *Tracer
+	name       string
+	kind       SpanKind
+	context    SpanContext
+	parent     SpanID // Zero for the root span of a trace
+	start      time.Time
+	end        time.Time
+	attributes []attribute
+	err        error
+}
+
+// attribute is an attribute of a span, with a string or an integer value.
+type attribute struct {
+	key         string
+	stringValue string
+	intValue    int64
+	isInt       bool
+}
+
+// Context returns the context to propagate to the children of the span, the zero SpanContext for a nil span.
+func (s *Span) Context() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.context
+}
+
+// StartChild starts a span of the trace of s, with s as its parent.
+func (s *Span) StartChild(name string, kind SpanKind) *Span {
+	if s == nil {
+		return nil
+	}
+	return s.tracer.Start(name, kind, s.context)
+}
+
+// SetAttribute sets an attribute of the span, unless value is empty, as e.g. messages without a key are.
+func (s *Span) SetAttribute(key string, value string) {
+	if s != nil && value != "" {
+		s.attributes = append(s.attributes, attribute{key: key, stringValue: value})
+	}
+}
+
+func (s *Span) SetIntAttribute(key string, value int64) {
+	if s != nil {
+		s.attributes = append(s.attributes, attribute{key: key, intValue: value, isInt: true})
+	}
+}
+
+// SetError marks the span as failed with err, if err is not nil.
+func (s *Span) SetError(err error) {
+	if s != nil && err != nil {
+		s.err = err
+	}
+}
+
+// End ends the span and queues it for export. The span must not be used afterwards.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.end = s.tracer.now()
+	s.tracer.enqueue(s)
+}
+
+func newTraceID() TraceID {
+	var id TraceID
+	for id == (TraceID{}) {
+		binary.BigEndian.PutUint64(id[:8], rand.Uint64())
+		binary.BigEndian.PutUint64(id[8:], rand.Uint64())
+	}
+	return id
+}
+
+func newSpanID() SpanID {
+	var id SpanID
+	for id == (SpanID{}) {
+		binary.BigEndian.PutUint64(id[:], rand.Uint64())
+	}
+	return id
+}
@@ -0,0 +1,218 @@
+package tracing_test
+
+import (
+	"encoding/json"
+	. "github.com/onsi/gomega"
+	"github.com/pkg/errors"
+	"github.com/varfrog/quicpubsub/pkg/sdk"
+	"github.com/varfrog/quicpubsub/pkg/tracing"
+	"go.uber.org/zap"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	g := NewWithT(t)
+
+	c, err := tracing.ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(c.IsValid()).To(BeTrue())
+	g.Expect(c.Sampled).To(BeTrue())
+	g.Expect(c.Traceparent()).To(Equal("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"))
+
+	c, err = tracing.ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00")
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(c.Sampled).To(BeFalse())
+
+	c, err = tracing.ParseTraceparent("01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-03-future")
+	g.Expect(err).NotTo(HaveOccurred(), "later versions may have more fields")
+	g.Expect(c.Sampled).To(BeTrue())
+
+	for _, value := range []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",       // No flags
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-00", // Extra field of version 00
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",    // Invalid version
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",    // Uppercase
+		"00-4bf92f3577b34da6a3ce929d0e0e473-00f067aa0ba902b7-01",     // Short trace ID
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",    // Zero trace ID
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",    // Zero span ID
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902bx-01",    // Not hex
+	} {
+		_, err := tracing.ParseTraceparent(value)
+		g.Expect(err).To(MatchError(tracing.ErrInvalidTraceparent), value)
+	}
+
+	_, ok := tracing.FromHeaders(map[string]string{sdk.HeaderTraceparent: "garbage"})
+	g.Expect(ok).To(BeFalse())
+	_, ok = tracing.FromHeaders(nil)
+	g.Expect(ok).To(BeFalse())
+}
+
+func TestTracer(t *testing.T) {
+	g := NewWithT(t)
+
+	exporter := &memoryExporter{}
+	tracer := tracing.NewTracer("quicpubsub-test", exporter, zap.NewNop())
+
+	publish := tracer.StartTrace(tracing.SpanPublish, tracing.SpanKindProducer)
+	publish.SetAttribute(tracing.AttributeMessageKey, "order-1")
+	headers := map[string]string{sdk.HeaderTraceparent: publish.Context().Traceparent()}
+	publish.End()
+
+	parent, ok := tracing.FromHeaders(headers)
+	g.Expect(ok).To(BeTrue())
+	receive := tracer.Start(tracing.SpanReceive, tracing.SpanKindServer, parent)
+	receive.SetIntAttribute(tracing.AttributeMessageSize, 42)
+	write := receive.StartChild(tracing.SpanWrite, tracing.SpanKindProducer)
+	write.SetError(errors.New("stream closed"))
+	write.End()
+	receive.End()
+
+	unsampled := parent
+	unsampled.Sampled = false
+	g.Expect(tracer.Start(tracing.SpanReceive, tracing.SpanKindServer, unsampled)).To(BeNil())
+	g.Expect(tracer.Start(tracing.SpanReceive, tracing.SpanKindServer, tracing.SpanContext{})).To(BeNil())
+
+	// Nil tracers and spans record nothing
+	var none *tracing.Tracer
+	span := none.StartTrace(tracing.SpanPublish, tracing.SpanKindProducer)
+	span.SetAttribute(tracing.AttributeMessageKey, "order-1")
+	span.StartChild(tracing.SpanWrite, tracing.SpanKindProducer).End()
+	span.End()
+	g.Expect(span.Context().IsValid()).To(BeFalse())
+	g.Expect(none.Close()).To(Succeed())
+
+	g.Expect(tracer.Close()).To(Succeed())
+	g.Expect(exporter.closed).To(BeTrue())
+	g.Expect(exporter.payloads).To(HaveLen(1))
+
+	var request otlpRequest
+	g.Expect(json.Unmarshal(exporter.payloads[0], &request)).To(Succeed())
+	g.Expect(request.ResourceSpans).To(HaveLen(1))
+	g.Expect(request.ResourceSpans[0].Resource.Attributes).To(ConsistOf(
+		otlpAttribute{Key: "service.name", Value: map[string]string{"stringValue": "quicpubsub-test"}}))
+	spans := request.ResourceSpans[0].ScopeSpans[0].Spans
+	g.Expect(spans).To(HaveLen(3))
+
+	g.Expect(spans[0].Name).To(Equal(tracing.SpanPublish))
+	g.Expect(spans[0].Kind).To(Equal(int(tracing.SpanKindProducer)))
+	g.Expect(spans[0].ParentSpanID).To(BeEmpty())
+	g.Expect(spans[0].Attributes).To(ConsistOf(
+		otlpAttribute{Key: tracing.AttributeMessageKey, Value: map[string]string{"stringValue": "order-1"}}))
+	g.Expect(spans[0].Status).To(BeEmpty())
+
+	g.Expect(spans[1].Name).To(Equal(tracing.SpanWrite))
+	g.Expect(spans[1].Status).To(Equal(map[string]any{"code": float64(2), "message": "stream closed"}))
+	g.Expect(spans[2].Name).To(Equal(tracing.SpanReceive))
+	g.Expect(spans[2].Attributes).To(ConsistOf(
+		otlpAttribute{Key: tracing.AttributeMessageSize, Value: map[string]string{"intValue": "42"}}))
+
+	for _, span := range spans {
+		g.Expect(span.TraceID).To(Equal(spans[0].TraceID), "all spans are of the trace of the publisher")
+		g.Expect(span.TraceID).To(HaveLen(32))
+		g.Expect(span.SpanID).To(HaveLen(16))
+		g.Expect(span.StartTimeUnixNano <= span.EndTimeUnixNano).To(BeTrue())
+	}
+	g.Expect(spans[2].ParentSpanID).To(Equal(spans[0].SpanID))
+	g.Expect(spans[1].ParentSpanID).To(Equal(spans[2].SpanID))
+}
+
+func TestNewExporter(t *testing.T) {
+	t.Run("File", func(t *testing.T) {
+		g := NewWithT(t)
+
+		path := filepath.Join(t.TempDir(), "spans.json")
+		exporter, err := tracing.NewExporter(path)
+		g.Expect(err).NotTo(HaveOccurred())
+		g.Expect(exporter).To(BeAssignableToTypeOf(&tracing.FileExporter{}))
+		g.Expect(exporter.Export([]byte(`{"resourceSpans":[]}`))).To(Succeed())
+		g.Expect(exporter.Export([]byte(`{"resourceSpans":[]}`))).To(Succeed())
+		g.Expect(exporter.Close()).To(Succeed())
+
+		data, err := os.ReadFile(path)
+		g.Expect(err).NotTo(HaveOccurred())
+		g.Expect(string(data)).To(Equal("{\"resourceSpans\":[]}\n{\"resourceSpans\":[]}\n"))
+	})
+
+	t.Run("HTTP", func(t *testing.T) {
+		g := NewWithT(t)
+
+		var (
+			received []string
+			status   = http.StatusOK
+		)
+		collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := io.ReadAll(r.Body)
+			received = append(received, r.Method+" "+r.URL.Path+" "+r.Header.Get("Content-Type")+" "+string(body))
+			w.WriteHeader(status)
+		}))
+		defer collector.Close()
+
+		exporter, err := tracing.NewExporter(collector.URL + "/v1/traces")
+		g.Expect(err).NotTo(HaveOccurred())
+		g.Expect(exporter.Export([]byte(`{"resourceSpans":[]}`))).To(Succeed())
+		status = http.StatusBadRequest
+		g.Expect(exporter.Export([]byte(`{}`))).To(MatchError(ContainSubstring("400")))
+		g.Expect(exporter.Close()).To(Succeed())
+		g.Expect(received).To(Equal([]string{
+			`POST /v1/traces application/json {"resourceSpans":[]}`,
+			`POST /v1/traces application/json {}`,
+		}))
+	})
+}
+
+// memoryExporter keeps the payloads it exports.
+type memoryExporter struct {
+	mu       sync.Mutex
+	payloads [][]byte
+	closed   bool
+}
+
+func (e *memoryExporter) Export(payload []byte) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.payloads = append(e.payloads, payload)
+	return nil
+}
+
+func (e *memoryExporter) Close() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.closed = true
+	return nil
+}
+
+// otlpRequest is the part of an OTLP ExportTraceServiceRequest in JSON that the tests check.
+type otlpRequest struct {
+	ResourceSpans []struct {
+		Resource struct {
+			Attributes []otlpAttribute `json:"attributes"`
+		} `json:"resource"`
+		ScopeSpans []struct {
+			Spans []otlpSpan `json:"spans"`
+		} `json:"scopeSpans"`
+	} `json:"resourceSpans"`
+}
+
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	ParentSpanID      string          `json:"parentSpanId"`
+	Name              string          `json:"name"`
+	Kind              int             `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes"`
+	Status            map[string]any  `json:"status"`
+}
+
+type otlpAttribute struct {
+	Key   string            `json:"key"`
+	Value map[string]string `json:"value"`
+}
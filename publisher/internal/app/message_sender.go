@@ -3,18 +3,27 @@ package app
 import (
 	"context"
 	"github.com/pkg/errors"
+	"github.com/varfrog/quicpubsub/pkg/client"
+	"github.com/varfrog/quicpubsub/pkg/sdk"
 	"go.uber.org/zap"
+	"sync/atomic"
 	"time"
 )
 
-// MessageSender runs a loop that continuously sends messages to a recipient, listens on a channel to stop or resume
+// MessageSender implements client.MessageSender. It runs a loop that continuously sends messages to a recipient, listens on a channel to stop or resume
 // sending.
 type MessageSender struct {
 	messageProvider MessageProvider
 	sendInterval    time.Duration
+	resumeAt        atomic.Int64 // Unix nanoseconds until which no messages are sent, as the server asked to slow down
 	logger          *zap.Logger
 }
 
+var (
+	_ client.MessageSender   = (*MessageSender)(nil)
+	_ client.SlowDownHandler = (*MessageSender)(nil)
+)
+
 // NewMessageSender is the constructor of MessageSender.
 // sendInterval is the wait time between sending messages.
 func NewMessageSender(
@@ -31,12 +40,12 @@ func NewMessageSender(
 
 // StartLoop waits on channel "sendMessagesCh" for "true" to start continuously sending messages, for "false" to stop
 // sending messages.
-// Messages are sent at intervals "sendInterval", configured at construction.
+// Messages are sent at intervals "sendInterval", configured at construction, except while backing off after SlowDown.
 // StartLoop calls "messageFn" to get the message body.
 // Notifies channel "failCh" on failure with the error.
 func (s *MessageSender) StartLoop(
 	ctx context.Context,
-	recipient MessageRecipient,
+	recipient client.MessageRecipient,
 	sendMessagesCh <-chan bool,
 	failCh chan<- error,
 ) {
@@ -49,16 +58,32 @@ func (s *MessageSender) StartLoop(
 		case val := <-sendMessagesCh:
 			send = val
 		case <-time.After(s.sendInterval):
-			if send {
+			if send && time.Now().UnixNano() >= s.resumeAt.Load() {
 				message, err := s.messageProvider.GetMessage()
 				if err != nil {
-					failCh <- errors.Wrapf(err, "get message from provider")
+					s.fail(ctx, failCh, errors.Wrapf(err, "get message from provider"))
+					return
 				}
-				if err := recipient.SendMessageToRecipient(message); err != nil {
-					failCh <- errors.Wrapf(err, "send message to recipient")
+				if err := recipient.SendMessageToRecipient(sdk.Message{Payload: message}); err != nil {
+					s.fail(ctx, failCh, errors.Wrapf(err, "send message to recipient"))
 					return
 				}
 			}
 		}
 	}
 }
+
+// SlowDown stops sending messages for retryAfter, as the server drops them meanwhile.
+func (s *MessageSender) SlowDown(retryAfter time.Duration) {
+	s.logger.Info("Backing off", zap.Duration("retry_after", retryAfter))
+	s.resumeAt.Store(time.Now().Add(retryAfter).UnixNano())
+}
+
+// fail notifies failCh about err unless ctx is done, in which case nobody is listening anymore.
+func (s *MessageSender) fail(ctx context.Context, failCh chan<- error, err error) {
+	select {
+	case <-ctx.Done():
+		s.logger.Debug("Not reporting a failure, context cancelled", zap.Error(err))
+	case failCh <- err:
+	}
+}
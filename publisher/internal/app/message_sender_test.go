@@ -2,6 +2,8 @@ package app_test
 
 import (
 	"context"
+	clientmocks "github.com/varfrog/quicpubsub/pkg/client/mocks"
+	"github.com/varfrog/quicpubsub/pkg/sdk"
 	"github.com/varfrog/quicpubsub/publisher/internal/app"
 	mocks "github.com/varfrog/quicpubsub/publisher/internal/app/mocks"
 	"go.uber.org/mock/gomock"
@@ -17,7 +19,7 @@ func TestMessageSender(t *testing.T) {
 		defer ctrl.Finish()
 
 		// Setup MessageRecipient
-		messageRecipient := mocks.NewMockMessageRecipient(ctrl)
+		messageRecipient := clientmocks.NewMockMessageRecipient(ctrl)
 		messageRecipient.EXPECT().SendMessageToRecipient(gomock.Any()).Times(0) // Assertion
 
 		// Setup MessageProvider
@@ -43,10 +45,10 @@ func TestMessageSender(t *testing.T) {
 		defer ctrl.Finish()
 
 		// Setup MessageRecipient
-		messageRecipient := mocks.NewMockMessageRecipient(ctrl)
+		messageRecipient := clientmocks.NewMockMessageRecipient(ctrl)
 		messageRecipient.
 			EXPECT().
-			SendMessageToRecipient([]byte("hello")).
+			SendMessageToRecipient(sdk.Message{Payload: []byte("hello")}).
 			MinTimes(3) // Ensure it's been called some number of times
 
 		// Setup MessageProvider
@@ -88,7 +90,7 @@ func TestMessageSender(t *testing.T) {
 		defer ctrl.Finish()
 
 		// Setup MessageRecipient
-		messageRecipient := mocks.NewMockMessageRecipient(ctrl)
+		messageRecipient := clientmocks.NewMockMessageRecipient(ctrl)
 		messageRecipient.
 			EXPECT().
 			SendMessageToRecipient(gomock.Any()).
@@ -122,6 +124,44 @@ func TestMessageSender(t *testing.T) {
 		time.Sleep(sendInterval * 1)
 		sendToggleCh <- false
 
+		wg.Wait()
+	})
+	t.Run("Backs off when told to slow down", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		// Setup MessageRecipient
+		messageRecipient := clientmocks.NewMockMessageRecipient(ctrl)
+		messageRecipient.
+			EXPECT().
+			SendMessageToRecipient(gomock.Any()).
+			MaxTimes(2) // Ensure max 2 send operations done if we slow down right after starting
+
+		// Setup MessageProvider
+		messageProvider := mocks.NewMockMessageProvider(ctrl)
+		messageProvider.EXPECT().GetMessage().Return([]byte("hello"), nil).AnyTimes()
+
+		// Setup MessageSender
+		sendInterval := time.Millisecond * 50
+		messageSender := app.NewMessageSender(messageProvider, sendInterval, zap.NewNop())
+
+		sendToggleCh := make(chan bool)
+		failureCh := make(chan error)
+
+		// Run 10 times longer than sendInterval, backing off for all of it
+		ctx, cancel := context.WithTimeout(context.Background(), sendInterval*10)
+		defer cancel()
+
+		wg := sync.WaitGroup{}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			messageSender.StartLoop(ctx, messageRecipient, sendToggleCh, failureCh)
+		}()
+
+		sendToggleCh <- true // Turn on message sending
+		messageSender.SlowDown(sendInterval * 20)
+
 		wg.Wait()
 	})
 }
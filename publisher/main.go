@@ -2,32 +2,46 @@ package main
 
 import (
 	"context"
-	"crypto/tls"
 	"flag"
 	"github.com/google/uuid"
 	"github.com/pkg/errors"
 	"github.com/quic-go/quic-go"
+	"github.com/varfrog/quicpubsub/pkg/client"
+	"github.com/varfrog/quicpubsub/pkg/encryption"
 	"github.com/varfrog/quicpubsub/pkg/quichelper"
+	"github.com/varfrog/quicpubsub/pkg/sdk"
+	"github.com/varfrog/quicpubsub/pkg/signing"
+	"github.com/varfrog/quicpubsub/pkg/tracing"
 	"github.com/varfrog/quicpubsub/publisher/internal/app"
-	"github.com/varfrog/quicpubsub/publisher/internal/transport"
 	"go.uber.org/zap"
 	"log"
 	"math"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"syscall"
 	"time"
 )
 
 // Represents configuration needed to run this app.
 type runConfig struct {
-	Help            bool   // Prints usage and exists if true
-	TLSCertsDir     string // Path to a directory containing TLS certificates
-	ServerPort      int
-	MaxMessageBytes int // Max number of bytes per RPC message (type int required by io.Reader)
+	client.TLSOptions          // How servers are verified and the client authenticates to them
+	Help              bool     // Prints usage and exists if true
+	ServerAddrs       []string // Server addresses "host:port", the host is resolved when dialing, failed over in turn
+	MaxMessageBytes   int      // Max number of bytes per RPC message (type int required by io.Reader)
+	Key               string   // Message key, the client connects to the server owning its partition if set
+	Token             string   // Bearer token to authenticate with, for servers that require one
+	SigningKeyPath    string   // Path to the ed25519 key messages are signed with, messages are not signed if empty
+	SignerID          string   // ID by which subscribers know the signing key
+	EncryptionPath    string   // Path to the keys payloads are encrypted with, payloads are not encrypted if empty
+	EncryptionKeyID   string   // ID of the key of the file at EncryptionPath that payloads are encrypted with
+	TraceExport       string   // URL or file path to export spans to, see tracing.NewExporter, messages are not traced if empty
+	Warnings          []string // About deprecated flags that are set, logged once the logger is created
 }
 
 func main() {
-	ctx := context.Background()
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
 
 	config, err := parseFlagsIntoConfig()
 	if err != nil {
@@ -43,33 +57,77 @@ func main() {
 		log.Fatal(err)
 	}
 
-	tlsConfig, err := buildTLSConfig(config.TLSCertsDir)
+	tlsConfig, err := config.BuildTLSConfig(sdk.ALPNPublisher)
 	if err != nil {
-		log.Fatalf("buildTLSConfig: %v", err)
+		log.Fatalf("BuildTLSConfig: %v", err)
 	}
 
 	logger, err := zap.NewDevelopment()
 	if err != nil {
 		log.Fatalf("zap.NewDevelopment: %v", err)
 	}
+	for _, warning := range config.Warnings {
+		logger.Warn(warning)
+	}
 
 	publisherUUID := uuid.New()
 
 	messageProvider := app.NewMessageProviderHello(publisherUUID.String())
 
-	publisher := transport.NewQUICPublisher(
-		transport.QUICPublisherConfig{
-			UUID:            publisherUUID,
-			TLSConfig:       tlsConfig,
-			ServerPort:      config.ServerPort,
-			MaxMessageBytes: config.MaxMessageBytes,
-		},
-		quic.Config{MaxIdleTimeout: math.MaxInt64},
-		app.NewMessageSender(messageProvider, time.Second, logger),
-		quichelper.NewPinger(quichelper.NewDefaultPingerConfig(), logger),
-		logger)
-
-	if err = publisher.Run(ctx); err != nil {
+	messageSender := app.NewMessageSender(messageProvider, time.Second, logger)
+	var signer *signing.Signer
+	if config.SigningKeyPath != "" {
+		signer, err = signing.LoadSigner(config.SignerID, config.SigningKeyPath)
+		if err != nil {
+			log.Fatalf("signing.LoadSigner: %v", err)
+		}
+	}
+	var encrypter *encryption.Encrypter
+	if config.EncryptionPath != "" {
+		keys, err := encryption.LoadKeys(config.EncryptionPath)
+		if err != nil {
+			log.Fatalf("encryption.LoadKeys: %v", err)
+		}
+		if encrypter, err = encryption.NewEncrypter(keys, config.EncryptionKeyID); err != nil {
+			log.Fatalf("encryption.NewEncrypter: %v", err)
+		}
+	}
+	var tracer *tracing.Tracer
+	if config.TraceExport != "" {
+		exporter, err := tracing.NewExporter(config.TraceExport)
+		if err != nil {
+			log.Fatalf("tracing.NewExporter: %v", err)
+		}
+		tracer = tracing.NewTracer("quicpubsub-publisher", exporter, logger.Named("Tracing"))
+		defer tracer.Close()
+	}
+	pinger := quichelper.NewPinger(quichelper.NewDefaultPingerConfig(), logger)
+
+	run := func(ctx context.Context, addr string) error {
+		publisher := client.NewQUICPublisher(
+			client.QUICPublisherConfig{
+				UUID:            publisherUUID,
+				TLSConfig:       tlsConfig,
+				ServerAddr:      addr,
+				MaxMessageBytes: config.MaxMessageBytes,
+				Key:             config.Key,
+				Token:           config.Token,
+				Encrypter:       encrypter,
+				Signer:          signer,
+				Tracer:          tracer,
+			},
+			quic.Config{MaxIdleTimeout: math.MaxInt64},
+			messageSender,
+			pinger,
+			logger)
+		return publisher.Run(ctx)
+	}
+	if config.Key != "" {
+		err = client.RunPartitioned(ctx, config.ServerAddrs, config.Key, tlsConfig, time.Second, logger, run)
+	} else {
+		err = client.RunWithFailover(ctx, config.ServerAddrs, time.Second, logger, run)
+	}
+	if err != nil {
 		log.Fatalf("Run: %v", err)
 	}
 }
@@ -84,21 +142,88 @@ func parseFlagsIntoConfig() (runConfig, error) {
 	var (
 		help            bool
 		certPath        string
+		serverAddr      string
 		serverPort      int
 		maxMessageBytes int
+		key             string
+		tenant          string
+		clientCertPath  string
+		clientKeyPath   string
+		serverName      string
+		caBundlePath    string
+		systemRoots     bool
+		pinnedSPKI      string
+		token           string
+		signingKeyPath  string
+		signerID        string
+		encryptionPath  string
+		encryptionKeyID string
+		traceExport     string
 	)
 
 	flag.BoolVar(&help, "help", false, "Print usage information")
 	flag.StringVar(&certPath, "cert-path", filepath.Join(workingDir, "certs"), "Path to certs dir")
-	flag.IntVar(&serverPort, "server-port", 5000, "Server port")
+	flag.StringVar(&serverAddr, "server-addr", "127.0.0.1:5000", "Server address host:port, e.g. [::1]:5000 or broker.example.com:5000. "+
+		"Comma-separated addresses of servers of a cluster are failed over in turn")
+	flag.IntVar(&serverPort, "server-port", 0, "Deprecated, use -server-addr. Connects to 127.0.0.1 on the port")
 	flag.IntVar(&maxMessageBytes, "max-message-bytes", 1000, "Max number of bytes per message")
+	flag.StringVar(&key, "key", "", "Message key. With partitioned servers, the partition map is fetched from any of "+
+		"-server-addr and the server owning the partition of the key is connected to")
+	flag.StringVar(&tenant, "tenant", "", "Tenant to connect as, declared to the server via ALPN. "+
+		"Must match the organization of the client certificate if it names one. The default tenant if empty")
+	flag.StringVar(&clientCertPath, "client-cert", "",
+		"Path to a client certificate to present to servers that authenticate clients (mTLS), e.g. certs/client.pem")
+	flag.StringVar(&clientKeyPath, "client-key", "", "Path to the private key of -client-cert, e.g. certs/client.key")
+	flag.StringVar(&serverName, "server-name", "", "Name the server certificate must be valid for, sent via SNI. "+
+		"The host of the server address if empty")
+	flag.StringVar(&caBundlePath, "ca-bundle", "", "Path to a PEM bundle of one or more CAs that issue server "+
+		"certificates. ca.pem in -cert-path if empty")
+	flag.BoolVar(&systemRoots, "system-roots", false, "Trust the CAs of the system too, in addition to -ca-bundle if given")
+	flag.StringVar(&pinnedSPKI, "pin-sha256", "", "Comma-separated base64 SHA-256 hashes of the public keys "+
+		"(SubjectPublicKeyInfo) that server certificates may have. Replaces verification against CAs if set")
+	flag.StringVar(&token, "token", os.Getenv("QUICPUBSUB_TOKEN"), "Bearer token to authenticate with to servers "+
+		"that require one, $QUICPUBSUB_TOKEN by default, which keeps it out of the process list")
+	flag.StringVar(&signingKeyPath, "signing-key", "", "Path to an ed25519 private key to sign messages with, "+
+		"which subscribers verify against their trust store, e.g. from ca -signing-key. Messages are not signed if empty")
+	flag.StringVar(&signerID, "signer", "", "ID by which subscribers know -signing-key in their trust store")
+	flag.StringVar(&encryptionPath, "encryption-keys", "", "Path to a JSON file of AES-256 keys by key ID, e.g. "+
+		"{\"2024-10\": \"<base64 key>\"}, shared with the subscribers, which decrypt the payloads. Payloads are "+
+		"encrypted with the key of -encryption-key-id, so that servers cannot read them. Not encrypted if empty")
+	flag.StringVar(&encryptionKeyID, "encryption-key-id", "", "ID of the key of -encryption-keys to encrypt payloads "+
+		"with. Keys are rotated by switching to a new key ID once subscribers have the key")
+	flag.StringVar(&traceExport, "trace-export", "", "Where to export the spans of published messages to, as OTLP "+
+		"JSON: an http:// or https:// URL to post them to, e.g. that of a local OpenTelemetry collector "+
+		"http://127.0.0.1:4318/v1/traces, or a file to append them to. Each message starts a trace, which servers "+
+		"and subscribers continue. Messages are not traced if empty")
 	flag.Parse()
 
+	var warnings []string
+	if warning := quichelper.ApplyDeprecatedPortFlag(flag.CommandLine, "server-port", "server-addr", &serverAddr); warning != "" {
+		warnings = append(warnings, warning)
+	}
+
 	return runConfig{
+		TLSOptions: client.TLSOptions{
+			TLSCertsDir:    certPath,
+			Tenant:         tenant,
+			ClientCertPath: clientCertPath,
+			ClientKeyPath:  clientKeyPath,
+			ServerName:     serverName,
+			CABundlePath:   caBundlePath,
+			SystemRoots:    systemRoots,
+			PinnedSPKI:     pinnedSPKI,
+		},
 		Help:            help,
-		TLSCertsDir:     certPath,
-		ServerPort:      serverPort,
+		ServerAddrs:     quichelper.ParseAddrList(serverAddr),
 		MaxMessageBytes: maxMessageBytes,
+		Key:             key,
+		Token:           token,
+		SigningKeyPath:  signingKeyPath,
+		SignerID:        signerID,
+		EncryptionPath:  encryptionPath,
+		EncryptionKeyID: encryptionKeyID,
+		TraceExport:     traceExport,
+		Warnings:        warnings,
 	}, nil
 }
 
@@ -106,20 +231,22 @@ func validateRunConfig(config runConfig) error {
 	if config.MaxMessageBytes < 1 {
 		return errors.New("MaxMessageBytes < 1")
 	}
-	if _, err := os.Stat(config.TLSCertsDir); errors.Is(err, os.ErrNotExist) {
-		return errors.New("cannot stat the TLS certs dir, change the working dir to the project root or specify flag -cert-path")
+	if len(config.ServerAddrs) == 0 {
+		return errors.New("no server addresses")
 	}
-	return nil
-}
-
-func buildTLSConfig(certDirPath string) (*tls.Config, error) {
-	rootCAs, err := quichelper.GetRootCertPool(certDirPath)
-	if err != nil {
-		return nil, errors.Wrap(err, "GetRootCertPool")
+	for _, addr := range config.ServerAddrs {
+		if err := quichelper.ValidateAddr(addr); err != nil {
+			return errors.Wrap(err, "ServerAddrs")
+		}
 	}
-
-	return &tls.Config{
-		RootCAs:            rootCAs,
-		InsecureSkipVerify: true,
-	}, nil
+	if err := config.TLSOptions.Validate(); err != nil {
+		return err
+	}
+	if config.SigningKeyPath != "" && config.SignerID == "" {
+		return errors.New("specify the ID of the signing key with flag -signer")
+	}
+	if config.EncryptionPath != "" && config.EncryptionKeyID == "" {
+		return errors.New("specify the key to encrypt payloads with with flag -encryption-key-id")
+	}
+	return nil
 }
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"github.com/varfrog/quicpubsub/pkg/audit"
+	"github.com/varfrog/quicpubsub/pkg/auth"
+	"github.com/varfrog/quicpubsub/server/internal/app"
+	"go.uber.org/zap"
+)
+
+// newAuthenticator returns the authenticator of the tokens of -auth-keys, nil if clients need no tokens.
+func newAuthenticator(
+	ctx context.Context,
+	config runConfig,
+	auditLog *audit.Log,
+	logger *zap.Logger,
+) (*app.Authenticator, error) {
+	if config.AuthKeysPath == "" {
+		return nil, nil
+	}
+	keys, err := auth.LoadKeys(config.AuthKeysPath)
+	if err != nil {
+		return nil, errors.Wrap(err, "auth.LoadKeys")
+	}
+	authenticator := app.NewAuthenticator(keys)
+	go reloadOnChange(ctx, []string{config.AuthKeysPath}, func() error {
+		keys, err := auth.LoadKeys(config.AuthKeysPath)
+		if err != nil {
+			return errors.Wrap(err, "auth.LoadKeys")
+		}
+		authenticator.SetKeys(keys)
+		return nil
+	}, auditLog, logger)
+	return authenticator, nil
+}
+
+// newAccessControl returns the access control of the ACL of -acl, nil if clients may do anything.
+func newAccessControl(
+	ctx context.Context,
+	config runConfig,
+	auditLog *audit.Log,
+	logger *zap.Logger,
+) (*app.AccessControl, error) {
+	if config.ACLPath == "" {
+		return nil, nil
+	}
+	acl, err := app.LoadACL(config.ACLPath)
+	if err != nil {
+		return nil, errors.Wrap(err, "LoadACL")
+	}
+	accessControl := app.NewAccessControl(acl)
+	go reloadOnChange(ctx, []string{config.ACLPath}, func() error {
+		acl, err := app.LoadACL(config.ACLPath)
+		if err != nil {
+			return errors.Wrap(err, "LoadACL")
+		}
+		accessControl.SetACL(acl)
+		return nil
+	}, auditLog, logger)
+	return accessControl, nil
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"github.com/varfrog/quicpubsub/pkg/audit"
+	"github.com/varfrog/quicpubsub/server/internal/app"
+	"github.com/varfrog/quicpubsub/server/internal/transport"
+	"go.uber.org/zap"
+)
+
+// serveAdmin serves the admin API at -admin-addr, over HTTPS with the certificate of the server if -admin-tls.
+func serveAdmin(
+	config runConfig,
+	publisherPool *app.PublisherPool,
+	subscriberPool *app.SubscriberPool,
+	observer *app.Observer,
+	drain context.CancelFunc,
+	authenticator *app.Authenticator,
+	certificates *transport.Certificates,
+	auditLog *audit.Log,
+	logger *zap.Logger,
+) {
+	admin := app.NewAdmin(publisherPool, subscriberPool, observer, drain, logger.Named("Admin"))
+	server := newHTTPServer(config.AdminAddr, transport.NewAdminHandler(admin, authenticator, auditLog, logger))
+	if !config.AdminTLS {
+		if err := server.ListenAndServe(); err != nil {
+			logger.Error("ListenAndServe", zap.Error(err))
+		}
+		return
+	}
+
+	server.TLSConfig = &tls.Config{GetCertificate: certificates.GetCertificate}
+	if err := server.ListenAndServeTLS("", ""); err != nil { // The certificate is that of TLSConfig
+		logger.Error("ListenAndServeTLS", zap.Error(err))
+	}
+}
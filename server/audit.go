@@ -0,0 +1,23 @@
+package main
+
+import (
+	"github.com/pkg/errors"
+	"github.com/varfrog/quicpubsub/pkg/audit"
+	"go.uber.org/zap"
+)
+
+// openAuditLog opens the audit trail of -audit-log, nil if nothing is audited.
+func openAuditLog(config runConfig, logger *zap.Logger) (*audit.Log, error) {
+	if config.AuditLogPath == "" {
+		return nil, nil
+	}
+	auditLog, err := audit.Open(audit.Config{
+		Path:     config.AuditLogPath,
+		MaxBytes: config.AuditLogMaxBytes,
+		MaxFiles: config.AuditLogMaxFiles,
+	}, logger)
+	if err != nil {
+		return nil, errors.Wrap(err, "audit.Open")
+	}
+	return auditLog, nil
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"github.com/pkg/errors"
+	"github.com/quic-go/quic-go"
+	"github.com/varfrog/quicpubsub/pkg/quichelper"
+	"github.com/varfrog/quicpubsub/server/internal/app"
+	"github.com/varfrog/quicpubsub/server/internal/transport"
+	"go.uber.org/zap"
+	"math"
+	"path/filepath"
+	"time"
+)
+
+// bridgeDedupWindow is how many message IDs of each origin are remembered to drop copies, see app.Deduplicator.
+const bridgeDedupWindow = 10000
+
+// newDeduplicator returns the deduplicator of the messages of bridges, nil if bridging is off.
+func newDeduplicator(config runConfig) *app.Deduplicator {
+	if config.BridgePubAddr == "" {
+		return nil
+	}
+	// Copies of a message only reach servers in a cycle of bridges, and each of those bridges to another
+	return app.NewDeduplicator(bridgeDedupWindow)
+}
+
+// newBridge returns the bridge to the remote server of -bridge-addr.
+func newBridge(
+	config runConfig,
+	certificates *transport.Certificates,
+	observer *app.Observer,
+	pinger *quichelper.Pinger,
+	logger *zap.Logger,
+) (*transport.QUICBridge, error) {
+	serverCAs, err := quichelper.GetRootCertPool(filepath.Dir(config.TLSCertPemPath))
+	if err != nil {
+		return nil, errors.Wrap(err, "GetRootCertPool")
+	}
+	direction, _ := app.ParseBridgeDirection(config.BridgeDirection) // Validated
+	return transport.NewQUICBridge(
+		transport.QUICBridgeConfig{
+			PubAddr:         config.BridgePubAddr,
+			SubAddr:         config.BridgeSubAddr,
+			TLSConfig:       buildBridgeTLSConfig(serverCAs, certificates),
+			MaxMessageBytes: config.MaxMessageBytes,
+			RetryInterval:   time.Second * 5,
+			Token:           config.BridgeToken,
+		},
+		quic.Config{MaxIdleTimeout: math.MaxInt64},
+		app.NewBridge(app.BridgeConfig{
+			LocalBrokerID: config.BrokerID,
+			Direction:     direction,
+			MaxHops:       config.BridgeMaxHops,
+		}),
+		observer,
+		pinger,
+		logger), nil
+}
+
+// validateBridgeConfig checks the bridge flags, given that bridging is on.
+func validateBridgeConfig(config runConfig) error {
+	if err := quichelper.ValidateAddr(config.BridgePubAddr); err != nil {
+		return errors.Wrap(err, "BridgePubAddr")
+	}
+	if err := quichelper.ValidateAddr(config.BridgeSubAddr); err != nil {
+		return errors.Wrap(err, "BridgeSubAddr")
+	}
+	if _, err := app.ParseBridgeDirection(config.BridgeDirection); err != nil {
+		return err
+	}
+	if config.BridgeMaxHops < 1 {
+		return errors.New("BridgeMaxHops < 1")
+	}
+	return nil
+}
+
+// buildBridgeTLSConfig returns the TLS config for connecting to other servers, presenting the server's certificate.
+func buildBridgeTLSConfig(rootCAs *x509.CertPool, certificates *transport.Certificates) *tls.Config {
+	config := &tls.Config{GetClientCertificate: certificates.GetClientCertificate}
+	quichelper.ServerVerification{RootCAs: rootCAs}.Apply(config)
+	return config
+}
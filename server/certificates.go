@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"github.com/varfrog/quicpubsub/pkg/audit"
+	"github.com/varfrog/quicpubsub/server/internal/transport"
+	"go.uber.org/zap"
+	"math"
+	"time"
+)
+
+// certificateExpiryWarningDays is how many days before the certificate of the server expires it is warned about.
+const certificateExpiryWarningDays = 30
+
+// loadCertificates loads the certificates of the server and reloads them when they change, until ctx is done.
+func loadCertificates(
+	ctx context.Context,
+	config runConfig,
+	auditLog *audit.Log,
+	logger *zap.Logger,
+) (*transport.Certificates, error) {
+	certificates, err := transport.LoadCertificates(
+		config.TLSCertPemPath, config.TLSPrivateKeyPath, config.ClientCAPath, time.Now())
+	if err != nil {
+		return nil, errors.Wrap(err, "LoadCertificates")
+	}
+	logCertificate(certificates, logger)
+	go reloadOnChange(ctx, certificates.Paths(), func() error {
+		if err := certificates.Reload(time.Now()); err != nil {
+			return errors.Wrap(err, "Reload")
+		}
+		logCertificate(certificates, logger)
+		return nil
+	}, auditLog, logger)
+	return certificates, nil
+}
+
+// logCertificate logs the expiry of the certificate of the server, as a warning if it is near.
+func logCertificate(certificates *transport.Certificates, logger *zap.Logger) {
+	leaf := certificates.Leaf()
+	daysToExpiry := certificates.DaysToExpiry(time.Now())
+	fields := []zap.Field{
+		zap.String("subject", leaf.Subject.String()),
+		zap.Time("not_after", leaf.NotAfter),
+		zap.Float64("days_to_expiry", math.Floor(daysToExpiry)),
+	}
+	if daysToExpiry < certificateExpiryWarningDays {
+		logger.Warn("The certificate expires soon, rotate it", fields...)
+		return
+	}
+	logger.Info("Using certificate", fields...)
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"crypto/tls"
+	"github.com/pkg/errors"
+	"github.com/varfrog/quicpubsub/pkg/quichelper"
+	"github.com/varfrog/quicpubsub/server/internal/app"
+	"github.com/varfrog/quicpubsub/server/internal/transport"
+	"go.uber.org/zap"
+	"path/filepath"
+)
+
+// newCluster returns the server's membership of the cluster of -cluster-peers.
+func newCluster(
+	config runConfig,
+	tlsConfig *tls.Config,
+	certificates *transport.Certificates,
+	observer *app.Observer,
+	logger *zap.Logger,
+) (*transport.QUICCluster, error) {
+	serverCAs, err := quichelper.GetRootCertPool(filepath.Dir(config.TLSCertPemPath))
+	if err != nil {
+		return nil, errors.Wrap(err, "GetRootCertPool")
+	}
+	return transport.NewQUICCluster(
+		transport.QUICClusterConfig{
+			NodeAddr:          config.ClusterAddr,
+			PeerAddrs:         config.ClusterPeerAddrs,
+			TLSConfig:         tlsConfig,
+			PeerCAs:           serverCAs,
+			PeerTLSConfig:     buildBridgeTLSConfig(serverCAs, certificates),
+			MaxMessageBytes:   config.MaxMessageBytes,
+			HeartbeatInterval: config.HeartbeatInterval,
+			HeartbeatTimeout:  config.HeartbeatTimeout,
+		},
+		app.NewCluster(
+			app.ClusterConfig{
+				NodeID:           config.ClusterAddr,
+				PeerIDs:          config.ClusterPeerAddrs,
+				HeartbeatTimeout: config.HeartbeatTimeout,
+			},
+			logger.Named("Cluster")),
+		observer,
+		logger.Named("QUICCluster")), nil
+}
+
+// validateClusterConfig checks the cluster flags, given that clustering is on.
+func validateClusterConfig(config runConfig) error {
+	if err := quichelper.ValidateAddr(config.ClusterAddr); err != nil {
+		return errors.Wrap(err, "ClusterAddr")
+	}
+	if len(config.ClusterPeerAddrs) == 0 {
+		return errors.New("no cluster peers")
+	}
+	for _, addr := range config.ClusterPeerAddrs {
+		if err := quichelper.ValidateAddr(addr); err != nil {
+			return errors.Wrap(err, "ClusterPeerAddrs")
+		}
+		if addr == config.ClusterAddr {
+			return errors.New("ClusterPeerAddrs must not include ClusterAddr")
+		}
+	}
+	if config.HeartbeatInterval <= 0 {
+		return errors.New("HeartbeatInterval <= 0")
+	}
+	if config.HeartbeatTimeout <= config.HeartbeatInterval {
+		return errors.New("HeartbeatTimeout must be longer than HeartbeatInterval")
+	}
+	return nil
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"net/http"
+	"time"
+)
+
+// Timeouts of the HTTP servers of the admin API and the metrics, so that slow or idle clients do not hold connections.
+const (
+	httpReadHeaderTimeout = time.Second * 5
+	httpReadTimeout       = time.Second * 10
+	httpIdleTimeout       = time.Minute
+)
+
+// newHTTPServer returns a server of handler at addr with the HTTP timeouts.
+func newHTTPServer(addr string, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: httpReadHeaderTimeout,
+		ReadTimeout:       httpReadTimeout,
+		IdleTimeout:       httpIdleTimeout,
+	}
+}
@@ -0,0 +1,256 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"os"
+	"sync/atomic"
+)
+
+// Actions that an ACL allows on subjects, the keys of messages (see sdk.HeaderKey).
+const (
+	ACLActionPublish   = "publish"
+	ACLActionSubscribe = "subscribe"
+)
+
+// ACLAnyIdentity stands for every client in ACLRule.Identity, including anonymous ones.
+const ACLAnyIdentity = "*"
+
+// ACLConfig is the configuration of access control lists, read by LoadACL.
+type ACLConfig struct {
+	Groups map[string][]string `json:"groups"` // Identities of clients by group name
+	Rules  []ACLRule           `json:"rules"`
+}
+
+// ACLRule allows the clients of an identity or a group to publish and subscribe to the subjects that match patterns,
+// where "*" matches any run of characters, e.g. "orders.*" matches "orders.eu" and "orders.eu.new".
+type ACLRule struct {
+	Identity  string   `json:"identity,omitempty"` // Identity of the client, or ACLAnyIdentity
+	Group     string   `json:"group,omitempty"`    // Group of ACLConfig.Groups, instead of Identity
+	Publish   []string `json:"publish,omitempty"`
+	Subscribe []string `json:"subscribe,omitempty"`
+}
+
+// AccessDeniedError is returned by AccessControl when an ACL does not allow a client an action.
+type AccessDeniedError struct {
+	Identity string // Empty if the client is anonymous
+	Action   string // One of the ACLAction* constants
+	Subject  string // Empty if the client may not take the action on any subject
+}
+
+func (e *AccessDeniedError) Error() string {
+	identity := e.Identity
+	if identity == "" {
+		identity = "anonymous client"
+	}
+	if e.Subject == "" {
+		return fmt.Sprintf("'%s' may not %s", identity, e.Action)
+	}
+	return fmt.Sprintf("'%s' may not %s to '%s'", identity, e.Action, e.Subject)
+}
+
+// ACL tells which subjects clients may publish and subscribe to. Clients have the permissions of the rules for their
+// identity, for the groups they are in and for ACLAnyIdentity together, and no others.
+type ACL struct {
+	permissions map[string]permissions // By identity
+	anyone      permissions            // Of the ACLAnyIdentity rules, for the identities without rules of their own
+}
+
+type permissions struct {
+	publish   []string
+	subscribe []string
+}
+
+func (p permissions) patterns(action string) []string {
+	if action == ACLActionPublish {
+		return p.publish
+	}
+	return p.subscribe
+}
+
+// LoadACL reads an ACLConfig from a JSON file and compiles it with NewACL.
+func LoadACL(path string) (*ACL, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "os.ReadFile")
+	}
+	var config ACLConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, errors.Wrap(err, "json.Unmarshal")
+	}
+	return NewACL(config)
+}
+
+// NewACL compiles the config, and returns an error if a rule names neither or both of an identity and a group,
+// or an unknown group.
+func NewACL(config ACLConfig) (*ACL, error) {
+	acl := &ACL{permissions: make(map[string]permissions)}
+	grant := func(identity string, rule ACLRule) {
+		if identity == ACLAnyIdentity {
+			acl.anyone = acl.anyone.with(rule)
+			return
+		}
+		acl.permissions[identity] = acl.permissions[identity].with(rule)
+	}
+
+	for i, rule := range config.Rules {
+		switch {
+		case (rule.Identity == "") == (rule.Group == ""):
+			return nil, fmt.Errorf("rule %d must name either an identity or a group", i)
+		case rule.Identity != "":
+			grant(rule.Identity, rule)
+		default:
+			members, ok := config.Groups[rule.Group]
+			if !ok {
+				return nil, fmt.Errorf("rule %d names unknown group '%s'", i, rule.Group)
+			}
+			for _, identity := range members {
+				grant(identity, rule)
+			}
+		}
+	}
+
+	// The rules for anyone apply to every identity
+	for identity, p := range acl.permissions {
+		acl.permissions[identity] = p.with(ACLRule{Publish: acl.anyone.publish, Subscribe: acl.anyone.subscribe})
+	}
+	return acl, nil
+}
+
+func (p permissions) with(rule ACLRule) permissions {
+	return permissions{
+		publish:   append(append([]string(nil), p.publish...), rule.Publish...),
+		subscribe: append(append([]string(nil), p.subscribe...), rule.Subscribe...),
+	}
+}
+
+// permissionsOf returns the permissions of the client with the identity, empty if the client is anonymous.
+func (a *ACL) permissionsOf(identity string) permissions {
+	if p, ok := a.permissions[identity]; ok {
+		return p
+	}
+	return a.anyone
+}
+
+// Allows returns whether the client with the identity may take the action on the subject.
+func (a *ACL) Allows(identity string, action string, subject string) bool {
+	for _, pattern := range a.permissionsOf(identity).patterns(action) {
+		if matchSubject(pattern, subject) {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowsAny returns whether the client with the identity may take the action on any subject at all.
+func (a *ACL) AllowsAny(identity string, action string) bool {
+	return len(a.permissionsOf(identity).patterns(action)) > 0
+}
+
+// matchSubject returns whether the subject matches the pattern, in which "*" matches any run of characters.
+func matchSubject(pattern string, subject string) bool {
+	// Greedy matching that backtracks to the last "*" on a mismatch
+	p, s := 0, 0
+	star, starS := -1, 0
+	for s < len(subject) {
+		switch {
+		case p < len(pattern) && pattern[p] == '*':
+			star, starS = p, s
+			p++
+		case p < len(pattern) && pattern[p] == subject[s]:
+			p++
+			s++
+		case star >= 0:
+			starS++
+			p, s = star+1, starS
+		default:
+			return false
+		}
+	}
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+	return p == len(pattern)
+}
+
+// ACLDenials counts the actions that AccessControl has denied.
+type ACLDenials struct {
+	Connect   uint64 // Connections refused as the client may not publish or subscribe to anything
+	Publish   uint64 // Messages dropped
+	Subscribe uint64 // Subscriptions refused
+}
+
+// AccessControl enforces an ACL. The ACL can be replaced while it is being enforced, so that it can be reloaded
+// without a restart.
+type AccessControl struct {
+	acl              atomic.Pointer[ACL]
+	connectDenials   atomic.Uint64
+	publishDenials   atomic.Uint64
+	subscribeDenials atomic.Uint64
+}
+
+// NewAccessControl is the constructor for AccessControl.
+func NewAccessControl(acl *ACL) *AccessControl {
+	a := &AccessControl{}
+	a.SetACL(acl)
+	return a
+}
+
+// SetACL replaces the ACL. The connections already allowed stay connected, while their messages and subscriptions
+// are checked against the new ACL from now on.
+func (a *AccessControl) SetACL(acl *ACL) {
+	a.acl.Store(acl)
+}
+
+// AuthorizeConnect returns an AccessDeniedError if the client with the identity may not take the action on any
+// subject, in which case there is no point for it to connect.
+func (a *AccessControl) AuthorizeConnect(identity string, action string) error {
+	if a.acl.Load().AllowsAny(identity, action) {
+		return nil
+	}
+	a.connectDenials.Add(1)
+	return &AccessDeniedError{Identity: identity, Action: action}
+}
+
+// AuthorizePublish returns an AccessDeniedError if the client with the identity may not publish to the subject.
+func (a *AccessControl) AuthorizePublish(identity string, subject string) error {
+	if a.acl.Load().Allows(identity, ACLActionPublish, subject) {
+		return nil
+	}
+	a.publishDenials.Add(1)
+	return &AccessDeniedError{Identity: identity, Action: ACLActionPublish, Subject: subject}
+}
+
+// AuthorizeSubscribe returns an AccessDeniedError if the client with the identity may not subscribe to the subject.
+func (a *AccessControl) AuthorizeSubscribe(identity string, subject string) error {
+	if a.acl.Load().Allows(identity, ACLActionSubscribe, subject) {
+		return nil
+	}
+	a.subscribeDenials.Add(1)
+	return &AccessDeniedError{Identity: identity, Action: ACLActionSubscribe, Subject: subject}
+}
+
+// CanReceive returns whether a message with the subject may be delivered to the subscriber with the identity.
+// Messages withheld are not counted as denials, as subscribers that don't subscribe to a subject get all messages
+// they may receive.
+func (a *AccessControl) CanReceive(identity string, subject string) bool {
+	return a.acl.Load().Allows(identity, ACLActionSubscribe, subject)
+}
+
+// Denials returns the number of actions denied so far.
+func (a *AccessControl) Denials() ACLDenials {
+	return ACLDenials{
+		Connect:   a.connectDenials.Load(),
+		Publish:   a.publishDenials.Load(),
+		Subscribe: a.subscribeDenials.Load(),
+	}
+}
+
+// identityOf returns the identity of a Publisher or Subscriber, and false if it is trusted, see Identified.
+func identityOf(connector any) (string, bool) {
+	if identified, ok := connector.(Identified); ok {
+		return identified.GetIdentity(), true
+	}
+	return "", false
+}
@@ -0,0 +1,98 @@
+package app_test
+
+import (
+	"errors"
+	. "github.com/onsi/gomega"
+	"github.com/varfrog/quicpubsub/server/internal/app"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadACL(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	path := filepath.Join(t.TempDir(), "acl.json")
+	g.Expect(os.WriteFile(path, []byte(`{
+		"groups": {"sensors": ["sensor-1", "sensor-2"]},
+		"rules": [
+			{"group": "sensors", "publish": ["telemetry.*"]},
+			{"identity": "alice", "publish": ["orders.*"], "subscribe": ["orders.*", "telemetry.*"]}
+		]
+	}`), 0600)).To(Succeed())
+	acl, err := app.LoadACL(path)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(acl.Allows("sensor-2", app.ACLActionPublish, "telemetry.temp")).To(BeTrue())
+	g.Expect(acl.Allows("alice", app.ACLActionSubscribe, "telemetry.temp")).To(BeTrue())
+
+	for _, invalid := range []string{
+		`{"rules": [{"publish": ["*"]}]}`,
+		`{"rules": [{"identity": "alice", "group": "sensors", "publish": ["*"]}]}`,
+		`{"rules": [{"group": "unknown", "publish": ["*"]}]}`,
+		`{"rules": {}}`,
+	} {
+		g.Expect(os.WriteFile(path, []byte(invalid), 0600)).To(Succeed())
+		_, err := app.LoadACL(path)
+		g.Expect(err).To(HaveOccurred(), invalid)
+	}
+}
+
+func TestACL_Allows(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	acl, err := app.NewACL(app.ACLConfig{
+		Groups: map[string][]string{"ops": {"bob"}},
+		Rules: []app.ACLRule{
+			{Identity: "alice", Publish: []string{"orders.*", "invoices"}},
+			{Group: "ops", Subscribe: []string{"*"}},
+			{Identity: app.ACLAnyIdentity, Subscribe: []string{"public.*"}},
+		},
+	})
+	g.Expect(err).NotTo(HaveOccurred())
+
+	// "*" matches any run of characters, the rest must match exactly
+	g.Expect(acl.Allows("alice", app.ACLActionPublish, "orders.eu")).To(BeTrue())
+	g.Expect(acl.Allows("alice", app.ACLActionPublish, "orders.eu.new")).To(BeTrue())
+	g.Expect(acl.Allows("alice", app.ACLActionPublish, "orders.")).To(BeTrue())
+	g.Expect(acl.Allows("alice", app.ACLActionPublish, "orders")).To(BeFalse())
+	g.Expect(acl.Allows("alice", app.ACLActionPublish, "invoices")).To(BeTrue())
+	g.Expect(acl.Allows("alice", app.ACLActionPublish, "invoices.eu")).To(BeFalse())
+	g.Expect(acl.Allows("alice", app.ACLActionSubscribe, "orders.eu")).To(BeFalse())
+
+	// Groups, and the rules for anyone apply to every identity, anonymous clients included
+	g.Expect(acl.Allows("bob", app.ACLActionSubscribe, "")).To(BeTrue())
+	g.Expect(acl.Allows("alice", app.ACLActionSubscribe, "public.news")).To(BeTrue())
+	g.Expect(acl.Allows("", app.ACLActionSubscribe, "public.news")).To(BeTrue())
+	g.Expect(acl.Allows("", app.ACLActionSubscribe, "private")).To(BeFalse())
+	g.Expect(acl.AllowsAny("", app.ACLActionPublish)).To(BeFalse())
+	g.Expect(acl.AllowsAny("alice", app.ACLActionPublish)).To(BeTrue())
+}
+
+func TestAccessControl(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	newACL := func(rules ...app.ACLRule) *app.ACL {
+		acl, err := app.NewACL(app.ACLConfig{Rules: rules})
+		g.Expect(err).NotTo(HaveOccurred())
+		return acl
+	}
+	accessControl := app.NewAccessControl(newACL(app.ACLRule{Identity: "alice", Publish: []string{"a.*"}}))
+
+	g.Expect(accessControl.AuthorizeConnect("alice", app.ACLActionPublish)).To(Succeed())
+	g.Expect(accessControl.AuthorizePublish("alice", "a.1")).To(Succeed())
+
+	var deniedErr *app.AccessDeniedError
+	err := accessControl.AuthorizePublish("alice", "b.1")
+	g.Expect(errors.As(err, &deniedErr)).To(BeTrue())
+	g.Expect(err).To(MatchError("'alice' may not publish to 'b.1'"))
+	g.Expect(accessControl.AuthorizeConnect("alice", app.ACLActionSubscribe)).To(MatchError("'alice' may not subscribe"))
+	g.Expect(accessControl.AuthorizeSubscribe("", "a.1")).To(MatchError("'anonymous client' may not subscribe to 'a.1'"))
+	g.Expect(accessControl.CanReceive("alice", "a.1")).To(BeFalse()) // Not counted
+	g.Expect(accessControl.Denials()).To(Equal(app.ACLDenials{Connect: 1, Publish: 1, Subscribe: 1}))
+
+	// A new ACL applies right away
+	accessControl.SetACL(newACL(app.ACLRule{Identity: "alice", Publish: []string{"b.*"}, Subscribe: []string{"a.*"}}))
+	g.Expect(accessControl.AuthorizePublish("alice", "a.1")).NotTo(Succeed())
+	g.Expect(accessControl.AuthorizePublish("alice", "b.1")).To(Succeed())
+	g.Expect(accessControl.CanReceive("alice", "a.1")).To(BeTrue())
+}
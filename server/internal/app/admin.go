@@ -0,0 +1,167 @@
+package app
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// ErrConnectionNotFound is returned by Admin when no publisher or subscriber has the given ID.
+var ErrConnectionNotFound = errors.New("connection not found")
+
+// ConnectionInfo describes a publisher or subscriber connection to an operator, see Described.
+type ConnectionInfo struct {
+	ID          string
+	Tenant      string
+	Identity    string // Empty if the client is anonymous
+	RemoteAddr  string
+	ConnectedAt time.Time
+	Messages    uint64        // Published by the publisher, or sent to the subscriber
+	Bytes       uint64        // Of the Messages
+	PingRTT     time.Duration // Mean round-trip time of the last pings, zero until measured, see quichelper.PingStats
+	PingJitter  time.Duration // Mean difference between the round-trip times of the last pings
+	PingLoss    float64       // Fraction of the last pings lost
+	Degraded    bool          // Whether the pings tell that the connection is degraded
+	Paused      bool          // Whether an operator has paused the publisher, see Admin.PausePublisher
+	HasDemand   bool          // Whether the subscriber wants messages, see ConditionalSubscriber
+}
+
+// Admin lets an operator inspect the publishers and subscribers connected to the server, disconnect them, pause
+// publishers, and drain the server.
+type Admin struct {
+	publisherPool  *PublisherPool
+	subscriberPool *SubscriberPool
+	observer       *Observer
+	drain          func() // Starts shutting the server down
+	draining       atomic.Bool
+	logger         *zap.Logger
+}
+
+// NewAdmin is the constructor for Admin. drain starts shutting the server down, as a signal does, and may be called
+// more than once.
+func NewAdmin(
+	publisherPool *PublisherPool,
+	subscriberPool *SubscriberPool,
+	observer *Observer,
+	drain func(),
+	logger *zap.Logger,
+) *Admin {
+	return &Admin{
+		publisherPool:  publisherPool,
+		subscriberPool: subscriberPool,
+		observer:       observer,
+		drain:          drain,
+		logger:         logger,
+	}
+}
+
+// Publishers describes the connected publishers, by ID.
+func (a *Admin) Publishers() []ConnectionInfo {
+	publishers := a.publisherPool.GetAll()
+	infos := make([]ConnectionInfo, 0, len(publishers))
+	for _, publisher := range publishers {
+		infos = append(infos, describe(publisher))
+	}
+	sortByID(infos)
+	return infos
+}
+
+// Subscribers describes the connected subscribers, including other servers of the cluster and bridges, by ID.
+func (a *Admin) Subscribers() []ConnectionInfo {
+	subscribers := a.subscriberPool.GetAll()
+	infos := make([]ConnectionInfo, 0, len(subscribers))
+	for _, subscriber := range subscribers {
+		info := describe(subscriber)
+		info.HasDemand = hasDemand(subscriber)
+		infos = append(infos, info)
+	}
+	sortByID(infos)
+	return infos
+}
+
+// SubscriberExistence returns whether subscribers exist, as the publishers of each tenant have been told, for
+// DefaultTenant and the tenants of the connected clients.
+func (a *Admin) SubscriberExistence() map[string]bool {
+	return a.observer.SubscriberExistence()
+}
+
+// Draining returns whether Drain has been called.
+func (a *Admin) Draining() bool {
+	return a.draining.Load()
+}
+
+// Disconnect closes the connection of the publisher and the subscriber with the ID, of which there are both if
+// a client publishes and subscribes on one connection, or returns ErrConnectionNotFound.
+func (a *Admin) Disconnect(id string) error {
+	found := false
+	if publisher, ok := a.publisherPool.Get(id); ok {
+		found = true
+		if err := publisher.Disconnect(); err != nil {
+			return errors.Wrap(err, "publisher.Disconnect")
+		}
+	}
+	if subscriber, ok := a.subscriberPool.Get(id); ok {
+		found = true
+		if err := subscriber.Disconnect(); err != nil {
+			return errors.Wrap(err, "subscriber.Disconnect")
+		}
+	}
+	if !found {
+		return fmt.Errorf("%w: '%s'", ErrConnectionNotFound, id)
+	}
+	a.logger.Info("Disconnected by an operator", zap.String("id", id))
+	return nil
+}
+
+// PausePublisher tells the publisher with the ID to stop publishing until ResumePublisher, or returns
+// ErrConnectionNotFound.
+func (a *Admin) PausePublisher(id string) error {
+	publisher, ok := a.publisherPool.Get(id)
+	if !ok {
+		return fmt.Errorf("%w: no publisher '%s'", ErrConnectionNotFound, id)
+	}
+	if err := publisher.Pause(); err != nil {
+		return errors.Wrap(err, "Pause")
+	}
+	a.logger.Info("Publisher paused by an operator", zap.String("publisher_id", id))
+	return nil
+}
+
+// ResumePublisher tells the publisher with the ID that it may publish again, or returns ErrConnectionNotFound.
+func (a *Admin) ResumePublisher(id string) error {
+	publisher, ok := a.publisherPool.Get(id)
+	if !ok {
+		return fmt.Errorf("%w: no publisher '%s'", ErrConnectionNotFound, id)
+	}
+	if err := publisher.Resume(); err != nil {
+		return errors.Wrap(err, "Resume")
+	}
+	a.logger.Info("Publisher resumed by an operator", zap.String("publisher_id", id))
+	return nil
+}
+
+// Drain starts shutting the server down: it stops accepting connections and lets publishers and subscribers finish
+// up and disconnect, see Observer.Shutdown.
+func (a *Admin) Drain() {
+	if a.draining.CompareAndSwap(false, true) {
+		a.logger.Info("Draining the server on request of an operator")
+	}
+	a.drain()
+}
+
+// describe returns the ConnectionInfo of a Publisher or Subscriber.
+func describe(connector interface{ GetID() string }) ConnectionInfo {
+	if described, ok := connector.(Described); ok {
+		return described.Describe()
+	}
+	return ConnectionInfo{ID: connector.GetID(), Tenant: tenantOf(connector)}
+}
+
+func sortByID(infos []ConnectionInfo) {
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].ID < infos[j].ID
+	})
+}
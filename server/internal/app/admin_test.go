@@ -0,0 +1,104 @@
+package app_test
+
+import (
+	"errors"
+	. "github.com/onsi/gomega"
+	"github.com/varfrog/quicpubsub/server/internal/app"
+	mocks "github.com/varfrog/quicpubsub/server/internal/app/mocks"
+	"go.uber.org/mock/gomock"
+	"go.uber.org/zap"
+	"testing"
+	"time"
+)
+
+// describedPublisher is a publisher of a client that describes its connection.
+type describedPublisher struct {
+	*mocks.MockPublisher
+	*mocks.MockDescribed
+}
+
+func TestAdmin(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	connectedAt := time.Date(2024, 10, 1, 12, 0, 0, 0, time.UTC)
+	alice := describedPublisher{mocks.NewMockPublisher(ctrl), mocks.NewMockDescribed(ctrl)}
+	alice.MockPublisher.EXPECT().GetID().AnyTimes().Return("alice")
+	alice.MockDescribed.EXPECT().Describe().AnyTimes().Return(app.ConnectionInfo{
+		ID:          "alice",
+		RemoteAddr:  "10.0.0.5:53122",
+		ConnectedAt: connectedAt,
+		Messages:    3,
+		Bytes:       300,
+		PingRTT:     time.Millisecond,
+	})
+	subscriber := mocks.NewMockSubscriber(ctrl)
+	subscriber.EXPECT().GetID().AnyTimes().Return("alice")
+	peer := mocks.NewMockPeerSubscriber(ctrl)
+	peer.EXPECT().GetID().AnyTimes().Return("node-2")
+	peer.EXPECT().HasDemand().AnyTimes().Return(false)
+
+	newAdmin := func(g *WithT, drain func()) *app.Admin {
+		publisherPool := app.NewPublisherPool()
+		subscriberPool := app.NewSubscriberPool()
+		g.Expect(publisherPool.Add(alice)).To(Succeed())
+		g.Expect(subscriberPool.Add(subscriber)).To(Succeed())
+		g.Expect(subscriberPool.Add(peer)).To(Succeed())
+		observer := app.NewObserver(publisherPool, subscriberPool, nil, nil, nil, nil, nil, nil, zap.NewNop())
+		return app.NewAdmin(publisherPool, subscriberPool, observer, drain, zap.NewNop())
+	}
+
+	t.Run("Lists connections", func(t *testing.T) {
+		g := NewWithT(t)
+
+		admin := newAdmin(g, func() {})
+		g.Expect(admin.Publishers()).To(Equal([]app.ConnectionInfo{{
+			ID:          "alice",
+			RemoteAddr:  "10.0.0.5:53122",
+			ConnectedAt: connectedAt,
+			Messages:    3,
+			Bytes:       300,
+			PingRTT:     time.Millisecond,
+		}}))
+		g.Expect(admin.Subscribers()).To(Equal([]app.ConnectionInfo{
+			{ID: "alice", HasDemand: true}, // Described by its ID only
+			{ID: "node-2", HasDemand: false},
+		}))
+		g.Expect(admin.SubscriberExistence()).To(Equal(map[string]bool{app.DefaultTenant: true}))
+	})
+
+	t.Run("Disconnects both roles of a connection", func(t *testing.T) {
+		g := NewWithT(t)
+
+		alice.MockPublisher.EXPECT().Disconnect().Times(1)
+		subscriber.EXPECT().Disconnect().Times(1)
+		admin := newAdmin(g, func() {})
+		g.Expect(admin.Disconnect("alice")).To(Succeed())
+		g.Expect(admin.Disconnect("bob")).To(MatchError(app.ErrConnectionNotFound))
+	})
+
+	t.Run("Pauses and resumes publishers", func(t *testing.T) {
+		g := NewWithT(t)
+
+		gomock.InOrder(
+			alice.MockPublisher.EXPECT().Pause(),
+			alice.MockPublisher.EXPECT().Resume().Return(errors.New("stream closed")),
+		)
+		admin := newAdmin(g, func() {})
+		g.Expect(admin.PausePublisher("alice")).To(Succeed())
+		g.Expect(admin.ResumePublisher("alice")).To(MatchError(ContainSubstring("stream closed")))
+		g.Expect(admin.PausePublisher("node-2")).To(MatchError(app.ErrConnectionNotFound), "not a publisher")
+	})
+
+	t.Run("Drains", func(t *testing.T) {
+		g := NewWithT(t)
+
+		drained := 0
+		admin := newAdmin(g, func() { drained++ })
+		g.Expect(admin.Draining()).To(BeFalse())
+		admin.Drain()
+		admin.Drain()
+		g.Expect(admin.Draining()).To(BeTrue())
+		g.Expect(drained).To(Equal(2))
+	})
+}
@@ -0,0 +1,111 @@
+package app
+
+import (
+	"github.com/pkg/errors"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrConnectionLimit is returned by Admission.Admit when the server is at its limit of live connections.
+	ErrConnectionLimit = errors.New("the server is at its connection limit")
+
+	// ErrConnectionLimitPerIP is returned by Admission.Admit when the IP address is at its limit of live connections.
+	ErrConnectionLimitPerIP = errors.New("too many connections from this address")
+
+	// ErrHandshakeRateLimit is returned by Admission.Admit when clients connect faster than the handshake rate.
+	ErrHandshakeRateLimit = errors.New("too many connection attempts, try again later")
+)
+
+// AdmissionConfig configures an Admission.
+type AdmissionConfig struct {
+	MaxConnections      int     // Max number of live connections
+	MaxConnectionsPerIP int     // Max number of live connections from a single IP address, unlimited if 0
+	HandshakeRate       float64 // Handshakes per second, unlimited if 0
+	HandshakeBurst      int     // Number of handshakes allowed at once before HandshakeRate applies
+}
+
+// Admission decides whether to accept new connections. It counts live connections in total and per IP address,
+// and limits the rate of handshakes with a token bucket, which holds HandshakeBurst tokens and is refilled at
+// HandshakeRate tokens per second. Connections also count towards the connections quota of their tenant.
+type Admission struct {
+	config      AdmissionConfig
+	tenants     *Tenants // Nil if tenants have no quotas
+	mu          sync.Mutex
+	connections int
+	perIP       map[string]int
+	handshakes  tokenBucket
+}
+
+// NewAdmission is the constructor for Admission. tenants is optional.
+func NewAdmission(config AdmissionConfig, tenants *Tenants) *Admission {
+	return &Admission{
+		config:     config,
+		tenants:    tenants,
+		perIP:      make(map[string]int),
+		handshakes: newTokenBucket(config.HandshakeRate, float64(config.HandshakeBurst)),
+	}
+}
+
+// Admit is called once a client of the tenant at ip has completed a handshake at now, and returns an error if
+// the connection must be refused, ErrUnknownTenant or a QuotaError among others. Otherwise the connection counts
+// towards the limits until Release is called.
+func (a *Admission) Admit(ip string, tenant string, now time.Time) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	// Refused connections take a token too, as the handshake has cost as much as an admitted one
+	if a.config.HandshakeRate > 0 {
+		if a.handshakes.wait(1, now) > 0 {
+			return ErrHandshakeRateLimit
+		}
+		a.handshakes.take(1)
+	}
+	if a.connections >= a.config.MaxConnections {
+		return ErrConnectionLimit
+	}
+	if a.config.MaxConnectionsPerIP > 0 && a.perIP[ip] >= a.config.MaxConnectionsPerIP {
+		return ErrConnectionLimitPerIP
+	}
+	if a.tenants != nil {
+		if err := a.tenants.Connect(tenant); err != nil {
+			return err
+		}
+	}
+
+	a.connections++
+	a.perIP[ip]++
+	return nil
+}
+
+// Release is called once an admitted connection of the tenant from ip is closed.
+func (a *Admission) Release(ip string, tenant string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.tenants != nil {
+		a.tenants.Disconnect(tenant)
+	}
+	a.connections--
+	if a.perIP[ip]--; a.perIP[ip] <= 0 {
+		delete(a.perIP, ip)
+	}
+}
+
+// UnderLoad returns whether clients are connecting at least as fast as the handshake rate allows, in which case
+// new clients should have to prove their address before their handshake is processed.
+func (a *Admission) UnderLoad(now time.Time) bool {
+	if a.config.HandshakeRate <= 0 {
+		return false
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.handshakes.available(now) < 1
+}
+
+// Connections returns the number of live connections.
+func (a *Admission) Connections() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.connections
+}
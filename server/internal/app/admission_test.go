@@ -0,0 +1,77 @@
+package app_test
+
+import (
+	. "github.com/onsi/gomega"
+	"github.com/varfrog/quicpubsub/server/internal/app"
+	"testing"
+	"time"
+)
+
+func TestAdmission_ConnectionLimit(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	now := time.Now()
+	admission := app.NewAdmission(app.AdmissionConfig{MaxConnections: 2}, nil)
+
+	g.Expect(admission.Admit("10.0.0.1", app.DefaultTenant, now)).To(Succeed())
+	g.Expect(admission.Admit("10.0.0.2", app.DefaultTenant, now)).To(Succeed())
+	g.Expect(admission.Admit("10.0.0.3", app.DefaultTenant, now)).To(MatchError(app.ErrConnectionLimit))
+	g.Expect(admission.Connections()).To(Equal(2))
+
+	admission.Release("10.0.0.1", app.DefaultTenant)
+	g.Expect(admission.Admit("10.0.0.3", app.DefaultTenant, now)).To(Succeed())
+}
+
+func TestAdmission_ConnectionLimitPerIP(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	now := time.Now()
+	admission := app.NewAdmission(app.AdmissionConfig{MaxConnections: 10, MaxConnectionsPerIP: 2}, nil)
+
+	g.Expect(admission.Admit("10.0.0.1", app.DefaultTenant, now)).To(Succeed())
+	g.Expect(admission.Admit("10.0.0.1", app.DefaultTenant, now)).To(Succeed())
+	g.Expect(admission.Admit("10.0.0.1", app.DefaultTenant, now)).To(MatchError(app.ErrConnectionLimitPerIP))
+	g.Expect(admission.Admit("10.0.0.2", app.DefaultTenant, now)).To(Succeed()) // Other addresses are not affected
+
+	admission.Release("10.0.0.1", app.DefaultTenant)
+	g.Expect(admission.Admit("10.0.0.1", app.DefaultTenant, now)).To(Succeed())
+}
+
+func TestAdmission_HandshakeRateLimit(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	now := time.Now()
+	admission := app.NewAdmission(app.AdmissionConfig{MaxConnections: 100, HandshakeRate: 10, HandshakeBurst: 3}, nil)
+
+	// The burst is admitted at once
+	for i := 0; i < 3; i++ {
+		g.Expect(admission.Admit("10.0.0.1", app.DefaultTenant, now)).To(Succeed())
+	}
+	g.Expect(admission.UnderLoad(now)).To(BeTrue())
+	g.Expect(admission.Admit("10.0.0.1", app.DefaultTenant, now)).To(MatchError(app.ErrHandshakeRateLimit))
+
+	// One token is earned every 100ms
+	g.Expect(admission.Admit("10.0.0.1", app.DefaultTenant, now.Add(time.Millisecond*50))).To(MatchError(app.ErrHandshakeRateLimit))
+	g.Expect(admission.Admit("10.0.0.1", app.DefaultTenant, now.Add(time.Millisecond*150))).To(Succeed())
+	g.Expect(admission.Admit("10.0.0.1", app.DefaultTenant, now.Add(time.Millisecond*150))).To(MatchError(app.ErrHandshakeRateLimit))
+
+	// Tokens are earned up to the burst only
+	later := now.Add(time.Hour)
+	g.Expect(admission.UnderLoad(later)).To(BeFalse())
+	for i := 0; i < 3; i++ {
+		g.Expect(admission.Admit("10.0.0.1", app.DefaultTenant, later)).To(Succeed())
+	}
+	g.Expect(admission.Admit("10.0.0.1", app.DefaultTenant, later)).To(MatchError(app.ErrHandshakeRateLimit))
+}
+
+func TestAdmission_RefusedHandshakesTakeTokens(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	now := time.Now()
+	admission := app.NewAdmission(app.AdmissionConfig{MaxConnections: 1, HandshakeRate: 1, HandshakeBurst: 2}, nil)
+
+	g.Expect(admission.Admit("10.0.0.1", app.DefaultTenant, now)).To(Succeed())
+	g.Expect(admission.Admit("10.0.0.2", app.DefaultTenant, now)).To(MatchError(app.ErrConnectionLimit))
+	admission.Release("10.0.0.1", app.DefaultTenant)
+	g.Expect(admission.Admit("10.0.0.1", app.DefaultTenant, now)).To(MatchError(app.ErrHandshakeRateLimit))
+}
@@ -0,0 +1,49 @@
+package app
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/varfrog/quicpubsub/pkg/auth"
+	"sync/atomic"
+	"time"
+)
+
+// ErrUnauthenticated is returned by Authenticator.Authenticate when a client must be refused, wrapped with why.
+var ErrUnauthenticated = errors.New("unauthenticated")
+
+// Authenticator authenticates clients by the bearer tokens they present, see package auth. Its keys can be replaced
+// while clients are being authenticated, so that they can be rotated without a restart.
+type Authenticator struct {
+	keys atomic.Pointer[auth.Keys]
+}
+
+// NewAuthenticator is the constructor for Authenticator.
+func NewAuthenticator(keys auth.Keys) *Authenticator {
+	a := &Authenticator{}
+	a.SetKeys(keys)
+	return a
+}
+
+// SetKeys replaces the keys tokens are verified with. Tokens signed with removed keys are refused from now on,
+// while clients that have already been authenticated stay connected.
+func (a *Authenticator) SetKeys(keys auth.Keys) {
+	a.keys.Store(&keys)
+}
+
+// Authenticate returns the claims of the token of a client if it is valid at now and allows all the roles the client
+// connects as. Otherwise it returns an error wrapping ErrUnauthenticated, and the error of auth.Verify if any.
+func (a *Authenticator) Authenticate(token string, roles []string, now time.Time) (auth.Claims, error) {
+	if token == "" {
+		return auth.Claims{}, fmt.Errorf("%w: no token", ErrUnauthenticated)
+	}
+	claims, err := auth.Verify(token, *a.keys.Load(), now)
+	if err != nil {
+		return auth.Claims{}, fmt.Errorf("%w: %w", ErrUnauthenticated, err)
+	}
+	for _, role := range roles {
+		if !claims.HasRole(role) {
+			return auth.Claims{}, fmt.Errorf("%w: token does not allow role '%s'", ErrUnauthenticated, role)
+		}
+	}
+	return claims, nil
+}
@@ -0,0 +1,47 @@
+package app_test
+
+import (
+	. "github.com/onsi/gomega"
+	"github.com/varfrog/quicpubsub/pkg/auth"
+	"github.com/varfrog/quicpubsub/server/internal/app"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAuthenticator(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	now := time.Now()
+	oldKeys := auth.Keys{"old": []byte(strings.Repeat("a", 32))}
+	newKeys := auth.Keys{"new": []byte(strings.Repeat("b", 32))}
+	sign := func(keys auth.Keys, keyID string, roles ...string) string {
+		token, err := auth.Sign(auth.Claims{KeyID: keyID, Name: "script", Roles: roles, ExpiresAt: now.Add(time.Minute).Unix()}, keys)
+		g.Expect(err).NotTo(HaveOccurred())
+		return token
+	}
+	authenticator := app.NewAuthenticator(oldKeys)
+
+	claims, err := authenticator.Authenticate(sign(oldKeys, "old", auth.RolePublish), []string{auth.RolePublish}, now)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(claims.Name).To(Equal("script"))
+
+	// Every role the client connects as must be allowed
+	_, err = authenticator.Authenticate(sign(oldKeys, "old", auth.RolePublish),
+		[]string{auth.RolePublish, auth.RoleSubscribe}, now)
+	g.Expect(err).To(MatchError(app.ErrUnauthenticated))
+	g.Expect(err.Error()).To(ContainSubstring("role 'subscribe'"))
+
+	_, err = authenticator.Authenticate("", []string{auth.RolePublish}, now)
+	g.Expect(err).To(MatchError(app.ErrUnauthenticated))
+	_, err = authenticator.Authenticate(sign(oldKeys, "old", auth.RolePublish), []string{auth.RolePublish}, now.Add(time.Hour))
+	g.Expect(err).To(MatchError(app.ErrUnauthenticated))
+	g.Expect(err).To(MatchError(auth.ErrExpired))
+
+	// Rotated keys apply right away
+	authenticator.SetKeys(newKeys)
+	_, err = authenticator.Authenticate(sign(oldKeys, "old", auth.RolePublish), []string{auth.RolePublish}, now)
+	g.Expect(err).To(MatchError(auth.ErrUnknownKey))
+	_, err = authenticator.Authenticate(sign(newKeys, "new", auth.RoleSubscribe), []string{auth.RoleSubscribe}, now)
+	g.Expect(err).NotTo(HaveOccurred())
+}
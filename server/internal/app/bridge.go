@@ -0,0 +1,142 @@
+package app
+
+import (
+	"fmt"
+	"github.com/varfrog/quicpubsub/pkg/sdk"
+	"strings"
+	"sync"
+)
+
+// BridgeDirection selects which messages a Bridge forwards.
+type BridgeDirection string
+
+const (
+	BridgeDirectionIn   BridgeDirection = "in"   // From the remote server to this one
+	BridgeDirectionOut  BridgeDirection = "out"  // From this server to the remote one
+	BridgeDirectionBoth BridgeDirection = "both" // Both ways
+)
+
+// ParseBridgeDirection parses "in", "out" or "both".
+func ParseBridgeDirection(direction string) (BridgeDirection, error) {
+	switch d := BridgeDirection(direction); d {
+	case BridgeDirectionIn, BridgeDirectionOut, BridgeDirectionBoth:
+		return d, nil
+	default:
+		return "", fmt.Errorf("unknown bridge direction '%s', expected one of in, out, both", direction)
+	}
+}
+
+type BridgeConfig struct {
+	LocalBrokerID string          // ID of this server
+	Direction     BridgeDirection // Which messages to forward
+	MaxHops       int             // Max number of servers a message is bridged through
+}
+
+// Bridge decides which messages to forward between this server and a remote server, and stamps them with the
+// sdk.HeaderOrigin and sdk.HeaderVia headers, which prevent messages from looping between bridged servers.
+//
+// A message forwarded to the remote server gets the ID of this server appended to sdk.HeaderVia, and a message
+// forwarded from the remote server gets the ID of the remote server appended. Messages are not forwarded to
+// servers they have been bridged through, nor through more than MaxHops servers.
+type Bridge struct {
+	config         BridgeConfig
+	mu             sync.RWMutex
+	remoteBrokerID string // Guarded by mu
+}
+
+// NewBridge is the constructor for Bridge.
+func NewBridge(config BridgeConfig) *Bridge {
+	return &Bridge{config: config}
+}
+
+// SetRemoteBrokerID sets the ID of the remote server once it is known.
+func (s *Bridge) SetRemoteBrokerID(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.remoteBrokerID = id
+}
+
+// ForwardsOut returns whether the bridge forwards messages to the remote server.
+func (s *Bridge) ForwardsOut() bool {
+	return s.config.Direction == BridgeDirectionOut || s.config.Direction == BridgeDirectionBoth
+}
+
+// ForwardsIn returns whether the bridge forwards messages from the remote server.
+func (s *Bridge) ForwardsIn() bool {
+	return s.config.Direction == BridgeDirectionIn || s.config.Direction == BridgeDirectionBoth
+}
+
+// ForwardOut returns the message to send to the remote server, and false if the message must not be sent.
+func (s *Bridge) ForwardOut(message sdk.Message) (sdk.Message, bool) {
+	remoteBrokerID := s.RemoteBrokerID()
+	if !s.ForwardsOut() || remoteBrokerID == "" {
+		return sdk.Message{}, false
+	}
+
+	via := parseVia(message)
+	if len(via) >= s.config.MaxHops || message.Headers[sdk.HeaderOrigin] == remoteBrokerID || contains(via, remoteBrokerID) {
+		return sdk.Message{}, false
+	}
+
+	return stamp(message, s.config.LocalBrokerID, append(via, s.config.LocalBrokerID)), true
+}
+
+// ForwardIn returns the message to pass to the subscribers of this server, and false if the message must be dropped,
+// e.g. because it has come back to where it was published.
+func (s *Bridge) ForwardIn(message sdk.Message) (sdk.Message, bool) {
+	remoteBrokerID := s.RemoteBrokerID()
+	if !s.ForwardsIn() || remoteBrokerID == "" {
+		return sdk.Message{}, false
+	}
+
+	via := parseVia(message)
+	if len(via) >= s.config.MaxHops || message.Headers[sdk.HeaderOrigin] == s.config.LocalBrokerID || contains(via, s.config.LocalBrokerID) {
+		return sdk.Message{}, false
+	}
+
+	return stamp(message, remoteBrokerID, append(via, remoteBrokerID)), true
+}
+
+// LocalBrokerID returns the ID of this server.
+func (s *Bridge) LocalBrokerID() string {
+	return s.config.LocalBrokerID
+}
+
+// RemoteBrokerID returns the ID of the remote server, empty until it is known.
+func (s *Bridge) RemoteBrokerID() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.remoteBrokerID
+}
+
+// parseVia returns the IDs of the servers the message has been bridged through.
+func parseVia(message sdk.Message) []string {
+	via := message.Headers[sdk.HeaderVia]
+	if via == "" {
+		return nil
+	}
+	return strings.Split(via, ",")
+}
+
+// stamp returns a copy of the message with the given via header, and with origin unless the message has one.
+func stamp(message sdk.Message, origin string, via []string) sdk.Message {
+	headers := make(map[string]string, len(message.Headers)+2)
+	for k, v := range message.Headers {
+		headers[k] = v
+	}
+	if headers[sdk.HeaderOrigin] == "" {
+		headers[sdk.HeaderOrigin] = origin
+	}
+	headers[sdk.HeaderVia] = strings.Join(via, ",")
+
+	return sdk.Message{Headers: headers, Payload: message.Payload}
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,112 @@
+package app_test
+
+import (
+	. "github.com/onsi/gomega"
+	"github.com/varfrog/quicpubsub/pkg/sdk"
+	"github.com/varfrog/quicpubsub/server/internal/app"
+	"testing"
+)
+
+func newBridge(localBrokerID, remoteBrokerID string, direction app.BridgeDirection) *app.Bridge {
+	bridge := app.NewBridge(app.BridgeConfig{LocalBrokerID: localBrokerID, Direction: direction, MaxHops: 3})
+	bridge.SetRemoteBrokerID(remoteBrokerID)
+	return bridge
+}
+
+func TestBridge_ForwardOut(t *testing.T) {
+	t.Run("Stamps local messages", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		message, ok := newBridge("A", "B", app.BridgeDirectionBoth).ForwardOut(sdk.Message{Payload: []byte("foo")})
+		g.Expect(ok).To(BeTrue())
+		g.Expect(message.Headers).To(Equal(map[string]string{sdk.HeaderOrigin: "A", sdk.HeaderVia: "A"}))
+		g.Expect(message.Payload).To(Equal([]byte("foo")))
+	})
+
+	t.Run("Keeps the origin and appends to via", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		message, ok := newBridge("A", "B", app.BridgeDirectionOut).ForwardOut(sdk.Message{
+			Headers: map[string]string{sdk.HeaderOrigin: "C", sdk.HeaderVia: "C"},
+		})
+		g.Expect(ok).To(BeTrue())
+		g.Expect(message.Headers).To(Equal(map[string]string{sdk.HeaderOrigin: "C", sdk.HeaderVia: "C,A"}))
+	})
+
+	t.Run("Does not send messages back to where they came from", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		bridge := newBridge("A", "B", app.BridgeDirectionBoth)
+
+		_, ok := bridge.ForwardOut(sdk.Message{Headers: map[string]string{sdk.HeaderOrigin: "B", sdk.HeaderVia: "B"}})
+		g.Expect(ok).To(BeFalse())
+
+		_, ok = bridge.ForwardOut(sdk.Message{Headers: map[string]string{sdk.HeaderOrigin: "C", sdk.HeaderVia: "C,B"}})
+		g.Expect(ok).To(BeFalse())
+	})
+
+	t.Run("Drops messages over the hop limit", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		_, ok := newBridge("A", "B", app.BridgeDirectionBoth).ForwardOut(sdk.Message{
+			Headers: map[string]string{sdk.HeaderOrigin: "C", sdk.HeaderVia: "C,D,E"},
+		})
+		g.Expect(ok).To(BeFalse())
+	})
+
+	t.Run("Does not forward in direction in", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		_, ok := newBridge("A", "B", app.BridgeDirectionIn).ForwardOut(sdk.Message{Payload: []byte("foo")})
+		g.Expect(ok).To(BeFalse())
+	})
+
+	t.Run("Does not forward until the remote server is known", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		_, ok := newBridge("A", "", app.BridgeDirectionBoth).ForwardOut(sdk.Message{Payload: []byte("foo")})
+		g.Expect(ok).To(BeFalse())
+	})
+}
+
+func TestBridge_ForwardIn(t *testing.T) {
+	t.Run("Stamps remote messages", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		message, ok := newBridge("A", "B", app.BridgeDirectionBoth).ForwardIn(sdk.Message{Payload: []byte("foo")})
+		g.Expect(ok).To(BeTrue())
+		g.Expect(message.Headers).To(Equal(map[string]string{sdk.HeaderOrigin: "B", sdk.HeaderVia: "B"}))
+	})
+
+	t.Run("Drops messages that come back", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		bridge := newBridge("A", "B", app.BridgeDirectionBoth)
+
+		// Sent to B and echoed back by B
+		_, ok := bridge.ForwardIn(sdk.Message{Headers: map[string]string{sdk.HeaderOrigin: "A", sdk.HeaderVia: "A"}})
+		g.Expect(ok).To(BeFalse())
+
+		// Came through A on its way elsewhere
+		_, ok = bridge.ForwardIn(sdk.Message{Headers: map[string]string{sdk.HeaderOrigin: "C", sdk.HeaderVia: "C,A"}})
+		g.Expect(ok).To(BeFalse())
+	})
+
+	t.Run("Does not forward in direction out", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		_, ok := newBridge("A", "B", app.BridgeDirectionOut).ForwardIn(sdk.Message{Payload: []byte("foo")})
+		g.Expect(ok).To(BeFalse())
+	})
+}
+
+func TestParseBridgeDirection(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	direction, err := app.ParseBridgeDirection("both")
+	g.Expect(err).To(BeNil())
+	g.Expect(direction).To(Equal(app.BridgeDirectionBoth))
+
+	_, err = app.ParseBridgeDirection("sideways")
+	g.Expect(err).To(HaveOccurred())
+}
@@ -0,0 +1,216 @@
+package app
+
+import (
+	"go.uber.org/zap"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+)
+
+// NodeState is what a server of a cluster reports about itself to the others.
+type NodeState struct {
+	NodeID      string   `json:"node_id"`
+	Publishers  []string `json:"publishers,omitempty"`  // IDs of the publishers connected to the server
+	Subscribers []string `json:"subscribers,omitempty"` // IDs of the subscribers connected to the server
+	HasDemand   bool     `json:"has_demand"`            // Whether any of the subscribers want messages
+}
+
+// ClusterView is the state of all servers of a cluster, which the leader builds and replicates to the others.
+type ClusterView struct {
+	Leader  string               `json:"leader"`
+	Term    uint64               `json:"term"`    // Increases with every election, views of a newer term win
+	Version uint64               `json:"version"` // Increases with every change, also across leaders and terms
+	Nodes   map[string]NodeState `json:"nodes"`   // By node ID, the alive servers only
+}
+
+type ClusterConfig struct {
+	NodeID           string        // ID of this server, its cluster address
+	PeerIDs          []string      // IDs of the other servers
+	HeartbeatTimeout time.Duration // A server which has not sent a heartbeat for this long is considered dead
+}
+
+// Cluster keeps track of which servers of a cluster are alive, elects the leader, and replicates the cluster view
+// primary-backup style: every server sends its NodeState to all others with its heartbeats, the leader merges them
+// into a ClusterView and replicates the view to the others, which adopt it. The leader is the alive server with
+// the lowest ID, so a new leader is elected as soon as the current one stops sending heartbeats, and it carries
+// on from its replica of the view.
+//
+// Every leader starts a new term, above any term it has seen, so that its views replace those of the previous
+// leaders even if it has restarted and lost its own. Without a quorum, each side of a partition elects its own leader,
+// and once the partition heals the leader of the higher term carries on until the lowest ID server takes over again.
+// Messages are not replicated.
+type Cluster struct {
+	config      ClusterConfig
+	mu          sync.Mutex
+	lastSeen    map[string]time.Time // Heartbeat times by node ID
+	reported    map[string]NodeState // Latest states reported by other servers, by node ID
+	alive       map[string]bool      // As of the last Update
+	leader      string
+	view        ClusterView
+	seenTerm    uint64 // Highest term of the views this server has built or received
+	seenVersion uint64 // Highest version of the views this server has built or received
+	contested   bool   // Whether another leader has claimed the term of the view, see see
+	logger      *zap.Logger
+}
+
+// NewCluster is the constructor for Cluster.
+func NewCluster(config ClusterConfig, logger *zap.Logger) *Cluster {
+	return &Cluster{
+		config:   config,
+		lastSeen: make(map[string]time.Time),
+		reported: make(map[string]NodeState),
+		alive:    map[string]bool{config.NodeID: true},
+		leader:   config.NodeID,
+		view:     ClusterView{Nodes: map[string]NodeState{}},
+		logger:   logger,
+	}
+}
+
+// OnHeartbeat records a heartbeat from another server with the state it reports. Returns whether the state
+// differs from the one reported before.
+func (c *Cluster) OnHeartbeat(state NodeState, now time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.lastSeen[state.NodeID] = now
+	changed := !reflect.DeepEqual(c.reported[state.NodeID], state)
+	c.reported[state.NodeID] = state
+	return changed
+}
+
+// OnView adopts a view replicated by the leader, unless it comes from a server this one does not consider the
+// leader, or it is older than the current view, by term first and by version second. Returns whether the view was
+// adopted.
+func (c *Cluster) OnView(view ClusterView) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.see(view)
+	if view.Leader != c.leader || view.Leader == c.config.NodeID {
+		return false
+	}
+	if view.Term < c.view.Term || view.Term == c.view.Term && view.Version <= c.view.Version {
+		return false
+	}
+	c.view = view
+	return true
+}
+
+// OnSeen records the term and leader of the view another server holds, and the highest version it has seen, which it
+// sends with its heartbeats. Once elected, this server starts a term above those it has seen, or above its own if
+// another leader has claimed the same term, e.g. after a restart or a partition.
+func (c *Cluster) OnSeen(leader string, term, version uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.see(ClusterView{Leader: leader, Term: term, Version: version})
+}
+
+// Seen returns the term and leader of the view this server holds, and the highest version it has seen.
+func (c *Cluster) Seen() (leader string, term, version uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.view.Leader, c.view.Term, c.seenVersion
+}
+
+// Update re-elects the leader based on the servers that are alive at now. If this server is the leader, it
+// rebuilds the view from its own state and the states the others have reported. Returns the current view.
+func (c *Cluster) Update(local NodeState, now time.Time) ClusterView {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.alive = map[string]bool{c.config.NodeID: true}
+	for _, id := range c.config.PeerIDs {
+		if lastSeen, ok := c.lastSeen[id]; ok && now.Sub(lastSeen) <= c.config.HeartbeatTimeout {
+			c.alive[id] = true
+		}
+	}
+
+	leader := c.config.NodeID
+	for id := range c.alive {
+		if id < leader {
+			leader = id
+		}
+	}
+	if leader != c.leader {
+		c.logger.Info("Leader changed", zap.String("leader", leader), zap.Strings("alive", c.aliveIDs()))
+		c.leader = leader
+	}
+
+	if leader == c.config.NodeID {
+		nodes := map[string]NodeState{c.config.NodeID: local}
+		for id := range c.alive {
+			if id == c.config.NodeID {
+				continue
+			}
+			if state, ok := c.reported[id]; ok {
+				nodes[id] = state
+			} else if state, ok := c.view.Nodes[id]; ok {
+				nodes[id] = state // From the replica of the previous leader's view
+			}
+		}
+		switch {
+		case c.view.Leader != leader || c.seenTerm > c.view.Term || c.contested:
+			// A new term, as this server has just been elected or another server has led since
+			c.view = ClusterView{Leader: leader, Term: c.seenTerm + 1, Version: c.seenVersion + 1, Nodes: nodes}
+			c.contested = false
+			c.logger.Info("Leading a new term", zap.Uint64("term", c.view.Term))
+		case !reflect.DeepEqual(c.view.Nodes, nodes):
+			c.view = ClusterView{Leader: leader, Term: c.view.Term, Version: c.seenVersion + 1, Nodes: nodes}
+		}
+		c.see(c.view)
+	}
+
+	return c.view
+}
+
+// Leader returns the ID of the leader, as of the last Update.
+func (c *Cluster) Leader() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.leader
+}
+
+// IsLeader returns whether this server is the leader, as of the last Update.
+func (c *Cluster) IsLeader() bool {
+	return c.Leader() == c.config.NodeID
+}
+
+// NodeHasDemand returns whether the given server is alive and, according to the view, has subscribers that want
+// messages.
+func (c *Cluster) NodeHasDemand(nodeID string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.alive[nodeID] && c.view.Nodes[nodeID].HasDemand
+}
+
+// View returns the current view.
+func (c *Cluster) View() ClusterView {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.view
+}
+
+// see records the term and version of a view, and whether its leader claims the term of the view of this server,
+// c.mu must be held.
+func (c *Cluster) see(view ClusterView) {
+	if view.Term == c.view.Term && view.Leader != "" && view.Leader != c.view.Leader {
+		c.contested = true
+	}
+	if view.Term > c.seenTerm {
+		c.seenTerm = view.Term
+	}
+	if view.Version > c.seenVersion {
+		c.seenVersion = view.Version
+	}
+}
+
+// aliveIDs returns the sorted IDs of alive servers, c.mu must be held.
+func (c *Cluster) aliveIDs() []string {
+	ids := make([]string, 0, len(c.alive))
+	for id := range c.alive {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
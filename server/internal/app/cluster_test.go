@@ -0,0 +1,143 @@
+package app_test
+
+import (
+	. "github.com/onsi/gomega"
+	"github.com/varfrog/quicpubsub/server/internal/app"
+	"go.uber.org/zap"
+	"testing"
+	"time"
+)
+
+func newCluster(nodeID string, peerIDs ...string) *app.Cluster {
+	return app.NewCluster(
+		app.ClusterConfig{NodeID: nodeID, PeerIDs: peerIDs, HeartbeatTimeout: time.Second},
+		zap.NewNop())
+}
+
+func TestCluster_ElectsLowestAliveNode(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	now := time.Now()
+	cluster := newCluster("b", "a", "c")
+
+	// Alone until heartbeats arrive
+	cluster.Update(app.NodeState{NodeID: "b"}, now)
+	g.Expect(cluster.IsLeader()).To(BeTrue())
+
+	cluster.OnHeartbeat(app.NodeState{NodeID: "a"}, now)
+	cluster.OnHeartbeat(app.NodeState{NodeID: "c"}, now)
+	cluster.Update(app.NodeState{NodeID: "b"}, now)
+	g.Expect(cluster.Leader()).To(Equal("a"))
+
+	// "a" stops sending heartbeats
+	cluster.OnHeartbeat(app.NodeState{NodeID: "c"}, now.Add(time.Second))
+	cluster.Update(app.NodeState{NodeID: "b"}, now.Add(time.Second*2))
+	g.Expect(cluster.Leader()).To(Equal("b"))
+}
+
+func TestCluster_LeaderReplicatesView(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	now := time.Now()
+	leader := newCluster("a", "b")
+	follower := newCluster("b", "a")
+
+	// Exchange heartbeats
+	leader.OnHeartbeat(app.NodeState{NodeID: "b", Subscribers: []string{"s1"}, HasDemand: true}, now)
+	follower.OnHeartbeat(app.NodeState{NodeID: "a", Publishers: []string{"p1"}}, now)
+	follower.Update(app.NodeState{NodeID: "b", Subscribers: []string{"s1"}, HasDemand: true}, now)
+
+	view := leader.Update(app.NodeState{NodeID: "a", Publishers: []string{"p1"}}, now)
+	g.Expect(view.Leader).To(Equal("a"))
+	g.Expect(view.Nodes).To(HaveLen(2))
+	g.Expect(leader.NodeHasDemand("b")).To(BeTrue())
+
+	// The follower adopts the view
+	g.Expect(follower.OnView(view)).To(BeTrue())
+	g.Expect(follower.View()).To(Equal(view))
+	g.Expect(follower.NodeHasDemand("a")).To(BeFalse())
+
+	// An unchanged state does not bump the version, an old view is not adopted again
+	g.Expect(leader.Update(app.NodeState{NodeID: "a", Publishers: []string{"p1"}}, now).Version).To(Equal(view.Version))
+	g.Expect(follower.OnView(view)).To(BeFalse())
+
+	// Once the leader is gone, the follower takes over from its replica
+	newView := follower.Update(app.NodeState{NodeID: "b"}, now.Add(time.Second*2))
+	g.Expect(newView.Leader).To(Equal("b"))
+	g.Expect(newView.Version).To(BeNumerically(">", view.Version))
+	g.Expect(newView.Nodes).To(HaveKey("b"))
+	g.Expect(newView.Nodes).ToNot(HaveKey("a"))
+}
+
+func TestCluster_IgnoresViewsFromNonLeaders(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	now := time.Now()
+	cluster := newCluster("c", "a", "b")
+	cluster.OnHeartbeat(app.NodeState{NodeID: "a"}, now)
+	cluster.OnHeartbeat(app.NodeState{NodeID: "b"}, now)
+	cluster.Update(app.NodeState{NodeID: "c"}, now)
+
+	g.Expect(cluster.OnView(app.ClusterView{Leader: "b", Version: 5})).To(BeFalse())
+	g.Expect(cluster.OnView(app.ClusterView{Leader: "a", Version: 5})).To(BeTrue())
+}
+
+func TestCluster_NewLeaderStartsNewTerm(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	now := time.Now()
+	leader := newCluster("a", "b")
+	follower := newCluster("b", "a")
+	leader.OnHeartbeat(app.NodeState{NodeID: "b"}, now)
+	follower.OnHeartbeat(app.NodeState{NodeID: "a"}, now)
+	follower.Update(app.NodeState{NodeID: "b"}, now)
+
+	// The follower adopts a few versions of the leader's view
+	var view app.ClusterView
+	for _, publisher := range []string{"p1", "p2", "p3"} {
+		view = leader.Update(app.NodeState{NodeID: "a", Publishers: []string{publisher}}, now)
+		g.Expect(follower.OnView(view)).To(BeTrue())
+	}
+	g.Expect(view.Term).To(Equal(uint64(1)))
+
+	// The leader dies and the follower takes over in a new term
+	followerView := follower.Update(app.NodeState{NodeID: "b"}, now.Add(time.Second*2))
+	g.Expect(followerView.Term).To(Equal(uint64(2)))
+	g.Expect(followerView.Version).To(Equal(view.Version + 1))
+
+	// The leader restarts and starts over, its views are older than those of the follower
+	restarted := newCluster("a", "b")
+	restartedView := restarted.Update(app.NodeState{NodeID: "a"}, now.Add(time.Second*3))
+	follower.OnHeartbeat(app.NodeState{NodeID: "a"}, now.Add(time.Second*3))
+	follower.Update(app.NodeState{NodeID: "b"}, now.Add(time.Second*3))
+	g.Expect(follower.Leader()).To(Equal("a"))
+	g.Expect(follower.OnView(restartedView)).To(BeFalse())
+
+	// Until it learns what the follower has seen with its heartbeat, and starts a new term above it
+	restarted.OnHeartbeat(app.NodeState{NodeID: "b"}, now.Add(time.Second*3))
+	restarted.OnSeen(follower.Seen())
+	newView := restarted.Update(app.NodeState{NodeID: "a"}, now.Add(time.Second*3))
+	g.Expect(newView.Term).To(Equal(uint64(3)))
+	g.Expect(newView.Version).To(Equal(followerView.Version + 1))
+	g.Expect(follower.OnView(newView)).To(BeTrue())
+	g.Expect(follower.View().Nodes).To(HaveKey("a"))
+
+	// Views of a newer term are adopted whatever their version
+	g.Expect(follower.OnView(app.ClusterView{Leader: "a", Term: 4, Version: 1})).To(BeTrue())
+	g.Expect(follower.OnView(app.ClusterView{Leader: "a", Term: 3, Version: 100})).To(BeFalse())
+}
+
+func TestCluster_NewTermOnContestedTerm(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	// Both sides of a partition have elected a leader of the same term
+	now := time.Now()
+	cluster := newCluster("a", "b")
+	view := cluster.Update(app.NodeState{NodeID: "a"}, now)
+	g.Expect(view.Term).To(Equal(uint64(1)))
+
+	// Once the partition heals, the leader starts a new term, which the other side adopts
+	cluster.OnHeartbeat(app.NodeState{NodeID: "b"}, now)
+	cluster.OnSeen("b", 1, 1)
+	g.Expect(cluster.Update(app.NodeState{NodeID: "a"}, now).Term).To(Equal(uint64(2)))
+}
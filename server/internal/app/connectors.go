@@ -1,6 +1,8 @@
 package app
 
-//go:generate mockgen -source connectors.go -destination mocks/mock_connectors.go Publisher,Subscriber
+import "time"
+
+//go:generate mockgen -source connectors.go -destination mocks/mock_connectors.go Publisher,Subscriber,ConditionalSubscriber,PeerSubscriber,BridgedSubscriber,KeySubscriber,TenantMember,Identified,Described
 
 // Publisher provides an abstraction for a publisher connection.
 type Publisher interface {
@@ -10,14 +12,100 @@ type Publisher interface {
 	// NotifyNoSubscribers notifies the publisher that no subscribers are connected.
 	NotifyNoSubscribers() error
 
+	// NotifyGoingAway informs the publisher that the server is shutting down.
+	NotifyGoingAway() error
+
+	// NotifySlowDown informs the publisher that it exceeds its rate limit, and that its messages are dropped for
+	// retryAfter.
+	NotifySlowDown(retryAfter time.Duration) error
+
+	// NotifyQuotaExceeded informs the publisher that its tenant exceeds the quota by the given sdk.Quota* name, and
+	// that its messages are dropped, for retryAfter if known.
+	NotifyQuotaExceeded(quota string, retryAfter time.Duration) error
+
+	// Pause tells the publisher to stop publishing until Resume, and drops the messages it publishes meanwhile.
+	Pause() error
+
+	// Resume tells the publisher that it may publish again after Pause.
+	Resume() error
+
+	// Disconnect closes the connection, telling the peer that the server is going away.
+	Disconnect() error
+
 	// GetID returns a unique identifier for this connection.
 	GetID() string
 }
 
 // Subscriber provides an abstraction for a subscriber connection.
 type Subscriber interface {
+	// SendMessageToSubscriber sends an encoded message to the subscriber. The message is shared with other
+	// subscribers and only valid during the call, so it must be copied to be kept, and must not be modified.
 	SendMessageToSubscriber(message []byte) error
 
+	// NotifyGoingAway informs the subscriber that the server is shutting down. No messages can be sent to the
+	// subscriber afterwards, the ones sent before are still delivered.
+	NotifyGoingAway() error
+
+	// Disconnect closes the connection, telling the peer that the server is going away.
+	Disconnect() error
+
 	// GetID returns a unique identifier for this connection.
 	GetID() string
 }
+
+// ConditionalSubscriber is a Subscriber that wants messages only at times, e.g. a bridge to another server wants them
+// only while that server has subscribers. Other subscribers always want messages.
+type ConditionalSubscriber interface {
+	Subscriber
+
+	// HasDemand returns whether the subscriber currently wants messages.
+	HasDemand() bool
+}
+
+// PeerSubscriber is a ConditionalSubscriber standing for another server of the cluster, which wants messages while
+// it has subscribers of its own. Messages from other servers of the cluster are not sent to peers, as each server
+// sends its messages to all the others.
+type PeerSubscriber interface {
+	ConditionalSubscriber
+
+	// GetNodeID returns the ID of the server in the cluster.
+	GetNodeID() string
+}
+
+// BridgedSubscriber is a Subscriber that may be a bridge from another server, which wants messages while that server
+// has subscribers. Its demand is not bridged back to that server, as it would then never end.
+type BridgedSubscriber interface {
+	Subscriber
+
+	// BridgedFrom returns the ID of the server that the subscriber bridges messages to, empty if it is no bridge.
+	BridgedFrom() string
+}
+
+// KeySubscriber is a Subscriber that may want only the messages with a key, see Observer.OnSubscribe.
+type KeySubscriber interface {
+	Subscriber
+
+	// GetKey returns the key of the messages the subscriber wants, empty if it wants all messages.
+	GetKey() string
+}
+
+// TenantMember is a Publisher or Subscriber that belongs to a tenant. The ones that don't implement it belong to
+// DefaultTenant.
+type TenantMember interface {
+	// GetTenant returns the tenant the connection is bound to.
+	GetTenant() string
+}
+
+// Identified is a Publisher or Subscriber of a client, which is subject to access control by its identity. The ones
+// that don't implement it, e.g. other servers of the cluster, are trusted.
+type Identified interface {
+	// GetIdentity returns the identity of the client, empty if the client is anonymous.
+	GetIdentity() string
+}
+
+// Described is a Publisher or Subscriber of a client that describes its connection, see Admin. The ones that don't
+// implement it, e.g. other servers of the cluster, are described by their ID and tenant only.
+type Described interface {
+	// Describe returns the current state of the connection.
+	Describe() ConnectionInfo
+}
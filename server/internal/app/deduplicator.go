@@ -0,0 +1,54 @@
+package app
+
+import (
+	"container/list"
+	"sync"
+)
+
+// Deduplicator remembers the IDs of the last messages of each origin, see sdk.HeaderMessageID and sdk.HeaderOrigin, so
+// that the copies of a message that reach this server over several paths of bridged servers, e.g. when the bridges
+// form a cycle, are dropped. It is safe for concurrent use.
+type Deduplicator struct {
+	window  int // Number of the last IDs remembered per origin
+	mu      sync.Mutex
+	origins map[string]*seenIDs
+}
+
+// seenIDs are the IDs of the messages of an origin, least recently seen last.
+type seenIDs struct {
+	order    *list.List // Of string IDs
+	elements map[string]*list.Element
+}
+
+// NewDeduplicator is the constructor for Deduplicator, which remembers the last window IDs of each origin.
+func NewDeduplicator(window int) *Deduplicator {
+	if window < 1 {
+		window = 1
+	}
+	return &Deduplicator{window: window, origins: make(map[string]*seenIDs)}
+}
+
+// Seen records the ID of a message of origin and returns whether it has been seen before, forgetting the least
+// recently seen ID of the origin once the window is full.
+func (d *Deduplicator) Seen(origin, id string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	seen, ok := d.origins[origin]
+	if !ok {
+		seen = &seenIDs{order: list.New(), elements: make(map[string]*list.Element)}
+		d.origins[origin] = seen
+	}
+	if element, ok := seen.elements[id]; ok {
+		seen.order.MoveToFront(element)
+		return true
+	}
+
+	seen.elements[id] = seen.order.PushFront(id)
+	if seen.order.Len() > d.window {
+		oldest := seen.order.Back()
+		seen.order.Remove(oldest)
+		delete(seen.elements, oldest.Value.(string))
+	}
+	return false
+}
@@ -0,0 +1,23 @@
+package app_test
+
+import (
+	. "github.com/onsi/gomega"
+	"github.com/varfrog/quicpubsub/server/internal/app"
+	"testing"
+)
+
+func TestDeduplicator(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	d := app.NewDeduplicator(2)
+	g.Expect(d.Seen("A", "1")).To(BeFalse())
+	g.Expect(d.Seen("A", "1")).To(BeTrue())
+	g.Expect(d.Seen("B", "1")).To(BeFalse(), "IDs are per origin")
+
+	// The least recently seen ID is forgotten once the window is full
+	g.Expect(d.Seen("A", "2")).To(BeFalse())
+	g.Expect(d.Seen("A", "1")).To(BeTrue())
+	g.Expect(d.Seen("A", "3")).To(BeFalse())
+	g.Expect(d.Seen("A", "2")).To(BeFalse())
+	g.Expect(d.Seen("A", "1")).To(BeFalse())
+}
@@ -0,0 +1,247 @@
+package app
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"github.com/varfrog/quicpubsub/pkg/buffer"
+	"github.com/varfrog/quicpubsub/pkg/tracing"
+	"go.uber.org/zap"
+	"hash/fnv"
+	"sync"
+	"sync/atomic"
+)
+
+// ErrDispatcherStopped is returned when dispatching a message after the Dispatcher has stopped.
+var ErrDispatcherStopped = errors.New("dispatcher stopped")
+
+// DispatcherConfig configures a Dispatcher.
+type DispatcherConfig struct {
+	Workers   int // Number of goroutines that deliver messages, subscribers are sharded across them
+	QueueSize int // Number of messages queued per worker, after which Dispatch blocks
+}
+
+// Dispatcher delivers messages to the subscribers of a SubscriberPool in parallel. Subscribers are sharded across
+// workers by ID, so that every subscriber receives messages in the order they were dispatched, and a slow subscriber
+// holds up only the subscribers of its shard. The publisher that dispatches a message is held up only once the queue
+// of a worker is full, so a subscriber that stops reading holds up everyone unless sending to it fails after a while,
+// as it does for subscribers of the transport, see transport.QUICSubServerConfig.WriteTimeout.
+type Dispatcher struct {
+	config         DispatcherConfig
+	subscriberPool *SubscriberPool
+	queues         []chan dispatchJob // One per worker
+	sharded        atomic.Pointer[shardedSubscribers]
+	metrics        *Metrics // Nil if metrics are not kept
+	stopCh         chan struct{}
+	stopOnce       sync.Once
+	wg             sync.WaitGroup
+	logger         *zap.Logger
+}
+
+// shardedSubscribers is a snapshot of a SubscriberPool split into one shard per worker. It is rebuilt whenever
+// the snapshot of the pool changes, i.e. when subscribers connect or disconnect, rather than for every message.
+type shardedSubscribers struct {
+	snapshot *[]Subscriber // The snapshot of the pool the shards are built from
+	shards   [][]Subscriber
+}
+
+// dispatchJob is a message for the subscribers of one shard, or a barrier for Flush if done is not nil.
+type dispatchJob struct {
+	message     *buffer.Buffer // Retained for the job, released once delivered
+	subscribers []Subscriber
+	filter      func(Subscriber) bool // Which subscribers get the message
+	span        *tracing.Span         // Nil if the message is not traced
+	group       *dispatchGroup        // Nil if nobody waits for the message to be delivered
+	done        chan<- struct{}
+}
+
+// dispatchGroup calls delivered once the jobs of a message for all shards are done.
+type dispatchGroup struct {
+	pending   atomic.Int32
+	delivered func()
+}
+
+// add counts a job of the message. It does nothing on a nil group, as do the other methods.
+func (g *dispatchGroup) add() {
+	if g != nil {
+		g.pending.Add(1)
+	}
+}
+
+// done is called when a job of the message is done, and calls delivered after the last one.
+func (g *dispatchGroup) done() {
+	if g != nil && g.pending.Add(-1) == 0 {
+		g.delivered()
+	}
+}
+
+// NewDispatcher is the constructor for Dispatcher. The workers run until Stop is called. metrics is optional.
+func NewDispatcher(
+	config DispatcherConfig,
+	subscriberPool *SubscriberPool,
+	metrics *Metrics,
+	logger *zap.Logger,
+) *Dispatcher {
+	d := &Dispatcher{
+		config:         config,
+		subscriberPool: subscriberPool,
+		queues:         make([]chan dispatchJob, config.Workers),
+		metrics:        metrics,
+		stopCh:         make(chan struct{}),
+		logger:         logger,
+	}
+	for i := range d.queues {
+		d.queues[i] = make(chan dispatchJob, config.QueueSize)
+		d.wg.Add(1)
+		go d.work(d.queues[i])
+	}
+	return d
+}
+
+// Dispatch queues the message for delivery to the subscribers for which filter returns true. The message is
+// retained for every shard it is queued to and released once delivered to that shard, so the caller may release its
+// own reference as soon as Dispatch returns. The bytes must not be modified afterwards. span is optional, the writes
+// of the message to subscribers are recorded as its children. delivered is optional, and called once the message has
+// been delivered to all shards, possibly before Dispatch returns. It is not called if the Dispatcher stops meanwhile.
+func (d *Dispatcher) Dispatch(
+	message *buffer.Buffer,
+	filter func(Subscriber) bool,
+	span *tracing.Span,
+	delivered func(),
+) error {
+	var group *dispatchGroup
+	if delivered != nil {
+		group = &dispatchGroup{delivered: delivered}
+		group.add() // Held until all the jobs are queued, so that delivered is not called early
+	}
+
+	for i, shard := range d.getSharded().shards {
+		if len(shard) == 0 {
+			continue
+		}
+		message.Retain()
+		group.add()
+		select {
+		case <-d.stopCh:
+			message.Release()
+			return ErrDispatcherStopped
+		case d.queues[i] <- dispatchJob{
+			message:     message,
+			subscribers: shard,
+			filter:      filter,
+			span:        span,
+			group:       group,
+		}:
+		}
+	}
+	group.done()
+	return nil
+}
+
+// Flush waits until the messages dispatched so far have been delivered, or until ctx is done, in which case it
+// returns ctx.Err() and the messages are still delivered afterwards.
+func (d *Dispatcher) Flush(ctx context.Context) error {
+	done := make(chan struct{}, len(d.queues)) // Buffered, so that workers don't block once Flush has returned
+	for _, queue := range d.queues {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-d.stopCh:
+			return nil
+		case queue <- dispatchJob{done: done}:
+		}
+	}
+	for range d.queues {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-d.stopCh:
+			return nil
+		case <-done:
+		}
+	}
+	return nil
+}
+
+// Stop stops the workers and waits for them to return. Messages that are still queued are dropped, and left to the
+// garbage collector rather than returned to their pools, call Flush first to deliver them.
+func (d *Dispatcher) Stop() {
+	d.stopOnce.Do(func() {
+		close(d.stopCh)
+	})
+	d.wg.Wait()
+}
+
+func (d *Dispatcher) work(queue <-chan dispatchJob) {
+	defer d.wg.Done()
+	for {
+		select {
+		case <-d.stopCh:
+			return
+		case job := <-queue:
+			if job.done != nil {
+				job.done <- struct{}{}
+				continue
+			}
+			deliver(job.message.Bytes(), job.subscribers, job.filter, d.metrics, job.span, d.logger)
+			job.message.Release()
+			job.group.done()
+		}
+	}
+}
+
+// getSharded returns the subscribers of the current snapshot of the pool split into shards, rebuilding the shards
+// if the snapshot has changed.
+func (d *Dispatcher) getSharded() *shardedSubscribers {
+	snapshot := d.subscriberPool.getSnapshot()
+	if sharded := d.sharded.Load(); sharded != nil && sharded.snapshot == snapshot {
+		return sharded
+	}
+
+	// Publishers dispatching concurrently may both rebuild the shards, which is harmless as they build the same ones
+	sharded := &shardedSubscribers{
+		snapshot: snapshot,
+		shards:   make([][]Subscriber, len(d.queues)),
+	}
+	for _, subscriber := range *snapshot {
+		i := shardOf(subscriber.GetID(), len(d.queues))
+		sharded.shards[i] = append(sharded.shards[i], subscriber)
+	}
+	d.sharded.Store(sharded)
+	return sharded
+}
+
+// deliver sends the message to the subscribers for which filter returns true, counting them in the optional metrics,
+// and recording each write as a child of the optional span.
+func deliver(
+	message []byte,
+	subscribers []Subscriber,
+	filter func(Subscriber) bool,
+	metrics *Metrics,
+	span *tracing.Span,
+	logger *zap.Logger,
+) {
+	for _, subscriber := range subscribers {
+		if !filter(subscriber) {
+			continue
+		}
+		write := span.StartChild(tracing.SpanWrite, tracing.SpanKindProducer)
+		err := subscriber.SendMessageToSubscriber(message)
+		metrics.sent(len(message), err)
+		if write != nil {
+			write.SetAttribute(tracing.AttributeSubscriber, subscriber.GetID())
+			write.SetError(err)
+			write.End()
+		}
+		if err != nil {
+			// Don't fail, allow other subscribers to receive messages
+			logger.Warn("SendMessageToSubscriber", zap.Error(err))
+		}
+	}
+}
+
+// shardOf returns the shard of a subscriber, which stays the same for as long as the subscriber is connected.
+func shardOf(subscriberID string, shards int) int {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(subscriberID)) // Never fails
+	return int(h.Sum32() % uint32(shards))
+}
@@ -0,0 +1,248 @@
+package app_test
+
+import (
+	"context"
+	"fmt"
+	. "github.com/onsi/gomega"
+	"github.com/varfrog/quicpubsub/pkg/buffer"
+	"github.com/varfrog/quicpubsub/server/internal/app"
+	"go.uber.org/zap"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// recordingSubscriber is an app.Subscriber that records the messages it receives.
+type recordingSubscriber struct {
+	id       string
+	mu       sync.Mutex
+	messages []string
+	delay    time.Duration // Time each message takes to send
+}
+
+func (s *recordingSubscriber) SendMessageToSubscriber(message []byte) error {
+	time.Sleep(s.delay)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.messages = append(s.messages, string(message))
+	return nil
+}
+
+func (s *recordingSubscriber) NotifyGoingAway() error { return nil }
+func (s *recordingSubscriber) Disconnect() error      { return nil }
+func (s *recordingSubscriber) GetID() string          { return s.id }
+
+func (s *recordingSubscriber) received() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.messages...)
+}
+
+// countingSubscriber is an app.Subscriber that only counts the messages it receives, for benchmarks.
+type countingSubscriber struct {
+	id    string
+	count atomic.Int64
+}
+
+func (s *countingSubscriber) SendMessageToSubscriber([]byte) error {
+	s.count.Add(1)
+	return nil
+}
+
+func (s *countingSubscriber) NotifyGoingAway() error { return nil }
+func (s *countingSubscriber) Disconnect() error      { return nil }
+func (s *countingSubscriber) GetID() string          { return s.id }
+
+func all(app.Subscriber) bool { return true }
+
+func TestDispatcher_DeliversInOrderToEverySubscriber(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	pool := app.NewSubscriberPool()
+	var subscribers []*recordingSubscriber
+	for i := 0; i < 20; i++ {
+		subscriber := &recordingSubscriber{id: strconv.Itoa(i)}
+		subscribers = append(subscribers, subscriber)
+		g.Expect(pool.Add(subscriber)).To(Succeed())
+	}
+
+	dispatcher := app.NewDispatcher(app.DispatcherConfig{Workers: 4, QueueSize: 2}, pool, nil, zap.NewNop())
+	defer dispatcher.Stop()
+
+	var expected []string
+	for i := 0; i < 100; i++ {
+		message := fmt.Sprintf("message %d", i)
+		expected = append(expected, message)
+		g.Expect(dispatcher.Dispatch(buffer.Wrap([]byte(message)), all, nil, nil)).To(Succeed())
+	}
+	g.Expect(dispatcher.Flush(context.Background())).To(Succeed())
+
+	for _, subscriber := range subscribers {
+		g.Expect(subscriber.received()).To(Equal(expected), subscriber.id)
+	}
+}
+
+func TestDispatcher_Filter(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	pool := app.NewSubscriberPool()
+	wanted := &recordingSubscriber{id: "wanted"}
+	unwanted := &recordingSubscriber{id: "unwanted"}
+	g.Expect(pool.Add(wanted)).To(Succeed())
+	g.Expect(pool.Add(unwanted)).To(Succeed())
+
+	dispatcher := app.NewDispatcher(app.DispatcherConfig{Workers: 2, QueueSize: 1}, pool, nil, zap.NewNop())
+	defer dispatcher.Stop()
+
+	err := dispatcher.Dispatch(buffer.Wrap([]byte("foo")), func(subscriber app.Subscriber) bool {
+		return subscriber.GetID() == "wanted"
+	}, nil, nil)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(dispatcher.Flush(context.Background())).To(Succeed())
+
+	g.Expect(wanted.received()).To(Equal([]string{"foo"}))
+	g.Expect(unwanted.received()).To(BeEmpty())
+}
+
+func TestDispatcher_SeesSubscriberChanges(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	pool := app.NewSubscriberPool()
+	first := &recordingSubscriber{id: "1"}
+	g.Expect(pool.Add(first)).To(Succeed())
+
+	dispatcher := app.NewDispatcher(app.DispatcherConfig{Workers: 2, QueueSize: 1}, pool, nil, zap.NewNop())
+	defer dispatcher.Stop()
+
+	g.Expect(dispatcher.Dispatch(buffer.Wrap([]byte("a")), all, nil, nil)).To(Succeed())
+	second := &recordingSubscriber{id: "2"}
+	g.Expect(pool.Add(second)).To(Succeed())
+	g.Expect(dispatcher.Dispatch(buffer.Wrap([]byte("b")), all, nil, nil)).To(Succeed())
+	pool.Remove(first)
+	g.Expect(dispatcher.Dispatch(buffer.Wrap([]byte("c")), all, nil, nil)).To(Succeed())
+	g.Expect(dispatcher.Flush(context.Background())).To(Succeed())
+
+	g.Expect(first.received()).To(Equal([]string{"a", "b"}))
+	g.Expect(second.received()).To(Equal([]string{"b", "c"}))
+}
+
+func TestDispatcher_SlowSubscriberHoldsUpOnlyItsShard(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	pool := app.NewSubscriberPool()
+	slow := &recordingSubscriber{id: "slow", delay: time.Second * 2}
+	g.Expect(pool.Add(slow)).To(Succeed())
+
+	dispatcher := app.NewDispatcher(app.DispatcherConfig{Workers: 2, QueueSize: 100}, pool, nil, zap.NewNop())
+	defer dispatcher.Stop()
+
+	// Keep the worker of the slow subscriber busy
+	g.Expect(dispatcher.Dispatch(buffer.Wrap([]byte("busy")), func(subscriber app.Subscriber) bool {
+		return subscriber == slow
+	}, nil, nil)).To(Succeed())
+
+	// Find a subscriber in the other shard, i.e. one that still receives messages
+	var fast *recordingSubscriber
+	for i := 0; fast == nil && i < 50; i++ {
+		candidate := &recordingSubscriber{id: strconv.Itoa(i)}
+		g.Expect(pool.Add(candidate)).To(Succeed())
+		g.Expect(dispatcher.Dispatch(buffer.Wrap([]byte("probe")), func(subscriber app.Subscriber) bool {
+			return subscriber == candidate
+		}, nil, nil)).To(Succeed())
+		time.Sleep(time.Millisecond * 20)
+		if len(candidate.received()) == 1 {
+			fast = candidate
+		}
+	}
+	g.Expect(fast).ToNot(BeNil())
+
+	g.Expect(dispatcher.Dispatch(buffer.Wrap([]byte("foo")), all, nil, nil)).To(Succeed())
+	g.Eventually(fast.received, time.Millisecond*500).Should(ContainElement("foo"))
+	g.Expect(slow.received()).To(BeEmpty())
+}
+
+func TestDispatcher_FlushReturnsOnceCtxIsDone(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	pool := app.NewSubscriberPool()
+	stuck := &recordingSubscriber{id: "stuck", delay: time.Second * 2} // A subscriber that does not read
+	g.Expect(pool.Add(stuck)).To(Succeed())
+
+	dispatcher := app.NewDispatcher(app.DispatcherConfig{Workers: 1, QueueSize: 1}, pool, nil, zap.NewNop())
+	defer dispatcher.Stop()
+	g.Expect(dispatcher.Dispatch(buffer.Wrap([]byte("foo")), all, nil, nil)).To(Succeed())
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+	defer cancel()
+	start := time.Now()
+	g.Expect(dispatcher.Flush(ctx)).To(MatchError(context.DeadlineExceeded))
+	g.Expect(time.Since(start)).To(BeNumerically("<", time.Second))
+	g.Expect(stuck.received()).To(BeEmpty())
+}
+
+func TestDispatcher_ReleasesMessageOnceDelivered(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	pool := app.NewSubscriberPool()
+	for i := 0; i < 10; i++ {
+		g.Expect(pool.Add(&recordingSubscriber{id: strconv.Itoa(i)})).To(Succeed())
+	}
+
+	dispatcher := app.NewDispatcher(app.DispatcherConfig{Workers: 4, QueueSize: 1}, pool, nil, zap.NewNop())
+	defer dispatcher.Stop()
+
+	message := buffer.Wrap([]byte("foo"))
+	g.Expect(dispatcher.Dispatch(message, all, nil, nil)).To(Succeed())
+	message.Release() // The reference of the caller
+	g.Expect(dispatcher.Flush(context.Background())).To(Succeed())
+
+	// Every worker has released its reference, so there are none left
+	g.Expect(message.Release).To(Panic())
+}
+
+func TestDispatcher_CallsDeliveredOnceDeliveredToAllShards(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	pool := app.NewSubscriberPool()
+	slow := &recordingSubscriber{id: "slow", delay: time.Millisecond * 200}
+	g.Expect(pool.Add(slow)).To(Succeed())
+	for i := 0; i < 10; i++ {
+		g.Expect(pool.Add(&recordingSubscriber{id: strconv.Itoa(i)})).To(Succeed())
+	}
+
+	dispatcher := app.NewDispatcher(app.DispatcherConfig{Workers: 4, QueueSize: 1}, pool, nil, zap.NewNop())
+	defer dispatcher.Stop()
+
+	var delivered atomic.Int32
+	g.Expect(dispatcher.Dispatch(buffer.Wrap([]byte("foo")), all, nil, func() { delivered.Add(1) })).To(Succeed())
+	g.Expect(delivered.Load()).To(BeZero()) // The slow subscriber is still receiving it
+	g.Eventually(slow.received, time.Second).Should(ContainElement("foo"))
+	g.Eventually(delivered.Load, time.Second).Should(BeEquivalentTo(1))
+	g.Consistently(delivered.Load, time.Millisecond*100).Should(BeEquivalentTo(1))
+
+	// Called right away if there are no subscribers
+	empty := app.NewDispatcher(app.DispatcherConfig{Workers: 2, QueueSize: 1}, app.NewSubscriberPool(), nil, zap.NewNop())
+	defer empty.Stop()
+	g.Expect(empty.Dispatch(buffer.Wrap([]byte("foo")), all, nil, func() { delivered.Add(1) })).To(Succeed())
+	g.Expect(delivered.Load()).To(BeEquivalentTo(2))
+}
+
+func TestDispatcher_Stop(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	pool := app.NewSubscriberPool()
+	g.Expect(pool.Add(&recordingSubscriber{id: "1", delay: time.Second})).To(Succeed())
+
+	dispatcher := app.NewDispatcher(app.DispatcherConfig{Workers: 1, QueueSize: 0}, pool, nil, zap.NewNop())
+	g.Expect(dispatcher.Dispatch(buffer.Wrap([]byte("foo")), all, nil, nil)).To(Succeed()) // Taken by the worker
+
+	dispatched := make(chan error)
+	go func() {
+		dispatched <- dispatcher.Dispatch(buffer.Wrap([]byte("bar")), all, nil, nil) // Blocks, as the worker is busy
+	}()
+	go dispatcher.Stop()
+
+	g.Eventually(dispatched, time.Second*3).Should(Receive(MatchError(app.ErrDispatcherStopped)))
+}
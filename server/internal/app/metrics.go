@@ -0,0 +1,75 @@
+package app
+
+import (
+	"github.com/varfrog/quicpubsub/pkg/metrics"
+	"time"
+)
+
+// Metrics are the metrics of the messages that pass through the server, see NewMetrics.
+type Metrics struct {
+	MessagesReceived *metrics.Counter   // Messages from publishers and other servers
+	BytesReceived    *metrics.Counter   // Their bytes
+	MessagesSent     *metrics.Counter   // Messages sent to subscribers, once per subscriber
+	BytesSent        *metrics.Counter   // Their bytes
+	SendErrors       *metrics.Counter   // Messages that could not be sent to a subscriber
+	FanOutLatency    *metrics.Histogram // Seconds from receiving a message to it being sent to all its subscribers
+	MessageSize      *metrics.Histogram // Bytes of the messages received
+}
+
+// NewMetrics registers the metrics of messages in the registry, along with gauges of the publishers and subscribers
+// in the pools.
+func NewMetrics(registry *metrics.Registry, publisherPool *PublisherPool, subscriberPool *SubscriberPool) *Metrics {
+	registry.GaugeFunc("quicpubsub_publishers", "Connected publishers.", func() float64 {
+		return float64(publisherPool.Len())
+	})
+	registry.GaugeFunc("quicpubsub_subscribers", "Connected subscribers.", func() float64 {
+		return float64(subscriberPool.Len())
+	})
+	return &Metrics{
+		MessagesReceived: registry.Counter("quicpubsub_messages_received_total",
+			"Messages received from publishers and other servers."),
+		BytesReceived: registry.Counter("quicpubsub_received_bytes_total",
+			"Bytes of the messages received from publishers and other servers."),
+		MessagesSent: registry.Counter("quicpubsub_messages_sent_total",
+			"Messages sent to subscribers, once per subscriber."),
+		BytesSent: registry.Counter("quicpubsub_sent_bytes_total",
+			"Bytes of the messages sent to subscribers."),
+		SendErrors: registry.Counter("quicpubsub_send_errors_total",
+			"Messages that could not be sent to a subscriber."),
+		FanOutLatency: registry.Histogram("quicpubsub_fanout_latency_seconds",
+			"Seconds from receiving a message to it being sent to all its subscribers.",
+			metrics.ExponentialBuckets(0.0001, 4, 9)), // 100µs to 6.5s
+		MessageSize: registry.Histogram("quicpubsub_message_size_bytes",
+			"Bytes of the messages received.",
+			metrics.ExponentialBuckets(64, 4, 8)), // 64B to 1MiB
+	}
+}
+
+// received counts a received message of the given size. It does nothing on nil Metrics, as do the other methods.
+func (m *Metrics) received(size int) {
+	if m != nil {
+		m.MessagesReceived.Inc()
+		m.BytesReceived.Add(uint64(size))
+		m.MessageSize.Observe(float64(size))
+	}
+}
+
+// sent counts a message of the given size sent to a subscriber, or that could not be sent if err is not nil.
+func (m *Metrics) sent(size int, err error) {
+	if m == nil {
+		return
+	}
+	if err != nil {
+		m.SendErrors.Inc()
+		return
+	}
+	m.MessagesSent.Inc()
+	m.BytesSent.Add(uint64(size))
+}
+
+// fannedOut observes the fan-out latency of a message received at start.
+func (m *Metrics) fannedOut(start time.Time) {
+	if m != nil {
+		m.FanOutLatency.Observe(time.Since(start).Seconds())
+	}
+}
@@ -0,0 +1,61 @@
+package app_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	. "github.com/onsi/gomega"
+	"github.com/varfrog/quicpubsub/pkg/buffer"
+	"github.com/varfrog/quicpubsub/pkg/metrics"
+	"github.com/varfrog/quicpubsub/server/internal/app"
+	mocks "github.com/varfrog/quicpubsub/server/internal/app/mocks"
+	"go.uber.org/mock/gomock"
+	"go.uber.org/zap"
+	"testing"
+)
+
+func TestMetrics(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	g := NewGomegaWithT(t)
+
+	message := []byte("0123456789")
+
+	healthy := mocks.NewMockSubscriber(ctrl)
+	healthy.EXPECT().GetID().AnyTimes().Return("healthy")
+	healthy.EXPECT().SendMessageToSubscriber(message).Times(2).Return(nil)
+	broken := mocks.NewMockSubscriber(ctrl)
+	broken.EXPECT().GetID().AnyTimes().Return("broken")
+	broken.EXPECT().SendMessageToSubscriber(message).Times(2).Return(errors.New("stream closed"))
+
+	publisherPool := app.NewPublisherPool()
+	subscriberPool := app.NewSubscriberPool()
+	g.Expect(subscriberPool.Add(healthy)).To(Succeed())
+	g.Expect(subscriberPool.Add(broken)).To(Succeed())
+
+	registry := metrics.NewRegistry()
+	m := app.NewMetrics(registry, publisherPool, subscriberPool)
+	dispatcher := app.NewDispatcher(app.DispatcherConfig{Workers: 2, QueueSize: 1}, subscriberPool, m, zap.NewNop())
+	defer dispatcher.Stop()
+	observer := app.NewObserver(publisherPool, subscriberPool, dispatcher, nil, nil, m, nil, nil, zap.NewNop())
+
+	g.Expect(observer.OnPublisherMessage(app.MessageOrigin{Tenant: app.DefaultTenant}, buffer.Wrap(message))).
+		To(Succeed())
+	g.Expect(observer.OnPeerMessage("", buffer.Wrap(message))).To(Succeed())
+	g.Expect(dispatcher.Flush(context.Background())).To(Succeed())
+
+	g.Expect(m.MessagesReceived.Value()).To(Equal(uint64(2)))
+	g.Expect(m.BytesReceived.Value()).To(Equal(uint64(20)))
+	g.Expect(m.MessagesSent.Value()).To(Equal(uint64(2)))
+	g.Expect(m.BytesSent.Value()).To(Equal(uint64(20)))
+	g.Expect(m.SendErrors.Value()).To(Equal(uint64(2)))
+	g.Expect(m.MessageSize.Count()).To(Equal(uint64(2)))
+	g.Expect(m.FanOutLatency.Count()).To(Equal(uint64(2)))
+
+	var buf bytes.Buffer
+	_, err := registry.WriteTo(&buf)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(buf.String()).To(ContainSubstring("\nquicpubsub_publishers 0\n"))
+	g.Expect(buf.String()).To(ContainSubstring("\nquicpubsub_subscribers 2\n"))
+	g.Expect(buf.String()).To(ContainSubstring("\nquicpubsub_message_size_bytes_bucket{le=\"64\"} 2\n"))
+}
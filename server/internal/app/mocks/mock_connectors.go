@@ -6,7 +6,9 @@ package mock_app
 
 import (
 	reflect "reflect"
+	time "time"
 
+	app "github.com/varfrog/quicpubsub/server/internal/app"
 	gomock "go.uber.org/mock/gomock"
 )
 
@@ -33,6 +35,20 @@ func (m *MockPublisher) EXPECT() *MockPublisherMockRecorder {
 	return m.recorder
 }
 
+// Disconnect mocks base method.
+func (m *MockPublisher) Disconnect() error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Disconnect")
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Disconnect indicates an expected call of Disconnect.
+func (mr *MockPublisherMockRecorder) Disconnect() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Disconnect", reflect.TypeOf((*MockPublisher)(nil).Disconnect))
+}
+
 // GetID mocks base method.
 func (m *MockPublisher) GetID() string {
 	m.ctrl.T.Helper()
@@ -61,6 +77,20 @@ func (mr *MockPublisherMockRecorder) NotifyExistsSubscriber() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "NotifyExistsSubscriber", reflect.TypeOf((*MockPublisher)(nil).NotifyExistsSubscriber))
 }
 
+// NotifyGoingAway mocks base method.
+func (m *MockPublisher) NotifyGoingAway() error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "NotifyGoingAway")
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// NotifyGoingAway indicates an expected call of NotifyGoingAway.
+func (mr *MockPublisherMockRecorder) NotifyGoingAway() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "NotifyGoingAway", reflect.TypeOf((*MockPublisher)(nil).NotifyGoingAway))
+}
+
 // NotifyNoSubscribers mocks base method.
 func (m *MockPublisher) NotifyNoSubscribers() error {
 	m.ctrl.T.Helper()
@@ -75,6 +105,62 @@ func (mr *MockPublisherMockRecorder) NotifyNoSubscribers() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "NotifyNoSubscribers", reflect.TypeOf((*MockPublisher)(nil).NotifyNoSubscribers))
 }
 
+// NotifyQuotaExceeded mocks base method.
+func (m *MockPublisher) NotifyQuotaExceeded(quota string, retryAfter time.Duration) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "NotifyQuotaExceeded", quota, retryAfter)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// NotifyQuotaExceeded indicates an expected call of NotifyQuotaExceeded.
+func (mr *MockPublisherMockRecorder) NotifyQuotaExceeded(quota, retryAfter interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "NotifyQuotaExceeded", reflect.TypeOf((*MockPublisher)(nil).NotifyQuotaExceeded), quota, retryAfter)
+}
+
+// NotifySlowDown mocks base method.
+func (m *MockPublisher) NotifySlowDown(retryAfter time.Duration) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "NotifySlowDown", retryAfter)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// NotifySlowDown indicates an expected call of NotifySlowDown.
+func (mr *MockPublisherMockRecorder) NotifySlowDown(retryAfter interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "NotifySlowDown", reflect.TypeOf((*MockPublisher)(nil).NotifySlowDown), retryAfter)
+}
+
+// Pause mocks base method.
+func (m *MockPublisher) Pause() error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Pause")
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Pause indicates an expected call of Pause.
+func (mr *MockPublisherMockRecorder) Pause() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Pause", reflect.TypeOf((*MockPublisher)(nil).Pause))
+}
+
+// Resume mocks base method.
+func (m *MockPublisher) Resume() error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Resume")
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Resume indicates an expected call of Resume.
+func (mr *MockPublisherMockRecorder) Resume() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Resume", reflect.TypeOf((*MockPublisher)(nil).Resume))
+}
+
 // MockSubscriber is a mock of Subscriber interface.
 type MockSubscriber struct {
 	ctrl     *gomock.Controller
@@ -98,6 +184,20 @@ func (m *MockSubscriber) EXPECT() *MockSubscriberMockRecorder {
 	return m.recorder
 }
 
+// Disconnect mocks base method.
+func (m *MockSubscriber) Disconnect() error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Disconnect")
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Disconnect indicates an expected call of Disconnect.
+func (mr *MockSubscriberMockRecorder) Disconnect() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Disconnect", reflect.TypeOf((*MockSubscriber)(nil).Disconnect))
+}
+
 // GetID mocks base method.
 func (m *MockSubscriber) GetID() string {
 	m.ctrl.T.Helper()
@@ -112,6 +212,20 @@ func (mr *MockSubscriberMockRecorder) GetID() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetID", reflect.TypeOf((*MockSubscriber)(nil).GetID))
 }
 
+// NotifyGoingAway mocks base method.
+func (m *MockSubscriber) NotifyGoingAway() error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "NotifyGoingAway")
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// NotifyGoingAway indicates an expected call of NotifyGoingAway.
+func (mr *MockSubscriberMockRecorder) NotifyGoingAway() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "NotifyGoingAway", reflect.TypeOf((*MockSubscriber)(nil).NotifyGoingAway))
+}
+
 // SendMessageToSubscriber mocks base method.
 func (m *MockSubscriber) SendMessageToSubscriber(message []byte) error {
 	m.ctrl.T.Helper()
@@ -125,3 +239,500 @@ func (mr *MockSubscriberMockRecorder) SendMessageToSubscriber(message interface{
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SendMessageToSubscriber", reflect.TypeOf((*MockSubscriber)(nil).SendMessageToSubscriber), message)
 }
+
+// MockConditionalSubscriber is a mock of ConditionalSubscriber interface.
+type MockConditionalSubscriber struct {
+	ctrl     *gomock.Controller
+	recorder *MockConditionalSubscriberMockRecorder
+}
+
+// MockConditionalSubscriberMockRecorder is the mock recorder for MockConditionalSubscriber.
+type MockConditionalSubscriberMockRecorder struct {
+	mock *MockConditionalSubscriber
+}
+
+// NewMockConditionalSubscriber creates a new mock instance.
+func NewMockConditionalSubscriber(ctrl *gomock.Controller) *MockConditionalSubscriber {
+	mock := &MockConditionalSubscriber{ctrl: ctrl}
+	mock.recorder = &MockConditionalSubscriberMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockConditionalSubscriber) EXPECT() *MockConditionalSubscriberMockRecorder {
+	return m.recorder
+}
+
+// Disconnect mocks base method.
+func (m *MockConditionalSubscriber) Disconnect() error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Disconnect")
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Disconnect indicates an expected call of Disconnect.
+func (mr *MockConditionalSubscriberMockRecorder) Disconnect() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Disconnect", reflect.TypeOf((*MockConditionalSubscriber)(nil).Disconnect))
+}
+
+// GetID mocks base method.
+func (m *MockConditionalSubscriber) GetID() string {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetID")
+	ret0, _ := ret[0].(string)
+	return ret0
+}
+
+// GetID indicates an expected call of GetID.
+func (mr *MockConditionalSubscriberMockRecorder) GetID() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetID", reflect.TypeOf((*MockConditionalSubscriber)(nil).GetID))
+}
+
+// HasDemand mocks base method.
+func (m *MockConditionalSubscriber) HasDemand() bool {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "HasDemand")
+	ret0, _ := ret[0].(bool)
+	return ret0
+}
+
+// HasDemand indicates an expected call of HasDemand.
+func (mr *MockConditionalSubscriberMockRecorder) HasDemand() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "HasDemand", reflect.TypeOf((*MockConditionalSubscriber)(nil).HasDemand))
+}
+
+// NotifyGoingAway mocks base method.
+func (m *MockConditionalSubscriber) NotifyGoingAway() error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "NotifyGoingAway")
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// NotifyGoingAway indicates an expected call of NotifyGoingAway.
+func (mr *MockConditionalSubscriberMockRecorder) NotifyGoingAway() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "NotifyGoingAway", reflect.TypeOf((*MockConditionalSubscriber)(nil).NotifyGoingAway))
+}
+
+// SendMessageToSubscriber mocks base method.
+func (m *MockConditionalSubscriber) SendMessageToSubscriber(message []byte) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SendMessageToSubscriber", message)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SendMessageToSubscriber indicates an expected call of SendMessageToSubscriber.
+func (mr *MockConditionalSubscriberMockRecorder) SendMessageToSubscriber(message interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SendMessageToSubscriber", reflect.TypeOf((*MockConditionalSubscriber)(nil).SendMessageToSubscriber), message)
+}
+
+// MockPeerSubscriber is a mock of PeerSubscriber interface.
+type MockPeerSubscriber struct {
+	ctrl     *gomock.Controller
+	recorder *MockPeerSubscriberMockRecorder
+}
+
+// MockPeerSubscriberMockRecorder is the mock recorder for MockPeerSubscriber.
+type MockPeerSubscriberMockRecorder struct {
+	mock *MockPeerSubscriber
+}
+
+// NewMockPeerSubscriber creates a new mock instance.
+func NewMockPeerSubscriber(ctrl *gomock.Controller) *MockPeerSubscriber {
+	mock := &MockPeerSubscriber{ctrl: ctrl}
+	mock.recorder = &MockPeerSubscriberMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockPeerSubscriber) EXPECT() *MockPeerSubscriberMockRecorder {
+	return m.recorder
+}
+
+// Disconnect mocks base method.
+func (m *MockPeerSubscriber) Disconnect() error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Disconnect")
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Disconnect indicates an expected call of Disconnect.
+func (mr *MockPeerSubscriberMockRecorder) Disconnect() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Disconnect", reflect.TypeOf((*MockPeerSubscriber)(nil).Disconnect))
+}
+
+// GetID mocks base method.
+func (m *MockPeerSubscriber) GetID() string {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetID")
+	ret0, _ := ret[0].(string)
+	return ret0
+}
+
+// GetID indicates an expected call of GetID.
+func (mr *MockPeerSubscriberMockRecorder) GetID() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetID", reflect.TypeOf((*MockPeerSubscriber)(nil).GetID))
+}
+
+// GetNodeID mocks base method.
+func (m *MockPeerSubscriber) GetNodeID() string {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetNodeID")
+	ret0, _ := ret[0].(string)
+	return ret0
+}
+
+// GetNodeID indicates an expected call of GetNodeID.
+func (mr *MockPeerSubscriberMockRecorder) GetNodeID() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetNodeID", reflect.TypeOf((*MockPeerSubscriber)(nil).GetNodeID))
+}
+
+// HasDemand mocks base method.
+func (m *MockPeerSubscriber) HasDemand() bool {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "HasDemand")
+	ret0, _ := ret[0].(bool)
+	return ret0
+}
+
+// HasDemand indicates an expected call of HasDemand.
+func (mr *MockPeerSubscriberMockRecorder) HasDemand() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "HasDemand", reflect.TypeOf((*MockPeerSubscriber)(nil).HasDemand))
+}
+
+// NotifyGoingAway mocks base method.
+func (m *MockPeerSubscriber) NotifyGoingAway() error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "NotifyGoingAway")
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// NotifyGoingAway indicates an expected call of NotifyGoingAway.
+func (mr *MockPeerSubscriberMockRecorder) NotifyGoingAway() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "NotifyGoingAway", reflect.TypeOf((*MockPeerSubscriber)(nil).NotifyGoingAway))
+}
+
+// SendMessageToSubscriber mocks base method.
+func (m *MockPeerSubscriber) SendMessageToSubscriber(message []byte) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SendMessageToSubscriber", message)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SendMessageToSubscriber indicates an expected call of SendMessageToSubscriber.
+func (mr *MockPeerSubscriberMockRecorder) SendMessageToSubscriber(message interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SendMessageToSubscriber", reflect.TypeOf((*MockPeerSubscriber)(nil).SendMessageToSubscriber), message)
+}
+
+// MockBridgedSubscriber is a mock of BridgedSubscriber interface.
+type MockBridgedSubscriber struct {
+	ctrl     *gomock.Controller
+	recorder *MockBridgedSubscriberMockRecorder
+}
+
+// MockBridgedSubscriberMockRecorder is the mock recorder for MockBridgedSubscriber.
+type MockBridgedSubscriberMockRecorder struct {
+	mock *MockBridgedSubscriber
+}
+
+// NewMockBridgedSubscriber creates a new mock instance.
+func NewMockBridgedSubscriber(ctrl *gomock.Controller) *MockBridgedSubscriber {
+	mock := &MockBridgedSubscriber{ctrl: ctrl}
+	mock.recorder = &MockBridgedSubscriberMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockBridgedSubscriber) EXPECT() *MockBridgedSubscriberMockRecorder {
+	return m.recorder
+}
+
+// BridgedFrom mocks base method.
+func (m *MockBridgedSubscriber) BridgedFrom() string {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "BridgedFrom")
+	ret0, _ := ret[0].(string)
+	return ret0
+}
+
+// BridgedFrom indicates an expected call of BridgedFrom.
+func (mr *MockBridgedSubscriberMockRecorder) BridgedFrom() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "BridgedFrom", reflect.TypeOf((*MockBridgedSubscriber)(nil).BridgedFrom))
+}
+
+// Disconnect mocks base method.
+func (m *MockBridgedSubscriber) Disconnect() error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Disconnect")
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Disconnect indicates an expected call of Disconnect.
+func (mr *MockBridgedSubscriberMockRecorder) Disconnect() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Disconnect", reflect.TypeOf((*MockBridgedSubscriber)(nil).Disconnect))
+}
+
+// GetID mocks base method.
+func (m *MockBridgedSubscriber) GetID() string {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetID")
+	ret0, _ := ret[0].(string)
+	return ret0
+}
+
+// GetID indicates an expected call of GetID.
+func (mr *MockBridgedSubscriberMockRecorder) GetID() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetID", reflect.TypeOf((*MockBridgedSubscriber)(nil).GetID))
+}
+
+// NotifyGoingAway mocks base method.
+func (m *MockBridgedSubscriber) NotifyGoingAway() error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "NotifyGoingAway")
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// NotifyGoingAway indicates an expected call of NotifyGoingAway.
+func (mr *MockBridgedSubscriberMockRecorder) NotifyGoingAway() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "NotifyGoingAway", reflect.TypeOf((*MockBridgedSubscriber)(nil).NotifyGoingAway))
+}
+
+// SendMessageToSubscriber mocks base method.
+func (m *MockBridgedSubscriber) SendMessageToSubscriber(message []byte) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SendMessageToSubscriber", message)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SendMessageToSubscriber indicates an expected call of SendMessageToSubscriber.
+func (mr *MockBridgedSubscriberMockRecorder) SendMessageToSubscriber(message interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SendMessageToSubscriber", reflect.TypeOf((*MockBridgedSubscriber)(nil).SendMessageToSubscriber), message)
+}
+
+// MockKeySubscriber is a mock of KeySubscriber interface.
+type MockKeySubscriber struct {
+	ctrl     *gomock.Controller
+	recorder *MockKeySubscriberMockRecorder
+}
+
+// MockKeySubscriberMockRecorder is the mock recorder for MockKeySubscriber.
+type MockKeySubscriberMockRecorder struct {
+	mock *MockKeySubscriber
+}
+
+// NewMockKeySubscriber creates a new mock instance.
+func NewMockKeySubscriber(ctrl *gomock.Controller) *MockKeySubscriber {
+	mock := &MockKeySubscriber{ctrl: ctrl}
+	mock.recorder = &MockKeySubscriberMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockKeySubscriber) EXPECT() *MockKeySubscriberMockRecorder {
+	return m.recorder
+}
+
+// Disconnect mocks base method.
+func (m *MockKeySubscriber) Disconnect() error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Disconnect")
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Disconnect indicates an expected call of Disconnect.
+func (mr *MockKeySubscriberMockRecorder) Disconnect() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Disconnect", reflect.TypeOf((*MockKeySubscriber)(nil).Disconnect))
+}
+
+// GetID mocks base method.
+func (m *MockKeySubscriber) GetID() string {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetID")
+	ret0, _ := ret[0].(string)
+	return ret0
+}
+
+// GetID indicates an expected call of GetID.
+func (mr *MockKeySubscriberMockRecorder) GetID() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetID", reflect.TypeOf((*MockKeySubscriber)(nil).GetID))
+}
+
+// GetKey mocks base method.
+func (m *MockKeySubscriber) GetKey() string {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetKey")
+	ret0, _ := ret[0].(string)
+	return ret0
+}
+
+// GetKey indicates an expected call of GetKey.
+func (mr *MockKeySubscriberMockRecorder) GetKey() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetKey", reflect.TypeOf((*MockKeySubscriber)(nil).GetKey))
+}
+
+// NotifyGoingAway mocks base method.
+func (m *MockKeySubscriber) NotifyGoingAway() error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "NotifyGoingAway")
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// NotifyGoingAway indicates an expected call of NotifyGoingAway.
+func (mr *MockKeySubscriberMockRecorder) NotifyGoingAway() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "NotifyGoingAway", reflect.TypeOf((*MockKeySubscriber)(nil).NotifyGoingAway))
+}
+
+// SendMessageToSubscriber mocks base method.
+func (m *MockKeySubscriber) SendMessageToSubscriber(message []byte) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SendMessageToSubscriber", message)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SendMessageToSubscriber indicates an expected call of SendMessageToSubscriber.
+func (mr *MockKeySubscriberMockRecorder) SendMessageToSubscriber(message interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SendMessageToSubscriber", reflect.TypeOf((*MockKeySubscriber)(nil).SendMessageToSubscriber), message)
+}
+
+// MockTenantMember is a mock of TenantMember interface.
+type MockTenantMember struct {
+	ctrl     *gomock.Controller
+	recorder *MockTenantMemberMockRecorder
+}
+
+// MockTenantMemberMockRecorder is the mock recorder for MockTenantMember.
+type MockTenantMemberMockRecorder struct {
+	mock *MockTenantMember
+}
+
+// NewMockTenantMember creates a new mock instance.
+func NewMockTenantMember(ctrl *gomock.Controller) *MockTenantMember {
+	mock := &MockTenantMember{ctrl: ctrl}
+	mock.recorder = &MockTenantMemberMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockTenantMember) EXPECT() *MockTenantMemberMockRecorder {
+	return m.recorder
+}
+
+// GetTenant mocks base method.
+func (m *MockTenantMember) GetTenant() string {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetTenant")
+	ret0, _ := ret[0].(string)
+	return ret0
+}
+
+// GetTenant indicates an expected call of GetTenant.
+func (mr *MockTenantMemberMockRecorder) GetTenant() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetTenant", reflect.TypeOf((*MockTenantMember)(nil).GetTenant))
+}
+
+// MockIdentified is a mock of Identified interface.
+type MockIdentified struct {
+	ctrl     *gomock.Controller
+	recorder *MockIdentifiedMockRecorder
+}
+
+// MockIdentifiedMockRecorder is the mock recorder for MockIdentified.
+type MockIdentifiedMockRecorder struct {
+	mock *MockIdentified
+}
+
+// NewMockIdentified creates a new mock instance.
+func NewMockIdentified(ctrl *gomock.Controller) *MockIdentified {
+	mock := &MockIdentified{ctrl: ctrl}
+	mock.recorder = &MockIdentifiedMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockIdentified) EXPECT() *MockIdentifiedMockRecorder {
+	return m.recorder
+}
+
+// GetIdentity mocks base method.
+func (m *MockIdentified) GetIdentity() string {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetIdentity")
+	ret0, _ := ret[0].(string)
+	return ret0
+}
+
+// GetIdentity indicates an expected call of GetIdentity.
+func (mr *MockIdentifiedMockRecorder) GetIdentity() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetIdentity", reflect.TypeOf((*MockIdentified)(nil).GetIdentity))
+}
+
+// MockDescribed is a mock of Described interface.
+type MockDescribed struct {
+	ctrl     *gomock.Controller
+	recorder *MockDescribedMockRecorder
+}
+
+// MockDescribedMockRecorder is the mock recorder for MockDescribed.
+type MockDescribedMockRecorder struct {
+	mock *MockDescribed
+}
+
+// NewMockDescribed creates a new mock instance.
+func NewMockDescribed(ctrl *gomock.Controller) *MockDescribed {
+	mock := &MockDescribed{ctrl: ctrl}
+	mock.recorder = &MockDescribedMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockDescribed) EXPECT() *MockDescribedMockRecorder {
+	return m.recorder
+}
+
+// Describe mocks base method.
+func (m *MockDescribed) Describe() app.ConnectionInfo {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Describe")
+	ret0, _ := ret[0].(app.ConnectionInfo)
+	return ret0
+}
+
+// Describe indicates an expected call of Describe.
+func (mr *MockDescribedMockRecorder) Describe() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Describe", reflect.TypeOf((*MockDescribed)(nil).Describe))
+}
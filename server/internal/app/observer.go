@@ -1,81 +1,246 @@
 package app
 
 import (
+	"context"
 	"github.com/pkg/errors"
+	"github.com/varfrog/quicpubsub/pkg/buffer"
+	"github.com/varfrog/quicpubsub/pkg/tracing"
 	"go.uber.org/zap"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
+// drainPollInterval is how often Shutdown checks whether all connectors have disconnected.
+const drainPollInterval = time.Millisecond * 50
+
 // Observer is the internal "event" dispatcher / message broker between connectors (Publishers and Subscribers).
 // It accepts events (in form of method calls) and knows how to notify which connectors.
+//
+// Connectors are isolated by tenant, see TenantMember: messages of a publisher are delivered only to subscribers of
+// its tenant, and publishers are notified only about the subscribers of their tenant. Other servers of the cluster
+// and bridges belong to DefaultTenant, so messages of other tenants stay on this server.
+//
+// If access is controlled, clients may publish and receive only the messages whose keys the ACL allows them, see
+// Identified. Messages from other servers of the cluster and bridges have been checked by the server they were published
+// to, which this server knows by its certificate.
+//
+// If messages are traced, the spans of receiving, routing and writing messages to subscribers continue the traces of
+// publishers, see MessageOrigin.Trace.
 type Observer struct {
 	publisherPool  *PublisherPool
 	subscriberPool *SubscriberPool
+	dispatcher     *Dispatcher     // Nil if messages are delivered on the goroutine of the caller
+	tenants        *Tenants        // Nil if tenants have no quotas
+	accessControl  *AccessControl  // Nil if clients may publish and subscribe to anything
+	metrics        *Metrics        // Nil if metrics are not kept
+	tracer         *tracing.Tracer // Nil if messages are not traced
+	deduplicator   *Deduplicator   // Nil if bridged messages are not deduplicated
+	keySubscribers atomic.Int64    // Number of KeySubscribers with a key, see NeedsKeys
+	tenantFilters  sync.Map        // Tenant to the func(Subscriber) bool that selects its subscribers with demand
+	listenersMu    sync.Mutex
+	listeners      []func() // Called whenever subscribers change, see AddSubscribersChangedListener
 	logger         *zap.Logger
 }
 
-// NewObserver is the constructor for Observer.
+// NewObserver is the constructor for Observer. dispatcher is optional, without one messages are delivered to
+// subscribers one after another on the goroutine that received the message. tenants is optional, without it
+// tenants are isolated but have no quotas on subscriptions, message rate and stored bytes. accessControl is optional,
+// without it clients may publish and subscribe to anything. metrics is optional, the Dispatcher needs the same ones to
+// count the messages it sends. tracer is optional. deduplicator is optional, without it the copies of a message that
+// reach this server over several bridges are all delivered.
 func NewObserver(
 	publisherPool *PublisherPool,
 	subscriberPool *SubscriberPool,
+	dispatcher *Dispatcher,
+	tenants *Tenants,
+	accessControl *AccessControl,
+	metrics *Metrics,
+	tracer *tracing.Tracer,
+	deduplicator *Deduplicator,
 	logger *zap.Logger,
 ) *Observer {
 	return &Observer{
 		publisherPool:  publisherPool,
 		subscriberPool: subscriberPool,
+		dispatcher:     dispatcher,
+		tenants:        tenants,
+		accessControl:  accessControl,
+		metrics:        metrics,
+		tracer:         tracer,
+		deduplicator:   deduplicator,
 		logger:         logger,
 	}
 }
 
+// ControlsAccess returns whether messages are subject to access control, in which case OnPublisherMessage and
+// OnPeerMessage need the keys of messages.
+func (s *Observer) ControlsAccess() bool {
+	return s.accessControl != nil
+}
+
+// NeedsKeys returns whether OnPublisherMessage and OnPeerMessage need the keys of messages, as access is controlled
+// or subscribers want only the messages with their keys.
+func (s *Observer) NeedsKeys() bool {
+	return s.ControlsAccess() || s.keySubscribers.Load() > 0
+}
+
+// Deduplicates returns whether bridged messages are deduplicated, in which case OnPublisherMessage needs their
+// origins and IDs.
+func (s *Observer) Deduplicates() bool {
+	return s.deduplicator != nil
+}
+
+// Traces returns whether messages are traced, in which case OnPublisherMessage needs their trace contexts.
+func (s *Observer) Traces() bool {
+	return s.tracer != nil
+}
+
+// OnSubscriberConnected adds the subscriber, or returns a QuotaError if its tenant is at its subscriptions quota, an
+// AccessDeniedError if the client may not subscribe to anything, or ErrDuplicateID if another subscriber has its ID.
+// The subscriber is not added on error, so OnSubscriberDisconnected must not be called then.
 func (s *Observer) OnSubscriberConnected(subscriber Subscriber) error {
+	if err := s.authorizeConnect(subscriber, ACLActionSubscribe); err != nil {
+		return err
+	}
+	tenant := tenantOf(subscriber)
+	if s.tenants != nil {
+		if err := s.tenants.Subscribe(tenant); err != nil {
+			return err
+		}
+	}
 	if err := s.subscriberPool.Add(subscriber); err != nil {
+		if s.tenants != nil {
+			s.tenants.Unsubscribe(tenant)
+		}
 		return errors.Wrap(err, "add a subscriber to a subscriber pool")
 	}
 
-	s.logger.Debug("Subscriber connected", zap.String("subscriber_id", subscriber.GetID()))
+	s.logger.Debug("Subscriber connected", zap.String("subscriber_id", subscriber.GetID()), zap.String("tenant", tenant))
 
 	// The server notifies publishers if a subscriber has connected
-	for _, publisher := range s.publisherPool.GetAll() {
-		if err := publisher.NotifyExistsSubscriber(); err != nil {
-			// Don't fail, allow other publishers to receive messages
-			s.logger.Warn("publisher.NotifyExistsSubscriber", zap.Error(err))
-		}
+	if hasDemand(subscriber) {
+		s.notifyExistsSubscriber(tenant)
 	}
+	s.notifySubscribersChanged()
 
 	return nil
 }
 
+// OnSubscriberDisconnected removes a subscriber that OnSubscriberConnected has added.
 func (s *Observer) OnSubscriberDisconnected(subscriber Subscriber) error {
-	s.subscriberPool.Remove(subscriber.GetID())
+	tenant := tenantOf(subscriber)
+	if s.subscriberPool.Remove(subscriber) {
+		if s.tenants != nil {
+			s.tenants.Unsubscribe(tenant) // Only once, and only if the subscriber was counted
+		}
+		if keyOf(subscriber) != "" {
+			s.keySubscribers.Add(-1)
+		}
+	}
 
 	// If no subscribers are connected, the server must inform the publishers
-	if s.subscriberPool.IsEmpty() {
-		s.logger.Debug("Last subscriber disconnected, notifying publishers")
-
-		for _, publisher := range s.publisherPool.GetAll() {
-			if err := publisher.NotifyNoSubscribers(); err != nil {
-				// Don't fail, allow other publishers to receive messages
-				s.logger.Warn("publisher.NotifyNoSubscribers", zap.Error(err))
-			}
-		}
+	if !s.existsSubscriber(tenant) {
+		s.logger.Debug("Last subscriber disconnected, notifying publishers", zap.String("tenant", tenant))
+		s.notifyNoSubscribers(tenant)
 	}
+	s.notifySubscribersChanged()
 
 	return nil
 }
 
+// OnSubscribe is called once a KeySubscriber subscribes to the key it returns, and returns an AccessDeniedError if the
+// client may not subscribe to it. Either way the subscriber receives only the messages with the key that it may
+// receive.
+func (s *Observer) OnSubscribe(subscriber Subscriber, key string) error {
+	if key != "" && keyOf(subscriber) == key {
+		s.keySubscribers.Add(1)
+	}
+	if s.accessControl == nil {
+		return nil
+	}
+	identity, ok := identityOf(subscriber)
+	if !ok {
+		return nil
+	}
+	return s.accessControl.AuthorizeSubscribe(identity, key)
+}
+
+// authorizeConnect returns an AccessDeniedError if the connector is of a client that may not take the action on
+// anything.
+func (s *Observer) authorizeConnect(connector any, action string) error {
+	if s.accessControl == nil {
+		return nil
+	}
+	identity, ok := identityOf(connector)
+	if !ok {
+		return nil
+	}
+	return s.accessControl.AuthorizeConnect(identity, action)
+}
+
+// OnSubscriberDemandChanged is called when a ConditionalSubscriber starts or stops wanting messages.
+func (s *Observer) OnSubscriberDemandChanged(subscriber ConditionalSubscriber) {
+	s.logger.Debug("Subscriber demand changed",
+		zap.String("subscriber_id", subscriber.GetID()),
+		zap.Bool("has_demand", subscriber.HasDemand()))
+
+	tenant := tenantOf(subscriber)
+	if s.existsSubscriber(tenant) {
+		s.notifyExistsSubscriber(tenant)
+	} else {
+		s.notifyNoSubscribers(tenant)
+	}
+	s.notifySubscribersChanged()
+}
+
+// AddSubscribersChangedListener registers fn to be called whenever a subscriber connects, disconnects or changes
+// its demand.
+func (s *Observer) AddSubscribersChangedListener(fn func()) {
+	s.listenersMu.Lock()
+	defer s.listenersMu.Unlock()
+	s.listeners = append(s.listeners, fn)
+}
+
+// HasDemandExcluding returns whether any subscriber of DefaultTenant other than the given one wants messages, as
+// only those receive messages from other servers. Subscribers that bridge to the server brokerID are excluded too,
+// as their demand is that of the server itself, unless brokerID is empty.
+func (s *Observer) HasDemandExcluding(subscriberID string, brokerID string) bool {
+	for _, subscriber := range s.subscriberPool.GetAll() {
+		if subscriber.GetID() == subscriberID || tenantOf(subscriber) != DefaultTenant || !hasDemand(subscriber) {
+			continue
+		}
+		if bridged, ok := subscriber.(BridgedSubscriber); ok && brokerID != "" && bridged.BridgedFrom() == brokerID {
+			continue
+		}
+		return true
+	}
+	return false
+}
+
+// OnPublisherConnected adds the publisher and tells it whether there are subscribers, or returns
+// an AccessDeniedError if the client may not publish anything, or ErrDuplicateID if another publisher has its ID.
+// The publisher is not added on error, so OnPublisherDisconnected must not be called then.
 func (s *Observer) OnPublisherConnected(publisher Publisher) error {
+	if err := s.authorizeConnect(publisher, ACLActionPublish); err != nil {
+		return err
+	}
 	if err := s.publisherPool.Add(publisher); err != nil {
 		return errors.Wrap(err, "add a publisher to a publisher pool")
 	}
 
 	// Notify the publisher right away about whether or not there are subscribers
-	if !s.subscriberPool.IsEmpty() {
+	if s.existsSubscriber(tenantOf(publisher)) {
 		s.logger.Debug("Subscribers exist, notifying the new publisher")
 		if err := publisher.NotifyExistsSubscriber(); err != nil {
+			s.publisherPool.Remove(publisher)
 			return errors.Wrap(err, "NotifyExistsSubscriber")
 		}
 	} else {
 		s.logger.Debug("No subscribers exist, notifying the new publisher")
 		if err := publisher.NotifyNoSubscribers(); err != nil {
+			s.publisherPool.Remove(publisher)
 			return errors.Wrap(err, "NotifyNoSubscribers")
 		}
 	}
@@ -83,20 +248,321 @@ func (s *Observer) OnPublisherConnected(publisher Publisher) error {
 	return nil
 }
 
+// OnPublisherDisconnected removes a publisher that OnPublisherConnected has added.
 func (s *Observer) OnPublisherDisconnected(publisher Publisher) {
 	s.logger.Debug("Publisher disconnected", zap.String("publisher_id", publisher.GetID()))
-	s.publisherPool.Remove(publisher.GetID())
+	s.publisherPool.Remove(publisher)
+}
+
+// MessageOrigin tells who published a message passed to Observer.OnPublisherMessage.
+type MessageOrigin struct {
+	Tenant   string
+	Identity string // Identity of the client, empty if the client is anonymous
+	Trusted  bool   // Whether the publisher is exempt from access control, e.g. a bridge to another server
+	Key      string // Key of the message, see sdk.HeaderKey, needed only if Observer.NeedsKeys
+
+	// OriginBroker and MessageID are the sdk.HeaderOrigin and sdk.HeaderMessageID of the message, needed only if
+	// Observer.Deduplicates. Messages without either are not deduplicated.
+	OriginBroker string
+	MessageID    string
+
+	// Trace is the trace context of the message, see sdk.HeaderTraceparent, and ReceivedAt when the server received
+	// it, needed only if Observer.Traces. Messages without a sampled trace context are not traced.
+	Trace      tracing.SpanContext
+	ReceivedAt time.Time
+}
+
+// OnPublisherMessage is called when the server receives a message from a publisher of the tenant of origin, and
+// delivers it to the subscribers of the tenant. The message may be delivered asynchronously, in which case it is
+// retained until then, so the caller releases its reference once this returns. Returns a QuotaError if the message
+// is dropped as the tenant is over its message rate or stored bytes quota, or an AccessDeniedError if the publisher
+// may not publish the key.
+func (s *Observer) OnPublisherMessage(origin MessageOrigin, message *buffer.Buffer) error {
+	span := s.tracer.StartAt(tracing.SpanReceive, tracing.SpanKindServer, origin.Trace, origin.ReceivedAt)
+	err := s.onPublisherMessage(origin, message, span)
+	if span != nil {
+		span.SetAttribute(tracing.AttributeTenant, origin.Tenant)
+		span.SetAttribute(tracing.AttributePublisher, origin.Identity)
+		span.SetAttribute(tracing.AttributeMessageKey, origin.Key)
+		span.SetIntAttribute(tracing.AttributeMessageSize, int64(message.Len()))
+		span.SetError(err)
+		span.End()
+	}
+	return err
+}
+
+// onPublisherMessage is OnPublisherMessage, recording the span of routing the message as a child of the optional
+// span of receiving it.
+func (s *Observer) onPublisherMessage(origin MessageOrigin, message *buffer.Buffer, span *tracing.Span) error {
+	if s.accessControl != nil && !origin.Trusted {
+		if err := s.accessControl.AuthorizePublish(origin.Identity, origin.Key); err != nil {
+			return err
+		}
+	}
+
+	if s.deduplicator != nil && origin.OriginBroker != "" && origin.MessageID != "" &&
+		s.deduplicator.Seen(origin.OriginBroker, origin.MessageID) {
+		s.logger.Debug("Dropping a copy of a bridged message",
+			zap.String("origin", origin.OriginBroker), zap.String("message_id", origin.MessageID))
+		return nil
+	}
+
+	tenant := origin.Tenant
+	var delivered func()
+	if s.tenants != nil {
+		size := message.Len()
+		if err := s.tenants.Publish(tenant, size, time.Now()); err != nil {
+			return err
+		}
+		if s.tenants.NeedsDelivered(tenant) {
+			delivered = func() { s.tenants.Delivered(tenant, size) }
+		}
+	}
+	filter := s.keyFilter(s.accessFilter(s.tenantFilter(tenant), origin.Key), origin.Key)
+	return s.dispatch(message, filter, span, delivered)
 }
 
-// OnPublisherMessage is called when the server receives a message from a publisher.
-func (s *Observer) OnPublisherMessage(message []byte) error {
-	s.logger.Debug("Sending message from publisher to subscribers")
+// dispatch delivers the message to the subscribers for which filter returns true, and calls the optional delivered
+// once done. If span is not nil, the routing of the message is recorded as a child of it, and each write to a
+// subscriber as a child of that.
+func (s *Observer) dispatch(
+	message *buffer.Buffer,
+	filter func(Subscriber) bool,
+	span *tracing.Span,
+	delivered func(),
+) error {
+	if s.metrics != nil {
+		s.metrics.received(message.Len())
+		start, next := time.Now(), delivered
+		delivered = func() {
+			s.metrics.fannedOut(start)
+			if next != nil {
+				next()
+			}
+		}
+	}
+	route := span.StartChild(tracing.SpanRoute, tracing.SpanKindInternal)
+	if route != nil {
+		next := delivered
+		delivered = func() {
+			route.End()
+			if next != nil {
+				next()
+			}
+		}
+	}
+	if s.dispatcher == nil {
+		deliver(message.Bytes(), s.subscriberPool.GetAll(), filter, s.metrics, route, s.logger)
+		if delivered != nil {
+			delivered()
+		}
+		return nil
+	}
+	if err := s.dispatcher.Dispatch(message, filter, route, delivered); err != nil {
+		return errors.Wrap(err, "Dispatch")
+	}
+	return nil
+}
 
+// tenantFilter returns a filter for the subscribers of the tenant that want messages. Filters are kept per tenant
+// rather than built per message, which would allocate.
+func (s *Observer) tenantFilter(tenant string) func(Subscriber) bool {
+	if tenant == DefaultTenant {
+		return isDefaultTenantWithDemand
+	}
+	if filter, ok := s.tenantFilters.Load(tenant); ok {
+		return filter.(func(Subscriber) bool)
+	}
+	filter, _ := s.tenantFilters.LoadOrStore(tenant, func(subscriber Subscriber) bool {
+		return tenantOf(subscriber) == tenant && hasDemand(subscriber)
+	})
+	return filter.(func(Subscriber) bool)
+}
+
+// keyFilter narrows the filter to the subscribers that want messages with the key, see KeySubscriber.
+func (s *Observer) keyFilter(filter func(Subscriber) bool, key string) func(Subscriber) bool {
+	if s.keySubscribers.Load() == 0 {
+		return filter // Without allocating a filter per message
+	}
+	return func(subscriber Subscriber) bool {
+		if !filter(subscriber) {
+			return false
+		}
+		subscribed := keyOf(subscriber)
+		return subscribed == "" || subscribed == key
+	}
+}
+
+// keyOf returns the key of a KeySubscriber, empty for other subscribers.
+func keyOf(subscriber Subscriber) string {
+	if keySubscriber, ok := subscriber.(KeySubscriber); ok {
+		return keySubscriber.GetKey()
+	}
+	return ""
+}
+
+// accessFilter narrows the filter to the subscribers that may receive messages with the key, if access is controlled.
+func (s *Observer) accessFilter(filter func(Subscriber) bool, key string) func(Subscriber) bool {
+	if s.accessControl == nil {
+		return filter // Without allocating a filter per message
+	}
+	return func(subscriber Subscriber) bool {
+		if !filter(subscriber) {
+			return false
+		}
+		identity, ok := identityOf(subscriber)
+		return !ok || s.accessControl.CanReceive(identity, key)
+	}
+}
+
+// SubscriberExistence returns whether any subscriber wants messages, as the publishers of each tenant have been
+// told, for DefaultTenant and the tenants of the connected publishers and subscribers.
+func (s *Observer) SubscriberExistence() map[string]bool {
+	existence := map[string]bool{DefaultTenant: false}
+	for _, publisher := range s.publisherPool.GetAll() {
+		existence[tenantOf(publisher)] = false
+	}
+	for _, subscriber := range s.subscriberPool.GetAll() {
+		tenant := tenantOf(subscriber)
+		existence[tenant] = existence[tenant] || hasDemand(subscriber)
+	}
+	return existence
+}
+
+// existsSubscriber returns whether any subscriber of the tenant wants messages.
+func (s *Observer) existsSubscriber(tenant string) bool {
 	for _, subscriber := range s.subscriberPool.GetAll() {
-		if err := subscriber.SendMessageToSubscriber(message); err != nil {
+		if tenantOf(subscriber) == tenant && hasDemand(subscriber) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Observer) notifyExistsSubscriber(tenant string) {
+	for _, publisher := range s.publisherPool.GetAll() {
+		if tenantOf(publisher) != tenant {
+			continue
+		}
+		if err := publisher.NotifyExistsSubscriber(); err != nil {
 			// Don't fail, allow other publishers to receive messages
-			s.logger.Warn("SendMessageToSubscriber", zap.Error(err))
+			s.logger.Warn("publisher.NotifyExistsSubscriber", zap.Error(err))
 		}
 	}
-	return nil
+}
+
+func (s *Observer) notifyNoSubscribers(tenant string) {
+	for _, publisher := range s.publisherPool.GetAll() {
+		if tenantOf(publisher) != tenant {
+			continue
+		}
+		if err := publisher.NotifyNoSubscribers(); err != nil {
+			// Don't fail, allow other publishers to receive messages
+			s.logger.Warn("publisher.NotifyNoSubscribers", zap.Error(err))
+		}
+	}
+}
+
+func (s *Observer) notifySubscribersChanged() {
+	s.listenersMu.Lock()
+	listeners := append([]func(){}, s.listeners...)
+	s.listenersMu.Unlock()
+
+	for _, fn := range listeners {
+		fn()
+	}
+}
+
+// isDefaultTenantWithDemand returns whether the subscriber is of DefaultTenant and wants messages.
+func isDefaultTenantWithDemand(subscriber Subscriber) bool {
+	return tenantOf(subscriber) == DefaultTenant && hasDemand(subscriber)
+}
+
+// hasDemand returns whether the subscriber wants messages, which all but ConditionalSubscriber always do.
+func hasDemand(subscriber Subscriber) bool {
+	if conditional, ok := subscriber.(ConditionalSubscriber); ok {
+		return conditional.HasDemand()
+	}
+	return true
+}
+
+// OnPeerMessage is called when the server receives a message with the key from another server of the cluster, which
+// has authenticated by its certificate. The message is sent to the subscribers of DefaultTenant of this server only.
+// The key is needed only if NeedsKeys.
+func (s *Observer) OnPeerMessage(key string, message *buffer.Buffer) error {
+	return s.dispatch(message, s.keyFilter(s.accessFilter(isLocalWithDemand, key), key), nil, nil)
+}
+
+// isLocalWithDemand returns whether the subscriber is of DefaultTenant, not another server of the cluster, and wants
+// messages.
+func isLocalWithDemand(subscriber Subscriber) bool {
+	if _, ok := subscriber.(PeerSubscriber); ok {
+		return false
+	}
+	return isDefaultTenantWithDemand(subscriber)
+}
+
+// LocalNodeState returns the state of this server for other servers of the cluster: the publishers and
+// subscribers connected to it, other than peers, and whether any of the subscribers of DefaultTenant want messages.
+func (s *Observer) LocalNodeState(nodeID string) NodeState {
+	state := NodeState{NodeID: nodeID}
+	for _, publisher := range s.publisherPool.GetAll() {
+		state.Publishers = append(state.Publishers, publisher.GetID())
+	}
+	for _, subscriber := range s.subscriberPool.GetAll() {
+		if _, ok := subscriber.(PeerSubscriber); ok {
+			continue
+		}
+		state.Subscribers = append(state.Subscribers, subscriber.GetID())
+		state.HasDemand = state.HasDemand || isDefaultTenantWithDemand(subscriber)
+	}
+	sort.Strings(state.Publishers)
+	sort.Strings(state.Subscribers)
+	return state
+}
+
+// Shutdown notifies all publishers and subscribers that the server is going away and waits for them to disconnect.
+// Once ctx is done, the remaining ones are disconnected.
+func (s *Observer) Shutdown(ctx context.Context) {
+	// Publishers first, so that they stop publishing before subscribers stop receiving
+	for _, publisher := range s.publisherPool.GetAll() {
+		if err := publisher.NotifyGoingAway(); err != nil {
+			s.logger.Warn("publisher.NotifyGoingAway", zap.Error(err))
+		}
+	}
+	if s.dispatcher != nil {
+		// Deliver the queued messages before the message streams close
+		if err := s.dispatcher.Flush(ctx); err != nil {
+			s.logger.Warn("Drain deadline reached before the queued messages were delivered", zap.Error(err))
+		}
+	}
+	for _, subscriber := range s.subscriberPool.GetAll() {
+		if err := subscriber.NotifyGoingAway(); err != nil {
+			s.logger.Warn("subscriber.NotifyGoingAway", zap.Error(err))
+		}
+	}
+
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+
+	for !s.publisherPool.IsEmpty() || !s.subscriberPool.IsEmpty() {
+		select {
+		case <-ctx.Done():
+			s.logger.Info("Drain deadline reached, disconnecting the remaining publishers and subscribers")
+			for _, publisher := range s.publisherPool.GetAll() {
+				if err := publisher.Disconnect(); err != nil {
+					s.logger.Warn("publisher.Disconnect", zap.Error(err))
+				}
+			}
+			for _, subscriber := range s.subscriberPool.GetAll() {
+				if err := subscriber.Disconnect(); err != nil {
+					s.logger.Warn("subscriber.Disconnect", zap.Error(err))
+				}
+			}
+			return
+		case <-ticker.C:
+		}
+	}
+
+	s.logger.Info("All publishers and subscribers disconnected")
 }
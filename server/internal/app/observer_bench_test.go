@@ -0,0 +1,71 @@
+package app_test
+
+import (
+	"context"
+	"fmt"
+	"github.com/varfrog/quicpubsub/pkg/buffer"
+	"github.com/varfrog/quicpubsub/server/internal/app"
+	"go.uber.org/zap"
+	"runtime"
+	"strconv"
+	"testing"
+)
+
+// BenchmarkObserver_OnPublisherMessage measures the fan-out of messages to in-memory subscribers, without
+// the network. Run with -benchtime=10000x to publish 10k messages, msgs/s is the sustained publishing rate.
+// Messages are taken from the buffer pool and released as by the server, so allocs/op shows the allocations
+// per message regardless of the number of subscribers.
+func BenchmarkObserver_OnPublisherMessage(b *testing.B) {
+	for _, subscribers := range []int{100, 1000, 10000} {
+		b.Run(fmt.Sprintf("inline/subscribers=%d", subscribers), func(b *testing.B) {
+			benchmarkOnPublisherMessage(b, subscribers, 0)
+		})
+		b.Run(fmt.Sprintf("dispatcher/subscribers=%d", subscribers), func(b *testing.B) {
+			benchmarkOnPublisherMessage(b, subscribers, runtime.NumCPU())
+		})
+	}
+}
+
+// benchmarkOnPublisherMessage publishes b.N messages to the given number of subscribers, delivered inline if
+// workers is 0 and by a Dispatcher otherwise.
+func benchmarkOnPublisherMessage(b *testing.B, subscribers int, workers int) {
+	pool := app.NewSubscriberPool()
+	counting := make([]*countingSubscriber, subscribers)
+	for i := range counting {
+		counting[i] = &countingSubscriber{id: strconv.Itoa(i)}
+		if err := pool.Add(counting[i]); err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	var dispatcher *app.Dispatcher
+	if workers > 0 {
+		dispatcher = app.NewDispatcher(app.DispatcherConfig{Workers: workers, QueueSize: 1024}, pool, nil, zap.NewNop())
+		defer dispatcher.Stop()
+	}
+	observer := app.NewObserver(app.NewPublisherPool(), pool, dispatcher, nil, nil, nil, nil, nil, zap.NewNop())
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		message := buffer.Get(256)
+		if err := observer.OnPublisherMessage(app.MessageOrigin{Tenant: app.DefaultTenant}, message); err != nil {
+			b.Fatal(err)
+		}
+		message.Release()
+	}
+	if dispatcher != nil {
+		_ = dispatcher.Flush(context.Background())
+	}
+	b.StopTimer()
+
+	var delivered int64
+	for _, subscriber := range counting {
+		delivered += subscriber.count.Load()
+	}
+	if delivered != int64(b.N*subscribers) {
+		b.Fatalf("delivered %d messages, want %d", delivered, b.N*subscribers)
+	}
+	b.ReportMetric(float64(b.N)/b.Elapsed().Seconds(), "msgs/s")
+	b.ReportMetric(float64(b.N*subscribers)/b.Elapsed().Seconds(), "deliveries/s")
+}
@@ -1,12 +1,17 @@
 package app_test
 
 import (
+	"context"
+	"errors"
 	. "github.com/onsi/gomega"
+	"github.com/varfrog/quicpubsub/pkg/buffer"
+	"github.com/varfrog/quicpubsub/pkg/sdk"
 	"github.com/varfrog/quicpubsub/server/internal/app"
 	mocks "github.com/varfrog/quicpubsub/server/internal/app/mocks"
 	"go.uber.org/mock/gomock"
 	"go.uber.org/zap"
 	"testing"
+	"time"
 )
 
 func TestObserver_OnPublisherConnected_subscriberExists(t *testing.T) {
@@ -27,7 +32,7 @@ func TestObserver_OnPublisherConnected_subscriberExists(t *testing.T) {
 	publisher.EXPECT().GetID().AnyTimes().Return("1")
 	publisher.EXPECT().NotifyExistsSubscriber().Times(1) // Assertion
 
-	observer := app.NewObserver(app.NewPublisherPool(), subscriberPool, zap.NewNop())
+	observer := app.NewObserver(app.NewPublisherPool(), subscriberPool, nil, nil, nil, nil, nil, nil, zap.NewNop())
 	g.Expect(observer.OnPublisherConnected(publisher)).To(Succeed())
 }
 
@@ -44,7 +49,7 @@ func TestObserver_OnPublisherConnected_subscriberNotExists(t *testing.T) {
 	publisher.EXPECT().GetID().AnyTimes().Return("1")
 	publisher.EXPECT().NotifyNoSubscribers().Times(1) // Assertion
 
-	observer := app.NewObserver(app.NewPublisherPool(), subscriberPool, zap.NewNop())
+	observer := app.NewObserver(app.NewPublisherPool(), subscriberPool, nil, nil, nil, nil, nil, nil, zap.NewNop())
 	g.Expect(observer.OnPublisherConnected(publisher)).To(Succeed())
 }
 
@@ -55,7 +60,7 @@ func TestObserver_OnPublisherDisconnected(t *testing.T) {
 	mockPublisher := mocks.NewMockPublisher(ctrl)
 	mockPublisher.EXPECT().GetID().AnyTimes().Return("1")
 
-	observer := app.NewObserver(app.NewPublisherPool(), app.NewSubscriberPool(), zap.NewNop())
+	observer := app.NewObserver(app.NewPublisherPool(), app.NewSubscriberPool(), nil, nil, nil, nil, nil, nil, zap.NewNop())
 	observer.OnPublisherDisconnected(mockPublisher)
 }
 
@@ -84,8 +89,8 @@ func TestObserver_OnPublisherMessage(t *testing.T) {
 	publisher := mocks.NewMockPublisher(ctrl)
 	publisher.EXPECT().GetID().AnyTimes().Return("1")
 
-	observer := app.NewObserver(app.NewPublisherPool(), subscriberPool, zap.NewNop())
-	g.Expect(observer.OnPublisherMessage(message)).To(Succeed())
+	observer := app.NewObserver(app.NewPublisherPool(), subscriberPool, nil, nil, nil, nil, nil, nil, zap.NewNop())
+	g.Expect(observer.OnPublisherMessage(app.MessageOrigin{Tenant: app.DefaultTenant}, buffer.Wrap(message))).To(Succeed())
 }
 
 func TestObserver_OnSubscriberConnected(t *testing.T) {
@@ -109,7 +114,7 @@ func TestObserver_OnSubscriberConnected(t *testing.T) {
 	subscriberPool := app.NewSubscriberPool()
 
 	// AcceptPublishers the test
-	observer := app.NewObserver(publisherPool, subscriberPool, zap.NewNop())
+	observer := app.NewObserver(publisherPool, subscriberPool, nil, nil, nil, nil, nil, nil, zap.NewNop())
 	g.Expect(observer.OnSubscriberConnected(subscriber)).To(Succeed())
 	g.Expect(subscriberPool.IsEmpty()).To(BeFalse())
 }
@@ -131,6 +136,401 @@ func TestObserver_OnSubscriberDisconnected_LastSubscriberDisconnects(t *testing.
 	publisherPool := app.NewPublisherPool()
 	g.Expect(publisherPool.Add(publisher)).To(Succeed())
 
-	observer := app.NewObserver(publisherPool, app.NewSubscriberPool(), zap.NewNop())
+	observer := app.NewObserver(publisherPool, app.NewSubscriberPool(), nil, nil, nil, nil, nil, nil, zap.NewNop())
 	g.Expect(observer.OnSubscriberDisconnected(mockSubscriber)).To(Succeed())
 }
+
+func TestObserver_ConditionalSubscriberWithoutDemand(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	g := NewGomegaWithT(t)
+
+	// A subscriber that does not want messages
+	subscriber := mocks.NewMockConditionalSubscriber(ctrl)
+	subscriber.EXPECT().GetID().AnyTimes().Return("1")
+	subscriber.EXPECT().HasDemand().AnyTimes().Return(false)
+	subscriber.EXPECT().SendMessageToSubscriber(gomock.Any()).Times(0) // Assertion
+
+	publisher := mocks.NewMockPublisher(ctrl)
+	publisher.EXPECT().GetID().AnyTimes().Return("1")
+	publisher.EXPECT().NotifyNoSubscribers().Times(1) // Assertion
+	publisher.EXPECT().NotifyExistsSubscriber().Times(0)
+
+	observer := app.NewObserver(app.NewPublisherPool(), app.NewSubscriberPool(), nil, nil, nil, nil, nil, nil, zap.NewNop())
+	g.Expect(observer.OnSubscriberConnected(subscriber)).To(Succeed())
+	g.Expect(observer.OnPublisherConnected(publisher)).To(Succeed())
+	g.Expect(observer.OnPublisherMessage(app.MessageOrigin{Tenant: app.DefaultTenant}, buffer.Wrap([]byte("foo")))).To(Succeed())
+}
+
+func TestObserver_OnSubscriberDemandChanged(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	g := NewGomegaWithT(t)
+
+	hasDemand := false
+	subscriber := mocks.NewMockConditionalSubscriber(ctrl)
+	subscriber.EXPECT().GetID().AnyTimes().Return("1")
+	subscriber.EXPECT().HasDemand().AnyTimes().DoAndReturn(func() bool { return hasDemand })
+
+	publisherPool := app.NewPublisherPool()
+	publisher := mocks.NewMockPublisher(ctrl)
+	publisher.EXPECT().GetID().AnyTimes().Return("1")
+	g.Expect(publisherPool.Add(publisher)).To(Succeed())
+
+	observer := app.NewObserver(publisherPool, app.NewSubscriberPool(), nil, nil, nil, nil, nil, nil, zap.NewNop())
+
+	changes := 0
+	observer.AddSubscribersChangedListener(func() { changes++ })
+
+	g.Expect(observer.OnSubscriberConnected(subscriber)).To(Succeed())
+	g.Expect(observer.HasDemandExcluding("", "")).To(BeFalse())
+
+	// Publishers are notified as the demand changes
+	hasDemand = true
+	publisher.EXPECT().NotifyExistsSubscriber().Times(1) // Assertion
+	observer.OnSubscriberDemandChanged(subscriber)
+	g.Expect(observer.HasDemandExcluding("", "")).To(BeTrue())
+	g.Expect(observer.HasDemandExcluding("1", "")).To(BeFalse())
+
+	hasDemand = false
+	publisher.EXPECT().NotifyNoSubscribers().Times(1) // Assertion
+	observer.OnSubscriberDemandChanged(subscriber)
+
+	g.Expect(changes).To(Equal(3))
+}
+
+func TestObserver_OnPeerMessage(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	g := NewGomegaWithT(t)
+
+	message := []byte("foo")
+
+	subscriber := mocks.NewMockSubscriber(ctrl)
+	subscriber.EXPECT().GetID().AnyTimes().Return("1")
+	subscriber.EXPECT().SendMessageToSubscriber(message).Times(1) // Assertion
+
+	// Messages from peers are not sent on to other peers
+	peer := mocks.NewMockPeerSubscriber(ctrl)
+	peer.EXPECT().GetID().AnyTimes().Return("peer-1")
+	peer.EXPECT().HasDemand().AnyTimes().Return(true)
+	peer.EXPECT().SendMessageToSubscriber(gomock.Any()).Times(0) // Assertion
+
+	subscriberPool := app.NewSubscriberPool()
+	g.Expect(subscriberPool.Add(subscriber)).To(Succeed())
+	g.Expect(subscriberPool.Add(peer)).To(Succeed())
+
+	observer := app.NewObserver(app.NewPublisherPool(), subscriberPool, nil, nil, nil, nil, nil, nil, zap.NewNop())
+	g.Expect(observer.OnPeerMessage("", buffer.Wrap(message))).To(Succeed())
+
+	// Peers are not part of the state of this server
+	g.Expect(observer.LocalNodeState("node-1")).To(Equal(app.NodeState{
+		NodeID:      "node-1",
+		Subscribers: []string{"1"},
+		HasDemand:   true,
+	}))
+}
+
+func TestObserver_Shutdown_DisconnectsRemainingOnDeadline(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	g := NewGomegaWithT(t)
+
+	// Initialize connectors which do not disconnect by themselves
+	publisher := mocks.NewMockPublisher(ctrl)
+	publisher.EXPECT().GetID().AnyTimes().Return("1")
+	publisher.EXPECT().NotifyGoingAway().Times(1) // Assertion
+	publisher.EXPECT().Disconnect().Times(1)      // Assertion
+
+	subscriber := mocks.NewMockSubscriber(ctrl)
+	subscriber.EXPECT().GetID().AnyTimes().Return("1")
+	subscriber.EXPECT().NotifyGoingAway().Times(1) // Assertion
+	subscriber.EXPECT().Disconnect().Times(1)      // Assertion
+
+	publisherPool := app.NewPublisherPool()
+	g.Expect(publisherPool.Add(publisher)).To(Succeed())
+	subscriberPool := app.NewSubscriberPool()
+	g.Expect(subscriberPool.Add(subscriber)).To(Succeed())
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+	defer cancel()
+
+	observer := app.NewObserver(publisherPool, subscriberPool, nil, nil, nil, nil, nil, nil, zap.NewNop())
+	observer.Shutdown(ctx)
+}
+
+func TestObserver_Shutdown_ReturnsOnceAllDisconnect(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	g := NewGomegaWithT(t)
+
+	subscriberPool := app.NewSubscriberPool()
+	observer := app.NewObserver(app.NewPublisherPool(), subscriberPool, nil, nil, nil, nil, nil, nil, zap.NewNop())
+
+	// Initialize a subscriber which disconnects when told that the server is going away
+	subscriber := mocks.NewMockSubscriber(ctrl)
+	subscriber.EXPECT().GetID().AnyTimes().Return("1")
+	subscriber.EXPECT().NotifyGoingAway().DoAndReturn(func() error {
+		go func() {
+			_ = observer.OnSubscriberDisconnected(subscriber)
+		}()
+		return nil
+	})
+	subscriber.EXPECT().Disconnect().Times(0) // Assertion
+
+	g.Expect(subscriberPool.Add(subscriber)).To(Succeed())
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	observer.Shutdown(ctx)
+	g.Expect(ctx.Err()).To(BeNil()) // Assertion: returned before the deadline
+	g.Expect(subscriberPool.IsEmpty()).To(BeTrue())
+}
+
+// tenantPublisher is a mock publisher of a tenant.
+type tenantPublisher struct {
+	*mocks.MockPublisher
+	tenant string
+}
+
+func (p tenantPublisher) GetTenant() string { return p.tenant }
+
+// tenantSubscriber is a subscriber of a tenant that records the messages it receives.
+type tenantSubscriber struct {
+	*recordingSubscriber
+	tenant string
+}
+
+func (s tenantSubscriber) GetTenant() string { return s.tenant }
+
+func TestObserver_IsolatesTenants(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	g := NewGomegaWithT(t)
+
+	// A publisher of tenant a is told only about the subscribers of tenant a
+	publisherA := tenantPublisher{MockPublisher: mocks.NewMockPublisher(ctrl), tenant: "a"}
+	publisherA.EXPECT().GetID().AnyTimes().Return("a")
+	publisherA.EXPECT().NotifyNoSubscribers().Times(1)    // Assertion: on connecting, b has a subscriber by then
+	publisherA.EXPECT().NotifyExistsSubscriber().Times(1) // Assertion: once a has a subscriber
+
+	// So is a publisher of the default tenant
+	publisher := mocks.NewMockPublisher(ctrl)
+	publisher.EXPECT().GetID().AnyTimes().Return("default")
+	publisher.EXPECT().NotifyNoSubscribers().Times(1) // Assertion: on connecting only
+
+	subscriberA := tenantSubscriber{recordingSubscriber: &recordingSubscriber{id: "1"}, tenant: "a"}
+	subscriberB := tenantSubscriber{recordingSubscriber: &recordingSubscriber{id: "2"}, tenant: "b"}
+
+	observer := app.NewObserver(app.NewPublisherPool(), app.NewSubscriberPool(), nil, nil, nil, nil, nil, nil, zap.NewNop())
+	g.Expect(observer.OnSubscriberConnected(subscriberB)).To(Succeed())
+	g.Expect(observer.OnPublisherConnected(publisherA)).To(Succeed())
+	g.Expect(observer.OnPublisherConnected(publisher)).To(Succeed())
+	g.Expect(observer.OnSubscriberConnected(subscriberA)).To(Succeed())
+
+	// Messages are delivered only to the subscribers of the tenant of the publisher
+	g.Expect(observer.OnPublisherMessage(app.MessageOrigin{Tenant: "a"}, buffer.Wrap([]byte("for a")))).To(Succeed())
+	g.Expect(observer.OnPublisherMessage(app.MessageOrigin{Tenant: "b"}, buffer.Wrap([]byte("for b")))).To(Succeed())
+	g.Expect(observer.OnPublisherMessage(app.MessageOrigin{Tenant: app.DefaultTenant}, buffer.Wrap([]byte("for nobody")))).To(Succeed())
+	g.Expect(subscriberA.received()).To(Equal([]string{"for a"}))
+	g.Expect(subscriberB.received()).To(Equal([]string{"for b"}))
+
+	// Other servers only learn about the demand of the default tenant
+	g.Expect(observer.HasDemandExcluding("", "")).To(BeFalse())
+	g.Expect(observer.LocalNodeState("node").HasDemand).To(BeFalse())
+}
+
+func TestObserver_EnforcesTenantQuotas(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	tenants := app.NewTenants(map[string]app.TenantQuota{
+		"a": {MaxSubscriptions: 1, MaxMessageRate: 2},
+	})
+	observer := app.NewObserver(app.NewPublisherPool(), app.NewSubscriberPool(), nil, tenants, nil, nil, nil, nil, zap.NewNop())
+
+	subscriber := tenantSubscriber{recordingSubscriber: &recordingSubscriber{id: "1"}, tenant: "a"}
+	g.Expect(observer.OnSubscriberConnected(subscriber)).To(Succeed())
+
+	var quotaErr *app.QuotaError
+	other := tenantSubscriber{recordingSubscriber: &recordingSubscriber{id: "2"}, tenant: "a"}
+	g.Expect(errors.As(observer.OnSubscriberConnected(other), &quotaErr)).To(BeTrue())
+	g.Expect(quotaErr.Quota).To(Equal(sdk.QuotaSubscriptions))
+
+	// The slot is freed once the subscriber disconnects
+	g.Expect(observer.OnSubscriberDisconnected(subscriber)).To(Succeed())
+	g.Expect(observer.OnSubscriberConnected(other)).To(Succeed())
+
+	// Messages beyond the message rate are dropped
+	g.Expect(observer.OnPublisherMessage(app.MessageOrigin{Tenant: "a"}, buffer.Wrap([]byte("1")))).To(Succeed())
+	g.Expect(observer.OnPublisherMessage(app.MessageOrigin{Tenant: "a"}, buffer.Wrap([]byte("2")))).To(Succeed())
+	g.Expect(errors.As(observer.OnPublisherMessage(app.MessageOrigin{Tenant: "a"}, buffer.Wrap([]byte("3"))), &quotaErr)).To(BeTrue())
+	g.Expect(quotaErr.Quota).To(Equal(sdk.QuotaMessageRate))
+	g.Expect(quotaErr.RetryAfter).To(BeNumerically(">", 0))
+	g.Expect(other.received()).To(Equal([]string{"1", "2"}))
+}
+
+type identifiedSubscriber struct {
+	*recordingSubscriber
+	identity string
+}
+
+func (s identifiedSubscriber) GetIdentity() string { return s.identity }
+
+type identifiedPublisher struct {
+	*mocks.MockPublisher
+	identity string
+}
+
+func (p identifiedPublisher) GetIdentity() string { return p.identity }
+
+func TestObserver_EnforcesACL(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	g := NewGomegaWithT(t)
+
+	acl, err := app.NewACL(app.ACLConfig{Rules: []app.ACLRule{
+		{Identity: "alice", Publish: []string{"orders.*"}},
+		{Identity: "bob", Subscribe: []string{"orders.eu"}},
+	}})
+	g.Expect(err).NotTo(HaveOccurred())
+	accessControl := app.NewAccessControl(acl)
+	observer := app.NewObserver(app.NewPublisherPool(), app.NewSubscriberPool(), nil, nil, accessControl, nil, nil, nil, zap.NewNop())
+	g.Expect(observer.ControlsAccess()).To(BeTrue())
+
+	// Clients that may not subscribe or publish anything are refused
+	var deniedErr *app.AccessDeniedError
+	alice := identifiedSubscriber{recordingSubscriber: &recordingSubscriber{id: "alice"}, identity: "alice"}
+	g.Expect(errors.As(observer.OnSubscriberConnected(alice), &deniedErr)).To(BeTrue())
+	bobPublisher := identifiedPublisher{MockPublisher: mocks.NewMockPublisher(ctrl), identity: "bob"}
+	g.Expect(errors.As(observer.OnPublisherConnected(bobPublisher), &deniedErr)).To(BeTrue())
+
+	publisher := identifiedPublisher{MockPublisher: mocks.NewMockPublisher(ctrl), identity: "alice"}
+	publisher.EXPECT().GetID().AnyTimes().Return("alice")
+	publisher.EXPECT().NotifyNoSubscribers().Times(1)
+	publisher.EXPECT().NotifyExistsSubscriber().AnyTimes()
+	g.Expect(observer.OnPublisherConnected(publisher)).To(Succeed())
+
+	bob := identifiedSubscriber{recordingSubscriber: &recordingSubscriber{id: "bob"}, identity: "bob"}
+	g.Expect(observer.OnSubscriberConnected(bob)).To(Succeed())
+	g.Expect(observer.OnSubscribe(bob, "orders.eu")).To(Succeed())
+	g.Expect(errors.As(observer.OnSubscribe(bob, "orders.us"), &deniedErr)).To(BeTrue())
+
+	// Subscribers that are trusted, e.g. bridges to other servers, receive everything
+	bridge := &recordingSubscriber{id: "bridge"}
+	g.Expect(observer.OnSubscriberConnected(bridge)).To(Succeed())
+
+	// Messages are dropped unless the publisher may publish their keys, and delivered only to subscribers that may
+	// receive them
+	publish := func(origin app.MessageOrigin, payload string) error {
+		return observer.OnPublisherMessage(origin, buffer.Wrap([]byte(payload)))
+	}
+	g.Expect(publish(app.MessageOrigin{Identity: "alice", Key: "orders.eu"}, "eu")).To(Succeed())
+	g.Expect(publish(app.MessageOrigin{Identity: "alice", Key: "orders.us"}, "us")).To(Succeed())
+	g.Expect(errors.As(publish(app.MessageOrigin{Identity: "alice", Key: "invoices"}, "invoice"), &deniedErr)).To(BeTrue())
+	g.Expect(deniedErr.Subject).To(Equal("invoices"))
+	g.Expect(publish(app.MessageOrigin{Key: "invoices", Trusted: true}, "bridged")).To(Succeed())
+	g.Expect(observer.OnPeerMessage("orders.eu", buffer.Wrap([]byte("from peer")))).To(Succeed())
+
+	g.Expect(bob.received()).To(Equal([]string{"eu", "from peer"}))
+	g.Expect(bridge.received()).To(Equal([]string{"eu", "us", "bridged", "from peer"}))
+	g.Expect(accessControl.Denials()).To(Equal(app.ACLDenials{Connect: 2, Publish: 1, Subscribe: 1}))
+}
+
+type bridgedSubscriber struct {
+	*recordingSubscriber
+	bridgedFrom string
+}
+
+func (s bridgedSubscriber) HasDemand() bool     { return true }
+func (s bridgedSubscriber) BridgedFrom() string { return s.bridgedFrom }
+
+type keySubscriber struct {
+	*recordingSubscriber
+	key string
+}
+
+func (s keySubscriber) GetKey() string { return s.key }
+
+func TestObserver_DeliversByKey(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	observer := app.NewObserver(app.NewPublisherPool(), app.NewSubscriberPool(), nil, nil, nil, nil, nil, nil, zap.NewNop())
+	g.Expect(observer.NeedsKeys()).To(BeFalse())
+	eu := keySubscriber{recordingSubscriber: &recordingSubscriber{id: "eu"}, key: "orders.eu"}
+	all := &recordingSubscriber{id: "all"}
+	g.Expect(observer.OnSubscriberConnected(eu)).To(Succeed())
+	g.Expect(observer.OnSubscriberConnected(all)).To(Succeed())
+	g.Expect(observer.OnSubscribe(eu, "orders.eu")).To(Succeed())
+	g.Expect(observer.NeedsKeys()).To(BeTrue())
+
+	// Subscribers of a key receive only the messages with the key, from publishers and other servers
+	publish := func(key string, payload string) {
+		g.Expect(observer.OnPublisherMessage(app.MessageOrigin{Key: key}, buffer.Wrap([]byte(payload)))).To(Succeed())
+	}
+	publish("orders.eu", "eu")
+	publish("orders.us", "us")
+	g.Expect(observer.OnPeerMessage("orders.us", buffer.Wrap([]byte("us from peer")))).To(Succeed())
+	g.Expect(eu.received()).To(Equal([]string{"eu"}))
+	g.Expect(all.received()).To(Equal([]string{"eu", "us", "us from peer"}))
+
+	g.Expect(observer.OnSubscriberDisconnected(eu)).To(Succeed())
+	g.Expect(observer.NeedsKeys()).To(BeFalse())
+}
+
+func TestObserver_HasDemandExcluding_bridgedSubscribers(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	observer := app.NewObserver(app.NewPublisherPool(), app.NewSubscriberPool(), nil, nil, nil, nil, nil, nil, zap.NewNop())
+	g.Expect(observer.OnSubscriberConnected(bridgedSubscriber{recordingSubscriber: &recordingSubscriber{id: "1"}, bridgedFrom: "B"})).To(Succeed())
+
+	// The demand of a bridge from a server is not demand that is bridged back to that server
+	g.Expect(observer.HasDemandExcluding("", "B")).To(BeFalse())
+	g.Expect(observer.HasDemandExcluding("", "C")).To(BeTrue())
+	g.Expect(observer.HasDemandExcluding("", "")).To(BeTrue())
+
+	g.Expect(observer.OnSubscriberConnected(&recordingSubscriber{id: "2"})).To(Succeed())
+	g.Expect(observer.HasDemandExcluding("", "B")).To(BeTrue())
+}
+
+func TestObserver_DropsCopiesOfBridgedMessages(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	observer := app.NewObserver(app.NewPublisherPool(), app.NewSubscriberPool(), nil, nil, nil, nil, nil, app.NewDeduplicator(10), zap.NewNop())
+	g.Expect(observer.Deduplicates()).To(BeTrue())
+	subscriber := &recordingSubscriber{id: "1"}
+	g.Expect(observer.OnSubscriberConnected(subscriber)).To(Succeed())
+
+	publish := func(origin app.MessageOrigin, payload string) {
+		g.Expect(observer.OnPublisherMessage(origin, buffer.Wrap([]byte(payload)))).To(Succeed())
+	}
+	publish(app.MessageOrigin{OriginBroker: "A", MessageID: "1"}, "first")
+	publish(app.MessageOrigin{OriginBroker: "A", MessageID: "1"}, "copy of first")
+	publish(app.MessageOrigin{OriginBroker: "B", MessageID: "1"}, "from B")
+	publish(app.MessageOrigin{}, "without ID")
+	publish(app.MessageOrigin{}, "without ID")
+
+	g.Expect(subscriber.received()).To(Equal([]string{"first", "from B", "without ID", "without ID"}))
+}
+
+func TestObserver_RefusesDuplicateIDs(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	tenants := app.NewTenants(map[string]app.TenantQuota{"a": {MaxSubscriptions: 2}})
+	observer := app.NewObserver(app.NewPublisherPool(), app.NewSubscriberPool(), nil, tenants, nil, nil, nil, nil, zap.NewNop())
+
+	first := tenantSubscriber{recordingSubscriber: &recordingSubscriber{id: "alice"}, tenant: "a"}
+	second := tenantSubscriber{recordingSubscriber: &recordingSubscriber{id: "alice"}, tenant: "a"}
+	g.Expect(observer.OnSubscriberConnected(first)).To(Succeed())
+	g.Expect(observer.OnSubscriberConnected(second)).To(MatchError(app.ErrDuplicateID))
+
+	// Disconnecting the refused subscriber neither removes the first one nor frees its subscription
+	g.Expect(observer.OnSubscriberDisconnected(second)).To(Succeed())
+	g.Expect(observer.OnPublisherMessage(app.MessageOrigin{Tenant: "a"}, buffer.Wrap([]byte("foo")))).To(Succeed())
+	g.Expect(first.received()).To(Equal([]string{"foo"}))
+	bob := tenantSubscriber{recordingSubscriber: &recordingSubscriber{id: "bob"}, tenant: "a"}
+	g.Expect(observer.OnSubscriberConnected(bob)).To(Succeed())
+	carol := tenantSubscriber{recordingSubscriber: &recordingSubscriber{id: "carol"}, tenant: "a"}
+	var quotaErr *app.QuotaError
+	g.Expect(errors.As(observer.OnSubscriberConnected(carol), &quotaErr)).To(BeTrue())
+}
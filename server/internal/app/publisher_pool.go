@@ -1,10 +1,14 @@
 package app
 
 import (
-	"fmt"
+	"github.com/pkg/errors"
 	"sync"
 )
 
+// ErrDuplicateID is returned when adding a publisher or subscriber to a pool that has another one with the same ID,
+// e.g. of another connection of a client with the same identity.
+var ErrDuplicateID = errors.New("duplicate ID")
+
 // PublisherPool is a container for publishers, used to Add or Remove them as they connect or disconnect
 // to or from the server.
 type PublisherPool struct {
@@ -23,13 +27,25 @@ func (p *PublisherPool) Add(publisher Publisher) error {
 	id := publisher.GetID()
 	_, loaded := p.publishers.LoadOrStore(id, publisher)
 	if loaded {
-		return fmt.Errorf("publisher by ID '%s' exists, not overriding", id)
+		return errors.Wrapf(ErrDuplicateID, "publisher by ID '%s' exists, not overriding", id)
 	}
 	return nil
 }
 
-func (p *PublisherPool) Remove(publisherID string) {
-	p.publishers.Delete(publisherID)
+// Remove removes the publisher if it is the one in the pool by its ID, so that a publisher that has not been added,
+// e.g. as another one has its ID, does not remove the other one. Returns whether the publisher was removed.
+func (p *PublisherPool) Remove(publisher Publisher) bool {
+	return p.publishers.CompareAndDelete(publisher.GetID(), publisher)
+}
+
+// Get returns the publisher with the ID, if any.
+func (p *PublisherPool) Get(publisherID string) (Publisher, bool) {
+	value, ok := p.publishers.Load(publisherID)
+	if !ok {
+		return nil, false
+	}
+	publisher, ok := value.(Publisher)
+	return publisher, ok
 }
 
 func (p *PublisherPool) GetAll() []Publisher {
@@ -44,3 +60,22 @@ func (p *PublisherPool) GetAll() []Publisher {
 
 	return publishers
 }
+
+// Len returns the number of publishers.
+func (p *PublisherPool) Len() int {
+	var n int
+	p.publishers.Range(func(key, value interface{}) bool {
+		n++
+		return true
+	})
+	return n
+}
+
+func (p *PublisherPool) IsEmpty() bool {
+	isEmpty := true
+	p.publishers.Range(func(key, value interface{}) bool {
+		isEmpty = false
+		return false // Stops iteration
+	})
+	return isEmpty
+}
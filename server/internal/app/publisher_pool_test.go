@@ -60,12 +60,37 @@ func TestPublisherPool_Remove(t *testing.T) {
 	pool := app.NewPublisherPool()
 
 	mockPublisher1 := mocks.NewMockPublisher(ctrl)
-	mockPublisher1.EXPECT().GetID().Return("1")
+	mockPublisher1.EXPECT().GetID().AnyTimes().Return("1")
 
 	err := pool.Add(mockPublisher1)
 	g.Expect(err).To(BeNil())
 
-	pool.Remove("1")
+	g.Expect(pool.Remove(mockPublisher1)).To(BeTrue())
+	g.Expect(pool.Remove(mockPublisher1)).To(BeFalse())
 
 	g.Expect(pool.GetAll()).To(HaveLen(0))
 }
+
+func TestPublisherPool_IsEmptyWhenNotEmpty(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	g := NewGomegaWithT(t)
+
+	pool := app.NewPublisherPool()
+
+	mockPublisher1 := mocks.NewMockPublisher(ctrl)
+	mockPublisher1.EXPECT().GetID().Return("1")
+
+	err := pool.Add(mockPublisher1)
+	g.Expect(err).To(BeNil())
+
+	g.Expect(pool.IsEmpty()).To(BeFalse())
+}
+
+func TestPublisherPool_IsEmptyWhenEmpty(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	pool := app.NewPublisherPool()
+
+	g.Expect(pool.IsEmpty()).To(BeTrue())
+}
@@ -0,0 +1,175 @@
+package app
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RateLimitMode selects what the server does with messages of a publisher that exceeds its rate limit.
+type RateLimitMode string
+
+const (
+	RateLimitModeThrottle RateLimitMode = "throttle" // Stop reading from the publisher until the rate allows
+	RateLimitModeReject   RateLimitMode = "reject"   // Drop the message and tell the publisher to slow down
+)
+
+// ParseRateLimitMode parses "throttle" or "reject".
+func ParseRateLimitMode(mode string) (RateLimitMode, error) {
+	switch m := RateLimitMode(mode); m {
+	case RateLimitModeThrottle, RateLimitModeReject:
+		return m, nil
+	default:
+		return "", fmt.Errorf("unknown rate limit mode '%s', expected one of throttle, reject", mode)
+	}
+}
+
+// RateLimit limits the messages of a publisher. Zero values are unlimited.
+type RateLimit struct {
+	MessagesPerSecond float64
+	BytesPerSecond    float64
+}
+
+// PublisherRateLimits are the rate limits of publishers by identity.
+type PublisherRateLimits struct {
+	Default   RateLimit            // For identities without an override
+	Overrides map[string]RateLimit // By identity
+}
+
+// For returns the rate limit of the identity.
+func (l PublisherRateLimits) For(identity string) RateLimit {
+	if limit, ok := l.Overrides[identity]; ok {
+		return limit
+	}
+	return l.Default
+}
+
+// ParseRateLimitOverrides parses comma-separated "identity=messages/s:bytes/s" items, e.g.
+// "10.0.0.5=100:100000,10.0.0.6=0:1000000", in which 0 is unlimited.
+func ParseRateLimitOverrides(overrides string) (map[string]RateLimit, error) {
+	limits := make(map[string]RateLimit)
+	for _, item := range strings.Split(overrides, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		identity, rates, ok := strings.Cut(item, "=")
+		messages, bytes, ok2 := strings.Cut(rates, ":")
+		if !ok || !ok2 || identity == "" {
+			return nil, fmt.Errorf("rate limit override '%s' is not in the form identity=messages/s:bytes/s", item)
+		}
+		messagesPerSecond, err := parseRate(messages)
+		if err != nil {
+			return nil, fmt.Errorf("messages/s of '%s': %w", item, err)
+		}
+		bytesPerSecond, err := parseRate(bytes)
+		if err != nil {
+			return nil, fmt.Errorf("bytes/s of '%s': %w", item, err)
+		}
+		limits[identity] = RateLimit{MessagesPerSecond: messagesPerSecond, BytesPerSecond: bytesPerSecond}
+	}
+	return limits, nil
+}
+
+// parseRate parses a non-negative number of things per second.
+func parseRate(rate string) (float64, error) {
+	r, err := strconv.ParseFloat(rate, 64)
+	if err != nil {
+		return 0, err
+	}
+	if r < 0 || math.IsNaN(r) || math.IsInf(r, 0) {
+		return 0, fmt.Errorf("rate %s is not a non-negative number", rate)
+	}
+	return r, nil
+}
+
+// RateLimiters hands out the RateLimiter of each publisher identity, which all connections of the identity share.
+// A RateLimiter is kept for as long as any connection of its identity holds it.
+type RateLimiters struct {
+	limits   PublisherRateLimits
+	mu       sync.Mutex
+	limiters map[string]*RateLimiter // By identity
+}
+
+// NewRateLimiters is the constructor for RateLimiters.
+func NewRateLimiters(limits PublisherRateLimits) *RateLimiters {
+	return &RateLimiters{
+		limits:   limits,
+		limiters: make(map[string]*RateLimiter),
+	}
+}
+
+// Acquire returns the RateLimiter of the identity. Call Release with the identity once done with it.
+func (r *RateLimiters) Acquire(identity string) *RateLimiter {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	limiter, ok := r.limiters[identity]
+	if !ok {
+		limiter = newRateLimiter(r.limits.For(identity))
+		r.limiters[identity] = limiter
+	}
+	limiter.holders++
+	return limiter
+}
+
+// Release drops the RateLimiter of the identity once no connection of the identity holds it anymore.
+func (r *RateLimiters) Release(identity string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if limiter, ok := r.limiters[identity]; ok {
+		if limiter.holders--; limiter.holders <= 0 {
+			delete(r.limiters, identity)
+		}
+	}
+}
+
+// RateLimiter limits the messages and bytes per second of a publisher identity with a token bucket each, which
+// allow a second's worth of messages at once.
+type RateLimiter struct {
+	limit    RateLimit
+	mu       sync.Mutex
+	messages tokenBucket
+	bytes    tokenBucket
+	holders  int // Guarded by the mutex of RateLimiters
+}
+
+func newRateLimiter(limit RateLimit) *RateLimiter {
+	return &RateLimiter{
+		limit:    limit,
+		messages: newTokenBucket(limit.MessagesPerSecond, limit.MessagesPerSecond),
+		bytes:    newTokenBucket(limit.BytesPerSecond, limit.BytesPerSecond),
+	}
+}
+
+// Allow returns 0 if a message of the given size can be accepted at now, counting it towards the limit. Otherwise
+// it returns how long until it can be accepted, without counting it.
+func (l *RateLimiter) Allow(bytes int, now time.Time) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var wait time.Duration
+	if l.limit.MessagesPerSecond > 0 {
+		wait = l.messages.wait(1, now)
+	}
+	if l.limit.BytesPerSecond > 0 {
+		if bytesWait := l.bytes.wait(float64(bytes), now); bytesWait > wait {
+			wait = bytesWait
+		}
+	}
+	if wait > 0 {
+		return wait
+	}
+
+	if l.limit.MessagesPerSecond > 0 {
+		l.messages.take(1)
+	}
+	if l.limit.BytesPerSecond > 0 {
+		l.bytes.take(float64(bytes))
+	}
+	return 0
+}
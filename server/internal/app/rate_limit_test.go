@@ -0,0 +1,104 @@
+package app_test
+
+import (
+	. "github.com/onsi/gomega"
+	"github.com/varfrog/quicpubsub/server/internal/app"
+	"testing"
+	"time"
+)
+
+func TestParseRateLimitMode(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	mode, err := app.ParseRateLimitMode("reject")
+	g.Expect(err).To(BeNil())
+	g.Expect(mode).To(Equal(app.RateLimitModeReject))
+
+	_, err = app.ParseRateLimitMode("ignore")
+	g.Expect(err).To(HaveOccurred())
+}
+
+func TestParseRateLimitOverrides(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	overrides, err := app.ParseRateLimitOverrides("10.0.0.5=100:100000, 10.0.0.6=0:2.5")
+	g.Expect(err).To(BeNil())
+	g.Expect(overrides).To(Equal(map[string]app.RateLimit{
+		"10.0.0.5": {MessagesPerSecond: 100, BytesPerSecond: 100000},
+		"10.0.0.6": {MessagesPerSecond: 0, BytesPerSecond: 2.5},
+	}))
+
+	overrides, err = app.ParseRateLimitOverrides("")
+	g.Expect(err).To(BeNil())
+	g.Expect(overrides).To(BeEmpty())
+
+	for _, invalid := range []string{"10.0.0.5", "10.0.0.5=100", "=1:1", "10.0.0.5=x:1", "10.0.0.5=1:-1"} {
+		_, err = app.ParseRateLimitOverrides(invalid)
+		g.Expect(err).To(HaveOccurred(), invalid)
+	}
+}
+
+func TestRateLimiter_MessagesPerSecond(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	now := time.Now()
+	limiters := app.NewRateLimiters(app.PublisherRateLimits{Default: app.RateLimit{MessagesPerSecond: 10}})
+	limiter := limiters.Acquire("10.0.0.1")
+
+	// A second's worth of messages is allowed at once
+	for i := 0; i < 10; i++ {
+		g.Expect(limiter.Allow(100, now)).To(BeZero())
+	}
+	g.Expect(limiter.Allow(100, now)).To(Equal(time.Millisecond * 100))
+
+	// Refused messages don't count
+	g.Expect(limiter.Allow(100, now.Add(time.Millisecond*50))).To(Equal(time.Millisecond * 50))
+	g.Expect(limiter.Allow(100, now.Add(time.Millisecond*100))).To(BeZero())
+}
+
+func TestRateLimiter_BytesPerSecond(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	now := time.Now()
+	limiters := app.NewRateLimiters(app.PublisherRateLimits{Default: app.RateLimit{BytesPerSecond: 1000}})
+	limiter := limiters.Acquire("10.0.0.1")
+
+	g.Expect(limiter.Allow(600, now)).To(BeZero())
+	g.Expect(limiter.Allow(600, now)).To(Equal(time.Millisecond * 200))
+	g.Expect(limiter.Allow(600, now.Add(time.Millisecond*200))).To(BeZero())
+
+	// A message larger than a second's worth is allowed once the bucket is full, and takes longer to make up for
+	later := now.Add(time.Hour)
+	g.Expect(limiter.Allow(3000, later)).To(BeZero())
+	g.Expect(limiter.Allow(1, later.Add(time.Second))).To(Equal(time.Second + time.Millisecond))
+}
+
+func TestRateLimiters_ShareLimiterPerIdentity(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	now := time.Now()
+	limiters := app.NewRateLimiters(app.PublisherRateLimits{
+		Default:   app.RateLimit{MessagesPerSecond: 1},
+		Overrides: map[string]app.RateLimit{"trusted": {}},
+	})
+
+	// Connections of the same identity share the limit
+	first := limiters.Acquire("10.0.0.1")
+	second := limiters.Acquire("10.0.0.1")
+	g.Expect(first.Allow(1, now)).To(BeZero())
+	g.Expect(second.Allow(1, now)).ToNot(BeZero())
+
+	// Other identities have their own
+	g.Expect(limiters.Acquire("10.0.0.2").Allow(1, now)).To(BeZero())
+
+	// Overrides apply, here unlimited
+	trusted := limiters.Acquire("trusted")
+	for i := 0; i < 100; i++ {
+		g.Expect(trusted.Allow(1, now)).To(BeZero())
+	}
+
+	// The limiter is dropped once the last connection of the identity releases it
+	limiters.Release("10.0.0.1")
+	limiters.Release("10.0.0.1")
+	g.Expect(limiters.Acquire("10.0.0.1").Allow(1, now)).To(BeZero())
+}
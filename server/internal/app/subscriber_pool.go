@@ -1,54 +1,95 @@
 package app
 
 import (
-	"fmt"
+	"github.com/pkg/errors"
 	"sync"
+	"sync/atomic"
 )
 
 // SubscriberPool is a container for subscribers, used to Add or Remove them as they connect or disconnect
 // to or from the server.
+//
+// Subscribers are read for every message but change only when subscribers connect or disconnect, so the pool keeps
+// an immutable snapshot of them that is replaced on every change (copy-on-write), and reading it takes no locks
+// and allocates nothing.
 type SubscriberPool struct {
-	subscribers sync.Map
+	mu          sync.Mutex // Serializes changes
+	subscribers map[string]Subscriber
+	snapshot    atomic.Pointer[[]Subscriber] // The values of subscribers, replaced on every change
 }
 
 // NewSubscriberPool is a constructor for SubscriberPool.
 func NewSubscriberPool() *SubscriberPool {
-	return &SubscriberPool{
-		subscribers: sync.Map{},
+	pool := &SubscriberPool{
+		subscribers: make(map[string]Subscriber),
 	}
+	pool.snapshot.Store(&[]Subscriber{})
+	return pool
 }
 
 func (p *SubscriberPool) Add(subscriber Subscriber) error {
 	id := subscriber.GetID()
-	_, loaded := p.subscribers.LoadOrStore(id, subscriber)
-	if loaded {
-		return fmt.Errorf("subscriber by ID '%s' exists, not overriding", id)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if _, ok := p.subscribers[id]; ok {
+		return errors.Wrapf(ErrDuplicateID, "subscriber by ID '%s' exists, not overriding", id)
 	}
+	p.subscribers[id] = subscriber
+	p.updateSnapshot()
 	return nil
 }
 
-func (p *SubscriberPool) Remove(subscriberID string) {
-	p.subscribers.Delete(subscriberID)
+// Remove removes the subscriber if it is the one in the pool by its ID, so that a subscriber that has not been added,
+// e.g. as another one has its ID, does not remove the other one. Returns whether the subscriber was removed.
+func (p *SubscriberPool) Remove(subscriber Subscriber) bool {
+	id := subscriber.GetID()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if current, ok := p.subscribers[id]; !ok || current != subscriber {
+		return false
+	}
+	delete(p.subscribers, id)
+	p.updateSnapshot()
+	return true
 }
 
-func (p *SubscriberPool) GetAll() []Subscriber {
-	var subscribers []Subscriber
+// Get returns the subscriber with the ID, if any.
+func (p *SubscriberPool) Get(subscriberID string) (Subscriber, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	subscriber, ok := p.subscribers[subscriberID]
+	return subscriber, ok
+}
 
-	p.subscribers.Range(func(key, value interface{}) bool {
-		if subscriber, ok := value.(Subscriber); ok {
-			subscribers = append(subscribers, subscriber)
-		}
-		return true
-	})
+// GetAll returns the current snapshot of the subscribers. The returned slice is shared and must not be modified.
+func (p *SubscriberPool) GetAll() []Subscriber {
+	return *p.getSnapshot()
+}
 
-	return subscribers
+// Len returns the number of subscribers.
+func (p *SubscriberPool) Len() int {
+	return len(p.GetAll())
 }
 
 func (p *SubscriberPool) IsEmpty() bool {
-	isEmpty := true
-	p.subscribers.Range(func(key, value interface{}) bool {
-		isEmpty = false
-		return false // Stops iteration
-	})
-	return isEmpty
+	return len(p.GetAll()) == 0
+}
+
+// getSnapshot returns the current snapshot, which is a different pointer after every change.
+func (p *SubscriberPool) getSnapshot() *[]Subscriber {
+	return p.snapshot.Load()
+}
+
+// updateSnapshot replaces the snapshot with a copy of the subscribers. The caller must hold mu.
+func (p *SubscriberPool) updateSnapshot() {
+	subscribers := make([]Subscriber, 0, len(p.subscribers))
+	for _, subscriber := range p.subscribers {
+		subscribers = append(subscribers, subscriber)
+	}
+	p.snapshot.Store(&subscribers)
 }
@@ -86,12 +86,13 @@ func TestSubscriberPool_Remove(t *testing.T) {
 	pool := app.NewSubscriberPool()
 
 	mockSubscriber1 := mocks.NewMockSubscriber(ctrl)
-	mockSubscriber1.EXPECT().GetID().Return("1")
+	mockSubscriber1.EXPECT().GetID().AnyTimes().Return("1")
 
 	err := pool.Add(mockSubscriber1)
 	g.Expect(err).To(BeNil())
 
-	pool.Remove("1")
+	g.Expect(pool.Remove(mockSubscriber1)).To(BeTrue())
+	g.Expect(pool.Remove(mockSubscriber1)).To(BeFalse())
 
 	g.Expect(pool.IsEmpty()).To(BeTrue())
 }
@@ -0,0 +1,236 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/varfrog/quicpubsub/pkg/sdk"
+	"os"
+	"sync"
+	"time"
+)
+
+// DefaultTenant is the tenant of clients that don't declare one, and of other servers of the cluster and bridges.
+// It has no quotas.
+const DefaultTenant = ""
+
+// ErrUnknownTenant is returned by Tenants when a client declares a tenant that is not configured.
+var ErrUnknownTenant = errors.New("unknown tenant")
+
+// tenantOf returns the tenant of a Publisher or Subscriber.
+func tenantOf(connector any) string {
+	if member, ok := connector.(TenantMember); ok {
+		return member.GetTenant()
+	}
+	return DefaultTenant
+}
+
+// TenantQuota limits what the clients of a tenant use together. Zero values are unlimited.
+type TenantQuota struct {
+	MaxConnections   int     `json:"max_connections"`   // Live connections
+	MaxMessageRate   float64 `json:"max_message_rate"`  // Messages published per second, with a second's worth at once
+	MaxStoredBytes   int64   `json:"max_stored_bytes"`  // Bytes of published messages not yet delivered
+	MaxSubscriptions int     `json:"max_subscriptions"` // Connected subscribers
+}
+
+// QuotaError is returned by Tenants when a tenant would exceed one of its quotas.
+type QuotaError struct {
+	Tenant     string
+	Quota      string        // One of the sdk.Quota* names
+	RetryAfter time.Duration // How long until the quota allows again, if known
+}
+
+func (e *QuotaError) Error() string {
+	return fmt.Sprintf("tenant '%s' exceeds its %s quota", e.Tenant, e.Quota)
+}
+
+// LoadTenantQuotas reads the quotas by tenant from a JSON file, an object with tenant names as keys, e.g.
+// {"acme": {"max_connections": 10, "max_message_rate": 100}}.
+func LoadTenantQuotas(path string) (map[string]TenantQuota, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "os.ReadFile")
+	}
+	var quotas map[string]TenantQuota
+	if err := json.Unmarshal(data, &quotas); err != nil {
+		return nil, errors.Wrap(err, "json.Unmarshal")
+	}
+	for tenant, quota := range quotas {
+		if err := sdk.ValidateTenant(tenant); err != nil {
+			return nil, err
+		}
+		if quota.MaxConnections < 0 || quota.MaxMessageRate < 0 || quota.MaxStoredBytes < 0 || quota.MaxSubscriptions < 0 {
+			return nil, fmt.Errorf("quotas of tenant '%s' must not be negative", tenant)
+		}
+	}
+	return quotas, nil
+}
+
+// Tenants keeps track of what each tenant uses and enforces the tenant quotas.
+type Tenants struct {
+	quotas map[string]TenantQuota // By tenant, nil if any tenant is accepted without quotas
+	mu     sync.Mutex
+	usage  map[string]*tenantUsage // By tenant, dropped once unused
+}
+
+type tenantUsage struct {
+	connections   int
+	subscriptions int
+	storedBytes   int64
+	messages      tokenBucket
+}
+
+func (u *tenantUsage) isIdle() bool {
+	return u.connections == 0 && u.subscriptions == 0 && u.storedBytes == 0
+}
+
+// NewTenants is the constructor for Tenants. If quotas is nil, clients may declare any tenant and tenants have
+// no quotas. Otherwise only the tenants in quotas and DefaultTenant are accepted.
+func NewTenants(quotas map[string]TenantQuota) *Tenants {
+	return &Tenants{
+		quotas: quotas,
+		usage:  make(map[string]*tenantUsage),
+	}
+}
+
+// Connect is called when a client of the tenant connects, and returns ErrUnknownTenant or a QuotaError if
+// the connection must be refused. Otherwise the connection counts towards the quota until Disconnect is called.
+func (t *Tenants) Connect(tenant string) error {
+	quota, err := t.quota(tenant)
+	if err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	usage := t.usageOf(tenant, quota)
+	if quota.MaxConnections > 0 && usage.connections >= quota.MaxConnections {
+		t.dropIfIdle(tenant, usage)
+		return &QuotaError{Tenant: tenant, Quota: sdk.QuotaConnections}
+	}
+	usage.connections++
+	return nil
+}
+
+// Disconnect is called once a connection admitted by Connect is closed.
+func (t *Tenants) Disconnect(tenant string) {
+	t.release(tenant, func(usage *tenantUsage) { usage.connections-- })
+}
+
+// Subscribe is called when a subscriber of the tenant connects, and returns a QuotaError if it must be refused.
+// Otherwise the subscriber counts towards the quota until Unsubscribe is called.
+func (t *Tenants) Subscribe(tenant string) error {
+	quota, err := t.quota(tenant)
+	if err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	usage := t.usageOf(tenant, quota)
+	if quota.MaxSubscriptions > 0 && usage.subscriptions >= quota.MaxSubscriptions {
+		t.dropIfIdle(tenant, usage)
+		return &QuotaError{Tenant: tenant, Quota: sdk.QuotaSubscriptions}
+	}
+	usage.subscriptions++
+	return nil
+}
+
+// Unsubscribe is called once a subscriber admitted by Subscribe disconnects.
+func (t *Tenants) Unsubscribe(tenant string) {
+	t.release(tenant, func(usage *tenantUsage) { usage.subscriptions-- })
+}
+
+// Publish is called when a publisher of the tenant publishes a message of the given size at now, and returns
+// a QuotaError if the message must be dropped. Otherwise the message counts towards the message rate, and its bytes
+// are stored until Delivered is called.
+func (t *Tenants) Publish(tenant string, bytes int, now time.Time) error {
+	quota, err := t.quota(tenant)
+	if err != nil {
+		return err
+	}
+	if quota.MaxMessageRate == 0 && quota.MaxStoredBytes == 0 {
+		return nil // Nothing to count, which spares the default tenant the lock
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	usage := t.usageOf(tenant, quota)
+	defer t.dropIfIdle(tenant, usage)
+	if quota.MaxMessageRate > 0 {
+		if wait := usage.messages.wait(1, now); wait > 0 {
+			return &QuotaError{Tenant: tenant, Quota: sdk.QuotaMessageRate, RetryAfter: wait}
+		}
+	}
+	if quota.MaxStoredBytes > 0 && usage.storedBytes+int64(bytes) > quota.MaxStoredBytes {
+		return &QuotaError{Tenant: tenant, Quota: sdk.QuotaStoredBytes}
+	}
+
+	if quota.MaxMessageRate > 0 {
+		usage.messages.take(1)
+	}
+	if quota.MaxStoredBytes > 0 {
+		usage.storedBytes += int64(bytes)
+	}
+	return nil
+}
+
+// Delivered is called once a message admitted by Publish has been delivered to all subscribers, or dropped.
+func (t *Tenants) Delivered(tenant string, bytes int) {
+	quota, err := t.quota(tenant)
+	if err != nil || quota.MaxStoredBytes == 0 {
+		return // Its bytes were not counted
+	}
+	t.release(tenant, func(usage *tenantUsage) { usage.storedBytes -= int64(bytes) })
+}
+
+// NeedsDelivered returns whether Delivered must be called for the messages of the tenant, which is only the case
+// if it has a quota on stored bytes.
+func (t *Tenants) NeedsDelivered(tenant string) bool {
+	quota, err := t.quota(tenant)
+	return err == nil && quota.MaxStoredBytes > 0
+}
+
+// quota returns the quota of the tenant, or ErrUnknownTenant.
+func (t *Tenants) quota(tenant string) (TenantQuota, error) {
+	if tenant == DefaultTenant || t.quotas == nil {
+		return TenantQuota{}, nil
+	}
+	quota, ok := t.quotas[tenant]
+	if !ok {
+		return TenantQuota{}, errors.Wrapf(ErrUnknownTenant, "tenant '%s'", tenant)
+	}
+	return quota, nil
+}
+
+// usageOf returns the usage of the tenant, creating it if needed. The caller must hold mu.
+func (t *Tenants) usageOf(tenant string, quota TenantQuota) *tenantUsage {
+	usage, ok := t.usage[tenant]
+	if !ok {
+		usage = &tenantUsage{messages: newTokenBucket(quota.MaxMessageRate, quota.MaxMessageRate)}
+		t.usage[tenant] = usage
+	}
+	return usage
+}
+
+// release applies a decrement to the usage of the tenant and drops it if unused.
+func (t *Tenants) release(tenant string, decrement func(usage *tenantUsage)) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if usage, ok := t.usage[tenant]; ok {
+		decrement(usage)
+		t.dropIfIdle(tenant, usage)
+	}
+}
+
+// dropIfIdle forgets the usage of the tenant if it has no connections, subscribers or stored bytes, so that tenants
+// don't pile up when any tenant is accepted. The caller must hold mu.
+func (t *Tenants) dropIfIdle(tenant string, usage *tenantUsage) {
+	if usage.isIdle() {
+		delete(t.usage, tenant)
+	}
+}
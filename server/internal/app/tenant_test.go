@@ -0,0 +1,69 @@
+package app_test
+
+import (
+	"errors"
+	. "github.com/onsi/gomega"
+	"github.com/varfrog/quicpubsub/pkg/sdk"
+	"github.com/varfrog/quicpubsub/server/internal/app"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLoadTenantQuotas(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	path := filepath.Join(t.TempDir(), "tenants.json")
+	g.Expect(os.WriteFile(path, []byte(`{"acme": {"max_connections": 10, "max_message_rate": 2.5}, "free": {}}`), 0600)).To(Succeed())
+	quotas, err := app.LoadTenantQuotas(path)
+	g.Expect(err).To(BeNil())
+	g.Expect(quotas).To(Equal(map[string]app.TenantQuota{
+		"acme": {MaxConnections: 10, MaxMessageRate: 2.5},
+		"free": {},
+	}))
+
+	for _, invalid := range []string{`{"has space": {}}`, `{"acme": {"max_stored_bytes": -1}}`, `[]`} {
+		g.Expect(os.WriteFile(path, []byte(invalid), 0600)).To(Succeed())
+		_, err = app.LoadTenantQuotas(path)
+		g.Expect(err).To(HaveOccurred(), invalid)
+	}
+}
+
+func TestTenants_Connect(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	tenants := app.NewTenants(map[string]app.TenantQuota{"acme": {MaxConnections: 1}})
+
+	g.Expect(tenants.Connect("acme")).To(Succeed())
+	var quotaErr *app.QuotaError
+	g.Expect(errors.As(tenants.Connect("acme"), &quotaErr)).To(BeTrue())
+	g.Expect(quotaErr.Quota).To(Equal(sdk.QuotaConnections))
+	tenants.Disconnect("acme")
+	g.Expect(tenants.Connect("acme")).To(Succeed())
+
+	// Only configured tenants are accepted, and the default tenant
+	g.Expect(tenants.Connect("other")).To(MatchError(app.ErrUnknownTenant))
+	g.Expect(tenants.Connect(app.DefaultTenant)).To(Succeed())
+
+	// Any tenant is accepted without quotas
+	g.Expect(app.NewTenants(nil).Connect("other")).To(Succeed())
+}
+
+func TestTenants_StoredBytes(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	now := time.Now()
+	tenants := app.NewTenants(map[string]app.TenantQuota{"acme": {MaxStoredBytes: 1000}})
+	g.Expect(tenants.NeedsDelivered("acme")).To(BeTrue())
+	g.Expect(tenants.NeedsDelivered(app.DefaultTenant)).To(BeFalse())
+
+	g.Expect(tenants.Publish("acme", 600, now)).To(Succeed())
+	var quotaErr *app.QuotaError
+	g.Expect(errors.As(tenants.Publish("acme", 600, now), &quotaErr)).To(BeTrue())
+	g.Expect(quotaErr.Quota).To(Equal(sdk.QuotaStoredBytes))
+
+	// Delivered messages no longer count
+	tenants.Delivered("acme", 600)
+	g.Expect(tenants.Publish("acme", 600, now)).To(Succeed())
+}
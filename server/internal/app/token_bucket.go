@@ -0,0 +1,53 @@
+package app
+
+import (
+	"math"
+	"time"
+)
+
+// tokenBucket holds up to capacity tokens and is refilled at rate tokens per second, starting full. It is not safe
+// for concurrent use.
+type tokenBucket struct {
+	rate       float64
+	capacity   float64
+	tokens     float64
+	refilledAt time.Time // Zero until first used
+}
+
+func newTokenBucket(rate float64, capacity float64) tokenBucket {
+	return tokenBucket{rate: rate, capacity: capacity, tokens: capacity}
+}
+
+// wait returns how long until n tokens can be taken at now, 0 if they can be taken right away. More tokens than
+// the capacity can be taken once the bucket is full, leaving it in debt, so that large amounts are not refused
+// forever.
+func (b *tokenBucket) wait(n float64, now time.Time) time.Duration {
+	b.refill(now)
+	missing := math.Min(n, b.capacity) - b.tokens
+	if missing <= 0 {
+		return 0
+	}
+	return time.Duration(math.Ceil(missing / b.rate * float64(time.Second)))
+}
+
+// take removes n tokens, which wait has allowed.
+func (b *tokenBucket) take(n float64) {
+	b.tokens -= n
+}
+
+// available returns the number of tokens at now.
+func (b *tokenBucket) available(now time.Time) float64 {
+	b.refill(now)
+	return b.tokens
+}
+
+// refill adds the tokens earned since the last refill, up to the capacity.
+func (b *tokenBucket) refill(now time.Time) {
+	if !b.refilledAt.IsZero() && now.After(b.refilledAt) {
+		earned := now.Sub(b.refilledAt).Seconds() * b.rate
+		b.tokens = math.Min(b.tokens+earned, b.capacity)
+	}
+	if now.After(b.refilledAt) {
+		b.refilledAt = now
+	}
+}
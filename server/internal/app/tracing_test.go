@@ -0,0 +1,152 @@
+package app_test
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	. "github.com/onsi/gomega"
+	"github.com/varfrog/quicpubsub/pkg/buffer"
+	"github.com/varfrog/quicpubsub/pkg/tracing"
+	"github.com/varfrog/quicpubsub/server/internal/app"
+	mocks "github.com/varfrog/quicpubsub/server/internal/app/mocks"
+	"go.uber.org/mock/gomock"
+	"go.uber.org/zap"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestObserver_TracesMessages(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	g := NewGomegaWithT(t)
+
+	message := []byte("0123456789")
+
+	healthy := mocks.NewMockSubscriber(ctrl)
+	healthy.EXPECT().GetID().AnyTimes().Return("healthy")
+	healthy.EXPECT().SendMessageToSubscriber(message).Times(3).Return(nil)
+	broken := mocks.NewMockSubscriber(ctrl)
+	broken.EXPECT().GetID().AnyTimes().Return("broken")
+	broken.EXPECT().SendMessageToSubscriber(message).Times(3).Return(errors.New("stream closed"))
+
+	subscriberPool := app.NewSubscriberPool()
+	g.Expect(subscriberPool.Add(healthy)).To(Succeed())
+	g.Expect(subscriberPool.Add(broken)).To(Succeed())
+
+	recorder := &spanRecorder{}
+	tracer := tracing.NewTracer("quicpubsub-server", recorder, zap.NewNop())
+	dispatcher := app.NewDispatcher(app.DispatcherConfig{Workers: 2, QueueSize: 1}, subscriberPool, nil, zap.NewNop())
+	defer dispatcher.Stop()
+	observer := app.NewObserver(app.NewPublisherPool(), subscriberPool, dispatcher, nil, nil, nil, tracer, nil, zap.NewNop())
+	g.Expect(observer.Traces()).To(BeTrue())
+
+	publish, err := tracing.ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	g.Expect(err).NotTo(HaveOccurred())
+	unsampled := publish
+	unsampled.Sampled = false
+	for _, trace := range []tracing.SpanContext{publish, unsampled, {}} {
+		origin := app.MessageOrigin{Tenant: app.DefaultTenant, Identity: "alice", Trace: trace, ReceivedAt: time.Now()}
+		g.Expect(observer.OnPublisherMessage(origin, buffer.Wrap(message))).To(Succeed())
+	}
+	g.Expect(dispatcher.Flush(context.Background())).To(Succeed())
+	g.Expect(tracer.Close()).To(Succeed())
+
+	spans := recorder.spans()
+	g.Expect(spans).To(HaveLen(4), "only the sampled message is traced")
+	byName := make(map[string][]recordedSpan)
+	for _, span := range spans {
+		g.Expect(span.TraceID).To(Equal(hex.EncodeToString(publish.TraceID[:])))
+		byName[span.Name] = append(byName[span.Name], span)
+	}
+
+	receive := byName[tracing.SpanReceive]
+	g.Expect(receive).To(HaveLen(1))
+	g.Expect(receive[0].ParentSpanID).To(Equal(hex.EncodeToString(publish.SpanID[:])))
+	g.Expect(receive[0].attribute(tracing.AttributePublisher)).To(Equal("alice"))
+
+	route := byName[tracing.SpanRoute]
+	g.Expect(route).To(HaveLen(1))
+	g.Expect(route[0].ParentSpanID).To(Equal(receive[0].SpanID))
+
+	writes := byName[tracing.SpanWrite]
+	sort.Slice(writes, func(i, j int) bool {
+		return writes[i].attribute(tracing.AttributeSubscriber) < writes[j].attribute(tracing.AttributeSubscriber)
+	})
+	g.Expect(writes).To(HaveLen(2))
+	for _, write := range writes {
+		g.Expect(write.ParentSpanID).To(Equal(route[0].SpanID))
+	}
+	g.Expect(writes[0].attribute(tracing.AttributeSubscriber)).To(Equal("broken"))
+	g.Expect(writes[0].Status.Message).To(Equal("stream closed"))
+	g.Expect(writes[1].attribute(tracing.AttributeSubscriber)).To(Equal("healthy"))
+	g.Expect(writes[1].Status.Message).To(BeEmpty())
+}
+
+// spanRecorder is a tracing.Exporter that decodes the spans it exports.
+type spanRecorder struct {
+	mu       sync.Mutex
+	payloads [][]byte
+}
+
+func (r *spanRecorder) Export(payload []byte) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.payloads = append(r.payloads, payload)
+	return nil
+}
+
+func (r *spanRecorder) Close() error { return nil }
+
+func (r *spanRecorder) spans() []recordedSpan {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var spans []recordedSpan
+	for _, payload := range r.payloads {
+		var request struct {
+			ResourceSpans []struct {
+				ScopeSpans []struct {
+					Spans []recordedSpan `json:"spans"`
+				} `json:"scopeSpans"`
+			} `json:"resourceSpans"`
+		}
+		if err := json.Unmarshal(payload, &request); err != nil {
+			panic(err)
+		}
+		for _, resourceSpans := range request.ResourceSpans {
+			for _, scopeSpans := range resourceSpans.ScopeSpans {
+				spans = append(spans, scopeSpans.Spans...)
+			}
+		}
+	}
+	return spans
+}
+
+// recordedSpan is the part of a span in OTLP JSON that the tests check.
+type recordedSpan struct {
+	TraceID      string `json:"traceId"`
+	SpanID       string `json:"spanId"`
+	ParentSpanID string `json:"parentSpanId"`
+	Name         string `json:"name"`
+	Attributes   []struct {
+		Key   string `json:"key"`
+		Value struct {
+			StringValue string `json:"stringValue"`
+		} `json:"value"`
+	} `json:"attributes"`
+	Status struct {
+		Message string `json:"message"`
+	} `json:"status"`
+}
+
+// attribute returns the string value of the attribute with the key, empty if the span does not have it.
+func (s recordedSpan) attribute(key string) string {
+	for _, a := range s.Attributes {
+		if a.Key == key {
+			return a.Value.StringValue
+		}
+	}
+	return ""
+}
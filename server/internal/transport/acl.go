@@ -0,0 +1,43 @@
+package transport
+
+import (
+	"github.com/quic-go/quic-go"
+	"github.com/varfrog/quicpubsub/pkg/audit"
+	"github.com/varfrog/quicpubsub/pkg/quichelper"
+	"github.com/varfrog/quicpubsub/pkg/sdk"
+	"github.com/varfrog/quicpubsub/server/internal/app"
+	"go.uber.org/zap"
+)
+
+// messageKey returns the key of the message in the frame, see sdk.HeaderKey, and false if the frame is corrupt.
+func messageKey(frame []byte) (string, bool) {
+	headers, ok := messageHeaders(frame)
+	return headers[sdk.HeaderKey], ok
+}
+
+// messageHeaders returns the headers of the message in the frame, and false if the frame is corrupt.
+func messageHeaders(frame []byte) (map[string]string, bool) {
+	message, err := quichelper.DecodeMessage(frame)
+	if err != nil {
+		return nil, false
+	}
+	return message.Headers, true
+}
+
+// refuseAccess closes the connection of a client that the access control list does not allow to publish or
+// subscribe to anything with sdk.ErrorCodeAccessDenied, and records the denial to auditLog.
+func refuseAccess(conn quic.Connection, role string, err *app.AccessDeniedError, auditLog *audit.Log, logger *zap.Logger) {
+	logger.Info("Refusing a connection",
+		zap.String("remote_addr", conn.RemoteAddr().String()), zap.String("reason", err.Error()))
+	auditLog.Record(accessDeniedRecord(conn, role, err))
+	_ = conn.CloseWithError(sdk.ErrorCodeAccessDenied, err.Error())
+}
+
+// accessDeniedRecord returns the audit record of a client connecting as role being denied access.
+func accessDeniedRecord(conn quic.Connection, role string, err *app.AccessDeniedError) audit.Record {
+	record := auditRecord(audit.EventAccessDenied, conn, role)
+	record.Action = err.Action
+	record.Key = err.Subject
+	record.Reason = err.Error()
+	return record
+}
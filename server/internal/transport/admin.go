@@ -0,0 +1,249 @@
+package transport
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/pkg/errors"
+	"github.com/varfrog/quicpubsub/pkg/audit"
+	"github.com/varfrog/quicpubsub/pkg/auth"
+	"github.com/varfrog/quicpubsub/server/internal/app"
+	"go.uber.org/zap"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Actions of the admin API, as recorded to the audit trail.
+const (
+	AdminActionDisconnect = "disconnect"
+	AdminActionPause      = "pause"
+	AdminActionResume     = "resume"
+	AdminActionDrain      = "drain"
+)
+
+// adminRole is the role of admin API calls in the audit trail.
+const adminRole = "admin"
+
+// AdminHandler serves the admin API of app.Admin over HTTP with JSON:
+//   - GET /admin/publishers and GET /admin/subscribers list the connections
+//   - GET /admin/state tells whether subscribers exist per tenant, and whether the server is draining
+//   - POST /admin/disconnect?id=ID disconnects the publisher and subscriber with the ID
+//   - POST /admin/pause?id=ID and POST /admin/resume?id=ID pause and resume the publisher with the ID
+//   - POST /admin/drain starts shutting the server down
+//
+// If clients authenticate with tokens, calls must present a token that allows auth.RoleAdmin in an
+// "Authorization: Bearer" header. POST calls must have a "Content-Type: application/json" header, which browsers
+// do not send across origins without asking the server first, so that web pages cannot make browsers of operators
+// call the API. Actions are recorded to the audit trail.
+type AdminHandler struct {
+	admin         *app.Admin
+	authenticator *app.Authenticator // Nil if calls are not authenticated
+	auditLog      *audit.Log         // Nil if nothing is audited
+	mux           *http.ServeMux
+	logger        *zap.Logger
+}
+
+var _ http.Handler = (*AdminHandler)(nil)
+
+// NewAdminHandler is the constructor for AdminHandler. authenticator is optional, without it anyone who can reach
+// the API may call it, so it should listen on a loopback or otherwise private address.
+func NewAdminHandler(
+	admin *app.Admin,
+	authenticator *app.Authenticator,
+	auditLog *audit.Log,
+	logger *zap.Logger,
+) *AdminHandler {
+	h := &AdminHandler{
+		admin:         admin,
+		authenticator: authenticator,
+		auditLog:      auditLog,
+		mux:           http.NewServeMux(),
+		logger:        logger,
+	}
+	h.mux.HandleFunc("/admin/publishers", h.get(h.publishers))
+	h.mux.HandleFunc("/admin/subscribers", h.get(h.subscribers))
+	h.mux.HandleFunc("/admin/state", h.get(h.state))
+	h.mux.HandleFunc("/admin/disconnect", h.post(AdminActionDisconnect, h.admin.Disconnect))
+	h.mux.HandleFunc("/admin/pause", h.post(AdminActionPause, h.admin.PausePublisher))
+	h.mux.HandleFunc("/admin/resume", h.post(AdminActionResume, h.admin.ResumePublisher))
+	h.mux.HandleFunc("/admin/drain", h.post(AdminActionDrain, func(string) error {
+		h.admin.Drain()
+		return nil
+	}))
+	return h
+}
+
+func (h *AdminHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if h.authenticator != nil {
+		token, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		claims, err := h.authenticator.Authenticate(token, []string{auth.RoleAdmin}, time.Now())
+		if err != nil {
+			h.logger.Info("Refusing an admin API call",
+				zap.String("remote_addr", r.RemoteAddr), zap.String("reason", err.Error()))
+			h.auditLog.Record(audit.Record{
+				Event:      audit.EventAuthenticationFailed,
+				Role:       adminRole,
+				RemoteAddr: r.RemoteAddr,
+				Reason:     err.Error(),
+			})
+			writeJSONError(w, http.StatusUnauthorized, err)
+			return
+		}
+		r = r.WithContext(withAdminIdentity(r.Context(), claims.Name))
+	}
+	h.mux.ServeHTTP(w, r)
+}
+
+// get handles GET requests with fn, which returns the response.
+func (h *AdminHandler) get(fn func() any) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeJSONError(w, http.StatusMethodNotAllowed, errors.New("use GET"))
+			return
+		}
+		writeJSON(w, http.StatusOK, fn())
+	}
+}
+
+// post handles POST requests that take the action on the connection of the "id" query parameter, if the action
+// needs one, and records the action to the audit trail.
+func (h *AdminHandler) post(action string, fn func(id string) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeJSONError(w, http.StatusMethodNotAllowed, errors.New("use POST"))
+			return
+		}
+		if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType != "application/json" {
+			writeJSONError(w, http.StatusUnsupportedMediaType, errors.New("use Content-Type: application/json"))
+			return
+		}
+		id := r.URL.Query().Get("id")
+		if id == "" && action != AdminActionDrain {
+			writeJSONError(w, http.StatusBadRequest, errors.New("specify the connection with query parameter id"))
+			return
+		}
+
+		record := audit.Record{
+			Event:      audit.EventAdmin,
+			Role:       adminRole,
+			ID:         id,
+			Identity:   adminIdentity(r.Context()),
+			RemoteAddr: r.RemoteAddr,
+			Action:     action,
+		}
+		if err := fn(id); err != nil {
+			status := http.StatusInternalServerError
+			if errors.Is(err, app.ErrConnectionNotFound) {
+				status = http.StatusNotFound
+			}
+			record.Reason = err.Error()
+			h.auditLog.Record(record)
+			writeJSONError(w, status, err)
+			return
+		}
+		h.auditLog.Record(record)
+		writeJSON(w, http.StatusOK, map[string]string{"result": "ok"})
+	}
+}
+
+// connectionJSON is the JSON of an app.ConnectionInfo.
+type connectionJSON struct {
+	ID           string     `json:"id"`
+	Tenant       string     `json:"tenant,omitempty"`
+	Identity     string     `json:"identity,omitempty"`
+	RemoteAddr   string     `json:"remote_addr,omitempty"`
+	ConnectedAt  *time.Time `json:"connected_at,omitempty"` // Nil for other servers of the cluster and bridges
+	Messages     uint64     `json:"messages"`
+	Bytes        uint64     `json:"bytes"`
+	PingRTTMs    float64    `json:"ping_rtt_ms"`
+	PingJitterMs float64    `json:"ping_jitter_ms"`
+	PingLoss     float64    `json:"ping_loss"`
+	Degraded     bool       `json:"degraded"`
+}
+
+func newConnectionJSON(info app.ConnectionInfo) connectionJSON {
+	c := connectionJSON{
+		ID:           info.ID,
+		Tenant:       info.Tenant,
+		Identity:     info.Identity,
+		RemoteAddr:   info.RemoteAddr,
+		Messages:     info.Messages,
+		Bytes:        info.Bytes,
+		PingRTTMs:    float64(info.PingRTT) / float64(time.Millisecond),
+		PingJitterMs: float64(info.PingJitter) / float64(time.Millisecond),
+		PingLoss:     info.PingLoss,
+		Degraded:     info.Degraded,
+	}
+	if !info.ConnectedAt.IsZero() {
+		c.ConnectedAt = &info.ConnectedAt
+	}
+	return c
+}
+
+type publisherJSON struct {
+	connectionJSON
+	Paused bool `json:"paused"`
+}
+
+type subscriberJSON struct {
+	connectionJSON
+	HasDemand bool `json:"has_demand"`
+}
+
+// adminStateJSON is the response of GET /admin/state.
+type adminStateJSON struct {
+	Draining         bool            `json:"draining"`
+	Publishers       int             `json:"publishers"`
+	Subscribers      int             `json:"subscribers"`
+	SubscribersExist map[string]bool `json:"subscribers_exist"` // By tenant, "" for the default tenant
+}
+
+func (h *AdminHandler) publishers() any {
+	publishers := []publisherJSON{}
+	for _, info := range h.admin.Publishers() {
+		publishers = append(publishers, publisherJSON{connectionJSON: newConnectionJSON(info), Paused: info.Paused})
+	}
+	return publishers
+}
+
+func (h *AdminHandler) subscribers() any {
+	subscribers := []subscriberJSON{}
+	for _, info := range h.admin.Subscribers() {
+		subscribers = append(subscribers,
+			subscriberJSON{connectionJSON: newConnectionJSON(info), HasDemand: info.HasDemand})
+	}
+	return subscribers
+}
+
+func (h *AdminHandler) state() any {
+	return adminStateJSON{
+		Draining:         h.admin.Draining(),
+		Publishers:       len(h.admin.Publishers()),
+		Subscribers:      len(h.admin.Subscribers()),
+		SubscribersExist: h.admin.SubscriberExistence(),
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body) // Fails only if the client has gone
+}
+
+func writeJSONError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+// adminIdentityKey is the context key of the identity of the caller of the admin API.
+type adminIdentityKey struct{}
+
+func withAdminIdentity(ctx context.Context, identity string) context.Context {
+	return context.WithValue(ctx, adminIdentityKey{}, identity)
+}
+
+// adminIdentity returns the name of the token of the caller of the admin API, empty if calls are not authenticated.
+func adminIdentity(ctx context.Context) string {
+	identity, _ := ctx.Value(adminIdentityKey{}).(string)
+	return identity
+}
@@ -0,0 +1,110 @@
+package transport_test
+
+import (
+	"encoding/json"
+	. "github.com/onsi/gomega"
+	"github.com/varfrog/quicpubsub/pkg/audit"
+	"github.com/varfrog/quicpubsub/pkg/auth"
+	"github.com/varfrog/quicpubsub/server/internal/app"
+	mocks "github.com/varfrog/quicpubsub/server/internal/app/mocks"
+	"github.com/varfrog/quicpubsub/server/internal/transport"
+	"go.uber.org/mock/gomock"
+	"go.uber.org/zap"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAdminHandler(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	g := NewGomegaWithT(t)
+
+	publisher := mocks.NewMockPublisher(ctrl)
+	publisher.EXPECT().GetID().AnyTimes().Return("alice")
+	publisher.EXPECT().Pause().Times(1)
+	subscriber := mocks.NewMockSubscriber(ctrl)
+	subscriber.EXPECT().GetID().AnyTimes().Return("bob")
+
+	publisherPool := app.NewPublisherPool()
+	subscriberPool := app.NewSubscriberPool()
+	g.Expect(publisherPool.Add(publisher)).To(Succeed())
+	g.Expect(subscriberPool.Add(subscriber)).To(Succeed())
+	observer := app.NewObserver(publisherPool, subscriberPool, nil, nil, nil, nil, nil, nil, zap.NewNop())
+	drained := false
+	admin := app.NewAdmin(publisherPool, subscriberPool, observer, func() { drained = true }, zap.NewNop())
+
+	keys := auth.Keys{"k1": []byte(strings.Repeat("k", 32))}
+	token := func(roles ...string) string {
+		token, err := auth.Sign(auth.Claims{KeyID: "k1", Name: "ops", Roles: roles, ExpiresAt: time.Now().Unix() + 60}, keys)
+		g.Expect(err).NotTo(HaveOccurred())
+		return token
+	}
+	auditPath := filepath.Join(t.TempDir(), "audit.log")
+	auditLog, err := audit.Open(audit.Config{Path: auditPath}, zap.NewNop())
+	g.Expect(err).NotTo(HaveOccurred())
+	handler := transport.NewAdminHandler(admin, app.NewAuthenticator(keys), auditLog, zap.NewNop())
+
+	call := func(method string, target string, token string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(method, target, nil)
+		if method == http.MethodPost {
+			request.Header.Set("Content-Type", "application/json")
+		}
+		if token != "" {
+			request.Header.Set("Authorization", "Bearer "+token)
+		}
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, request)
+		return recorder
+	}
+	adminToken := token(auth.RoleAdmin)
+
+	g.Expect(call(http.MethodGet, "/admin/publishers", "").Code).To(Equal(http.StatusUnauthorized))
+	g.Expect(call(http.MethodGet, "/admin/publishers", token(auth.RolePublish)).Code).
+		To(Equal(http.StatusUnauthorized), "a client token is no admin token")
+
+	response := call(http.MethodGet, "/admin/publishers", adminToken)
+	g.Expect(response.Code).To(Equal(http.StatusOK))
+	g.Expect(response.Body.String()).To(MatchJSON(
+		`[{"id": "alice", "messages": 0, "bytes": 0, "ping_rtt_ms": 0, "ping_jitter_ms": 0, "ping_loss": 0, "degraded": false, "paused": false}]`))
+
+	response = call(http.MethodGet, "/admin/state", adminToken)
+	g.Expect(response.Code).To(Equal(http.StatusOK))
+	g.Expect(response.Body.String()).To(MatchJSON(
+		`{"draining": false, "publishers": 1, "subscribers": 1, "subscribers_exist": {"": true}}`))
+
+	g.Expect(call(http.MethodGet, "/admin/pause?id=alice", adminToken).Code).To(Equal(http.StatusMethodNotAllowed))
+	g.Expect(call(http.MethodPost, "/admin/pause", adminToken).Code).To(Equal(http.StatusBadRequest))
+	simplePost := httptest.NewRequest(http.MethodPost, "/admin/pause?id=alice", nil)
+	simplePost.Header.Set("Authorization", "Bearer "+adminToken)
+	simplePost.Header.Set("Content-Type", "text/plain")
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, simplePost)
+	g.Expect(recorder.Code).To(Equal(http.StatusUnsupportedMediaType), "a POST that browsers send across origins")
+	g.Expect(call(http.MethodPost, "/admin/pause?id=alice", adminToken).Code).To(Equal(http.StatusOK))
+	g.Expect(call(http.MethodPost, "/admin/pause?id=bob", adminToken).Code).
+		To(Equal(http.StatusNotFound), "not a publisher")
+	g.Expect(call(http.MethodPost, "/admin/drain", adminToken).Code).To(Equal(http.StatusOK))
+	g.Expect(drained).To(BeTrue())
+
+	g.Expect(auditLog.Close()).To(Succeed())
+	var actions []string
+	data, err := os.ReadFile(auditPath)
+	g.Expect(err).NotTo(HaveOccurred())
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		var record audit.Record
+		g.Expect(json.Unmarshal([]byte(line), &record)).To(Succeed())
+		actions = append(actions, strings.Join([]string{record.Event, record.Identity, record.Action, record.ID}, " "))
+	}
+	g.Expect(actions).To(Equal([]string{
+		audit.EventAuthenticationFailed + "   ",
+		audit.EventAuthenticationFailed + "   ",
+		audit.EventAdmin + " ops pause alice",
+		audit.EventAdmin + " ops pause bob",
+		audit.EventAdmin + " ops drain ",
+	}))
+}
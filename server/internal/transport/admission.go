@@ -0,0 +1,83 @@
+package transport
+
+import (
+	"github.com/pkg/errors"
+	"github.com/quic-go/quic-go"
+	"github.com/varfrog/quicpubsub/pkg/audit"
+	"github.com/varfrog/quicpubsub/pkg/sdk"
+	"github.com/varfrog/quicpubsub/server/internal/app"
+	"go.uber.org/zap"
+	"net"
+	"time"
+)
+
+// RequireAddressValidation returns a function for quic.Config.RequireAddressValidation, which makes clients prove
+// their address with a Retry before their handshake is processed: always if always is true, otherwise only while
+// clients connect at least as fast as the handshake rate allows. This keeps the server from being used to amplify
+// traffic towards spoofed addresses.
+func RequireAddressValidation(admission *app.Admission, always bool) func(net.Addr) bool {
+	return func(net.Addr) bool {
+		return always || admission.UnderLoad(time.Now())
+	}
+}
+
+// admit checks a connection that has just been accepted against the limits of admission, including the quotas of
+// its tenant, see connTenant. A refused connection is closed with sdk.ErrorCodeConnectionLimit,
+// sdk.ErrorCodeHandshakeRateLimit, sdk.ErrorCodeUnknownTenant or sdk.ErrorCodeQuotaExceeded and false is returned.
+// An admitted connection counts towards the limits until it is closed. Refusals are recorded to auditLog.
+func admit(
+	conn quic.Connection,
+	role string,
+	admission *app.Admission,
+	auditLog *audit.Log,
+	logger *zap.Logger,
+) bool {
+	ip := remoteIP(conn)
+	tenant, err := connTenant(conn)
+	if err == nil {
+		err = admission.Admit(ip, tenant, time.Now())
+	} else {
+		err = errors.Wrap(app.ErrUnknownTenant, err.Error())
+	}
+	if err != nil {
+		logger.Info("Refusing a connection",
+			zap.String("remote_addr", conn.RemoteAddr().String()), zap.String("reason", err.Error()))
+		record := auditRecord(audit.EventRefused, conn, role)
+		record.Reason = err.Error()
+		auditLog.Record(record)
+		go func() {
+			// Waits for the close to be sent, so don't hold up accepting
+			_ = conn.CloseWithError(refusalCode(err), err.Error())
+		}()
+		return false
+	}
+
+	go func() {
+		<-conn.Context().Done()
+		admission.Release(ip, tenant)
+	}()
+	return true
+}
+
+// refusalCode returns the code with which to close a connection that admission refuses with err.
+func refusalCode(err error) quic.ApplicationErrorCode {
+	var quotaErr *app.QuotaError
+	switch {
+	case errors.Is(err, app.ErrHandshakeRateLimit):
+		return sdk.ErrorCodeHandshakeRateLimit
+	case errors.Is(err, app.ErrUnknownTenant):
+		return sdk.ErrorCodeUnknownTenant
+	case errors.As(err, &quotaErr):
+		return sdk.ErrorCodeQuotaExceeded
+	default:
+		return sdk.ErrorCodeConnectionLimit
+	}
+}
+
+// remoteIP returns the IP address of the client, by which connections are limited per client.
+func remoteIP(conn quic.Connection) string {
+	if addr, ok := conn.RemoteAddr().(*net.UDPAddr); ok {
+		return addr.IP.String()
+	}
+	return conn.RemoteAddr().String()
+}
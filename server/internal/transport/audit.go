@@ -0,0 +1,19 @@
+package transport
+
+import (
+	"github.com/quic-go/quic-go"
+	"github.com/varfrog/quicpubsub/pkg/audit"
+)
+
+// auditRecord returns an audit record of the event for the connection of a client that connects as role, see the
+// sdk.ALPN* constants, with the identity, tenant and address of the client.
+func auditRecord(event string, conn quic.Connection, role string) audit.Record {
+	tenant, _ := connTenant(conn) // Empty if it does not match the certificate, then the reason tells why
+	return audit.Record{
+		Event:      event,
+		Role:       role,
+		Identity:   clientIdentity(conn),
+		Tenant:     tenant,
+		RemoteAddr: conn.RemoteAddr().String(),
+	}
+}
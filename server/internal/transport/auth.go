@@ -0,0 +1,116 @@
+package transport
+
+import (
+	"context"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/quic-go/quic-go"
+	"github.com/varfrog/quicpubsub/pkg/audit"
+	"github.com/varfrog/quicpubsub/pkg/auth"
+	"github.com/varfrog/quicpubsub/pkg/quichelper"
+	"github.com/varfrog/quicpubsub/pkg/sdk"
+	"github.com/varfrog/quicpubsub/server/internal/app"
+	"go.uber.org/zap"
+	"time"
+)
+
+const (
+	// authTimeout is how long a client has to present its token once connected.
+	authTimeout = 10 * time.Second
+
+	// maxAuthEventBytes is the max size of the event with which a client presents its token.
+	maxAuthEventBytes = 8 * 1024
+)
+
+// authenticatedConn is the connection of a client that has authenticated with a token.
+type authenticatedConn struct {
+	quic.Connection
+	claims auth.Claims
+}
+
+// authenticate authenticates a client that has been admitted and connects as role, if authenticator is not nil,
+// before the connection is handed over to be served. Clients authenticated by a verified certificate are served
+// right away. Others must present a token, see sdk.ErrorCodeUnauthenticated, and are served as an authenticatedConn,
+// so that they are identified by the name of the token. If the client is refused, the connection is closed with
+// sdk.ErrorCodeUnauthenticated and false is returned. Token authentications, and failures, are recorded to auditLog.
+func authenticate(
+	ctx context.Context,
+	conn quic.Connection,
+	role string,
+	authenticator *app.Authenticator,
+	auditLog *audit.Log,
+	logger *zap.Logger,
+) (quic.Connection, bool) {
+	if authenticator == nil || clientIdentity(conn) != "" || role == sdk.ALPNPartitionMap {
+		return conn, true // The partition map is served to anyone, as it has to be fetched before connecting
+	}
+
+	claims, err := receiveToken(ctx, conn, role, authenticator)
+	if err != nil {
+		if !errors.Is(err, app.ErrUnauthenticated) {
+			err = fmt.Errorf("%w: %w", app.ErrUnauthenticated, err)
+		}
+		logger.Info("Refusing a connection",
+			zap.String("remote_addr", conn.RemoteAddr().String()), zap.String("reason", err.Error()))
+		record := auditRecord(audit.EventAuthenticationFailed, conn, role)
+		record.Reason = err.Error()
+		auditLog.Record(record)
+		_ = conn.CloseWithError(sdk.ErrorCodeUnauthenticated, err.Error())
+		return nil, false
+	}
+	logger.Info("Client authenticated", zap.String("name", claims.Name), zap.Time("expiry", claims.Expiry()))
+	authenticated := &authenticatedConn{Connection: conn, claims: claims}
+	auditLog.Record(auditRecord(audit.EventAuthenticated, authenticated, role))
+	return authenticated, true
+}
+
+// receiveToken accepts the stream on which the client presents its token, which it opens before any other, and
+// responds with sdk.CodeAuthenticated if authenticator accepts the token.
+func receiveToken(
+	ctx context.Context,
+	conn quic.Connection,
+	role string,
+	authenticator *app.Authenticator,
+) (auth.Claims, error) {
+	ctx, cancel := context.WithTimeout(ctx, authTimeout)
+	defer cancel()
+
+	stream, err := conn.AcceptStream(ctx)
+	if err != nil {
+		return auth.Claims{}, errors.Wrap(err, "AcceptStream")
+	}
+	if err := stream.SetReadDeadline(time.Now().Add(authTimeout)); err != nil {
+		return auth.Claims{}, errors.Wrap(err, "SetReadDeadline")
+	}
+	event, err := quichelper.ReceiveStreamEvent(stream, maxAuthEventBytes)
+	if err != nil {
+		return auth.Claims{}, errors.Wrap(err, "ReceiveStreamEvent")
+	}
+	if event.Code != sdk.CodeAuthenticate {
+		return auth.Claims{}, errors.Errorf("expected a '%s' event, got '%s'", sdk.CodeAuthenticate, event.Code)
+	}
+
+	claims, err := authenticator.Authenticate(event.Token, tokenRoles(role), time.Now())
+	if err != nil {
+		return auth.Claims{}, err
+	}
+	if err := quichelper.SendEvent(stream, sdk.Event{Code: sdk.CodeAuthenticated}); err != nil {
+		return auth.Claims{}, errors.Wrap(err, "SendEvent")
+	}
+	if err := stream.Close(); err != nil {
+		return auth.Claims{}, errors.Wrap(err, "stream.Close")
+	}
+	return claims, nil
+}
+
+// tokenRoles returns the roles a token must allow for the client to connect as the ALPN role.
+func tokenRoles(role string) []string {
+	switch role {
+	case sdk.ALPNPublisher:
+		return []string{auth.RolePublish}
+	case sdk.ALPNSubscriber:
+		return []string{auth.RoleSubscribe}
+	default:
+		return []string{auth.RolePublish, auth.RoleSubscribe}
+	}
+}
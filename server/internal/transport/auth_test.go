@@ -0,0 +1,193 @@
+package transport_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	. "github.com/onsi/gomega"
+	"github.com/panjf2000/ants/v2"
+	"github.com/quic-go/quic-go"
+	"github.com/varfrog/quicpubsub/pkg/audit"
+	"github.com/varfrog/quicpubsub/pkg/auth"
+	"github.com/varfrog/quicpubsub/pkg/client"
+	"github.com/varfrog/quicpubsub/pkg/quichelper"
+	"github.com/varfrog/quicpubsub/pkg/sdk"
+	"github.com/varfrog/quicpubsub/server/internal/app"
+	"github.com/varfrog/quicpubsub/server/internal/transport"
+	"go.uber.org/zap"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTokenAuthentication(t *testing.T) {
+	logger := zap.NewNop()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	keys := auth.Keys{"k1": []byte(strings.Repeat("k", 32))}
+	serverTLSConfig := generateTLSConfig(t)
+	addr := freeUDPAddr(t)
+	subscriberPool := app.NewSubscriberPool()
+	dispatcher := app.NewDispatcher(app.DispatcherConfig{Workers: 1}, subscriberPool, nil, logger)
+	defer dispatcher.Stop()
+	observer := app.NewObserver(app.NewPublisherPool(), subscriberPool, dispatcher, nil, nil, nil, nil, nil, logger)
+	connectionPool, err := ants.NewPool(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer connectionPool.Release()
+	admission := app.NewAdmission(app.AdmissionConfig{MaxConnections: 10}, nil)
+	authenticator := app.NewAuthenticator(keys)
+	pinger := quichelper.NewPinger(quichelper.NewDefaultPingerConfig(), logger)
+	auditPath := filepath.Join(t.TempDir(), "audit.log")
+	auditLog, err := audit.Open(audit.Config{Path: auditPath}, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := transport.NewQUICServer(
+		transport.QUICServerConfig{TLSConfig: serverTLSConfig},
+		connectionPool,
+		admission,
+		authenticator,
+		auditLog,
+		transport.NewQUICPubServer(
+			transport.QUICPubServerConfig{TLSConfig: serverTLSConfig, MaxMessageBytes: 1000},
+			connectionPool, admission, authenticator, auditLog, app.NewRateLimiters(app.PublisherRateLimits{}), observer, pinger, logger),
+		transport.NewQUICSubServer(
+			transport.QUICSubServerConfig{TLSConfig: serverTLSConfig, MaxMessageBytes: 1000},
+			connectionPool, admission, authenticator, auditLog, observer, pinger, logger),
+		pinger,
+		logger)
+	go func() {
+		quicConfig := quic.Config{MaxIdleTimeout: math.MaxInt64, MaxIncomingStreams: 10, MaxIncomingUniStreams: 10}
+		if err := server.Accept(ctx, addr, quicConfig); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	runSubscriber := func(ctx context.Context, token string) error {
+		subscriber := client.NewQUICSubscriber(
+			client.QUICSubscriberConfig{
+				TLSConfig:       clientTLSConfig(sdk.ALPNSubscriber),
+				ServerAddr:      addr,
+				MaxMessageBytes: 1000,
+				Token:           token,
+			},
+			quic.Config{MaxIdleTimeout: math.MaxInt64},
+			countingHandler{}, nil, pinger, logger)
+		return subscriber.Run(ctx)
+	}
+	sign := func(roles ...string) string {
+		token, err := auth.Sign(auth.Claims{KeyID: "k1", Name: "script", Roles: roles, ExpiresAt: time.Now().Add(time.Minute).Unix()}, keys)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+
+	t.Run("Valid token", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		go func() {
+			_ = runSubscriber(ctx, sign(auth.RoleSubscribe))
+		}()
+		g.Eventually(func() []string {
+			var ids []string
+			for _, subscriber := range subscriberPool.GetAll() {
+				ids = append(ids, subscriber.GetID())
+			}
+			return ids
+		}, 5*time.Second, 10*time.Millisecond).Should(ConsistOf("script")) // Identified by the name of the token
+	})
+
+	for name, token := range map[string]string{
+		"Token of another role":   sign(auth.RolePublish),
+		"Token of an unknown key": "e30.AAAA",
+	} {
+		token := token
+		t.Run(name, func(t *testing.T) {
+			g := NewGomegaWithT(t)
+
+			ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+			defer cancel()
+			err := runSubscriber(ctx, token)
+			var refusedErr client.RefusedError
+			g.Expect(errors.As(err, &refusedErr)).To(BeTrue(), "%v", err)
+			g.Expect(refusedErr.Code).To(BeEquivalentTo(sdk.ErrorCodeUnauthenticated))
+			g.Expect(refusedErr.Reason).To(ContainSubstring("unauthenticated"))
+		})
+	}
+
+	t.Run("Audit trail", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		g.Eventually(func() []string {
+			return auditEvents(t, auditPath)
+		}, 5*time.Second, 10*time.Millisecond).Should(ContainElement("disconnected script"))
+		g.Expect(auditEvents(t, auditPath)).To(ConsistOf(
+			"authenticated script",
+			"connected script",
+			"disconnected script",
+			"authentication_failed ",
+			"authentication_failed ",
+		))
+		_, err := audit.Verify(auditPath)
+		g.Expect(err).NotTo(HaveOccurred())
+	})
+	t.Run("Second connection of an identity", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		firstErr := make(chan error, 1)
+		go func() {
+			firstErr <- runSubscriber(ctx, sign(auth.RoleSubscribe))
+		}()
+		g.Eventually(subscriberPool.GetAll, 5*time.Second, 10*time.Millisecond).Should(HaveLen(1))
+		first := subscriberPool.GetAll()[0]
+
+		// Refused, while the first connection stays
+		refuseCtx, refuseCancel := context.WithTimeout(ctx, 5*time.Second)
+		defer refuseCancel()
+		err := runSubscriber(refuseCtx, sign(auth.RoleSubscribe))
+		var refusedErr client.RefusedError
+		g.Expect(errors.As(err, &refusedErr)).To(BeTrue(), "%v", err)
+		g.Expect(refusedErr.Code).To(BeEquivalentTo(sdk.ErrorCodeDuplicateIdentity))
+		g.Consistently(subscriberPool.GetAll, 200*time.Millisecond, 10*time.Millisecond).Should(ConsistOf(first))
+
+		// Accepted once the first connection is gone
+		cancel()
+		g.Eventually(firstErr, 5*time.Second).Should(Receive(BeNil()))
+		g.Eventually(subscriberPool.GetAll, 5*time.Second, 10*time.Millisecond).Should(BeEmpty())
+		reconnectCtx, reconnectCancel := context.WithCancel(context.Background())
+		defer reconnectCancel()
+		go func() {
+			_ = runSubscriber(reconnectCtx, sign(auth.RoleSubscribe))
+		}()
+		g.Eventually(subscriberPool.GetAll, 5*time.Second, 10*time.Millisecond).Should(HaveLen(1))
+	})
+}
+
+// auditEvents returns the events of the records of the audit trail at path, each followed by the identity of the
+// client.
+func auditEvents(t *testing.T, path string) []string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var events []string
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		var record audit.Record
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatal(err)
+		}
+		events = append(events, record.Event+" "+record.Identity)
+	}
+	return events
+}
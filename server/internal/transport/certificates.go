@@ -0,0 +1,129 @@
+package transport
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/varfrog/quicpubsub/pkg/quichelper"
+	"sync/atomic"
+	"time"
+)
+
+// Certificates holds the certificate of the server and the CAs that client certificates are verified against, which
+// can be reloaded from their files while serving, so that they are rotated without dropping connections.
+// Handshakes use the ones held at the time, see Apply and withClientCAs.
+type Certificates struct {
+	certPath     string
+	keyPath      string
+	clientCAPath string // Empty if clients are not verified by their certificates
+	cert         atomic.Pointer[tls.Certificate]
+	clientCAs    atomic.Pointer[x509.CertPool]
+}
+
+// LoadCertificates loads the key pair of the server and the optional bundle of client CAs, see Reload.
+func LoadCertificates(certPath string, keyPath string, clientCAPath string, now time.Time) (*Certificates, error) {
+	c := &Certificates{certPath: certPath, keyPath: keyPath, clientCAPath: clientCAPath}
+	if err := c.Reload(now); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Reload loads the files again and swaps in what they hold. If the key does not match the certificate, the
+// certificate is not valid at now, or the client CA bundle is invalid, nothing is swapped and an error is returned.
+func (c *Certificates) Reload(now time.Time) error {
+	cert, err := tls.LoadX509KeyPair(c.certPath, c.keyPath)
+	if err != nil {
+		return errors.Wrap(err, "tls.LoadX509KeyPair")
+	}
+	cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return errors.Wrap(err, "x509.ParseCertificate")
+	}
+	if now.Before(cert.Leaf.NotBefore) {
+		return fmt.Errorf("the certificate is not valid until %s", cert.Leaf.NotBefore.Format(time.RFC3339))
+	}
+	if now.After(cert.Leaf.NotAfter) {
+		return fmt.Errorf("the certificate expired at %s", cert.Leaf.NotAfter.Format(time.RFC3339))
+	}
+
+	var clientCAs *x509.CertPool
+	if c.clientCAPath != "" {
+		clientCAs, err = quichelper.LoadCertPool(c.clientCAPath)
+		if err != nil {
+			return errors.Wrap(err, "LoadCertPool")
+		}
+	}
+
+	c.cert.Store(&cert)
+	c.clientCAs.Store(clientCAs)
+	return nil
+}
+
+// Leaf returns the certificate of the server.
+func (c *Certificates) Leaf() *x509.Certificate {
+	return c.cert.Load().Leaf
+}
+
+// DaysToExpiry returns the number of days from now until the certificate of the server expires.
+func (c *Certificates) DaysToExpiry(now time.Time) float64 {
+	return c.Leaf().NotAfter.Sub(now).Hours() / 24
+}
+
+// Paths returns the paths of the files the certificates are loaded from.
+func (c *Certificates) Paths() []string {
+	paths := []string{c.certPath, c.keyPath}
+	if c.clientCAPath != "" {
+		paths = append(paths, c.clientCAPath)
+	}
+	return paths
+}
+
+// GetCertificate returns the certificate held at the time, for tls.Config.GetCertificate.
+func (c *Certificates) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return c.cert.Load(), nil
+}
+
+// GetClientCertificate returns the certificate held at the time, for tls.Config.GetClientCertificate, so that this
+// server presents it to other servers too.
+func (c *Certificates) GetClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	return c.cert.Load(), nil
+}
+
+// Apply makes config present the certificate held at the time of each handshake, and verify client certificates
+// against the client CAs held now. Listeners derived from config verify client certificates against the client CAs
+// held at the time of each handshake, see withClientCAs.
+func (c *Certificates) Apply(config *tls.Config) {
+	config.Certificates = nil
+	config.GetCertificate = c.GetCertificate
+	config.ClientCAs = c.clientCAs.Load()
+}
+
+// withClientCAs returns config, as derived for a listener, such that client certificates are verified against
+// the client CAs that certificates hold at the time of each handshake, if certificates is not nil. The config that
+// the GetConfigForClient of config selects, e.g. that of negotiateTenantALPN, is used with those CAs.
+func withClientCAs(config *tls.Config, certificates *Certificates) *tls.Config {
+	if certificates == nil || certificates.clientCAPath == "" {
+		return config
+	}
+
+	selectConfig := config.GetConfigForClient
+	config = config.Clone()
+	config.GetConfigForClient = func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+		selected := config
+		if selectConfig != nil {
+			selectedConfig, err := selectConfig(hello)
+			if err != nil {
+				return nil, err
+			}
+			if selectedConfig != nil {
+				selected = selectedConfig
+			}
+		}
+		selected = selected.Clone()
+		selected.ClientCAs = certificates.clientCAs.Load()
+		return selected, nil
+	}
+	return config
+}
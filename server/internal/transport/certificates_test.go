@@ -0,0 +1,142 @@
+package transport
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	. "github.com/onsi/gomega"
+	"github.com/varfrog/quicpubsub/pkg/sdk"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCertificates_Reload(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	now := time.Date(2024, 10, 1, 0, 0, 0, 0, time.UTC)
+	dir := t.TempDir()
+	certPath := filepath.Join(dir, "cert.pem")
+	keyPath := filepath.Join(dir, "private.key")
+	caPath := filepath.Join(dir, "ca.pem")
+
+	writeKeyPair(t, certPath, keyPath, "first", now.Add(-time.Hour), now.Add(time.Hour*24*90))
+	writeKeyPair(t, caPath, filepath.Join(dir, "ca.key"), "ca", now.Add(-time.Hour), now.Add(time.Hour*24*365))
+
+	certificates, err := LoadCertificates(certPath, keyPath, caPath, now)
+	g.Expect(err).To(BeNil())
+	g.Expect(certificates.Leaf().Subject.CommonName).To(Equal("first"))
+	g.Expect(certificates.DaysToExpiry(now)).To(BeNumerically("~", 90, 0.01))
+	g.Expect(certificates.Paths()).To(Equal([]string{certPath, keyPath, caPath}))
+
+	config := &tls.Config{}
+	certificates.Apply(config)
+	cert, err := config.GetCertificate(nil)
+	g.Expect(err).To(BeNil())
+	g.Expect(cert.Leaf.Subject.CommonName).To(Equal("first"))
+	clientCAs := config.ClientCAs
+	g.Expect(clientCAs).ToNot(BeNil())
+
+	// A valid pair is swapped in
+	writeKeyPair(t, certPath, keyPath, "second", now.Add(-time.Hour), now.Add(time.Hour*24*10))
+	writeKeyPair(t, caPath, filepath.Join(dir, "ca.key"), "ca2", now.Add(-time.Hour), now.Add(time.Hour*24*365))
+	g.Expect(certificates.Reload(now)).To(Succeed())
+	cert, _ = config.GetCertificate(nil)
+	g.Expect(cert.Leaf.Subject.CommonName).To(Equal("second"))
+	g.Expect(certificates.DaysToExpiry(now)).To(BeNumerically("~", 10, 0.01))
+	g.Expect(certificates.clientCAs.Load().Equal(clientCAs)).To(BeFalse())
+
+	// A key that does not match the certificate is refused
+	writeKeyPair(t, filepath.Join(dir, "other.pem"), keyPath, "other", now.Add(-time.Hour), now.Add(time.Hour))
+	g.Expect(certificates.Reload(now)).ToNot(Succeed())
+	g.Expect(certificates.Leaf().Subject.CommonName).To(Equal("second"))
+
+	// An expired certificate is refused, as is one that is not valid yet
+	writeKeyPair(t, certPath, keyPath, "expired", now.Add(-time.Hour*2), now.Add(-time.Hour))
+	g.Expect(certificates.Reload(now)).ToNot(Succeed())
+	writeKeyPair(t, certPath, keyPath, "future", now.Add(time.Hour), now.Add(time.Hour*2))
+	g.Expect(certificates.Reload(now)).ToNot(Succeed())
+	g.Expect(certificates.Leaf().Subject.CommonName).To(Equal("second"))
+
+	// An invalid client CA bundle is refused along with the pair
+	writeKeyPair(t, certPath, keyPath, "third", now.Add(-time.Hour), now.Add(time.Hour))
+	g.Expect(os.WriteFile(caPath, []byte("not a certificate"), 0600)).To(Succeed())
+	g.Expect(certificates.Reload(now)).ToNot(Succeed())
+	g.Expect(certificates.Leaf().Subject.CommonName).To(Equal("second"))
+}
+
+func TestWithClientCAs(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	now := time.Now()
+	dir := t.TempDir()
+	certPath := filepath.Join(dir, "cert.pem")
+	keyPath := filepath.Join(dir, "private.key")
+	caPath := filepath.Join(dir, "ca.pem")
+	writeKeyPair(t, certPath, keyPath, "server", now.Add(-time.Hour), now.Add(time.Hour))
+	writeKeyPair(t, caPath, filepath.Join(dir, "ca.key"), "ca", now.Add(-time.Hour), now.Add(time.Hour))
+
+	g.Expect(withClientCAs(&tls.Config{}, nil).GetConfigForClient).To(BeNil())
+
+	certificates, err := LoadCertificates(certPath, keyPath, caPath, now)
+	g.Expect(err).To(BeNil())
+	base := &tls.Config{}
+	certificates.Apply(base)
+	config := withClientCAs(withRoleALPN(base), certificates)
+
+	// The CAs held at the time of the handshake are used with the config that negotiateTenantALPN selects
+	writeKeyPair(t, caPath, filepath.Join(dir, "ca.key"), "ca2", now.Add(-time.Hour), now.Add(time.Hour))
+	g.Expect(certificates.Reload(now)).To(Succeed())
+
+	protocol := sdk.TenantALPN(sdk.ALPNPublisher, "acme")
+	selected, err := config.GetConfigForClient(&tls.ClientHelloInfo{SupportedProtos: []string{protocol}})
+	g.Expect(err).To(BeNil())
+	g.Expect(selected.NextProtos).To(Equal([]string{protocol}))
+	g.Expect(selected.ClientCAs.Equal(certificates.clientCAs.Load())).To(BeTrue())
+	g.Expect(selected.ClientCAs.Equal(base.ClientCAs)).To(BeFalse())
+
+	selected, err = config.GetConfigForClient(&tls.ClientHelloInfo{})
+	g.Expect(err).To(BeNil())
+	g.Expect(selected.NextProtos).To(Equal(config.NextProtos))
+	g.Expect(selected.ClientCAs.Equal(certificates.clientCAs.Load())).To(BeTrue())
+}
+
+// writeKeyPair writes a new self-signed certificate with the common name, valid from notBefore until notAfter, and its
+// key to PEM files.
+func writeKeyPair(t *testing.T, certPath string, keyPath string, commonName string, notBefore, notAfter time.Time) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: commonName},
+		NotBefore:             notBefore,
+		NotAfter:              notAfter,
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	certDER, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	if err := os.WriteFile(certPath, certPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyPath, keyPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,89 @@
+package transport
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	"github.com/quic-go/quic-go"
+	"github.com/varfrog/quicpubsub/pkg/audit"
+	"github.com/varfrog/quicpubsub/pkg/sdk"
+	"github.com/varfrog/quicpubsub/server/internal/app"
+	"go.uber.org/zap"
+)
+
+// ParseClientAuth parses how the server authenticates clients by their certificates: "none", "verify-if-given"
+// (clients may present a certificate, which is verified if they do) or "require" (clients must present
+// a certificate that verifies).
+func ParseClientAuth(clientAuth string) (tls.ClientAuthType, error) {
+	switch clientAuth {
+	case "none":
+		return tls.NoClientCert, nil
+	case "verify-if-given":
+		return tls.VerifyClientCertIfGiven, nil
+	case "require":
+		return tls.RequireAndVerifyClientCert, nil
+	default:
+		return 0, fmt.Errorf("unknown client auth '%s', expected one of none, verify-if-given, require", clientAuth)
+	}
+}
+
+// clientIdentity returns the identity of the client by the name of its token if it has authenticated with one,
+// or by its verified certificate, see certificateIdentity, or "" if the client has presented neither.
+func clientIdentity(conn quic.Connection) string {
+	if authenticated, ok := conn.(*authenticatedConn); ok {
+		return authenticated.claims.Name
+	}
+	state := conn.ConnectionState().TLS
+	if len(state.VerifiedChains) == 0 || len(state.PeerCertificates) == 0 {
+		return ""
+	}
+	return certificateIdentity(state.PeerCertificates[0])
+}
+
+// connectorID returns the ID of a publisher or subscriber of a client with the given identity, which is the identity
+// itself, so that it stays the same across connections, or a random ID for anonymous clients. Hence an identity can
+// have a single publisher and a single subscriber connected at a time, further connections are refused, see
+// refuseConnector.
+func connectorID(identity string) string {
+	if identity == "" {
+		return uuid.New().String()
+	}
+	return identity
+}
+
+// refuseConnector closes the connection of a publisher or subscriber with the ID that the observer has not added for
+// err, with sdk.ErrorCodeDuplicateIdentity if another connection of the client's identity is connected, or else
+// sdk.ErrorCodeInternal, and records the refusal to auditLog.
+func refuseConnector(conn quic.Connection, role, id string, err error, auditLog *audit.Log, logger *zap.Logger) {
+	var (
+		code   quic.ApplicationErrorCode = sdk.ErrorCodeInternal
+		reason                           = err.Error()
+	)
+	if errors.Is(err, app.ErrDuplicateID) {
+		code, reason = sdk.ErrorCodeDuplicateIdentity, fmt.Sprintf("identity '%s' is connected already", id)
+	}
+	logger.Info("Refusing a connection",
+		zap.String("remote_addr", conn.RemoteAddr().String()), zap.String("id", id), zap.Error(err))
+	record := auditRecord(audit.EventRefused, conn, role)
+	record.ID, record.Reason = id, reason
+	auditLog.Record(record)
+	_ = conn.CloseWithError(code, reason) // Never fails
+}
+
+// certificateIdentity returns the identity of a client certificate, which stays the same when the certificate is
+// renewed: its first URI, email or DNS subject alternative name, in that order, or else the common name of its
+// subject.
+func certificateIdentity(cert *x509.Certificate) string {
+	switch {
+	case len(cert.URIs) > 0:
+		return cert.URIs[0].String()
+	case len(cert.EmailAddresses) > 0:
+		return cert.EmailAddresses[0]
+	case len(cert.DNSNames) > 0:
+		return cert.DNSNames[0]
+	default:
+		return cert.Subject.CommonName
+	}
+}
@@ -0,0 +1,43 @@
+package transport
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	. "github.com/onsi/gomega"
+	"net/url"
+	"testing"
+)
+
+func TestParseClientAuth(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	clientAuth, err := ParseClientAuth("require")
+	g.Expect(err).To(BeNil())
+	g.Expect(clientAuth).To(Equal(tls.RequireAndVerifyClientCert))
+
+	_, err = ParseClientAuth("request")
+	g.Expect(err).To(HaveOccurred())
+}
+
+func TestCertificateIdentity(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	uri, _ := url.Parse("spiffe://example.com/publisher")
+	cert := &x509.Certificate{
+		Subject:        pkix.Name{CommonName: "alice"},
+		DNSNames:       []string{"alice.example.com"},
+		EmailAddresses: []string{"alice@example.com"},
+		URIs:           []*url.URL{uri},
+	}
+	g.Expect(certificateIdentity(cert)).To(Equal("spiffe://example.com/publisher"))
+
+	cert.URIs = nil
+	g.Expect(certificateIdentity(cert)).To(Equal("alice@example.com"))
+
+	cert.EmailAddresses = nil
+	g.Expect(certificateIdentity(cert)).To(Equal("alice.example.com"))
+
+	cert.DNSNames = nil
+	g.Expect(certificateIdentity(cert)).To(Equal("alice"))
+}
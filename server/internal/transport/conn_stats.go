@@ -0,0 +1,35 @@
+package transport
+
+import (
+	"github.com/varfrog/quicpubsub/pkg/quichelper"
+	"sync/atomic"
+	"time"
+)
+
+// ConnStats are the statistics of a client connection that the admin API shows, shared by the publisher and
+// the subscriber of a client that publishes and subscribes on one connection.
+type ConnStats struct {
+	connectedAt time.Time
+	messagesIn  atomic.Uint64 // Published by the client
+	bytesIn     atomic.Uint64
+	messagesOut atomic.Uint64 // Sent to the client
+	bytesOut    atomic.Uint64
+	ping        *quichelper.PingMonitor
+}
+
+// NewConnStats is the constructor for ConnStats. ping measures the pings of the connection.
+func NewConnStats(connectedAt time.Time, ping *quichelper.PingMonitor) *ConnStats {
+	return &ConnStats{connectedAt: connectedAt, ping: ping}
+}
+
+// received counts a message of the given size published by the client.
+func (s *ConnStats) received(bytes int) {
+	s.messagesIn.Add(1)
+	s.bytesIn.Add(uint64(bytes))
+}
+
+// sent counts a message of the given size sent to the client.
+func (s *ConnStats) sent(bytes int) {
+	s.messagesOut.Add(1)
+	s.bytesOut.Add(uint64(bytes))
+}
@@ -0,0 +1,194 @@
+package transport_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"flag"
+	"github.com/panjf2000/ants/v2"
+	"github.com/quic-go/quic-go"
+	"github.com/varfrog/quicpubsub/pkg/client"
+	"github.com/varfrog/quicpubsub/pkg/quichelper"
+	"github.com/varfrog/quicpubsub/pkg/sdk"
+	"github.com/varfrog/quicpubsub/server/internal/app"
+	"github.com/varfrog/quicpubsub/server/internal/transport"
+	"go.uber.org/zap"
+	"math"
+	"math/big"
+	"net"
+	"runtime"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var loopbackSubscribers = flag.Int("loopback-subscribers", 100,
+	"Number of subscribers of BenchmarkLoopback, e.g. -args -loopback-subscribers 10000")
+
+// BenchmarkLoopback publishes b.N messages through a server on loopback to subscribers connected over QUIC,
+// and measures the time until every subscriber has received every message.
+func BenchmarkLoopback(b *testing.B) {
+	logger := zap.NewNop()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	serverTLSConfig := generateTLSConfig(b)
+	addr := freeUDPAddr(b)
+	subscriberPool := app.NewSubscriberPool()
+	dispatcher := app.NewDispatcher(app.DispatcherConfig{Workers: runtime.NumCPU(), QueueSize: 1024}, subscriberPool, nil, logger)
+	defer dispatcher.Stop()
+	observer := app.NewObserver(app.NewPublisherPool(), subscriberPool, dispatcher, nil, nil, nil, nil, nil, logger)
+
+	connectionPool, err := ants.NewPool(*loopbackSubscribers + 10)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer connectionPool.Release()
+	admission := app.NewAdmission(app.AdmissionConfig{MaxConnections: *loopbackSubscribers + 10}, nil)
+	pinger := quichelper.NewPinger(quichelper.NewDefaultPingerConfig(), logger)
+	const maxMessageBytes = 1000
+	server := transport.NewQUICServer(
+		transport.QUICServerConfig{TLSConfig: serverTLSConfig},
+		connectionPool,
+		admission,
+		nil,
+		nil,
+		transport.NewQUICPubServer(
+			transport.QUICPubServerConfig{TLSConfig: serverTLSConfig, MaxMessageBytes: maxMessageBytes},
+			connectionPool, admission, nil, nil, app.NewRateLimiters(app.PublisherRateLimits{}), observer, pinger, logger),
+		transport.NewQUICSubServer(
+			transport.QUICSubServerConfig{TLSConfig: serverTLSConfig, MaxMessageBytes: maxMessageBytes},
+			connectionPool, admission, nil, nil, observer, pinger, logger),
+		pinger,
+		logger)
+	go func() {
+		quicConfig := quic.Config{MaxIdleTimeout: math.MaxInt64, MaxIncomingStreams: 100, MaxIncomingUniStreams: 100}
+		if err := server.Accept(ctx, addr, quicConfig); err != nil {
+			b.Error(err)
+		}
+	}()
+
+	// Connect the subscribers
+	received := &atomic.Int64{}
+	handler := countingHandler{count: received}
+	for i := 0; i < *loopbackSubscribers; i++ {
+		subscriber := client.NewQUICSubscriber(
+			client.QUICSubscriberConfig{
+				TLSConfig:       clientTLSConfig(sdk.ALPNSubscriber),
+				ServerAddr:      addr,
+				MaxMessageBytes: maxMessageBytes,
+			},
+			quic.Config{MaxIdleTimeout: math.MaxInt64},
+			handler, nil, pinger, logger)
+		go func() {
+			_ = subscriber.Run(ctx)
+		}()
+	}
+	waitFor(b, func() bool { return len(subscriberPool.GetAll()) == *loopbackSubscribers })
+
+	// Publish
+	b.ResetTimer()
+	publisher := client.NewQUICPublisher(
+		client.QUICPublisherConfig{
+			TLSConfig:       clientTLSConfig(sdk.ALPNPublisher),
+			ServerAddr:      addr,
+			MaxMessageBytes: maxMessageBytes,
+		},
+		quic.Config{MaxIdleTimeout: math.MaxInt64},
+		&countedSender{messages: b.N, message: make([]byte, 256)},
+		pinger, logger)
+	go func() {
+		_ = publisher.Run(ctx)
+	}()
+	want := int64(b.N * *loopbackSubscribers)
+	waitFor(b, func() bool { return received.Load() == want })
+	b.StopTimer()
+
+	b.ReportMetric(float64(b.N)/b.Elapsed().Seconds(), "msgs/s")
+	b.ReportMetric(float64(want)/b.Elapsed().Seconds(), "deliveries/s")
+}
+
+// countingHandler is a client.MessageHandler that counts the messages it receives.
+type countingHandler struct {
+	count *atomic.Int64
+}
+
+func (h countingHandler) HandleMessage(sdk.Message) error {
+	h.count.Add(1)
+	return nil
+}
+
+// countedSender is a client.MessageSender that sends a number of messages as fast as possible once there are
+// subscribers.
+type countedSender struct {
+	messages int
+	message  []byte
+}
+
+func (s *countedSender) StartLoop(
+	ctx context.Context,
+	recipient client.MessageRecipient,
+	sendMessagesCh <-chan bool,
+	failCh chan<- error,
+) {
+	for send := false; !send; {
+		select {
+		case <-ctx.Done():
+			return
+		case send = <-sendMessagesCh:
+		}
+	}
+	for i := 0; i < s.messages; i++ {
+		if err := recipient.SendMessageToRecipient(sdk.Message{Payload: s.message}); err != nil {
+			failCh <- err
+			return
+		}
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-sendMessagesCh:
+		}
+	}
+}
+
+// waitFor polls cond until it is true, failing the benchmark after a minute.
+func waitFor(b *testing.B, cond func() bool) {
+	deadline := time.Now().Add(time.Minute)
+	for !cond() {
+		if time.Now().After(deadline) {
+			b.Fatal("timed out")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func freeUDPAddr(b testing.TB) string {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer conn.Close()
+	return conn.LocalAddr().String()
+}
+
+func generateTLSConfig(b testing.TB) *tls.Config {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		b.Fatal(err)
+	}
+	template := x509.Certificate{SerialNumber: big.NewInt(1), NotAfter: time.Now().Add(time.Hour)}
+	certDER, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		b.Fatal(err)
+	}
+	return &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{certDER}, PrivateKey: key}}}
+}
+
+func clientTLSConfig(role string) *tls.Config {
+	return &tls.Config{InsecureSkipVerify: true, NextProtos: []string{role}}
+}
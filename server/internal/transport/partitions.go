@@ -0,0 +1,75 @@
+package transport
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/pkg/errors"
+	"github.com/quic-go/quic-go"
+	"github.com/varfrog/quicpubsub/pkg/partition"
+	"github.com/varfrog/quicpubsub/pkg/sdk"
+	"go.uber.org/zap"
+	"time"
+)
+
+// partitionMapTimeout is the time given to a client to read the partition map before its connection is closed.
+const partitionMapTimeout = time.Second * 10
+
+// PartitionConfig configures a server that owns a set of partitions. Partitioning is off if the map has no
+// partitions.
+type PartitionConfig struct {
+	Map      partition.Map
+	NodeAddr string // Client address "host:port" of this server, as listed in the map
+}
+
+// Enabled returns whether partitioning is on.
+func (c PartitionConfig) Enabled() bool {
+	return c.Map.Partitions > 0
+}
+
+// redirectAddr returns the address of the owner of the partition of key if it is not this server. Messages without
+// a key are not partitioned, any server takes them.
+func (c PartitionConfig) redirectAddr(key string) (string, bool) {
+	if !c.Enabled() || key == "" {
+		return "", false
+	}
+	owner := c.Map.Owner(key)
+	return owner, owner != c.NodeAddr
+}
+
+// redirect closes the connection of a client whose key belongs to a partition owned by the server at addr.
+func redirect(conn quic.Connection, addr string, logger *zap.Logger) {
+	logger.Info("Redirecting the client to the owner of the partition", zap.String("owner", addr))
+	if err := conn.CloseWithError(sdk.ErrorCodeWrongPartition, addr); err != nil {
+		logger.Warn("CloseWithError", zap.Error(err))
+	}
+}
+
+// servePartitionMap sends the partition map to a client that declared sdk.ALPNPartitionMap, and waits for the client
+// to close the connection.
+func servePartitionMap(ctx context.Context, conn quic.Connection, m partition.Map) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return errors.Wrap(err, "json.Marshal")
+	}
+
+	stream, err := conn.OpenUniStreamSync(ctx)
+	if err != nil {
+		return errors.Wrap(err, "OpenUniStreamSync")
+	}
+	if _, err := stream.Write(data); err != nil {
+		return errors.Wrap(err, "stream.Write")
+	}
+	if err := stream.Close(); err != nil {
+		return errors.Wrap(err, "stream.Close")
+	}
+
+	// Closing the connection right away could discard the map before the client reads it
+	select {
+	case <-conn.Context().Done():
+	case <-time.After(partitionMapTimeout):
+		if err := conn.CloseWithError(sdk.ErrorCodeNoError, "partition map timeout"); err != nil {
+			return errors.Wrap(err, "CloseWithError")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,336 @@
+package transport
+
+import (
+	"context"
+	"crypto/tls"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	"github.com/quic-go/quic-go"
+	"github.com/varfrog/quicpubsub/pkg/buffer"
+	"github.com/varfrog/quicpubsub/pkg/client"
+	"github.com/varfrog/quicpubsub/pkg/quichelper"
+	"github.com/varfrog/quicpubsub/pkg/sdk"
+	"github.com/varfrog/quicpubsub/pkg/tracing"
+	"github.com/varfrog/quicpubsub/server/internal/app"
+	"go.uber.org/zap"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// bridgeQueueSize is the number of messages that can wait to be sent to the remote server.
+const bridgeQueueSize = 1000
+
+type QUICBridgeConfig struct {
+	PubAddr         string        // Address "host:port" of the remote server for publishers
+	SubAddr         string        // Address "host:port" of the remote server for subscribers, may equal PubAddr
+	TLSConfig       *tls.Config   // For connecting to the remote server, the roles are declared via ALPN
+	MaxMessageBytes int           // Max bytes to read/write to/from streams
+	RetryInterval   time.Duration // Wait time between connection attempts
+	Token           string        // Token to authenticate with if the remote server requires one, optional
+}
+
+// QUICBridge connects to a remote server both as a publisher and as a subscriber, and forwards messages between the
+// remote server and this one as app.Bridge decides.
+//
+// To this server's observer it is a subscriber that wants messages while the remote server has subscribers. To the
+// remote server it is a subscriber that wants messages while this server has subscribers other than the bridge
+// itself, so that publishers anywhere only publish when someone anywhere is listening.
+type QUICBridge struct {
+	id              uuid.UUID
+	config          QUICBridgeConfig
+	bridge          *app.Bridge
+	observer        *app.Observer
+	publisher       *client.QUICPublisher
+	subscriber      *client.QUICSubscriber
+	outboundCh      chan sdk.Message // Messages to send to the remote server
+	remoteDemand    atomic.Bool      // Whether the remote server has subscribers
+	demandChangedCh chan struct{}    // Notified when the demand of this server may have changed
+	readyMu         sync.Mutex
+	readyCh         chan struct{} // Closed once the remote server has told its ID, guarded by readyMu
+	stopOnce        sync.Once
+	stopCh          chan struct{} // Closed once the bridge is told to stop
+	logger          *zap.Logger
+}
+
+var (
+	_ app.ConditionalSubscriber = (*QUICBridge)(nil)
+	_ client.MessageSender      = (*QUICBridge)(nil)
+	_ client.MessageHandler     = (*QUICBridge)(nil)
+)
+
+func NewQUICBridge(
+	config QUICBridgeConfig,
+	quicConfig quic.Config,
+	bridge *app.Bridge,
+	observer *app.Observer,
+	pinger *quichelper.Pinger,
+	logger *zap.Logger,
+) *QUICBridge {
+	s := &QUICBridge{
+		id:              uuid.New(),
+		config:          config,
+		bridge:          bridge,
+		observer:        observer,
+		outboundCh:      make(chan sdk.Message, bridgeQueueSize),
+		demandChangedCh: make(chan struct{}, 1),
+		stopCh:          make(chan struct{}),
+		logger:          logger,
+	}
+
+	pubTLSConfig := config.TLSConfig.Clone()
+	pubTLSConfig.NextProtos = []string{sdk.ALPNPublisher}
+	s.publisher = client.NewQUICPublisher(
+		client.QUICPublisherConfig{
+			UUID:            s.id,
+			TLSConfig:       pubTLSConfig,
+			ServerAddr:      config.PubAddr,
+			MaxMessageBytes: config.MaxMessageBytes,
+			Token:           config.Token,
+		},
+		quicConfig,
+		s,
+		pinger,
+		logger.Named("Publisher"))
+
+	subTLSConfig := config.TLSConfig.Clone()
+	subTLSConfig.NextProtos = []string{sdk.ALPNSubscriber}
+	s.subscriber = client.NewQUICSubscriber(
+		client.QUICSubscriberConfig{
+			TLSConfig:       subTLSConfig,
+			ServerAddr:      config.SubAddr,
+			MaxMessageBytes: config.MaxMessageBytes,
+			Token:           config.Token,
+			BrokerID:        bridge.LocalBrokerID(),
+		},
+		quicConfig,
+		s,
+		&localDemand{bridge: s},
+		pinger,
+		logger.Named("Subscriber"))
+
+	return s
+}
+
+// Run registers the bridge with the observer and keeps connecting to the remote server until ctx is done or the
+// bridge is told to stop.
+func (s *QUICBridge) Run(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-s.stopCh:
+			cancel()
+		}
+	}()
+
+	s.observer.AddSubscribersChangedListener(s.signalDemandChanged)
+	if err := s.observer.OnSubscriberConnected(s); err != nil {
+		return errors.Wrap(err, "OnSubscriberConnected")
+	}
+	defer func() {
+		if err := s.observer.OnSubscriberDisconnected(s); err != nil {
+			s.logger.Error("OnSubscriberDisconnected", zap.Error(err))
+		}
+	}()
+
+	for {
+		if err := s.runSession(ctx); err != nil {
+			s.logger.Warn("Bridge session failed", zap.Error(err))
+		}
+		s.setRemoteDemand(false)
+
+		select {
+		case <-ctx.Done():
+			s.logger.Info("Stopping bridging, context cancelled")
+			return nil
+		case <-time.After(s.config.RetryInterval):
+			s.logger.Info("Reconnecting to the remote server")
+		}
+	}
+}
+
+// runSession connects to the remote server until either connection ends. The subscriber connects once the
+// remote server has told its ID over the publisher connection, as messages cannot be stamped without it.
+func (s *QUICBridge) runSession(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	readyCh := make(chan struct{})
+	s.readyMu.Lock()
+	s.readyCh = readyCh
+	s.readyMu.Unlock()
+
+	pubErrCh := make(chan error, 1)
+	go func() {
+		pubErrCh <- s.publisher.Run(ctx)
+		cancel()
+	}()
+
+	select {
+	case <-ctx.Done():
+		return errors.Wrap(<-pubErrCh, "publisher.Run")
+	case <-readyCh:
+	}
+
+	subErr := s.subscriber.Run(ctx)
+	cancel()
+	if err := <-pubErrCh; err != nil {
+		return errors.Wrap(err, "publisher.Run")
+	}
+	if subErr != nil {
+		return errors.Wrap(subErr, "subscriber.Run")
+	}
+	return nil
+}
+
+// StartLoop implements client.MessageSender: it tracks whether the remote server has subscribers and sends it
+// the messages queued by SendMessageToSubscriber.
+func (s *QUICBridge) StartLoop(
+	ctx context.Context,
+	recipient client.MessageRecipient,
+	sendMessagesCh <-chan bool,
+	failCh chan<- error,
+) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case val := <-sendMessagesCh:
+			s.markReady()
+			s.setRemoteDemand(val)
+		case message := <-s.outboundCh:
+			if err := recipient.SendMessageToRecipient(message); err != nil {
+				select {
+				case <-ctx.Done():
+				case failCh <- errors.Wrap(err, "SendMessageToRecipient"):
+				}
+				return
+			}
+		}
+	}
+}
+
+// HandleMessage implements client.MessageHandler: it passes messages from the remote server to the subscribers
+// of this server.
+func (s *QUICBridge) HandleMessage(message sdk.Message) error {
+	message, ok := s.bridge.ForwardIn(message)
+	if !ok {
+		return nil
+	}
+
+	frame, err := quichelper.EncodeMessage(message)
+	if err != nil {
+		return errors.Wrap(err, "EncodeMessage")
+	}
+	origin := app.MessageOrigin{
+		Tenant:       app.DefaultTenant,
+		Trusted:      true,
+		Key:          message.Headers[sdk.HeaderKey], // For the subscribers that may receive it, see app.AccessControl
+		OriginBroker: message.Headers[sdk.HeaderOrigin],
+		MessageID:    message.Headers[sdk.HeaderMessageID],
+	}
+	if s.observer.Traces() {
+		origin.Trace, _ = tracing.FromHeaders(message.Headers)
+		origin.ReceivedAt = time.Now()
+	}
+	if err := s.observer.OnPublisherMessage(origin, buffer.Wrap(frame)); err != nil {
+		return errors.Wrap(err, "OnPublisherMessage")
+	}
+	return nil
+}
+
+// SendMessageToSubscriber queues a message of this server to be sent to the remote server. The payload is copied, as
+// the frame is only valid during the call.
+func (s *QUICBridge) SendMessageToSubscriber(frame []byte) error {
+	message, err := quichelper.DecodeMessage(frame)
+	if err != nil {
+		return errors.Wrap(err, "DecodeMessage")
+	}
+	message, ok := s.bridge.ForwardOut(message)
+	if !ok {
+		return nil
+	}
+	message.Payload = append([]byte(nil), message.Payload...)
+
+	select {
+	case s.outboundCh <- message:
+		return nil
+	default:
+		return errors.New("the bridge queue is full, dropping the message")
+	}
+}
+
+// HasDemand returns whether the remote server has subscribers.
+func (s *QUICBridge) HasDemand() bool {
+	return s.remoteDemand.Load()
+}
+
+// NotifyGoingAway stops the bridge, which disconnects from the remote server.
+func (s *QUICBridge) NotifyGoingAway() error {
+	s.stopOnce.Do(func() { close(s.stopCh) })
+	return nil
+}
+
+// Disconnect stops the bridge, which disconnects from the remote server.
+func (s *QUICBridge) Disconnect() error {
+	return s.NotifyGoingAway()
+}
+
+func (s *QUICBridge) GetID() string {
+	return s.id.String()
+}
+
+// markReady learns the ID of the remote server, which it tells with its first event, and lets the subscriber
+// connect.
+func (s *QUICBridge) markReady() {
+	s.readyMu.Lock()
+	defer s.readyMu.Unlock()
+
+	select {
+	case <-s.readyCh:
+		return // Already ready
+	default:
+	}
+
+	remoteBrokerID := s.publisher.RemoteBrokerID()
+	if remoteBrokerID == "" {
+		s.logger.Warn("The remote server has not told its ID, no messages will be forwarded")
+	}
+	s.bridge.SetRemoteBrokerID(remoteBrokerID)
+	close(s.readyCh)
+	s.logger.Info("Bridged to the remote server", zap.String("remote_broker_id", remoteBrokerID))
+}
+
+// setRemoteDemand records whether the remote server has subscribers, which matters only if messages are
+// forwarded to it.
+func (s *QUICBridge) setRemoteDemand(hasDemand bool) {
+	hasDemand = hasDemand && s.bridge.ForwardsOut()
+	if s.remoteDemand.Swap(hasDemand) != hasDemand {
+		s.observer.OnSubscriberDemandChanged(s)
+	}
+}
+
+// signalDemandChanged lets the subscriber re-check the demand of this server.
+func (s *QUICBridge) signalDemandChanged() {
+	select {
+	case s.demandChangedCh <- struct{}{}:
+	default: // A signal is pending already
+	}
+}
+
+// localDemand implements client.DemandSource for the remote server: messages are wanted while subscribers of this
+// server, other than the bridge itself and the bridges from the remote server, want them. The demand of the bridges
+// from the remote server is its own demand, which would keep the demand of both servers up for good if bridged back.
+type localDemand struct {
+	bridge *QUICBridge
+}
+
+func (d *localDemand) HasDemand() bool {
+	return d.bridge.bridge.ForwardsIn() && d.bridge.observer.HasDemandExcluding(d.bridge.GetID(), d.bridge.bridge.RemoteBrokerID())
+}
+
+func (d *localDemand) DemandChanged() <-chan struct{} {
+	return d.bridge.demandChangedCh
+}
@@ -0,0 +1,72 @@
+package transport_test
+
+import (
+	"crypto/tls"
+	. "github.com/onsi/gomega"
+	"github.com/quic-go/quic-go"
+	"github.com/varfrog/quicpubsub/pkg/quichelper"
+	"github.com/varfrog/quicpubsub/pkg/sdk"
+	"github.com/varfrog/quicpubsub/server/internal/app"
+	"github.com/varfrog/quicpubsub/server/internal/transport"
+	"go.uber.org/zap"
+	"sync"
+	"testing"
+)
+
+// identifiedSubscriber is a subscriber of a client with an identity that records the payloads of its messages.
+type identifiedSubscriber struct {
+	id       string
+	identity string
+	mu       sync.Mutex
+	payloads []string
+}
+
+func (s *identifiedSubscriber) SendMessageToSubscriber(frame []byte) error {
+	message, err := quichelper.DecodeMessage(frame)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.payloads = append(s.payloads, string(message.Payload))
+	return nil
+}
+
+func (s *identifiedSubscriber) NotifyGoingAway() error { return nil }
+func (s *identifiedSubscriber) Disconnect() error      { return nil }
+func (s *identifiedSubscriber) GetID() string          { return s.id }
+func (s *identifiedSubscriber) GetIdentity() string    { return s.identity }
+
+func (s *identifiedSubscriber) received() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.payloads...)
+}
+
+func TestQUICBridge_DeliversBridgedMessagesByACL(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	acl, err := app.NewACL(app.ACLConfig{Rules: []app.ACLRule{{Identity: "bob", Subscribe: []string{"orders.*"}}}})
+	g.Expect(err).NotTo(HaveOccurred())
+	observer := app.NewObserver(app.NewPublisherPool(), app.NewSubscriberPool(), nil, nil, app.NewAccessControl(acl),
+		nil, nil, nil, zap.NewNop())
+	bob := &identifiedSubscriber{id: "bob", identity: "bob"}
+	g.Expect(observer.OnSubscriberConnected(bob)).To(Succeed())
+
+	bridge := app.NewBridge(app.BridgeConfig{LocalBrokerID: "A", Direction: app.BridgeDirectionIn, MaxHops: 2})
+	bridge.SetRemoteBrokerID("B")
+	quicBridge := transport.NewQUICBridge(
+		transport.QUICBridgeConfig{TLSConfig: &tls.Config{}, MaxMessageBytes: 1000},
+		quic.Config{}, bridge, observer, quichelper.NewPinger(quichelper.NewDefaultPingerConfig(), zap.NewNop()),
+		zap.NewNop())
+
+	// Bridged messages are delivered to the subscribers that may receive their keys, not only to those that may
+	// receive messages without a key
+	for _, message := range []sdk.Message{
+		{Headers: map[string]string{sdk.HeaderKey: "orders.eu"}, Payload: []byte("order")},
+		{Headers: map[string]string{sdk.HeaderKey: "invoices"}, Payload: []byte("invoice")},
+	} {
+		g.Expect(quicBridge.HandleMessage(message)).To(Succeed())
+	}
+	g.Expect(bob.received()).To(Equal([]string{"order"}))
+}
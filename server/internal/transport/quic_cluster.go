@@ -0,0 +1,265 @@
+package transport
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/quic-go/quic-go"
+	"github.com/varfrog/quicpubsub/pkg/quichelper"
+	"github.com/varfrog/quicpubsub/pkg/sdk"
+	"github.com/varfrog/quicpubsub/server/internal/app"
+	"go.uber.org/zap"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+type QUICClusterConfig struct {
+	NodeAddr          string         // Address "host:port" to listen on for other servers, also the ID of this server
+	PeerAddrs         []string       // Cluster addresses of the other servers
+	TLSConfig         *tls.Config    // For accepting connections from other servers
+	PeerCAs           *x509.CertPool // CAs that issue the certificates the other servers present
+	PeerTLSConfig     *tls.Config    // For connecting to other servers
+	MaxMessageBytes   int            // Max bytes to read from message streams
+	HeartbeatInterval time.Duration  // How often heartbeats are sent to the other servers
+	HeartbeatTimeout  time.Duration  // A server which has not sent a heartbeat for this long is considered dead
+}
+
+// QUICCluster connects this server to the other servers of a cluster. Every server sends heartbeats with its
+// app.NodeState to all others, and the leader replicates the app.ClusterView with its heartbeats. Each other server
+// is an app.PeerSubscriber of the observer, so that publishers here publish while a subscriber anywhere in the
+// cluster wants messages, and messages published here reach the subscribers of the other servers. Only the servers
+// of PeerAddrs may connect, see verifyPeer.
+type QUICCluster struct {
+	config     QUICClusterConfig
+	quicConfig quic.Config
+	cluster    *app.Cluster
+	observer   *app.Observer
+	peers      []*QUICPeerConn
+	viewMu     sync.Mutex    // Serializes applying views
+	updateCh   chan struct{} // Notified when heartbeats should be sent right away
+	logger     *zap.Logger
+}
+
+func NewQUICCluster(
+	config QUICClusterConfig,
+	cluster *app.Cluster,
+	observer *app.Observer,
+	logger *zap.Logger,
+) *QUICCluster {
+	// Connections to dead servers time out without heartbeats
+	quicConfig := quic.Config{MaxIdleTimeout: config.HeartbeatTimeout}
+
+	peerTLSConfig := config.PeerTLSConfig.Clone()
+	peerTLSConfig.NextProtos = []string{sdk.ALPNPeer}
+
+	s := &QUICCluster{
+		config:     config,
+		quicConfig: quicConfig,
+		cluster:    cluster,
+		observer:   observer,
+		updateCh:   make(chan struct{}, 1),
+		logger:     logger,
+	}
+	for _, addr := range config.PeerAddrs {
+		s.peers = append(s.peers, NewQUICPeerConn(addr, peerTLSConfig, quicConfig, config.HeartbeatInterval, logger))
+	}
+	return s
+}
+
+// Run serves the other servers and sends them heartbeats until ctx is done.
+func (s *QUICCluster) Run(ctx context.Context) error {
+	tlsConfig := s.config.TLSConfig.Clone()
+	tlsConfig.NextProtos = []string{sdk.ALPNPeer}
+	tlsConfig.GetConfigForClient = nil
+	tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	tlsConfig.ClientCAs = s.config.PeerCAs
+	tlsConfig.VerifyConnection = s.verifyPeer
+	listener, err := quic.ListenAddr(s.config.NodeAddr, tlsConfig, &s.quicConfig)
+	if err != nil {
+		return errors.Wrap(err, "quic.ListenAddr")
+	}
+	defer listener.Close()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	wg := sync.WaitGroup{}
+	defer wg.Wait()
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		s.acceptPeers(ctx, listener)
+	}()
+
+	for _, peer := range s.peers {
+		peer := peer
+		if err := s.observer.OnSubscriberConnected(peer); err != nil {
+			return errors.Wrap(err, "OnSubscriberConnected")
+		}
+		defer func() {
+			if err := s.observer.OnSubscriberDisconnected(peer); err != nil {
+				s.logger.Error("OnSubscriberDisconnected", zap.Error(err))
+			}
+		}()
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			peer.Run(ctx)
+		}()
+	}
+
+	// Send the state of this server as soon as its subscribers change
+	s.observer.AddSubscribersChangedListener(s.signalUpdate)
+
+	ticker := time.NewTicker(s.config.HeartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			s.logger.Info("Leaving the cluster, context cancelled")
+			return nil
+		case <-ticker.C:
+		case <-s.updateCh:
+		}
+		s.sendHeartbeats()
+	}
+}
+
+// sendHeartbeats updates the cluster, applies the view and sends heartbeats to all other servers.
+func (s *QUICCluster) sendHeartbeats() {
+	state := s.observer.LocalNodeState(s.config.NodeAddr)
+	view := s.cluster.Update(state, time.Now())
+	s.applyView()
+
+	message := peerMessage{State: state}
+	message.Leader, message.Term, message.Version = s.cluster.Seen()
+	if s.cluster.IsLeader() {
+		message.View = &view
+	}
+	for _, peer := range s.peers {
+		if err := peer.SendControl(message); err != nil {
+			s.logger.Debug("SendControl", zap.String("addr", peer.GetNodeID()), zap.Error(err))
+		}
+	}
+}
+
+// applyView updates the demand of peers as the cluster view says, notifying the observer about changes.
+func (s *QUICCluster) applyView() {
+	s.viewMu.Lock()
+	defer s.viewMu.Unlock()
+
+	for _, peer := range s.peers {
+		if peer.SetDemand(s.cluster.NodeHasDemand(peer.GetNodeID())) {
+			s.observer.OnSubscriberDemandChanged(peer)
+		}
+	}
+}
+
+func (s *QUICCluster) signalUpdate() {
+	select {
+	case s.updateCh <- struct{}{}:
+	default: // An update is pending already
+	}
+}
+
+// verifyPeer accepts the certificates of the servers of PeerAddrs only, which must be valid for the host of one of
+// the addresses, so that other clients of PeerCAs cannot join the cluster.
+func (s *QUICCluster) verifyPeer(state tls.ConnectionState) error {
+	if len(state.PeerCertificates) == 0 {
+		return errors.New("no certificate")
+	}
+	leaf := state.PeerCertificates[0]
+	for _, addr := range s.config.PeerAddrs {
+		if host, _, err := net.SplitHostPort(addr); err == nil && leaf.VerifyHostname(host) == nil {
+			return nil
+		}
+	}
+	return fmt.Errorf("certificate of '%s' is not valid for any cluster peer", certificateIdentity(leaf))
+}
+
+// acceptPeers accepts connections from other servers until ctx is done.
+func (s *QUICCluster) acceptPeers(ctx context.Context, listener *quic.Listener) {
+	for {
+		conn, err := listener.Accept(ctx)
+		if err != nil {
+			if ctx.Err() == nil {
+				s.logger.Error("listener.Accept", zap.Error(err))
+			}
+			return
+		}
+		go s.servePeer(ctx, conn)
+	}
+}
+
+// servePeer receives heartbeats and messages from another server until the connection or ctx is done.
+func (s *QUICCluster) servePeer(ctx context.Context, conn quic.Connection) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go cancelOnConnectionClose(ctx, conn, cancel)
+
+	// A stream is accepted once data arrives on it, which the message stream may never get
+	controlStream, err := conn.AcceptUniStream(ctx)
+	if err != nil {
+		logStreamError(s.logger, "AcceptUniStream", err)
+		return
+	}
+	go func() {
+		messageStream, err := conn.AcceptUniStream(ctx)
+		if err != nil {
+			logStreamError(s.logger, "AcceptUniStream", err)
+			return
+		}
+		s.receiveMessages(ctx, messageStream)
+	}()
+
+	s.receiveControl(ctx, controlStream)
+}
+
+// receiveControl handles heartbeats and views until ctx is done or the stream ends.
+func (s *QUICCluster) receiveControl(ctx context.Context, stream quic.ReceiveStream) {
+	decoder := json.NewDecoder(stream)
+	for ctx.Err() == nil {
+		var message peerMessage
+		if err := decoder.Decode(&message); err != nil {
+			s.logger.Debug("Stopping receiving heartbeats", zap.Error(err))
+			return
+		}
+
+		s.cluster.OnSeen(message.Leader, message.Term, message.Version)
+		if s.cluster.OnHeartbeat(message.State, time.Now()) && s.cluster.IsLeader() {
+			s.signalUpdate() // Replicate the change right away
+		}
+		if message.View != nil && s.cluster.OnView(*message.View) {
+			s.applyView()
+		}
+	}
+}
+
+// receiveMessages passes messages from another server to the subscribers of this server until ctx is done or the
+// stream ends.
+func (s *QUICCluster) receiveMessages(ctx context.Context, stream quic.ReceiveStream) {
+	for ctx.Err() == nil {
+		frame, err := quichelper.ReadFrame(stream, s.config.MaxMessageBytes)
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				s.logger.Debug("Stopping receiving messages from peer", zap.Error(err))
+			}
+			return
+		}
+		var key string
+		if s.observer.NeedsKeys() {
+			key, _ = messageKey(frame.Bytes())
+		}
+		if err := s.observer.OnPeerMessage(key, frame); err != nil {
+			s.logger.Warn("OnPeerMessage", zap.Error(err))
+		}
+		frame.Release()
+	}
+}
@@ -0,0 +1,170 @@
+package transport_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	. "github.com/onsi/gomega"
+	"github.com/quic-go/quic-go"
+	"github.com/varfrog/quicpubsub/pkg/quichelper"
+	"github.com/varfrog/quicpubsub/pkg/sdk"
+	"github.com/varfrog/quicpubsub/server/internal/app"
+	"github.com/varfrog/quicpubsub/server/internal/transport"
+	"go.uber.org/zap"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+)
+
+// selfSignedCertificate returns a new self-signed certificate for the IP addresses, which can issue itself as a CA.
+func selfSignedCertificate(t *testing.T, ips ...net.IP) (tls.Certificate, *x509.Certificate) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "server"},
+		IPAddresses:           ips,
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	certDER, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(certDER)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{certDER}, PrivateKey: key, Leaf: leaf}, leaf
+}
+
+// startCluster runs a cluster of this server and an unreachable peer on loopback until ctx is done, which accepts
+// peers whose certificates peerCAs issue, and returns its address.
+func startCluster(ctx context.Context, t *testing.T, observer *app.Observer, peerCAs *x509.CertPool) string {
+	addr, peerAddr := freeUDPAddr(t), freeUDPAddr(t)
+	cluster := transport.NewQUICCluster(
+		transport.QUICClusterConfig{
+			NodeAddr:          addr,
+			PeerAddrs:         []string{peerAddr},
+			TLSConfig:         generateTLSConfig(t),
+			PeerCAs:           peerCAs,
+			PeerTLSConfig:     &tls.Config{},
+			MaxMessageBytes:   1000,
+			HeartbeatInterval: time.Millisecond * 100,
+			HeartbeatTimeout:  time.Second,
+		},
+		app.NewCluster(app.ClusterConfig{NodeID: addr, PeerIDs: []string{peerAddr}, HeartbeatTimeout: time.Second}, zap.NewNop()),
+		observer,
+		zap.NewNop())
+	go func() {
+		_ = cluster.Run(ctx)
+	}()
+	return addr
+}
+
+// deliverToCluster connects to the cluster at addr as a peer with the certificate, if any, sends a heartbeat and the
+// messages, and keeps the connection open until the test ends.
+func deliverToCluster(ctx context.Context, t *testing.T, addr string, cert *tls.Certificate, messages ...sdk.Message) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: true, NextProtos: []string{sdk.ALPNPeer}}
+	if cert != nil {
+		tlsConfig.Certificates = []tls.Certificate{*cert}
+	}
+	dialCtx, dialCancel := context.WithTimeout(ctx, 5*time.Second)
+	defer dialCancel()
+	conn, err := quichelper.DialAddr(dialCtx, addr, tlsConfig, &quic.Config{})
+	if err != nil {
+		return // Refused during the handshake
+	}
+	t.Cleanup(func() { _ = conn.CloseWithError(0, "") })
+
+	data := []byte(`{"state": {"node_id": "intruder"}}`)
+	control, err := conn.OpenUniStream()
+	if err != nil {
+		return
+	}
+	_, _ = control.Write(data)
+	stream, err := conn.OpenUniStream()
+	if err != nil {
+		return
+	}
+	for _, message := range messages {
+		frame, err := quichelper.EncodeMessage(message)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _ = stream.Write(frame)
+	}
+}
+
+func payload(payload string) sdk.Message {
+	return sdk.Message{Payload: []byte(payload)}
+}
+
+func TestQUICCluster_AcceptsPeersOnly(t *testing.T) {
+	g := NewGomegaWithT(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	loopback := net.ParseIP("127.0.0.1")
+	peerCert, peerLeaf := selfSignedCertificate(t, loopback)
+	otherHostCert, otherHostLeaf := selfSignedCertificate(t, net.ParseIP("192.0.2.1"))
+	otherCACert, _ := selfSignedCertificate(t, loopback)
+	peerCAs := x509.NewCertPool()
+	peerCAs.AddCert(peerLeaf)
+	peerCAs.AddCert(otherHostLeaf)
+
+	observer := app.NewObserver(app.NewPublisherPool(), app.NewSubscriberPool(), nil, nil, nil, nil, nil, nil, zap.NewNop())
+	subscriber := &identifiedSubscriber{id: "subscriber"}
+	g.Expect(observer.OnSubscriberConnected(subscriber)).To(Succeed())
+	addr := startCluster(ctx, t, observer, peerCAs)
+
+	deliverToCluster(ctx, t, addr, &peerCert, payload("from peer"))
+	g.Eventually(subscriber.received, 5*time.Second, 10*time.Millisecond).Should(Equal([]string{"from peer"}))
+
+	deliverToCluster(ctx, t, addr, nil, payload("without certificate"))
+	deliverToCluster(ctx, t, addr, &otherCACert, payload("of another CA"))
+	deliverToCluster(ctx, t, addr, &otherHostCert, payload("of a host that is no peer"))
+	g.Consistently(subscriber.received, 500*time.Millisecond, 10*time.Millisecond).
+		Should(Equal([]string{"from peer"}))
+}
+
+func TestQUICCluster_DeliversPeerMessagesByACL(t *testing.T) {
+	g := NewGomegaWithT(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	peerCert, peerLeaf := selfSignedCertificate(t, net.ParseIP("127.0.0.1"))
+	peerCAs := x509.NewCertPool()
+	peerCAs.AddCert(peerLeaf)
+
+	acl, err := app.NewACL(app.ACLConfig{Rules: []app.ACLRule{{Identity: "bob", Subscribe: []string{"orders.*"}}}})
+	g.Expect(err).NotTo(HaveOccurred())
+	observer := app.NewObserver(app.NewPublisherPool(), app.NewSubscriberPool(), nil, nil, app.NewAccessControl(acl),
+		nil, nil, nil, zap.NewNop())
+	bob := &identifiedSubscriber{id: "bob", identity: "bob"}
+	g.Expect(observer.OnSubscriberConnected(bob)).To(Succeed())
+	addr := startCluster(ctx, t, observer, peerCAs)
+
+	keyed := func(key string, payload string) sdk.Message {
+		return sdk.Message{Headers: map[string]string{sdk.HeaderKey: key}, Payload: []byte(payload)}
+	}
+
+	// A client that is no peer cannot publish through the cluster address, not even keys that the ACL allows
+	deliverToCluster(ctx, t, addr, nil, keyed("orders.eu", "unauthenticated"))
+
+	// Messages of peers reach the subscribers that may receive their keys only
+	deliverToCluster(ctx, t, addr, &peerCert, keyed("invoices", "invoice"), keyed("orders.eu", "order"))
+	g.Eventually(bob.received, 5*time.Second, 10*time.Millisecond).Should(Equal([]string{"order"}))
+	g.Consistently(bob.received, 500*time.Millisecond, 10*time.Millisecond).Should(Equal([]string{"order"}))
+}
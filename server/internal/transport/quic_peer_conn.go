@@ -0,0 +1,171 @@
+package transport
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/quic-go/quic-go"
+	"github.com/varfrog/quicpubsub/pkg/quichelper"
+	"github.com/varfrog/quicpubsub/server/internal/app"
+	"go.uber.org/zap"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// peerMessage is sent on the control stream between servers of a cluster.
+type peerMessage struct {
+	State   app.NodeState    `json:"state"`            // Heartbeat with the state of the sender
+	View    *app.ClusterView `json:"view,omitempty"`   // Set if the sender is the leader
+	Leader  string           `json:"leader,omitempty"` // Leader of the view the sender holds
+	Term    uint64           `json:"term"`             // Term of the view the sender holds
+	Version uint64           `json:"version"`          // Highest version of the views the sender has seen
+}
+
+// QUICPeerConn implements app.PeerSubscriber for another server of the cluster. It dials the server and keeps two
+// streams to it: a control stream for heartbeats and views, and a stream for messages.
+type QUICPeerConn struct {
+	addr          string
+	tlsConfig     *tls.Config
+	quicConfig    quic.Config
+	retryInterval time.Duration
+	mu            sync.Mutex
+	controlStream quic.SendStream // Nil while disconnected, guarded by mu
+	messageStream quic.SendStream // Nil while disconnected, guarded by mu
+	demand        atomic.Bool     // Whether the server has subscribers, as the cluster view says
+	logger        *zap.Logger
+}
+
+var _ app.PeerSubscriber = (*QUICPeerConn)(nil)
+
+// NewQUICPeerConn is the constructor for QUICPeerConn. addr is the cluster address of the server.
+func NewQUICPeerConn(
+	addr string,
+	tlsConfig *tls.Config,
+	quicConfig quic.Config,
+	retryInterval time.Duration,
+	logger *zap.Logger,
+) *QUICPeerConn {
+	return &QUICPeerConn{
+		addr:          addr,
+		tlsConfig:     tlsConfig,
+		quicConfig:    quicConfig,
+		retryInterval: retryInterval,
+		logger:        logger,
+	}
+}
+
+// Run keeps a connection to the server until ctx is done, reconnecting whenever it fails.
+func (s *QUICPeerConn) Run(ctx context.Context) {
+	for {
+		if err := s.connect(ctx); err != nil {
+			s.logger.Debug("Connection to peer failed", zap.String("addr", s.addr), zap.Error(err))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(s.retryInterval):
+		}
+	}
+}
+
+// connect connects to the server and waits until the connection or ctx is done.
+func (s *QUICPeerConn) connect(ctx context.Context) error {
+	conn, err := quichelper.DialAddr(ctx, s.addr, s.tlsConfig, &s.quicConfig)
+	if err != nil {
+		return errors.Wrap(err, "quichelper.DialAddr")
+	}
+	defer conn.CloseWithError(0, "")
+
+	// The peer accepts the streams in the order they are opened
+	controlStream, err := conn.OpenUniStream()
+	if err != nil {
+		return errors.Wrap(err, "OpenUniStream")
+	}
+	messageStream, err := conn.OpenUniStream()
+	if err != nil {
+		return errors.Wrap(err, "OpenUniStream")
+	}
+
+	s.mu.Lock()
+	s.controlStream, s.messageStream = controlStream, messageStream
+	s.mu.Unlock()
+	s.logger.Info("Connected to peer", zap.String("addr", s.addr))
+
+	select {
+	case <-ctx.Done():
+	case <-conn.Context().Done():
+		s.logger.Info("Disconnected from peer", zap.String("addr", s.addr))
+	}
+
+	s.mu.Lock()
+	s.controlStream, s.messageStream = nil, nil
+	s.mu.Unlock()
+	return nil
+}
+
+// SendControl sends a heartbeat, with a view if set, unless disconnected.
+func (s *QUICPeerConn) SendControl(message peerMessage) error {
+	messageBytes, err := json.Marshal(message)
+	if err != nil {
+		return errors.Wrap(err, "json.Marshal")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.controlStream == nil {
+		return nil // Heartbeats resume once connected
+	}
+	if _, err := s.controlStream.Write(messageBytes); err != nil {
+		return errors.Wrap(err, "controlStream.Write")
+	}
+	return nil
+}
+
+func (s *QUICPeerConn) SendMessageToSubscriber(message []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.messageStream == nil {
+		return fmt.Errorf("not connected to peer %s", s.addr)
+	}
+	writtenBytes, err := s.messageStream.Write(message)
+	if err != nil {
+		return errors.Wrap(err, "messageStream.Write")
+	}
+	if writtenBytes < len(message) {
+		return fmt.Errorf("written %d bytes, message length is %d bytes", writtenBytes, len(message))
+	}
+	return nil
+}
+
+func (s *QUICPeerConn) HasDemand() bool {
+	return s.demand.Load()
+}
+
+// SetDemand sets whether the server has subscribers, returns whether that changed.
+func (s *QUICPeerConn) SetDemand(hasDemand bool) bool {
+	return s.demand.Swap(hasDemand) != hasDemand
+}
+
+// NotifyGoingAway does nothing, the connection to the peer is closed once the cluster stops.
+func (s *QUICPeerConn) NotifyGoingAway() error {
+	return nil
+}
+
+// Disconnect does nothing, the connection to the peer is closed once the cluster stops.
+func (s *QUICPeerConn) Disconnect() error {
+	return nil
+}
+
+func (s *QUICPeerConn) GetNodeID() string {
+	return s.addr
+}
+
+func (s *QUICPeerConn) GetID() string {
+	return "peer-" + s.addr
+}
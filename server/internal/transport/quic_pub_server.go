@@ -3,27 +3,37 @@ package transport
 import (
 	"context"
 	"crypto/tls"
-	"fmt"
+	"encoding/json"
 	"github.com/panjf2000/ants/v2"
 	"github.com/pkg/errors"
 	"github.com/quic-go/quic-go"
+	"github.com/varfrog/quicpubsub/pkg/audit"
 	"github.com/varfrog/quicpubsub/pkg/quichelper"
+	"github.com/varfrog/quicpubsub/pkg/sdk"
+	"github.com/varfrog/quicpubsub/pkg/tracing"
 	"github.com/varfrog/quicpubsub/server/internal/app"
 	"go.uber.org/zap"
+	"io"
 	"time"
 )
 
 type QUICPubServerConfig struct {
-	TLSConfig           *tls.Config
-	PublisherListenPort int           // Port to listen on for publisher connections
-	MaxMessageBytes     int           // Max bytes to read/write to/from streams, int because io.Reader uses int
-	PingTimeout         time.Duration // Ping request read/write deadline exceeding which the peer is considered dead
+	BrokerID        string // ID of this server, sent to publishers with events
+	TLSConfig       *tls.Config
+	Certificates    *Certificates // Reloadable certificates that TLSConfig uses, see Certificates.Apply, static if nil
+	MaxMessageBytes int           // Max bytes to read/write to/from streams, int because io.Reader uses int
+	Partitions      PartitionConfig
+	RateLimitMode   app.RateLimitMode // What to do with messages of publishers that exceed their rate limit
 }
 
 // QUICPubServer is a server for publishers connections.
 type QUICPubServer struct {
 	config         QUICPubServerConfig
 	connectionPool *ants.Pool
+	admission      *app.Admission
+	authenticator  *app.Authenticator // Nil if clients don't authenticate with tokens
+	auditLog       *audit.Log         // Nil if nothing is audited
+	rateLimiters   *app.RateLimiters
 	observer       *app.Observer
 	pinger         *quichelper.Pinger
 	logger         *zap.Logger
@@ -32,6 +42,10 @@ type QUICPubServer struct {
 func NewQUICPubServer(
 	config QUICPubServerConfig,
 	connectionPool *ants.Pool,
+	admission *app.Admission,
+	authenticator *app.Authenticator,
+	auditLog *audit.Log,
+	rateLimiters *app.RateLimiters,
 	observer *app.Observer,
 	pinger *quichelper.Pinger,
 	logger *zap.Logger,
@@ -39,17 +53,19 @@ func NewQUICPubServer(
 	return &QUICPubServer{
 		config:         config,
 		connectionPool: connectionPool,
+		admission:      admission,
+		authenticator:  authenticator,
+		auditLog:       auditLog,
+		rateLimiters:   rateLimiters,
 		observer:       observer,
 		pinger:         pinger,
 		logger:         logger,
 	}
 }
 
-func (s *QUICPubServer) AcceptPublishers(ctx context.Context, quicConfig quic.Config) error {
-	listener, err := quic.ListenAddr(
-		fmt.Sprintf("127.0.0.1:%d", s.config.PublisherListenPort),
-		s.config.TLSConfig,
-		&quicConfig)
+// AcceptPublishers listens for publisher connections on listenAddr ("host:port") and serves them.
+func (s *QUICPubServer) AcceptPublishers(ctx context.Context, listenAddr string, quicConfig quic.Config) error {
+	listener, err := quic.ListenAddr(listenAddr, withClientCAs(withOptionalRoleALPN(s.config.TLSConfig), s.config.Certificates), &quicConfig)
 	if err != nil {
 		return errors.Wrap(err, "quic.ListenAddr")
 	}
@@ -60,14 +76,25 @@ func (s *QUICPubServer) AcceptPublishers(ctx context.Context, quicConfig quic.Co
 			s.logger.Info("Stopping accepting publisher connections, context cancelled")
 			return nil
 		default:
-			s.logger.Info("Waiting for publisher connections")
+			s.logger.Info("Waiting for publisher connections", zap.String("addr", listenAddr))
 			conn, err := listener.Accept(ctx)
 			if err != nil {
+				if ctx.Err() != nil {
+					s.logger.Info("Stopping accepting publisher connections, context cancelled")
+					return nil
+				}
 				return errors.Wrap(err, "listener.Accept")
 			}
 			s.logger.Info("Got a publisher connection")
+			if !admit(conn, sdk.ALPNPublisher, s.admission, s.auditLog, s.logger) {
+				continue
+			}
 
 			err = s.connectionPool.Submit(func() {
+				conn, ok := authenticate(context.Background(), conn, sdk.ALPNPublisher, s.authenticator, s.auditLog, s.logger)
+				if !ok {
+					return
+				}
 				if err := s.processConnection(context.Background(), conn); err != nil {
 					s.logger.Error("processConnection", zap.Error(err))
 				}
@@ -87,14 +114,15 @@ func (s *QUICPubServer) processConnection(ctx context.Context, conn quic.Connect
 	// are blocking, don't depend on the order these streams are opened or accepted on the peer, so do this
 	// in goroutines, and send ready-to-use streams on channels.
 	var (
-		eventStreamCh   = make(chan quic.SendStream)    // For events to publishers
-		messageStreamCh = make(chan quic.ReceiveStream) // For messages from publishers
-		pingStreamCh    = make(chan quic.Stream)
+		eventStreamCh   = make(chan quic.SendStream, 1)    // For events to publishers
+		messageStreamCh = make(chan quic.ReceiveStream, 1) // For messages from publishers
+		keyStreamCh     = make(chan quic.ReceiveStream, 1) // Optional, for publishers that tell their key
+		pingStreamCh    = make(chan quic.Stream, 1)
 	)
 	go func() {
 		stream, err := conn.OpenUniStream() // Blocking call
 		if err != nil {
-			s.logger.Error("OpenUniStream", zap.Error(err))
+			logStreamError(s.logger, "OpenUniStream", err)
 			cancel()
 			return
 		}
@@ -104,17 +132,24 @@ func (s *QUICPubServer) processConnection(ctx context.Context, conn quic.Connect
 	go func() {
 		stream, err := conn.AcceptUniStream(ctx) // Blocking call
 		if err != nil {
-			s.logger.Error("AcceptUniStream", zap.Error(err))
+			logStreamError(s.logger, "AcceptUniStream", err)
 			cancel()
 			return
 		}
 		s.logger.Info("Message stream ready")
 		messageStreamCh <- stream
+
+		stream, err = conn.AcceptUniStream(ctx) // Blocking call, only publishers with a key open this stream
+		if err != nil {
+			return
+		}
+		s.logger.Info("Key stream ready")
+		keyStreamCh <- stream
 	}()
 	go func() {
 		stream, err := conn.AcceptStream(ctx) // Blocking call
 		if err != nil {
-			s.logger.Error("AcceptStream", zap.Error(err))
+			logStreamError(s.logger, "AcceptStream", err)
 			cancel()
 			return
 		}
@@ -122,56 +157,132 @@ func (s *QUICPubServer) processConnection(ctx context.Context, conn quic.Connect
 		pingStreamCh <- stream
 	}()
 
-	// Initialize the publisher, notify the observer about a new publisher
-	var publisher *QUICPublisherConn
+	go cancelOnConnectionClose(ctx, conn, cancel)
+
+	monitor := quichelper.NewLoggingMonitor(s.pinger,
+		s.logger.With(zap.String("remote_addr", conn.RemoteAddr().String())))
+	stats := NewConnStats(time.Now(), monitor)
+	s.serve(ctx, cancel, conn, stats, eventStreamCh, messageStreamCh, keyStreamCh)
+
+	go quichelper.ReceiveAndRespondToPings(ctx, s.pinger, monitor, pingStreamCh, cancel,
+		"Publisher timed out", s.logger)
+
+	return nil
+}
+
+// serve registers a publisher with the observer, receives its messages and unregisters it when ctx is done. It calls
+// cancel on failure. keyStreamCh is nil if the publisher cannot tell its key.
+func (s *QUICPubServer) serve(
+	ctx context.Context,
+	cancel context.CancelFunc,
+	conn quic.Connection,
+	stats *ConnStats,
+	eventStreamCh <-chan quic.SendStream,
+	messageStreamCh <-chan quic.ReceiveStream,
+	keyStreamCh <-chan quic.ReceiveStream,
+) {
+	identity := publisherIdentity(conn)
+	tenant, _ := connTenant(conn) // Checked on admission
+	rateLimit := &publisherRateLimit{
+		limiter: s.rateLimiters.Acquire(identity),
+		mode:    s.config.RateLimitMode,
+		logger:  s.logger,
+	}
+	go func() {
+		<-ctx.Done()
+		s.rateLimiters.Release(identity)
+	}()
+
+	// Initialize the publisher, notify the observer about a new publisher, and about its disconnection once done
 	go func() {
-		stream := <-eventStreamCh // Wait for the send stream to be available
+		var stream quic.SendStream
+		select {
+		case <-ctx.Done():
+			return
+		case stream = <-eventStreamCh: // Wait for the send stream to be available
+		}
 		s.logger.Info("Publisher send stream is available")
 
-		publisher = NewQUICPublisherConn(stream)
-		s.logger.Info("Publisher created", zap.String("id", publisher.GetID()))
+		publisher := NewQUICPublisherConn(conn, stream, s.config.BrokerID, tenant, clientIdentity(conn), stats)
+		s.logger.Info("Publisher created",
+			zap.String("id", publisher.GetID()), zap.String("identity", identity), zap.String("tenant", tenant))
+		rateLimit.publisher.Store(publisher)
 
 		if err := s.observer.OnPublisherConnected(publisher); err != nil {
-			s.logger.Error("Failure in OnPublisherConnected", zap.Error(err))
+			var deniedErr *app.AccessDeniedError
+			if errors.As(err, &deniedErr) {
+				refuseAccess(conn, sdk.ALPNPublisher, deniedErr, s.auditLog, s.logger)
+				cancel()
+				return // Never added, so not to be removed
+			}
+			refuseConnector(conn, sdk.ALPNPublisher, publisher.GetID(), err, s.auditLog, s.logger)
 			cancel()
-			return
+			return // Never added, so not to be removed
 		}
+		record := auditRecord(audit.EventConnected, conn, sdk.ALPNPublisher)
+		record.ID = publisher.GetID()
+		s.auditLog.Record(record)
+
+		<-ctx.Done()
+		s.observer.OnPublisherDisconnected(publisher)
+		record.Event = audit.EventDisconnected
+		s.auditLog.Record(record)
 	}()
 
 	// Receive messages.
 	go func() {
-		stream := <-messageStreamCh // Wait until the stream becomes available
+		var stream quic.ReceiveStream
+		select {
+		case <-ctx.Done():
+			return
+		case stream = <-messageStreamCh: // Wait until the stream becomes available
+		}
 		s.logger.Debug("Message stream available")
-		if err := s.receiveMessages(ctx, stream); err != nil {
+		origin := app.MessageOrigin{Tenant: tenant, Identity: clientIdentity(conn)}
+		if err := s.receiveMessages(ctx, conn, stream, origin, rateLimit, stats); err != nil {
 			s.logger.Error("receiveMessages", zap.Error(err))
-			cancel()
-			return
 		}
+		cancel()
 	}()
 
-	go quichelper.ReceiveAndRespondToPings(ctx, s.pinger, pingStreamCh, cancel, "Publisher timed out", s.logger)
-
-	go s.monitorDoneContext(ctx, publisher)
-
-	return nil
+	if keyStreamCh != nil && s.config.Partitions.Enabled() {
+		go s.receiveKey(ctx, conn, keyStreamCh)
+	}
 }
 
-// monitorDoneContext monitors when the publisher has finished and informs the observer when so
-func (s *QUICPubServer) monitorDoneContext(ctx context.Context, publisher *QUICPublisherConn) {
-	func() {
-		for {
-			select {
-			case <-ctx.Done():
-				if publisher != nil {
-					s.observer.OnPublisherDisconnected(publisher)
-				}
-				return
-			}
-		}
-	}()
+// receiveKey waits for the publisher to open a key stream and redirects the publisher if this server does not own
+// the partition of the key it sends.
+func (s *QUICPubServer) receiveKey(ctx context.Context, conn quic.Connection, keyStreamCh <-chan quic.ReceiveStream) {
+	var stream quic.ReceiveStream
+	select {
+	case <-ctx.Done():
+		return
+	case stream = <-keyStreamCh:
+	}
+
+	var event sdk.Event
+	if err := json.NewDecoder(stream).Decode(&event); err != nil {
+		s.logger.Debug("Cannot receive the key", zap.Error(err))
+		return
+	}
+	if event.Code != sdk.CodeKey {
+		s.logger.Info("Got unknown key event, ignoring", zap.String("event", event.Code))
+		return
+	}
+	if owner, ok := s.config.Partitions.redirectAddr(event.Key); ok {
+		redirect(conn, owner, s.logger)
+	}
 }
 
-func (s *QUICPubServer) receiveMessages(ctx context.Context, stream quic.ReceiveStream) error {
+// receiveMessages passes messages of a publisher to the observer until ctx is done or the stream is closed.
+func (s *QUICPubServer) receiveMessages(
+	ctx context.Context,
+	conn quic.Connection,
+	stream quic.ReceiveStream,
+	origin app.MessageOrigin,
+	rateLimit *publisherRateLimit,
+	stats *ConnStats,
+) error {
 	// Don't timeout, as the publisher can stay idle until it starts sending messages
 	if err := stream.SetReadDeadline(time.Time{}); err != nil {
 		return errors.Wrap(err, "SetReadDeadline")
@@ -183,23 +294,80 @@ func (s *QUICPubServer) receiveMessages(ctx context.Context, stream quic.Receive
 			s.logger.Info("Stopping receiving messages as context is done")
 			return nil
 		default:
-			if err := s.receiveMessage(stream); err != nil {
+			if err := s.receiveMessage(ctx, conn, stream, origin, rateLimit, stats); err != nil {
+				if errors.Is(err, io.EOF) {
+					s.logger.Info("Publisher closed the message stream")
+					return nil
+				}
+				if _, ok := quichelper.ApplicationErrorCode(err); ok {
+					s.logger.Info("Publisher connection closed")
+					return nil
+				}
 				return errors.Wrap(err, "receiveMessage")
 			}
 		}
 	}
 }
 
-func (s *QUICPubServer) receiveMessage(stream quic.ReceiveStream) error {
-	msg := make([]byte, s.config.MaxMessageBytes)
-	n, err := stream.Read(msg)
+// receiveMessage passes a message from the stream to the observer once the rate limit of the publisher allows. It
+// drops the messages of other partitions, over quota, denied or of paused publishers, telling the publisher why.
+func (s *QUICPubServer) receiveMessage(
+	ctx context.Context,
+	conn quic.Connection,
+	stream quic.ReceiveStream,
+	origin app.MessageOrigin,
+	rateLimit *publisherRateLimit,
+	stats *ConnStats,
+) error {
+	// The frame is passed on as is, subscribers receive it in the same encoding
+	frame, err := quichelper.ReadFrame(stream, s.config.MaxMessageBytes)
 	if err != nil {
-		return errors.Wrap(err, "stream.Read")
+		return errors.Wrap(err, "ReadFrame")
+	}
+	defer frame.Release() // The observer retains the frame for as long as it is being delivered
+	stats.received(frame.Len())
+	if s.observer.Traces() {
+		origin.ReceivedAt = time.Now()
+	}
+
+	if publisher := rateLimit.publisher.Load(); publisher != nil && publisher.IsPaused() {
+		return nil
+	}
+
+	if s.config.Partitions.Enabled() || s.observer.NeedsKeys() || s.observer.Traces() || s.observer.Deduplicates() {
+		// Frames with corrupt headers are passed on without a key or trace context, as without partitioning
+		headers, ok := messageHeaders(frame.Bytes())
+		key := headers[sdk.HeaderKey]
+		if ok && s.config.Partitions.Enabled() {
+			if owner, ok := s.config.Partitions.redirectAddr(key); ok {
+				redirect(conn, owner, s.logger)
+				return nil
+			}
+		}
+		origin.Key = key
+		origin.Trace, _ = tracing.FromHeaders(headers)
+		origin.OriginBroker, origin.MessageID = headers[sdk.HeaderOrigin], headers[sdk.HeaderMessageID]
 	}
-	msg = msg[0:n] // Trim to the number of bytes it read
 
-	err = s.observer.OnPublisherMessage(msg)
+	if !rateLimit.admit(ctx, frame.Len()) {
+		return nil
+	}
+
+	err = s.observer.OnPublisherMessage(origin, frame)
 	if err != nil {
+		var quotaErr *app.QuotaError
+		if errors.As(err, &quotaErr) {
+			rateLimit.quotaExceeded(time.Now(), quotaErr)
+			return nil
+		}
+		var deniedErr *app.AccessDeniedError
+		if errors.As(err, &deniedErr) {
+			// Recorded as often as the publisher is told, so that a publisher cannot flood the audit trail
+			if rateLimit.accessDenied(time.Now(), deniedErr) {
+				s.auditLog.Record(accessDeniedRecord(conn, sdk.ALPNPublisher, deniedErr))
+			}
+			return nil
+		}
 		return errors.Wrap(err, "OnPublisherMessage")
 	}
 
@@ -3,26 +3,53 @@ package transport
 import (
 	"encoding/json"
 	"fmt"
-	"github.com/google/uuid"
 	"github.com/pkg/errors"
 	"github.com/quic-go/quic-go"
 	"github.com/varfrog/quicpubsub/pkg/sdk"
 	"github.com/varfrog/quicpubsub/server/internal/app"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 // QUICPublisherConn implements the app.Publisher interface.
 type QUICPublisherConn struct {
-	id         uuid.UUID
+	id         string // The client identity, or random if the client is anonymous
+	identity   string // Empty if the client is anonymous
+	conn       quic.Connection
 	sendStream quic.SendStream
+	sendMu     sync.Mutex // Events are sent from different goroutines
+	brokerID   string     // ID of this server, sent with every event
+	tenant     string
+	stats      *ConnStats
+	paused     atomic.Bool // Whether an operator has paused the publisher, see Pause
 }
 
-var _ app.Publisher = (*QUICPublisherConn)(nil)
+var (
+	_ app.Publisher    = (*QUICPublisherConn)(nil)
+	_ app.TenantMember = (*QUICPublisherConn)(nil)
+	_ app.Identified   = (*QUICPublisherConn)(nil)
+	_ app.Described    = (*QUICPublisherConn)(nil)
+)
 
-// NewQUICPublisherConn is the constructor for QUICPublisherConn
-func NewQUICPublisherConn(sendStream quic.SendStream) *QUICPublisherConn {
+// NewQUICPublisherConn is the constructor for QUICPublisherConn. identity is the identity of the authenticated
+// client, which becomes the ID of the publisher, if empty the publisher gets a random ID.
+func NewQUICPublisherConn(
+	conn quic.Connection,
+	sendStream quic.SendStream,
+	brokerID string,
+	tenant string,
+	identity string,
+	stats *ConnStats,
+) *QUICPublisherConn {
 	return &QUICPublisherConn{
-		id:         uuid.New(),
+		id:         connectorID(identity),
+		identity:   identity,
+		conn:       conn,
 		sendStream: sendStream,
+		brokerID:   brokerID,
+		tenant:     tenant,
+		stats:      stats,
 	}
 }
 
@@ -40,16 +67,106 @@ func (s *QUICPublisherConn) NotifyNoSubscribers() error {
 	return nil
 }
 
+func (s *QUICPublisherConn) NotifyGoingAway() error {
+	if err := s.sendEvent(sdk.Event{Code: sdk.CodeGoingAway}); err != nil {
+		return errors.Wrap(err, "sendEvent")
+	}
+	return nil
+}
+
+func (s *QUICPublisherConn) NotifySlowDown(retryAfter time.Duration) error {
+	if err := s.sendEvent(sdk.Event{Code: sdk.CodeSlowDown, RetryAfterMs: retryAfter.Milliseconds()}); err != nil {
+		return errors.Wrap(err, "sendEvent")
+	}
+	return nil
+}
+
+func (s *QUICPublisherConn) NotifyQuotaExceeded(quota string, retryAfter time.Duration) error {
+	event := sdk.Event{Code: sdk.CodeQuotaExceeded, Quota: quota, RetryAfterMs: retryAfter.Milliseconds()}
+	if err := s.sendEvent(event); err != nil {
+		return errors.Wrap(err, "sendEvent")
+	}
+	return nil
+}
+
+// NotifyAccessDenied tells the publisher that its messages with the key are dropped as it may not publish them.
+func (s *QUICPublisherConn) NotifyAccessDenied(key string) error {
+	if err := s.sendEvent(sdk.Event{Code: sdk.CodeAccessDenied, Key: key}); err != nil {
+		return errors.Wrap(err, "sendEvent")
+	}
+	return nil
+}
+
+// Pause sends sdk.CodePaused, after which IsPaused returns true, so that the messages of the publisher are dropped
+// until Resume.
+func (s *QUICPublisherConn) Pause() error {
+	s.paused.Store(true)
+	if err := s.sendEvent(sdk.Event{Code: sdk.CodePaused}); err != nil {
+		return errors.Wrap(err, "sendEvent")
+	}
+	return nil
+}
+
+func (s *QUICPublisherConn) Resume() error {
+	s.paused.Store(false)
+	if err := s.sendEvent(sdk.Event{Code: sdk.CodeResumed}); err != nil {
+		return errors.Wrap(err, "sendEvent")
+	}
+	return nil
+}
+
+// IsPaused returns whether an operator has paused the publisher.
+func (s *QUICPublisherConn) IsPaused() bool {
+	return s.paused.Load()
+}
+
+func (s *QUICPublisherConn) Disconnect() error {
+	if err := s.conn.CloseWithError(sdk.ErrorCodeGoingAway, "server going away"); err != nil {
+		return errors.Wrap(err, "CloseWithError")
+	}
+	return nil
+}
+
 func (s *QUICPublisherConn) GetID() string {
-	return s.id.String()
+	return s.id
+}
+
+func (s *QUICPublisherConn) GetTenant() string {
+	return s.tenant
+}
+
+func (s *QUICPublisherConn) GetIdentity() string {
+	return s.identity
+}
+
+func (s *QUICPublisherConn) Describe() app.ConnectionInfo {
+	ping := s.stats.ping.Stats()
+	return app.ConnectionInfo{
+		ID:          s.id,
+		Tenant:      s.tenant,
+		Identity:    s.identity,
+		RemoteAddr:  s.conn.RemoteAddr().String(),
+		ConnectedAt: s.stats.connectedAt,
+		Messages:    s.stats.messagesIn.Load(),
+		Bytes:       s.stats.bytesIn.Load(),
+		PingRTT:     ping.RTT,
+		PingJitter:  ping.Jitter,
+		PingLoss:    ping.Loss,
+		Degraded:    ping.Degraded,
+		Paused:      s.IsPaused(),
+	}
 }
 
 func (s *QUICPublisherConn) sendEvent(event sdk.Event) error {
+	event.BrokerID = s.brokerID
 	messageBytes, err := json.Marshal(event)
 	if err != nil {
 		return errors.Wrap(err, "json.Marshall")
 	}
 
+	s.sendMu.Lock()
+	defer s.sendMu.Unlock()
+
 	writtenBytes, err := s.sendStream.Write(messageBytes)
 	if err != nil {
 		return errors.Wrap(err, "stream.Write")
@@ -0,0 +1,251 @@
+package transport
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"github.com/panjf2000/ants/v2"
+	"github.com/pkg/errors"
+	"github.com/quic-go/quic-go"
+	"github.com/varfrog/quicpubsub/pkg/audit"
+	"github.com/varfrog/quicpubsub/pkg/quichelper"
+	"github.com/varfrog/quicpubsub/pkg/sdk"
+	"github.com/varfrog/quicpubsub/server/internal/app"
+	"go.uber.org/zap"
+	"net"
+	"time"
+)
+
+type QUICServerConfig struct {
+	TLSConfig    *tls.Config
+	Certificates *Certificates // Reloadable certificates that TLSConfig uses, see Certificates.Apply, static if nil
+	Partitions   PartitionConfig
+}
+
+// QUICServer serves publishers and subscribers on a single UDP socket, by the role they declare via ALPN, and the
+// partition map if partitioning is on.
+type QUICServer struct {
+	config         QUICServerConfig
+	connectionPool *ants.Pool
+	admission      *app.Admission
+	authenticator  *app.Authenticator // Nil if clients don't authenticate with tokens
+	auditLog       *audit.Log         // Nil if nothing is audited
+	pubServer      *QUICPubServer
+	subServer      *QUICSubServer
+	pinger         *quichelper.Pinger
+	logger         *zap.Logger
+}
+
+func NewQUICServer(
+	config QUICServerConfig,
+	connectionPool *ants.Pool,
+	admission *app.Admission,
+	authenticator *app.Authenticator,
+	auditLog *audit.Log,
+	pubServer *QUICPubServer,
+	subServer *QUICSubServer,
+	pinger *quichelper.Pinger,
+	logger *zap.Logger,
+) *QUICServer {
+	return &QUICServer{
+		config:         config,
+		connectionPool: connectionPool,
+		admission:      admission,
+		authenticator:  authenticator,
+		auditLog:       auditLog,
+		pubServer:      pubServer,
+		subServer:      subServer,
+		pinger:         pinger,
+		logger:         logger,
+	}
+}
+
+// Accept listens for publisher and subscriber connections on listenAddr ("host:port") and serves them.
+func (s *QUICServer) Accept(ctx context.Context, listenAddr string, quicConfig quic.Config) error {
+	udpAddr, err := net.ResolveUDPAddr("udp", listenAddr)
+	if err != nil {
+		return errors.Wrap(err, "net.ResolveUDPAddr")
+	}
+	udpConn, err := net.ListenUDP("udp", udpAddr)
+	if err != nil {
+		return errors.Wrap(err, "net.ListenUDP")
+	}
+
+	// Publishers and subscribers share a single transport, i.e. a single UDP socket. It is not closed once
+	// accepting stops, as closing it would close the connections before they are drained.
+	tr := &quic.Transport{Conn: udpConn}
+
+	tlsConfig := withRoleALPN(s.config.TLSConfig)
+	if s.config.Partitions.Enabled() {
+		tlsConfig.NextProtos = append(tlsConfig.NextProtos, sdk.ALPNPartitionMap)
+	}
+	listener, err := tr.Listen(withClientCAs(tlsConfig, s.config.Certificates), &quicConfig)
+	if err != nil {
+		return errors.Wrap(err, "transport.Listen")
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			s.logger.Info("Stopping accepting connections, context cancelled")
+			return nil
+		default:
+			s.logger.Info("Waiting for connections", zap.String("addr", listenAddr))
+			conn, err := listener.Accept(ctx)
+			if err != nil {
+				if ctx.Err() != nil {
+					s.logger.Info("Stopping accepting connections, context cancelled")
+					return nil
+				}
+				return errors.Wrap(err, "listener.Accept")
+			}
+			role, _ := sdk.ParseTenantALPN(conn.ConnectionState().TLS.NegotiatedProtocol)
+			s.logger.Info("Got a connection", zap.String("role", role))
+			if !admit(conn, role, s.admission, s.auditLog, s.logger) {
+				continue
+			}
+
+			err = s.connectionPool.Submit(func() {
+				conn, ok := authenticate(context.Background(), conn, role, s.authenticator, s.auditLog, s.logger)
+				if !ok {
+					return
+				}
+				if err := s.processConnection(context.Background(), conn, role); err != nil {
+					s.logger.Error("processConnection", zap.Error(err))
+				}
+			})
+			if err != nil {
+				return errors.Wrap(err, "connectionPool.Submit")
+			}
+		}
+	}
+}
+
+// processConnection hands the connection over to a server based on the role the client declared.
+func (s *QUICServer) processConnection(ctx context.Context, conn quic.Connection, role string) error {
+	switch role {
+	case sdk.ALPNPublisher:
+		return s.pubServer.processConnection(ctx, conn)
+	case sdk.ALPNSubscriber:
+		return s.subServer.processConnection(ctx, conn)
+	case sdk.ALPNPubSub:
+		return s.processPubSubConnection(ctx, conn)
+	case sdk.ALPNPartitionMap:
+		return servePartitionMap(ctx, conn, s.config.Partitions.Map)
+	default:
+		if err := conn.CloseWithError(sdk.ErrorCodeUnknownRole, "unknown role"); err != nil {
+			return errors.Wrap(err, "CloseWithError")
+		}
+		return fmt.Errorf("unknown role '%s'", role)
+	}
+}
+
+// processPubSubConnection serves a client that is both a publisher and a subscriber on one connection.
+func (s *QUICServer) processPubSubConnection(ctx context.Context, conn quic.Connection) error {
+	// Create a cancel function so we can cancel goroutines without cancelling the passed-in context
+	ctx, cancel := context.WithCancel(ctx)
+
+	var (
+		eventStreamCh      = make(chan quic.SendStream, 1)    // For events to the publisher
+		messageStreamCh    = make(chan quic.ReceiveStream, 1) // For messages from the publisher
+		subscriberStreamCh = make(chan quic.SendStream, 1)    // For messages to the subscriber
+		pingStreamCh       = make(chan quic.Stream, 1)
+	)
+	go func() {
+		// Open the streams one after another so that their IDs, and hence the order in which the client
+		// accepts them, are known: the event stream comes first.
+		eventStream, err := conn.OpenUniStream() // Blocking call
+		if err != nil {
+			logStreamError(s.logger, "OpenUniStream", err)
+			cancel()
+			return
+		}
+		s.logger.Info("Event sending stream ready")
+		eventStreamCh <- eventStream
+
+		subscriberStream, err := conn.OpenUniStream() // Blocking call
+		if err != nil {
+			logStreamError(s.logger, "OpenUniStream", err)
+			cancel()
+			return
+		}
+		s.logger.Info("Message sending stream ready")
+		subscriberStreamCh <- subscriberStream
+	}()
+	go func() {
+		stream, err := conn.AcceptUniStream(ctx) // Blocking call
+		if err != nil {
+			logStreamError(s.logger, "AcceptUniStream", err)
+			cancel()
+			return
+		}
+		s.logger.Info("Message stream ready")
+		messageStreamCh <- stream
+	}()
+	go func() {
+		stream, err := conn.AcceptStream(ctx) // Blocking call
+		if err != nil {
+			logStreamError(s.logger, "AcceptStream", err)
+			cancel()
+			return
+		}
+		s.logger.Info("Ping stream ready")
+		pingStreamCh <- stream
+	}()
+
+	go cancelOnConnectionClose(ctx, conn, cancel)
+
+	monitor := quichelper.NewLoggingMonitor(s.pinger,
+		s.logger.With(zap.String("remote_addr", conn.RemoteAddr().String())))
+	stats := NewConnStats(time.Now(), monitor)
+	s.pubServer.serve(ctx, cancel, conn, stats, eventStreamCh, messageStreamCh, nil)
+	s.subServer.serve(ctx, cancel, conn, stats, subscriberStreamCh, nil, nil)
+
+	go quichelper.ReceiveAndRespondToPings(ctx, s.pinger, monitor, pingStreamCh, cancel,
+		"Client timed out", s.logger)
+
+	return nil
+}
+
+// cancelOnConnectionClose calls cancel once the connection is closed, rather than waiting for pings to time out.
+func cancelOnConnectionClose(ctx context.Context, conn quic.Connection, cancel context.CancelFunc) {
+	select {
+	case <-ctx.Done():
+	case <-conn.Context().Done():
+		cancel()
+	}
+}
+
+// logStreamError logs a failure to open or accept a stream, which is expected if the connection has been closed.
+func logStreamError(logger *zap.Logger, msg string, err error) {
+	if _, ok := quichelper.ApplicationErrorCode(err); ok || errors.Is(err, context.Canceled) {
+		logger.Debug(msg, zap.Error(err))
+		return
+	}
+	logger.Error(msg, zap.Error(err))
+}
+
+// withRoleALPN returns a copy of config that negotiates the client's role, and its tenant if any, via ALPN.
+func withRoleALPN(config *tls.Config) *tls.Config {
+	config = config.Clone()
+	config.NextProtos = []string{sdk.ALPNPublisher, sdk.ALPNSubscriber, sdk.ALPNPubSub}
+	negotiateTenantALPN(config)
+	return config
+}
+
+// withOptionalRoleALPN returns a copy of config that negotiates roles via ALPN, accepting clients that offer none.
+func withOptionalRoleALPN(config *tls.Config) *tls.Config {
+	withoutALPN := config.Clone()
+	withALPN := withRoleALPN(config)
+
+	withoutALPN.GetConfigForClient = func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+		if len(hello.SupportedProtos) == 0 {
+			return nil, nil
+		}
+		if tenantConfig, err := withALPN.GetConfigForClient(hello); tenantConfig != nil || err != nil {
+			return tenantConfig, err
+		}
+		return withALPN, nil
+	}
+	return withoutALPN
+}
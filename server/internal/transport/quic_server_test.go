@@ -0,0 +1,195 @@
+package transport_test
+
+import (
+	"context"
+	"crypto/tls"
+	. "github.com/onsi/gomega"
+	"github.com/panjf2000/ants/v2"
+	"github.com/quic-go/quic-go"
+	"github.com/varfrog/quicpubsub/pkg/client"
+	"github.com/varfrog/quicpubsub/pkg/quichelper"
+	"github.com/varfrog/quicpubsub/pkg/sdk"
+	"github.com/varfrog/quicpubsub/server/internal/app"
+	"github.com/varfrog/quicpubsub/server/internal/transport"
+	"go.uber.org/zap"
+	"math"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// loopbackServer is a server on loopback, with the pools that its publishers and subscribers are added to.
+type loopbackServer struct {
+	publisherPool  *app.PublisherPool
+	subscriberPool *app.SubscriberPool
+	pubServer      *transport.QUICPubServer
+	subServer      *transport.QUICSubServer
+	server         *transport.QUICServer
+	pinger         *quichelper.Pinger
+}
+
+func newLoopbackServer(t *testing.T) *loopbackServer {
+	logger := zap.NewNop()
+	serverTLSConfig := generateTLSConfig(t)
+	publisherPool := app.NewPublisherPool()
+	subscriberPool := app.NewSubscriberPool()
+	dispatcher := app.NewDispatcher(app.DispatcherConfig{Workers: 1}, subscriberPool, nil, logger)
+	t.Cleanup(dispatcher.Stop)
+	observer := app.NewObserver(publisherPool, subscriberPool, dispatcher, nil, nil, nil, nil, nil, logger)
+	connectionPool, err := ants.NewPool(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(connectionPool.Release)
+	admission := app.NewAdmission(app.AdmissionConfig{MaxConnections: 10}, nil)
+	pinger := quichelper.NewPinger(quichelper.NewDefaultPingerConfig(), logger)
+	pubServer := transport.NewQUICPubServer(
+		transport.QUICPubServerConfig{TLSConfig: serverTLSConfig, MaxMessageBytes: 1000},
+		connectionPool, admission, nil, nil, app.NewRateLimiters(app.PublisherRateLimits{}), observer, pinger, logger)
+	subServer := transport.NewQUICSubServer(
+		transport.QUICSubServerConfig{TLSConfig: serverTLSConfig, MaxMessageBytes: 1000, WriteTimeout: time.Second},
+		connectionPool, admission, nil, nil, observer, pinger, logger)
+	return &loopbackServer{
+		publisherPool:  publisherPool,
+		subscriberPool: subscriberPool,
+		pubServer:      pubServer,
+		subServer:      subServer,
+		server: transport.NewQUICServer(
+			transport.QUICServerConfig{TLSConfig: serverTLSConfig},
+			connectionPool, admission, nil, nil, pubServer, subServer, pinger, logger),
+		pinger: pinger,
+	}
+}
+
+var loopbackQUICConfig = quic.Config{MaxIdleTimeout: math.MaxInt64, MaxIncomingStreams: 10, MaxIncomingUniStreams: 10}
+
+func (s *loopbackServer) newPublisher(addr string, tlsConfig *tls.Config, messages int) *client.QUICPublisher {
+	return client.NewQUICPublisher(
+		client.QUICPublisherConfig{TLSConfig: tlsConfig, ServerAddr: addr, MaxMessageBytes: 1000},
+		quic.Config{MaxIdleTimeout: math.MaxInt64},
+		&countedSender{messages: messages, message: []byte("message")},
+		s.pinger, zap.NewNop())
+}
+
+func (s *loopbackServer) newSubscriber(addr string, tlsConfig *tls.Config, received *atomic.Int64) *client.QUICSubscriber {
+	return client.NewQUICSubscriber(
+		client.QUICSubscriberConfig{TLSConfig: tlsConfig, ServerAddr: addr, MaxMessageBytes: 1000},
+		quic.Config{MaxIdleTimeout: math.MaxInt64},
+		countingHandler{count: received}, nil, s.pinger, zap.NewNop())
+}
+
+func TestQUICServer(t *testing.T) {
+	t.Run("Serves publishers and subscribers on a single port", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		s := newLoopbackServer(t)
+		addr := freeUDPAddr(t)
+		go func() {
+			_ = s.server.Accept(ctx, addr, loopbackQUICConfig)
+		}()
+
+		received := &atomic.Int64{}
+		subscriber := s.newSubscriber(addr, clientTLSConfig(sdk.ALPNSubscriber), received)
+		go func() {
+			_ = subscriber.Run(ctx)
+		}()
+		g.Eventually(s.subscriberPool.GetAll, 5*time.Second, 10*time.Millisecond).Should(HaveLen(1))
+
+		publisher := s.newPublisher(addr, clientTLSConfig(sdk.ALPNPublisher), 10)
+		go func() {
+			_ = publisher.Run(ctx)
+		}()
+		g.Eventually(received.Load, 5*time.Second, 10*time.Millisecond).Should(BeEquivalentTo(10))
+		g.Expect(s.publisherPool.Len()).To(Equal(1))
+	})
+
+	t.Run("Serves clients that publish and subscribe on a single connection", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		s := newLoopbackServer(t)
+		addr := freeUDPAddr(t)
+		go func() {
+			_ = s.server.Accept(ctx, addr, loopbackQUICConfig)
+		}()
+
+		received := &atomic.Int64{}
+		pubSub := client.NewQUICPubSub(
+			s.newPublisher(addr, clientTLSConfig(sdk.ALPNPubSub), 10),
+			s.newSubscriber(addr, nil, received),
+			zap.NewNop())
+		runErr := make(chan error, 1)
+		go func() {
+			runErr <- pubSub.Run(ctx)
+		}()
+
+		// The client is a subscriber of its own messages
+		g.Eventually(received.Load, 5*time.Second, 10*time.Millisecond).Should(BeEquivalentTo(10))
+		g.Expect(s.publisherPool.Len()).To(Equal(1))
+		g.Expect(s.subscriberPool.GetAll()).To(HaveLen(1))
+
+		// And it is removed from both pools once it disconnects
+		cancel()
+		g.Eventually(runErr, 5*time.Second).Should(Receive(BeNil()))
+		g.Eventually(s.publisherPool.IsEmpty, 5*time.Second, 10*time.Millisecond).Should(BeTrue())
+		g.Eventually(s.subscriberPool.GetAll, 5*time.Second, 10*time.Millisecond).Should(BeEmpty())
+	})
+
+	t.Run("Refuses clients of unknown roles", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		s := newLoopbackServer(t)
+		addr := freeUDPAddr(t)
+		go func() {
+			_ = s.server.Accept(ctx, addr, loopbackQUICConfig)
+		}()
+
+		dialCtx, dialCancel := context.WithTimeout(ctx, 5*time.Second)
+		defer dialCancel()
+		_, err := quichelper.DialAddr(dialCtx, addr, clientTLSConfig("observer"), &quic.Config{})
+		g.Expect(err).To(MatchError(ContainSubstring("no application protocol")))
+		g.Expect(s.publisherPool.IsEmpty()).To(BeTrue())
+		g.Expect(s.subscriberPool.GetAll()).To(BeEmpty())
+	})
+
+	t.Run("Serves clients that do not declare their role on the ports of their role", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		s := newLoopbackServer(t)
+		pubAddr, subAddr := freeUDPAddr(t), freeUDPAddr(t)
+		go func() {
+			_ = s.pubServer.AcceptPublishers(ctx, pubAddr, loopbackQUICConfig)
+		}()
+		go func() {
+			_ = s.subServer.AcceptSubscribers(ctx, subAddr, loopbackQUICConfig)
+		}()
+
+		received := &atomic.Int64{}
+		legacyTLSConfig := func() *tls.Config { return &tls.Config{InsecureSkipVerify: true} }
+		subscriber := s.newSubscriber(subAddr, legacyTLSConfig(), received)
+		go func() {
+			_ = subscriber.Run(ctx)
+		}()
+		g.Eventually(s.subscriberPool.GetAll, 5*time.Second, 10*time.Millisecond).Should(HaveLen(1))
+
+		publisher := s.newPublisher(pubAddr, legacyTLSConfig(), 10)
+		go func() {
+			_ = publisher.Run(ctx)
+		}()
+		g.Eventually(received.Load, 5*time.Second, 10*time.Millisecond).Should(BeEquivalentTo(10))
+
+		// Clients that do declare their role are served there too
+		declared := s.newSubscriber(subAddr, clientTLSConfig(sdk.ALPNSubscriber), &atomic.Int64{})
+		go func() {
+			_ = declared.Run(ctx)
+		}()
+		g.Eventually(s.subscriberPool.GetAll, 5*time.Second, 10*time.Millisecond).Should(HaveLen(2))
+	})
+}
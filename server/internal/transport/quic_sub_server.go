@@ -3,26 +3,37 @@ package transport
 import (
 	"context"
 	"crypto/tls"
-	"fmt"
+	"encoding/json"
 	"github.com/panjf2000/ants/v2"
 	"github.com/pkg/errors"
 	"github.com/quic-go/quic-go"
+	"github.com/varfrog/quicpubsub/pkg/audit"
 	"github.com/varfrog/quicpubsub/pkg/quichelper"
+	"github.com/varfrog/quicpubsub/pkg/sdk"
 	"github.com/varfrog/quicpubsub/server/internal/app"
 	"go.uber.org/zap"
 	"net"
+	"time"
 )
 
 type QUICSubServerConfig struct {
-	TLSConfig            *tls.Config
-	SubscriberListenPort int // Port to listen on for subscriber connections
-	MaxMessageBytes      int // Max bytes to read/write to/from streams, int because io.Reader uses int
+	TLSConfig       *tls.Config
+	Certificates    *Certificates // Reloadable certificates that TLSConfig uses, see Certificates.Apply, static if nil
+	MaxMessageBytes int           // Max bytes to read/write to/from streams, int because io.Reader uses int
+	Partitions      PartitionConfig
+
+	// WriteTimeout is the max time that writing a message to a subscriber may take, after which the subscriber is
+	// disconnected with sdk.ErrorCodeSlowConsumer, so that it does not hold up the other subscribers. No limit if 0.
+	WriteTimeout time.Duration
 }
 
 // QUICSubServer is a server for subscriber connections.
 type QUICSubServer struct {
 	config         QUICSubServerConfig
 	connectionPool *ants.Pool
+	admission      *app.Admission
+	authenticator  *app.Authenticator // Nil if clients don't authenticate with tokens
+	auditLog       *audit.Log         // Nil if nothing is audited
 	observer       *app.Observer
 	pinger         *quichelper.Pinger
 	logger         *zap.Logger
@@ -31,6 +42,9 @@ type QUICSubServer struct {
 func NewQUICSubServer(
 	config QUICSubServerConfig,
 	connectionPool *ants.Pool,
+	admission *app.Admission,
+	authenticator *app.Authenticator,
+	auditLog *audit.Log,
 	observer *app.Observer,
 	pinger *quichelper.Pinger,
 	logger *zap.Logger,
@@ -38,17 +52,18 @@ func NewQUICSubServer(
 	return &QUICSubServer{
 		config:         config,
 		connectionPool: connectionPool,
+		admission:      admission,
+		authenticator:  authenticator,
+		auditLog:       auditLog,
 		observer:       observer,
 		pinger:         pinger,
 		logger:         logger,
 	}
 }
 
-func (s *QUICSubServer) AcceptSubscribers(ctx context.Context, quicConfig quic.Config) error {
-	listener, err := quic.ListenAddr(
-		fmt.Sprintf("127.0.0.1:%d", s.config.SubscriberListenPort),
-		s.config.TLSConfig,
-		&quicConfig)
+// AcceptSubscribers listens for subscriber connections on listenAddr ("host:port") and serves them.
+func (s *QUICSubServer) AcceptSubscribers(ctx context.Context, listenAddr string, quicConfig quic.Config) error {
+	listener, err := quic.ListenAddr(listenAddr, withClientCAs(withOptionalRoleALPN(s.config.TLSConfig), s.config.Certificates), &quicConfig)
 	if err != nil {
 		return errors.Wrap(err, "quic.ListenAddr")
 	}
@@ -59,14 +74,25 @@ func (s *QUICSubServer) AcceptSubscribers(ctx context.Context, quicConfig quic.C
 			s.logger.Info("Stopping accepting subscriber connections, context cancelled")
 			return nil
 		default:
-			s.logger.Info("Waiting for a subscriber connection")
+			s.logger.Info("Waiting for a subscriber connection", zap.String("addr", listenAddr))
 			conn, err := listener.Accept(ctx)
 			if err != nil {
+				if ctx.Err() != nil {
+					s.logger.Info("Stopping accepting subscriber connections, context cancelled")
+					return nil
+				}
 				return errors.Wrap(err, "listener.Accept")
 			}
 			s.logger.Info("Got a subscriber connection")
+			if !admit(conn, sdk.ALPNSubscriber, s.admission, s.auditLog, s.logger) {
+				continue
+			}
 
 			err = s.connectionPool.Submit(func() {
+				conn, ok := authenticate(context.Background(), conn, sdk.ALPNSubscriber, s.authenticator, s.auditLog, s.logger)
+				if !ok {
+					return
+				}
 				if err := s.processConnection(context.Background(), conn); err != nil {
 					s.logger.Error("processConnection", zap.Error(err))
 				}
@@ -86,23 +112,35 @@ func (s *QUICSubServer) processConnection(ctx context.Context, conn quic.Connect
 	// are blocking, don't depend on the order these streams are opened or accepted on the peer, so do this
 	// in goroutines, and send ready-to-use streams on channels.
 	var (
-		messagesStreamCh = make(chan quic.SendStream)
-		pingStreamCh     = make(chan quic.Stream)
+		messagesStreamCh = make(chan quic.SendStream, 1)
+		eventStreamCh    = make(chan quic.SendStream, 1)
+		demandStreamCh   = make(chan quic.ReceiveStream, 1) // Optional, for subscribers that tell their demand
+		pingStreamCh     = make(chan quic.Stream, 1)
 	)
 	go func() {
-		stream, err := conn.OpenUniStream() // Blocking call
+		// Open the streams one after another so that the client accepts the message stream first.
+		messagesStream, err := conn.OpenUniStream() // Blocking call
 		if err != nil {
-			s.logger.Error("OpenUniStream", zap.Error(err))
+			logStreamError(s.logger, "OpenUniStream", err)
 			cancel()
 			return
 		}
 		s.logger.Info("Message sending stream ready")
-		messagesStreamCh <- stream
+		messagesStreamCh <- messagesStream
+
+		eventStream, err := conn.OpenUniStream() // Blocking call
+		if err != nil {
+			logStreamError(s.logger, "OpenUniStream", err)
+			cancel()
+			return
+		}
+		s.logger.Info("Event sending stream ready")
+		eventStreamCh <- eventStream
 	}()
 	go func() {
 		stream, err := conn.AcceptStream(ctx) // Blocking call
 		if err != nil {
-			s.logger.Error("AcceptStream", zap.Error(err))
+			logStreamError(s.logger, "AcceptStream", err)
 			cancel()
 			return
 		}
@@ -110,42 +148,167 @@ func (s *QUICSubServer) processConnection(ctx context.Context, conn quic.Connect
 		pingStreamCh <- stream
 	}()
 
-	// Initialize the subscriber, notify the observer about the new subscriber
-	var subscriber *QUICSubscriberConn
 	go func() {
-		sendStream := <-messagesStreamCh // Wait for the send stream to be available
-		s.logger.Info("Subscriber send stream is available")
-
-		subscriber = NewQUICSubscriberConn(sendStream)
-		s.logger.Info("Subscriber created", zap.String("id", subscriber.GetID()))
-
-		if err := s.observer.OnSubscriberConnected(subscriber); err != nil {
-			s.logger.Warn("OnSubscriberConnected", zap.Error(err))
-			cancel()
+		stream, err := conn.AcceptUniStream(ctx) // Blocking call, most subscribers never open this stream
+		if err != nil {
 			return
 		}
+		s.logger.Info("Demand stream ready")
+		demandStreamCh <- stream
 	}()
 
+	go cancelOnConnectionClose(ctx, conn, cancel)
+
+	monitor := quichelper.NewLoggingMonitor(s.pinger,
+		s.logger.With(zap.String("remote_addr", conn.RemoteAddr().String())))
+	stats := NewConnStats(time.Now(), monitor)
+	s.serve(ctx, cancel, conn, stats, messagesStreamCh, eventStreamCh, demandStreamCh)
+
 	// Receive and respond to pings.
-	go quichelper.ReceiveAndRespondToPings(ctx, s.pinger, pingStreamCh, cancel, "Subscriber timed out", s.logger)
+	go quichelper.ReceiveAndRespondToPings(ctx, s.pinger, monitor, pingStreamCh, cancel,
+		"Subscriber timed out", s.logger)
 
-	// Monitor failures in other goroutines and on failure notify the observer.
+	return nil
+}
+
+// serve registers a subscriber with the observer and unregisters it when ctx is done. It calls cancel on failure.
+// eventStreamCh and demandStreamCh are nil if the subscriber receives events elsewhere or cannot tell its demand.
+func (s *QUICSubServer) serve(
+	ctx context.Context,
+	cancel context.CancelFunc,
+	conn quic.Connection,
+	stats *ConnStats,
+	messagesStreamCh <-chan quic.SendStream,
+	eventStreamCh <-chan quic.SendStream,
+	demandStreamCh <-chan quic.ReceiveStream,
+) {
 	go func() {
-		for {
+		var sendStream quic.SendStream
+		select {
+		case <-ctx.Done():
+			return
+		case sendStream = <-messagesStreamCh: // Wait for the send stream to be available
+		}
+		s.logger.Info("Subscriber send stream is available")
+
+		var eventStream quic.SendStream
+		if eventStreamCh != nil {
 			select {
 			case <-ctx.Done():
-				if subscriber != nil {
-					err := s.observer.OnSubscriberDisconnected(subscriber)
-					if err != nil {
-						s.logger.Error("OnSubscriberDisconnected", zap.Error(err))
-					}
-				}
 				return
+			case eventStream = <-eventStreamCh:
+			}
+		}
+
+		tenant, _ := connTenant(conn) // Checked on admission
+		subscriber := NewQUICSubscriberConn(
+			conn, sendStream, eventStream, tenant, clientIdentity(conn), s.config.WriteTimeout, stats)
+		s.logger.Info("Subscriber created", zap.String("id", subscriber.GetID()), zap.String("tenant", tenant))
+
+		if err := s.observer.OnSubscriberConnected(subscriber); err != nil {
+			var deniedErr *app.AccessDeniedError
+			if errors.As(err, &deniedErr) {
+				refuseAccess(conn, sdk.ALPNSubscriber, deniedErr, s.auditLog, s.logger)
+				cancel()
+				return // Never added, so not to be removed
 			}
+			var quotaErr *app.QuotaError
+			if errors.As(err, &quotaErr) {
+				s.logger.Info("Refusing a subscriber over its tenant quota",
+					zap.String("id", subscriber.GetID()), zap.String("reason", err.Error()))
+				record := auditRecord(audit.EventRefused, conn, sdk.ALPNSubscriber)
+				record.ID, record.Reason = subscriber.GetID(), err.Error()
+				s.auditLog.Record(record)
+				if err := subscriber.RefuseOverQuota(quotaErr.Quota); err != nil {
+					s.logger.Warn("RefuseOverQuota", zap.Error(err))
+				}
+				cancel()
+				return // Never added, so not to be removed
+			}
+			refuseConnector(conn, sdk.ALPNSubscriber, subscriber.GetID(), err, s.auditLog, s.logger)
+			cancel()
+			return // Never added, so not to be removed
 		}
+		record := auditRecord(audit.EventConnected, conn, sdk.ALPNSubscriber)
+		record.ID = subscriber.GetID()
+		s.auditLog.Record(record)
+
+		if demandStreamCh != nil {
+			go s.receiveDemand(ctx, conn, subscriber, demandStreamCh)
+		}
+
+		// Wait for failures in other goroutines and then notify the observer.
+		<-ctx.Done()
+		if err := s.observer.OnSubscriberDisconnected(subscriber); err != nil {
+			s.logger.Error("OnSubscriberDisconnected", zap.Error(err))
+		}
+		record.Event = audit.EventDisconnected
+		s.auditLog.Record(record)
 	}()
+}
 
-	return nil
+// receiveDemand updates the demand of the subscriber from its demand stream until ctx is done or the stream ends.
+// A subscriber of a key of another partition is redirected to its owner.
+func (s *QUICSubServer) receiveDemand(
+	ctx context.Context,
+	conn quic.Connection,
+	subscriber *QUICSubscriberConn,
+	demandStreamCh <-chan quic.ReceiveStream,
+) {
+	var stream quic.ReceiveStream
+	select {
+	case <-ctx.Done():
+		return
+	case stream = <-demandStreamCh:
+	}
+
+	// Events are decoded one after another as several of them may arrive in one read
+	decoder := json.NewDecoder(stream)
+	for ctx.Err() == nil {
+		var event sdk.Event
+		if err := decoder.Decode(&event); err != nil {
+			s.logger.Debug("Stopping receiving demand", zap.Error(err))
+			return
+		}
+
+		switch event.Code {
+		case sdk.CodeKey:
+			if subscriber.GetKey() != "" {
+				s.logger.Info("Ignoring a second key", zap.String("id", subscriber.GetID()), zap.String("key", event.Key))
+				continue
+			}
+			if owner, ok := s.config.Partitions.redirectAddr(event.Key); ok {
+				redirect(conn, owner, s.logger)
+				return
+			}
+			subscriber.SetKey(event.Key)
+			if err := s.observer.OnSubscribe(subscriber, event.Key); err != nil {
+				s.logger.Info("Subscription refused", zap.String("id", subscriber.GetID()), zap.String("reason", err.Error()))
+				var deniedErr *app.AccessDeniedError
+				if errors.As(err, &deniedErr) {
+					record := accessDeniedRecord(conn, sdk.ALPNSubscriber, deniedErr)
+					record.ID = subscriber.GetID()
+					s.auditLog.Record(record)
+				}
+				if err := subscriber.NotifyAccessDenied(event.Key); err != nil {
+					s.logger.Warn("NotifyAccessDenied", zap.Error(err))
+				}
+				continue
+			}
+			s.logger.Debug("Subscriber subscribed", zap.String("id", subscriber.GetID()), zap.String("key", event.Key))
+			continue
+		case sdk.CodeExistsSubscriber, sdk.CodeNoSubscribers:
+			if event.BrokerID != "" {
+				subscriber.SetBridgedFrom(event.BrokerID)
+			}
+			subscriber.SetDemand(event.Code == sdk.CodeExistsSubscriber)
+		default:
+			s.logger.Info("Got unknown demand event, ignoring", zap.String("event", event.Code))
+			continue
+		}
+		s.logger.Debug("Subscriber demand changed", zap.String("id", subscriber.GetID()), zap.Bool("has_demand", subscriber.HasDemand()))
+		s.observer.OnSubscriberDemandChanged(subscriber)
+	}
 }
 
 // receivePingMessage waits for a message from the subscriber. Returns ErrNetworkTimeout on timeout, in which case
@@ -0,0 +1,75 @@
+package transport_test
+
+import (
+	"context"
+	. "github.com/onsi/gomega"
+	"github.com/quic-go/quic-go"
+	"github.com/varfrog/quicpubsub/pkg/client"
+	"github.com/varfrog/quicpubsub/pkg/sdk"
+	"go.uber.org/zap"
+	"math"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// blockingHandler is a client.MessageHandler that never returns, so that its subscriber stops reading messages.
+type blockingHandler struct {
+	unblock <-chan struct{}
+}
+
+func (h blockingHandler) HandleMessage(sdk.Message) error {
+	<-h.unblock
+	return nil
+}
+
+func TestQUICSubServer_DisconnectsSlowConsumers(t *testing.T) {
+	g := NewGomegaWithT(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	s := newLoopbackServer(t)
+	addr := freeUDPAddr(t)
+	go func() {
+		_ = s.subServer.AcceptSubscribers(ctx, addr, loopbackQUICConfig)
+	}()
+	pubAddr := freeUDPAddr(t)
+	go func() {
+		_ = s.pubServer.AcceptPublishers(ctx, pubAddr, loopbackQUICConfig)
+	}()
+
+	// A subscriber that stops reading, with small receive windows so that writes to it block soon
+	unblock := make(chan struct{})
+	defer close(unblock)
+	slow := client.NewQUICSubscriber(
+		client.QUICSubscriberConfig{TLSConfig: clientTLSConfig(sdk.ALPNSubscriber), ServerAddr: addr, MaxMessageBytes: 1000},
+		quic.Config{
+			MaxIdleTimeout:                 math.MaxInt64,
+			InitialStreamReceiveWindow:     1000,
+			MaxStreamReceiveWindow:         1000,
+			InitialConnectionReceiveWindow: 1000,
+			MaxConnectionReceiveWindow:     1000,
+		},
+		blockingHandler{unblock: unblock}, nil, s.pinger, zap.NewNop())
+	go func() {
+		_ = slow.Run(ctx)
+	}()
+	g.Eventually(s.subscriberPool.GetAll, 5*time.Second, 10*time.Millisecond).Should(HaveLen(1))
+
+	// And one that reads, in the same dispatch shard
+	received := &atomic.Int64{}
+	subscriber := s.newSubscriber(addr, clientTLSConfig(sdk.ALPNSubscriber), received)
+	go func() {
+		_ = subscriber.Run(ctx)
+	}()
+	g.Eventually(s.subscriberPool.GetAll, 5*time.Second, 10*time.Millisecond).Should(HaveLen(2))
+
+	publisher := s.newPublisher(pubAddr, clientTLSConfig(sdk.ALPNPublisher), 1000)
+	go func() {
+		_ = publisher.Run(ctx)
+	}()
+
+	// The slow subscriber is disconnected once a write to it times out, and holds up the other one only until then
+	g.Eventually(s.subscriberPool.GetAll, 5*time.Second, 10*time.Millisecond).Should(HaveLen(1))
+	g.Eventually(received.Load, 5*time.Second, 10*time.Millisecond).Should(BeEquivalentTo(1000))
+}
@@ -1,41 +1,225 @@
 package transport
 
 import (
+	"encoding/json"
 	"fmt"
-	"github.com/google/uuid"
 	"github.com/pkg/errors"
 	"github.com/quic-go/quic-go"
+	"github.com/varfrog/quicpubsub/pkg/sdk"
 	"github.com/varfrog/quicpubsub/server/internal/app"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 // QUICSubscriberConn implements the app.Subscriber for a QUIC connection.
 type QUICSubscriberConn struct {
-	id         uuid.UUID
-	sendStream quic.SendStream
+	id           string // The client identity, or random if the client is anonymous
+	identity     string // Empty if the client is anonymous
+	conn         quic.Connection
+	sendStream   quic.SendStream
+	sendMu       sync.Mutex      // Messages are sent from different goroutines
+	closed       bool            // Whether sendStream is closed, guarded by sendMu
+	eventStream  quic.SendStream // Nil if the subscriber receives events elsewhere, e.g. as a publisher
+	eventMu      sync.Mutex      // Events are sent from different goroutines
+	writeTimeout time.Duration   // Max time a message write may take, no limit if 0
+	demand       atomic.Bool     // Whether the subscriber wants messages, see SetDemand
+	bridgedFrom  atomic.Value    // Of string, the ID of the server that the subscriber bridges to, see SetBridgedFrom
+	key          atomic.Value    // Of string, the key of the messages the subscriber wants, see SetKey
+	tenant       string
+	stats        *ConnStats
 }
 
-var _ app.Subscriber = (*QUICSubscriberConn)(nil)
+var (
+	_ app.ConditionalSubscriber = (*QUICSubscriberConn)(nil)
+	_ app.BridgedSubscriber     = (*QUICSubscriberConn)(nil)
+	_ app.KeySubscriber         = (*QUICSubscriberConn)(nil)
+	_ app.TenantMember          = (*QUICSubscriberConn)(nil)
+	_ app.Identified            = (*QUICSubscriberConn)(nil)
+	_ app.Described             = (*QUICSubscriberConn)(nil)
+)
 
-// NewQUICSubscriberConn is the constructor for QUICSubscriberConn.
-func NewQUICSubscriberConn(sendStream quic.SendStream) *QUICSubscriberConn {
-	return &QUICSubscriberConn{
-		id:         uuid.New(),
-		sendStream: sendStream,
+// NewQUICSubscriberConn is the constructor for QUICSubscriberConn. eventStream is optional. identity is the identity
+// of the authenticated client, which becomes the ID of the subscriber, if empty the subscriber gets a random ID.
+// A subscriber whose message write takes longer than writeTimeout is disconnected, see QUICSubServerConfig.
+func NewQUICSubscriberConn(
+	conn quic.Connection,
+	sendStream quic.SendStream,
+	eventStream quic.SendStream,
+	tenant string,
+	identity string,
+	writeTimeout time.Duration,
+	stats *ConnStats,
+) *QUICSubscriberConn {
+	subscriber := &QUICSubscriberConn{
+		id:           connectorID(identity),
+		identity:     identity,
+		conn:         conn,
+		sendStream:   sendStream,
+		eventStream:  eventStream,
+		writeTimeout: writeTimeout,
+		tenant:       tenant,
+		stats:        stats,
 	}
+	subscriber.demand.Store(true) // Until the subscriber says otherwise
+	return subscriber
 }
 
 func (s *QUICSubscriberConn) SendMessageToSubscriber(message []byte) error {
+	s.sendMu.Lock()
+	defer s.sendMu.Unlock()
+
+	if s.closed {
+		return errors.New("the message stream is closed")
+	}
+
+	if s.writeTimeout > 0 {
+		if err := s.sendStream.SetWriteDeadline(time.Now().Add(s.writeTimeout)); err != nil {
+			return errors.Wrap(err, "sendStream.SetWriteDeadline")
+		}
+	}
 	writtenBytes, err := s.sendStream.Write(message)
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		// The message may be partly written, so the stream can't carry more messages
+		s.closed = true
+		_ = s.conn.CloseWithError(sdk.ErrorCodeSlowConsumer, "slow consumer") // Never fails
+		return errors.Wrap(err, "sendStream.Write, disconnected the subscriber")
+	}
 	if err != nil {
 		return errors.Wrap(err, "sendStream.Write")
 	}
 	if writtenBytes < len(message) {
 		return fmt.Errorf("written %d bytes, message length is %d bytes", writtenBytes, len(message))
 	}
+	s.stats.sent(writtenBytes)
+
+	return nil
+}
+
+// NotifyGoingAway closes the message stream, so that the subscriber receives the pending messages followed by
+// the end of the stream, and sends sdk.CodeGoingAway on the event stream.
+func (s *QUICSubscriberConn) NotifyGoingAway() error {
+	s.sendMu.Lock()
+	s.closed = true
+	err := s.sendStream.Close()
+	s.sendMu.Unlock()
+	if err != nil {
+		return errors.Wrap(err, "sendStream.Close")
+	}
+
+	if err := s.sendEvent(sdk.Event{Code: sdk.CodeGoingAway}); err != nil {
+		return errors.Wrap(err, "sendEvent")
+	}
+	return nil
+}
+
+// RefuseOverQuota tells the subscriber that its tenant exceeds the quota by the given sdk.Quota* name with
+// sdk.CodeQuotaExceeded on the event stream, if any, and closes the connection with sdk.ErrorCodeQuotaExceeded.
+func (s *QUICSubscriberConn) RefuseOverQuota(quota string) error {
+	if err := s.sendEvent(sdk.Event{Code: sdk.CodeQuotaExceeded, Quota: quota}); err != nil {
+		return errors.Wrap(err, "sendEvent")
+	}
+	if err := s.conn.CloseWithError(sdk.ErrorCodeQuotaExceeded, fmt.Sprintf("%s quota exceeded", quota)); err != nil {
+		return errors.Wrap(err, "CloseWithError")
+	}
+	return nil
+}
+
+// NotifyAccessDenied tells the subscriber that it does not receive messages with the key as it may not subscribe to
+// them, with sdk.CodeAccessDenied on the event stream, if any.
+func (s *QUICSubscriberConn) NotifyAccessDenied(key string) error {
+	if err := s.sendEvent(sdk.Event{Code: sdk.CodeAccessDenied, Key: key}); err != nil {
+		return errors.Wrap(err, "sendEvent")
+	}
+	return nil
+}
+
+// sendEvent sends the event on the event stream, if any.
+func (s *QUICSubscriberConn) sendEvent(event sdk.Event) error {
+	if s.eventStream == nil {
+		return nil
+	}
+	eventBytes, err := json.Marshal(event)
+	if err != nil {
+		return errors.Wrap(err, "json.Marshall")
+	}
+
+	s.eventMu.Lock()
+	defer s.eventMu.Unlock()
+
+	writtenBytes, err := s.eventStream.Write(eventBytes)
+	if err != nil {
+		return errors.Wrap(err, "eventStream.Write")
+	}
+	if writtenBytes < len(eventBytes) {
+		return fmt.Errorf("written %d bytes, event length is %d bytes", writtenBytes, len(eventBytes))
+	}
+	return nil
+}
 
+func (s *QUICSubscriberConn) Disconnect() error {
+	if err := s.conn.CloseWithError(sdk.ErrorCodeGoingAway, "server going away"); err != nil {
+		return errors.Wrap(err, "CloseWithError")
+	}
 	return nil
 }
 
+func (s *QUICSubscriberConn) HasDemand() bool {
+	return s.demand.Load()
+}
+
+// SetDemand sets whether the subscriber wants messages, as the subscriber has told.
+func (s *QUICSubscriberConn) SetDemand(hasDemand bool) {
+	s.demand.Store(hasDemand)
+}
+
+// SetBridgedFrom sets the ID of the server that the subscriber bridges messages to, as the subscriber has told with
+// its demand.
+func (s *QUICSubscriberConn) SetBridgedFrom(brokerID string) {
+	s.bridgedFrom.Store(brokerID)
+}
+
+func (s *QUICSubscriberConn) BridgedFrom() string {
+	brokerID, _ := s.bridgedFrom.Load().(string)
+	return brokerID
+}
+
+// SetKey sets the key of the messages the subscriber wants, as the subscriber has told.
+func (s *QUICSubscriberConn) SetKey(key string) {
+	s.key.Store(key)
+}
+
+func (s *QUICSubscriberConn) GetKey() string {
+	key, _ := s.key.Load().(string)
+	return key
+}
+
 func (s *QUICSubscriberConn) GetID() string {
-	return s.id.String()
+	return s.id
+}
+
+func (s *QUICSubscriberConn) GetTenant() string {
+	return s.tenant
+}
+
+func (s *QUICSubscriberConn) GetIdentity() string {
+	return s.identity
+}
+
+func (s *QUICSubscriberConn) Describe() app.ConnectionInfo {
+	ping := s.stats.ping.Stats()
+	return app.ConnectionInfo{
+		ID:          s.id,
+		Tenant:      s.tenant,
+		Identity:    s.identity,
+		RemoteAddr:  s.conn.RemoteAddr().String(),
+		ConnectedAt: s.stats.connectedAt,
+		Messages:    s.stats.messagesOut.Load(),
+		Bytes:       s.stats.bytesOut.Load(),
+		PingRTT:     ping.RTT,
+		PingJitter:  ping.Jitter,
+		PingLoss:    ping.Loss,
+		Degraded:    ping.Degraded,
+	}
 }
@@ -0,0 +1,69 @@
+package transport_test
+
+import (
+	"bytes"
+	"encoding/json"
+	. "github.com/onsi/gomega"
+	"github.com/quic-go/quic-go"
+	"github.com/varfrog/quicpubsub/pkg/sdk"
+	"github.com/varfrog/quicpubsub/server/internal/transport"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// recordingStream is a quic.SendStream that records what is written to it, and counts the writes that overlap.
+type recordingStream struct {
+	quic.SendStream
+	writing  atomic.Bool
+	overlaps atomic.Int64
+	mu       sync.Mutex
+	written  bytes.Buffer
+}
+
+func (s *recordingStream) Write(p []byte) (int, error) {
+	if s.writing.Swap(true) {
+		s.overlaps.Add(1)
+	}
+	defer s.writing.Store(false)
+	time.Sleep(time.Microsecond * 100) // As a write to the network may take
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.written.Write(p)
+}
+
+func (s *recordingStream) Close() error {
+	return nil
+}
+
+func TestQUICSubscriberConn_SendsEventsOneAtATime(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	eventStream := &recordingStream{}
+	subscriber := transport.NewQUICSubscriberConn(nil, &recordingStream{}, eventStream, "", "", 0, nil)
+
+	// Events are sent from the dispatcher workers and from the observer shutting down
+	wg := sync.WaitGroup{}
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				g.Expect(subscriber.NotifyAccessDenied("orders.eu")).To(Succeed())
+			}
+		}()
+	}
+	g.Expect(subscriber.NotifyGoingAway()).To(Succeed())
+	wg.Wait()
+
+	g.Expect(eventStream.overlaps.Load()).To(BeZero())
+	decoder := json.NewDecoder(&eventStream.written)
+	events := 0
+	for decoder.More() {
+		var event sdk.Event
+		g.Expect(decoder.Decode(&event)).To(Succeed())
+		events++
+	}
+	g.Expect(events).To(Equal(41))
+}
@@ -0,0 +1,117 @@
+package transport
+
+import (
+	"context"
+	"github.com/quic-go/quic-go"
+	"github.com/varfrog/quicpubsub/server/internal/app"
+	"go.uber.org/zap"
+	"sync/atomic"
+	"time"
+)
+
+// publisherRateLimit enforces the rate limit of a publisher connection on the messages it sends, and tells
+// the publisher when its messages are dropped over the quotas of its tenant or the access control list.
+type publisherRateLimit struct {
+	limiter       *app.RateLimiter
+	mode          app.RateLimitMode
+	publisher     atomic.Pointer[QUICPublisherConn] // Set once the event stream is ready, told to slow down
+	slowDownUntil time.Time                         // Until when the publisher has been told to slow down
+	quotaNotified time.Time                         // When the publisher has last been told its tenant is over quota
+	deniedKey     string                            // Key the publisher has last been told it may not publish
+	deniedAt      time.Time                         // When the publisher has last been told about deniedKey
+	logger        *zap.Logger
+}
+
+// quotaNoticeInterval is how often a publisher is told at most that its tenant is over a quota, for quotas that
+// don't tell when they allow again, or that it may not publish a key.
+const quotaNoticeInterval = time.Second
+
+// publisherIdentity returns the identity by which the rate of a publisher is limited: the identity of the client
+// if it is authenticated, otherwise its IP address.
+func publisherIdentity(conn quic.Connection) string {
+	if identity := clientIdentity(conn); identity != "" {
+		return identity
+	}
+	return remoteIP(conn)
+}
+
+// admit returns whether to pass on a message of the given size. When throttling, it waits until the rate allows,
+// which holds up reading from the stream and hence the publisher via flow control, and returns false only if ctx
+// is done meanwhile. When rejecting, it returns false right away and tells the publisher to slow down.
+func (l *publisherRateLimit) admit(ctx context.Context, bytes int) bool {
+	for {
+		now := time.Now()
+		wait := l.limiter.Allow(bytes, now)
+		if wait == 0 {
+			return true
+		}
+
+		if l.mode == app.RateLimitModeReject {
+			l.slowDown(now, wait)
+			return false
+		}
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(wait):
+		}
+	}
+}
+
+// quotaExceeded tells the publisher that its message is dropped as its tenant is over a quota, unless it has been
+// told so lately.
+func (l *publisherRateLimit) quotaExceeded(now time.Time, err *app.QuotaError) {
+	interval := err.RetryAfter
+	if interval <= 0 {
+		interval = quotaNoticeInterval
+	}
+	if now.Sub(l.quotaNotified) < interval {
+		return
+	}
+	publisher := l.publisher.Load()
+	if publisher == nil {
+		return
+	}
+	l.quotaNotified = now
+	l.logger.Info("Tenant exceeds its quota, dropping the messages of its publisher",
+		zap.String("publisher_id", publisher.GetID()), zap.String("reason", err.Error()))
+	if err := publisher.NotifyQuotaExceeded(err.Quota, err.RetryAfter); err != nil {
+		l.logger.Warn("NotifyQuotaExceeded", zap.Error(err))
+	}
+}
+
+// accessDenied tells the publisher that its message is dropped as it may not publish its key, unless it has been told
+// so lately about the same key. Returns whether the publisher has been told.
+func (l *publisherRateLimit) accessDenied(now time.Time, err *app.AccessDeniedError) bool {
+	if err.Subject == l.deniedKey && now.Sub(l.deniedAt) < quotaNoticeInterval {
+		return false
+	}
+	publisher := l.publisher.Load()
+	if publisher == nil {
+		return false
+	}
+	l.deniedKey, l.deniedAt = err.Subject, now
+	l.logger.Info("Access denied, dropping the messages of the publisher",
+		zap.String("publisher_id", publisher.GetID()), zap.String("reason", err.Error()))
+	if err := publisher.NotifyAccessDenied(err.Subject); err != nil {
+		l.logger.Warn("NotifyAccessDenied", zap.Error(err))
+	}
+	return true
+}
+
+// slowDown tells the publisher to slow down, unless it has already been told to for longer than wait.
+func (l *publisherRateLimit) slowDown(now time.Time, wait time.Duration) {
+	if now.Before(l.slowDownUntil) {
+		return // Messages sent before the publisher got the event
+	}
+	publisher := l.publisher.Load()
+	if publisher == nil {
+		return // Dropped all the same
+	}
+	l.slowDownUntil = now.Add(wait)
+	l.logger.Info("Publisher exceeds its rate limit, dropping its messages",
+		zap.String("publisher_id", publisher.GetID()), zap.Duration("retry_after", wait))
+	if err := publisher.NotifySlowDown(wait); err != nil {
+		l.logger.Warn("NotifySlowDown", zap.Error(err))
+	}
+}
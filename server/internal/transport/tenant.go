@@ -0,0 +1,68 @@
+package transport
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"github.com/quic-go/quic-go"
+	"github.com/varfrog/quicpubsub/pkg/sdk"
+)
+
+// connTenant returns the tenant the client declared via ALPN, see sdk.TenantALPN. If the client presented
+// a certificate that names a tenant as the organization of its subject, the client is bound to that tenant: it must
+// declare the same one or none.
+func connTenant(conn quic.Connection) (string, error) {
+	state := conn.ConnectionState().TLS
+	_, tenant := sdk.ParseTenantALPN(state.NegotiatedProtocol)
+	if len(state.VerifiedChains) == 0 || len(state.PeerCertificates) == 0 {
+		return tenant, nil // Unverified certificates don't name a tenant
+	}
+
+	certTenant, ok := certificateTenant(state.PeerCertificates[0])
+	switch {
+	case !ok:
+		return tenant, nil
+	case tenant == "":
+		return certTenant, nil
+	case tenant != certTenant:
+		return "", fmt.Errorf("tenant '%s' does not match the tenant '%s' of the client certificate", tenant, certTenant)
+	default:
+		return tenant, nil
+	}
+}
+
+// certificateTenant returns the tenant named by a client certificate, the first organization of its subject.
+func certificateTenant(cert *x509.Certificate) (string, bool) {
+	if len(cert.Subject.Organization) == 0 {
+		return "", false
+	}
+	return cert.Subject.Organization[0], true
+}
+
+// negotiateTenantALPN sets config.GetConfigForClient so that clients that declare a tenant along with their role,
+// see sdk.TenantALPN, negotiate that protocol, as the tenants cannot be listed in config.NextProtos upfront.
+// Clients that declare an invalid tenant fail to negotiate a protocol.
+func negotiateTenantALPN(config *tls.Config) {
+	config.GetConfigForClient = func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+		for _, protocol := range hello.SupportedProtos {
+			role, tenant := sdk.ParseTenantALPN(protocol)
+			if tenant == "" || !isClientRole(role) || sdk.ValidateTenant(tenant) != nil {
+				continue
+			}
+			tenantConfig := config.Clone()
+			tenantConfig.NextProtos = []string{protocol}
+			return tenantConfig, nil
+		}
+		return nil, nil // Negotiate one of config.NextProtos
+	}
+}
+
+// isClientRole returns whether the ALPN protocol name is the role of a publisher or subscriber client.
+func isClientRole(role string) bool {
+	switch role {
+	case sdk.ALPNPublisher, sdk.ALPNSubscriber, sdk.ALPNPubSub:
+		return true
+	default:
+		return false
+	}
+}
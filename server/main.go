@@ -5,9 +5,11 @@ import (
 	"crypto/tls"
 	"flag"
 	"fmt"
+	"github.com/google/uuid"
 	"github.com/panjf2000/ants/v2"
 	"github.com/pkg/errors"
 	"github.com/quic-go/quic-go"
+	"github.com/varfrog/quicpubsub/pkg/audit"
 	"github.com/varfrog/quicpubsub/pkg/quichelper"
 	"github.com/varfrog/quicpubsub/server/internal/app"
 	"github.com/varfrog/quicpubsub/server/internal/transport"
@@ -15,24 +17,75 @@ import (
 	"log"
 	"math"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"runtime"
+	"strings"
 	"sync"
+	"syscall"
 	"time"
 )
 
+// maxPingSuspicion is the highest -ping-suspicion, far beyond any useful one, as higher ones overflow a float64.
+const maxPingSuspicion = 100
+
 // runConfig represents configuration needed to run this app.
 type runConfig struct {
-	Help                 bool   // Prints usage and exists if true
-	TLSCertPemPath       string // Path to TLS cert.pem
-	TLSPrivateKeyPath    string // Path to TLS private.key
-	PublisherListenPort  int    // Port to listen on for publisher connections
-	SubscriberListenPort int    // Port to listen on for subscriber connections
-	MaxConnections       int    // Max number of simulteneous connections (type int required by ants)
-	MaxMessageBytes      int    // Max number of bytes per RPC message (type int required by io.Reader)
+	Help                     bool          // Prints usage and exists if true
+	TLSCertPemPath           string        // Path to TLS cert.pem
+	TLSPrivateKeyPath        string        // Path to TLS private.key
+	ClientCAPath             string        // Path to a PEM bundle of the CAs that client certificates are verified against
+	ClientAuth               string        // How clients are authenticated by their certificates: none, verify-if-given or require
+	ListenAddrs              []string      // Addresses to listen on for both publisher and subscriber connections
+	PublisherListenAddrs     []string      // Addresses to listen on for publisher connections
+	SubscriberListenAddrs    []string      // Addresses to listen on for subscriber connections
+	MaxConnections           int           // Max number of live publisher and subscriber connections (type int required by ants)
+	MaxConnectionsPerIP      int           // Max number of live connections from a single IP address, unlimited if 0
+	HandshakeRate            float64       // Handshakes per second, unlimited if 0
+	HandshakeBurst           int           // Number of handshakes allowed at once before HandshakeRate applies
+	RequireAddressValidation bool          // Whether clients always prove their address with a Retry, not only under load
+	MaxMessageBytes          int           // Max number of bytes per RPC message (type int required by io.Reader)
+	DrainTimeout             time.Duration // Time given to clients to disconnect on shutdown
+	PingSuspicion            float64       // Phi at which a client whose pings are late is considered dead, see quichelper.FailureDetector
+	BrokerID                 string        // ID of this server among bridged servers
+	BridgePubAddr            string        // Address of a remote server to bridge to for publishers, bridging is off if empty
+	BridgeSubAddr            string        // Address of the remote server for subscribers
+	BridgeDirection          string        // Which messages to bridge: in, out or both
+	BridgeMaxHops            int           // Max number of servers a message is bridged through
+	ClusterAddr              string        // Address to listen on for other servers of the cluster, clustering is off if empty
+	ClusterPeerAddrs         []string      // Cluster addresses of the other servers of the cluster
+	HeartbeatInterval        time.Duration // How often servers of the cluster send heartbeats to each other
+	HeartbeatTimeout         time.Duration // Time without heartbeats after which a server of the cluster is considered dead
+	Partitions               int           // Number of partitions that message keys hash onto, partitioning is off if 0
+	PartitionNodeAddrs       []string      // Client addresses of all servers that partitions are assigned to, this one included
+	AdvertiseAddr            string        // Client address of this server as listed in PartitionNodeAddrs
+	DispatchWorkers          int           // Number of goroutines delivering messages to subscribers
+	DispatchQueueSize        int           // Number of messages queued per dispatch worker
+	SubscriberWriteTimeout   time.Duration // Max time writing a message to a subscriber may take, no limit if 0
+	PublisherMessageRate     float64       // Default messages per second of a publisher identity, unlimited if 0
+	PublisherByteRate        float64       // Default bytes per second of a publisher identity, unlimited if 0
+	PublisherRateOverrides   string        // Rate limits of specific identities, see app.ParseRateLimitOverrides
+	PublisherRateMode        string        // What to do with messages beyond the rate limit: throttle or reject
+	TenantsPath              string        // Path to a JSON file of tenant quotas, see app.LoadTenantQuotas, any tenant is accepted if empty
+	AuthKeysPath             string        // Path to a JSON file of token keys, see auth.LoadKeys, clients don't need tokens if empty
+	BridgeToken              string        // Token with which the bridge authenticates to the remote server, if it requires one
+	ACLPath                  string        // Path to a JSON file of access control lists, see app.LoadACL, clients may do anything if empty
+	MetricsAddr              string        // Address to serve metrics on over HTTP, metrics are not served if empty
+	AdminAddr                string        // Address to serve the admin API on over HTTP, it is not served if empty
+	AdminTLS                 bool          // Whether the admin API is served over HTTPS with the certificate of the server
+	AdminInsecure            bool          // Whether the admin API may be served without AuthKeysPath, to anyone who can reach it
+	TraceExport              string        // URL or file path to export spans to, see tracing.NewExporter, nothing is traced if empty
+	AuditLogPath             string        // Path to the audit trail, nothing is audited if empty
+	AuditLogMaxBytes         int64         // Size after which the audit trail is rotated, never if 0
+	AuditLogMaxFiles         int           // Max number of rotated audit trail files to keep, all if 0
+	Warnings                 []string      // About deprecated flags that are set, logged once the logger is created
 }
 
 func main() {
-	ctx := context.Background()
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	ctx, drain := context.WithCancel(ctx) // The admin API drains the server as a signal does
+	defer drain()
 
 	config, err := parseFlagsIntoConfig()
 	if err != nil {
@@ -48,14 +101,32 @@ func main() {
 		log.Fatal(err)
 	}
 
-	tlsConfig, err := buildTLSConfig(config.TLSCertPemPath, config.TLSPrivateKeyPath)
+	logger, err := zap.NewDevelopment()
 	if err != nil {
-		log.Fatalf("buildTLSConfig: %v", err)
+		log.Fatalf("zap.NewDevelopment: %v", err)
+	}
+	for _, warning := range config.Warnings {
+		logger.Warn(warning)
 	}
 
-	logger, err := zap.NewDevelopment()
+	auditLog, err := openAuditLog(config, logger.Named("Audit")) // Nil records nothing
 	if err != nil {
-		log.Fatalf("zap.NewDevelopment: %v", err)
+		log.Fatalf("openAuditLog: %v", err)
+	}
+	defer auditLog.Close()
+	auditLog.Record(audit.Record{Event: audit.EventAdmin, Action: "start"})
+
+	certificates, err := loadCertificates(ctx, config, auditLog, logger.Named("Certificates"))
+	if err != nil {
+		log.Fatalf("loadCertificates: %v", err)
+	}
+	clientAuth, _ := transport.ParseClientAuth(config.ClientAuth) // Validated
+	tlsConfig := &tls.Config{ClientAuth: clientAuth}
+	certificates.Apply(tlsConfig)
+
+	registry := newRegistry(config, certificates) // Nil if metrics are not served
+	if registry != nil {
+		go serveMetrics(config.MetricsAddr, registry, logger.Named("Metrics"))
 	}
 
 	connectionPool, err := ants.NewPool(config.MaxConnections)
@@ -64,67 +135,219 @@ func main() {
 	}
 	defer ants.Release()
 
+	var tenantQuotas map[string]app.TenantQuota
+	if config.TenantsPath != "" {
+		tenantQuotas, err = app.LoadTenantQuotas(config.TenantsPath)
+		if err != nil {
+			log.Fatalf("LoadTenantQuotas: %v", err)
+		}
+	}
+	tenants := app.NewTenants(tenantQuotas)
+
+	authenticator, err := newAuthenticator(ctx, config, auditLog, logger.Named("AuthKeys"))
+	if err != nil {
+		log.Fatalf("newAuthenticator: %v", err)
+	}
+	accessControl, err := newAccessControl(ctx, config, auditLog, logger.Named("ACL"))
+	if err != nil {
+		log.Fatalf("newAccessControl: %v", err)
+	}
+
+	admission := app.NewAdmission(app.AdmissionConfig{
+		MaxConnections:      config.MaxConnections,
+		MaxConnectionsPerIP: config.MaxConnectionsPerIP,
+		HandshakeRate:       config.HandshakeRate,
+		HandshakeBurst:      config.HandshakeBurst,
+	}, tenants)
+
+	rateLimitOverrides, _ := app.ParseRateLimitOverrides(config.PublisherRateOverrides) // Validated
+	rateLimitMode, _ := app.ParseRateLimitMode(config.PublisherRateMode)                // Validated
+	rateLimiters := app.NewRateLimiters(app.PublisherRateLimits{
+		Default: app.RateLimit{
+			MessagesPerSecond: config.PublisherMessageRate,
+			BytesPerSecond:    config.PublisherByteRate,
+		},
+		Overrides: rateLimitOverrides,
+	})
+
+	partitionConfig, err := newPartitionConfig(config)
+	if err != nil {
+		log.Fatalf("newPartitionConfig: %v", err)
+	}
+
 	subscriberPool := app.NewSubscriberPool()
 	publisherPool := app.NewPublisherPool()
-	observer := app.NewObserver(publisherPool, subscriberPool, logger.Named("Observer"))
-	pinger := quichelper.NewPinger(quichelper.NewDefaultPingerConfig(), logger)
+	var appMetrics *app.Metrics // Nil if metrics are not served
+	pingerConfig := quichelper.NewDefaultPingerConfig()
+	pingerConfig.FailureDetector.Threshold = config.PingSuspicion
+	if registry != nil {
+		appMetrics = app.NewMetrics(registry, publisherPool, subscriberPool)
+		pingerConfig.Timeouts = registry.Counter("quicpubsub_ping_timeouts_total",
+			"Publishers and subscribers disconnected as they stopped responding to pings.")
+		pingerConfig.Degradations = registry.Counter("quicpubsub_ping_degradations_total",
+			"Times that connections degraded, as their pings took too long or were lost.")
+	}
+	tracer, err := newTracer(config, logger.Named("Tracing")) // Nil if messages are not traced
+	if err != nil {
+		log.Fatalf("newTracer: %v", err)
+	}
+	defer tracer.Close() // After the dispatcher stops
+	dispatcher := app.NewDispatcher(
+		app.DispatcherConfig{Workers: config.DispatchWorkers, QueueSize: config.DispatchQueueSize},
+		subscriberPool,
+		appMetrics,
+		logger.Named("Dispatcher"))
+	defer dispatcher.Stop()
+	observer := app.NewObserver(
+		publisherPool,
+		subscriberPool,
+		dispatcher,
+		tenants,
+		accessControl,
+		appMetrics,
+		tracer,
+		newDeduplicator(config), // Nil delivers every copy
+		logger.Named("Observer"))
+	pinger := quichelper.NewPinger(pingerConfig, logger)
+
+	if config.AdminAddr != "" {
+		go serveAdmin(config, publisherPool, subscriberPool, observer, drain, authenticator, certificates, auditLog,
+			logger.Named("AdminAPI"))
+	}
+
+	pubServer := transport.NewQUICPubServer(
+		transport.QUICPubServerConfig{
+			BrokerID:        config.BrokerID,
+			TLSConfig:       tlsConfig,
+			Certificates:    certificates,
+			MaxMessageBytes: config.MaxMessageBytes,
+			Partitions:      partitionConfig,
+			RateLimitMode:   rateLimitMode,
+		},
+		connectionPool,
+		admission,
+		authenticator,
+		auditLog,
+		rateLimiters,
+		observer,
+		pinger,
+		logger.Named("QUICPubServer"))
+	subServer := transport.NewQUICSubServer(
+		transport.QUICSubServerConfig{
+			TLSConfig:       tlsConfig,
+			Certificates:    certificates,
+			MaxMessageBytes: config.MaxMessageBytes,
+			Partitions:      partitionConfig,
+			WriteTimeout:    config.SubscriberWriteTimeout,
+		},
+		connectionPool,
+		admission,
+		authenticator,
+		auditLog,
+		observer,
+		pinger,
+		logger.Named("QUICSubServer"))
+	quicConfig := quic.Config{
+		MaxIdleTimeout:           math.MaxInt64, // We ping, so no need for this
+		MaxIncomingStreams:       int64(config.MaxConnections),
+		MaxIncomingUniStreams:    int64(config.MaxConnections),
+		RequireAddressValidation: transport.RequireAddressValidation(admission, config.RequireAddressValidation),
+	}
 
 	wg := sync.WaitGroup{}
 
-	// Serve Publishers
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		server := transport.NewQUICPubServer(
-			transport.QUICPubServerConfig{
-				TLSConfig:           tlsConfig,
-				PublisherListenPort: config.PublisherListenPort,
-				MaxMessageBytes:     config.MaxMessageBytes,
-				PingTimeout:         time.Second * 10,
+	if len(config.ListenAddrs) > 0 {
+		// Serve Publishers and Subscribers on single ports
+		server := transport.NewQUICServer(
+			transport.QUICServerConfig{
+				TLSConfig:    tlsConfig,
+				Certificates: certificates,
+				Partitions:   partitionConfig,
 			},
 			connectionPool,
-			observer,
+			admission,
+			authenticator,
+			auditLog,
+			pubServer,
+			subServer,
 			pinger,
-			logger.Named("QUICPubServer"))
-
-		err = server.AcceptPublishers(
-			ctx,
-			quic.Config{
-				MaxIdleTimeout:        math.MaxInt64, // We ping, so no need for this
-				MaxIncomingStreams:    int64(config.MaxConnections),
-				MaxIncomingUniStreams: int64(config.MaxConnections),
-			})
+			logger.Named("QUICServer"))
+
+		for _, addr := range config.ListenAddrs {
+			addr := addr
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if err := server.Accept(ctx, addr, quicConfig); err != nil {
+					fmt.Printf("Accept on %s: %v", addr, err)
+				}
+			}()
+		}
+	} else {
+		// Serve Publishers
+		for _, addr := range config.PublisherListenAddrs {
+			addr := addr
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if err := pubServer.AcceptPublishers(ctx, addr, quicConfig); err != nil {
+					fmt.Printf("AcceptPublishers on %s: %v", addr, err)
+				}
+			}()
+		}
+
+		// Serve Subscribers
+		for _, addr := range config.SubscriberListenAddrs {
+			addr := addr
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if err := subServer.AcceptSubscribers(ctx, addr, quicConfig); err != nil {
+					fmt.Printf("AcceptSubscribers on %s: %v", addr, err)
+				}
+			}()
+		}
+	}
+
+	// Bridge to a remote server
+	if config.BridgePubAddr != "" {
+		bridge, err := newBridge(config, certificates, observer, pinger, logger.Named("QUICBridge"))
 		if err != nil {
-			fmt.Printf("AcceptPublishers: %v", err)
-		}
-	}()
-
-	// Serve Subscribers
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		server := transport.NewQUICSubServer(
-			transport.QUICSubServerConfig{
-				TLSConfig:            tlsConfig,
-				SubscriberListenPort: config.SubscriberListenPort,
-				MaxMessageBytes:      config.MaxMessageBytes,
-			},
-			connectionPool,
-			observer,
-			pinger,
-			logger.Named("QUICSubServer"))
+			log.Fatalf("newBridge: %v", err)
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := bridge.Run(ctx); err != nil {
+				fmt.Printf("Bridge to %s: %v", config.BridgePubAddr, err)
+			}
+		}()
+	}
 
-		err = server.AcceptSubscribers(ctx, quic.Config{
-			MaxIdleTimeout:        math.MaxInt64, // We ping, so no need for this
-			MaxIncomingStreams:    int64(config.MaxConnections),
-			MaxIncomingUniStreams: int64(config.MaxConnections),
-		})
+	// Join a cluster
+	if config.ClusterAddr != "" {
+		cluster, err := newCluster(config, tlsConfig, certificates, observer, logger)
 		if err != nil {
-			fmt.Printf("AcceptSubscribers: %v", err)
+			log.Fatalf("newCluster: %v", err)
 		}
-	}()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := cluster.Run(ctx); err != nil {
+				fmt.Printf("Cluster on %s: %v", config.ClusterAddr, err)
+			}
+		}()
+	}
 
+	// Accepting stops once a signal, or the admin API, cancels ctx
 	wg.Wait()
+	stop() // Let a second signal terminate the process right away
+
+	logger.Info("Shutting down, draining connections", zap.Duration("timeout", config.DrainTimeout))
+	auditLog.Record(audit.Record{Event: audit.EventAdmin, Action: "shutdown"})
+	drainCtx, cancelDrain := context.WithTimeout(context.Background(), config.DrainTimeout)
+	defer cancelDrain()
+	observer.Shutdown(drainCtx)
 }
 
 // parseFlagsIntoConfig gets a config needed to run this app from command-line flags.
@@ -135,32 +358,246 @@ func parseFlagsIntoConfig() (runConfig, error) {
 	}
 
 	var (
-		help                 bool
-		certPemPath          string
-		privateKeyPath       string
-		publisherListenPort  int
-		subscriberListenPort int
-		maxConnections       int
-		maxMessageBytes      int
+		help                     bool
+		certPemPath              string
+		privateKeyPath           string
+		clientCAPath             string
+		clientAuth               string
+		listenAddrs              string
+		publisherListenAddrs     string
+		subscriberListenAddrs    string
+		listenPort               int
+		publisherListenPort      int
+		subscriberListenPort     int
+		maxConnections           int
+		maxConnectionsPerIP      int
+		handshakeRate            float64
+		handshakeBurst           int
+		requireAddressValidation bool
+		maxMessageBytes          int
+		drainTimeout             time.Duration
+		pingSuspicion            float64
+		brokerID                 string
+		bridgeAddr               string
+		bridgeSubAddr            string
+		bridgeDirection          string
+		bridgeMaxHops            int
+		clusterAddr              string
+		clusterPeerAddrs         string
+		heartbeatInterval        time.Duration
+		heartbeatTimeout         time.Duration
+		partitions               int
+		partitionNodeAddrs       string
+		advertiseAddr            string
+		dispatchWorkers          int
+		dispatchQueueSize        int
+		subscriberWriteTimeout   time.Duration
+		publisherMessageRate     float64
+		publisherByteRate        float64
+		publisherRateOverrides   string
+		publisherRateMode        string
+		tenantsPath              string
+		authKeysPath             string
+		bridgeToken              string
+		aclPath                  string
+		metricsAddr              string
+		adminAddr                string
+		adminTLS                 bool
+		adminInsecure            bool
+		traceExport              string
+		auditLogPath             string
+		auditLogMaxBytes         int64
+		auditLogMaxFiles         int
 	)
 
 	flag.BoolVar(&help, "help", false, "Print usage information")
-	flag.StringVar(&certPemPath, "cert", filepath.Join(workingDir, "certs", "cert.pem"), "Path to TLS cert.pem")
+	flag.StringVar(&certPemPath, "cert", filepath.Join(workingDir, "certs", "cert.pem"),
+		"Path to TLS cert.pem. The certificate, its key and the client CA bundle are reloaded when they change, "+
+			"so they can be rotated without a restart")
 	flag.StringVar(&privateKeyPath, "key", filepath.Join(workingDir, "certs", "private.key"), "Path to TLS private.key")
-	flag.IntVar(&publisherListenPort, "pub-in-port", 5000, "Port to listen on for publisher connections")
-	flag.IntVar(&subscriberListenPort, "sub-in-port", 5001, "Port to listen on for subscriber connections")
-	flag.IntVar(&maxConnections, "max-connections", 10000, "Max number of simultaneous connections")
+	flag.StringVar(&clientCAPath, "client-ca", "",
+		"Path to a PEM bundle of the CAs that client certificates are verified against, e.g. certs/ca.pem")
+	flag.StringVar(&clientAuth, "client-auth", "none",
+		"How clients are authenticated by their certificates (mTLS): none, verify-if-given or require. "+
+			"A verified certificate sets the identity of the client, see -client-ca")
+	flag.StringVar(&listenAddrs, "listen-addr", "",
+		"Comma-separated host:port addresses to listen on for both publisher and subscriber connections, "+
+			"clients declare their role via ALPN. Overrides -pub-in-addr and -sub-in-addr when set")
+	flag.StringVar(&publisherListenAddrs, "pub-in-addr", "127.0.0.1:5000",
+		"Comma-separated host:port addresses to listen on for publisher connections")
+	flag.StringVar(&subscriberListenAddrs, "sub-in-addr", "127.0.0.1:5001",
+		"Comma-separated host:port addresses to listen on for subscriber connections")
+	flag.IntVar(&listenPort, "listen-port", 0, "Deprecated, use -listen-addr. Listens on 127.0.0.1 on the port")
+	flag.IntVar(&publisherListenPort, "pub-in-port", 0, "Deprecated, use -pub-in-addr. Listens on 127.0.0.1 on the port")
+	flag.IntVar(&subscriberListenPort, "sub-in-port", 0, "Deprecated, use -sub-in-addr. Listens on 127.0.0.1 on the port")
+	flag.IntVar(&maxConnections, "max-connections", 10000,
+		"Max number of live publisher and subscriber connections, further ones are refused")
+	flag.IntVar(&maxConnectionsPerIP, "max-connections-per-ip", 100,
+		"Max number of live connections from a single IP address, further ones are refused. Unlimited if 0")
+	flag.Float64Var(&handshakeRate, "handshake-rate", 100,
+		"Handshakes per second, connections beyond the rate are refused and clients have to prove their address "+
+			"with a Retry while it is reached. Unlimited if 0")
+	flag.IntVar(&handshakeBurst, "handshake-burst", 200, "Number of handshakes allowed at once before -handshake-rate applies")
+	flag.BoolVar(&requireAddressValidation, "require-address-validation", false,
+		"Make every client prove its address with a Retry, at the cost of a round trip, not only under load")
 	flag.IntVar(&maxMessageBytes, "max-message-bytes", 1000, "Max number of bytes per message")
+	flag.DurationVar(&drainTimeout, "drain-timeout", time.Second*5,
+		"Time given to clients to disconnect on shutdown, after which they are disconnected")
+	flag.Float64Var(&pingSuspicion, "ping-suspicion", quichelper.NewDefaultPingerConfig().FailureDetector.Threshold,
+		"Suspicion at which a client is disconnected as its pings are late, as phi = -log10 of the probability that "+
+			fmt.Sprintf("pings come that late, given how often they came so far, up to %d. ", maxPingSuspicion)+
+			"Higher tolerates longer pauses, lower detects dead clients sooner")
+	flag.StringVar(&brokerID, "broker-id", uuid.New().String(), "ID of this server among bridged servers, random by default")
+	flag.StringVar(&bridgeAddr, "bridge-addr", "",
+		"host:port of a remote server to bridge to, on which it accepts publishers (or both roles on a single port)")
+	flag.StringVar(&bridgeSubAddr, "bridge-sub-addr", "",
+		"host:port on which the remote server accepts subscribers, defaults to -bridge-addr")
+	flag.StringVar(&bridgeDirection, "bridge-direction", string(app.BridgeDirectionBoth),
+		"Which messages to bridge: in (from the remote server), out (to the remote server) or both")
+	flag.IntVar(&bridgeMaxHops, "bridge-max-hops", 8, "Max number of servers a message is bridged through")
+	flag.StringVar(&clusterAddr, "cluster-addr", "",
+		"host:port to listen on for other servers of a cluster, also the ID of this server in the cluster")
+	flag.StringVar(&clusterPeerAddrs, "cluster-peers", "", "Comma-separated -cluster-addr of the other servers of the cluster")
+	flag.DurationVar(&heartbeatInterval, "cluster-heartbeat-interval", time.Millisecond*500,
+		"How often servers of the cluster send heartbeats to each other")
+	flag.DurationVar(&heartbeatTimeout, "cluster-heartbeat-timeout", time.Second*2,
+		"Time without heartbeats after which a server of the cluster is considered dead")
+	flag.IntVar(&partitions, "partitions", 0,
+		"Number of partitions that message keys hash onto, shared by all servers listed in -partition-nodes. "+
+			"Partitioning is off if 0")
+	flag.StringVar(&partitionNodeAddrs, "partition-nodes", "",
+		"Comma-separated -advertise-addr of all servers that partitions are assigned to, this one included")
+	flag.StringVar(&advertiseAddr, "advertise-addr", "",
+		"host:port on which clients reach this server, defaults to the first -listen-addr")
+	flag.IntVar(&dispatchWorkers, "dispatch-workers", runtime.NumCPU(),
+		"Number of goroutines delivering messages to subscribers, each one serves a shard of the subscribers")
+	flag.IntVar(&dispatchQueueSize, "dispatch-queue-size", 1024,
+		"Number of messages queued per dispatch worker, after which publishers are held up")
+	flag.DurationVar(&subscriberWriteTimeout, "subscriber-write-timeout", time.Second*5,
+		"Max time writing a message to a subscriber may take, after which the subscriber is disconnected as a slow "+
+			"consumer, so that it does not hold up the subscribers of its dispatch worker and publishers. No limit if 0")
+	flag.Float64Var(&publisherMessageRate, "publisher-message-rate", 0,
+		"Messages per second each publisher identity may send, identified by IP address. Unlimited if 0")
+	flag.Float64Var(&publisherByteRate, "publisher-byte-rate", 0,
+		"Bytes per second each publisher identity may send, identified by IP address. Unlimited if 0")
+	flag.StringVar(&publisherRateOverrides, "publisher-rate-overrides", "",
+		"Comma-separated rate limits of specific publisher identities as identity=messages/s:bytes/s, "+
+			"e.g. 10.0.0.5=100:100000, in which 0 is unlimited")
+	flag.StringVar(&publisherRateMode, "publisher-rate-mode", string(app.RateLimitModeThrottle),
+		"What to do with messages of publishers beyond their rate: throttle (stop reading until the rate allows) "+
+			"or reject (drop them and tell the publisher to slow down)")
+	flag.StringVar(&tenantsPath, "tenants", "",
+		"Path to a JSON file of the quotas of tenants, e.g. {\"acme\": {\"max_connections\": 10}}, "+
+			"only those tenants are accepted then. Any tenant is accepted without quotas if empty")
+	flag.StringVar(&authKeysPath, "auth-keys", "",
+		"Path to a JSON file of the keys of bearer tokens, e.g. {\"2024-10\": \"<base64 secret>\"}. Clients without "+
+			"a verified certificate must then authenticate with a token signed with one of the keys. The file is "+
+			"reloaded when it changes, so keys can be rotated without a restart")
+	flag.StringVar(&bridgeToken, "bridge-token", os.Getenv("QUICPUBSUB_BRIDGE_TOKEN"),
+		"Token with which the bridge authenticates to the remote server if it requires tokens, "+
+			"$QUICPUBSUB_BRIDGE_TOKEN by default")
+	flag.StringVar(&aclPath, "acl", "",
+		"Path to a JSON file of access control lists that allow client identities and groups to publish and "+
+			"subscribe to message keys matching patterns, e.g. {\"rules\": [{\"identity\": \"alice\", \"publish\": "+
+			"[\"orders.*\"]}]}. Clients may publish and subscribe to anything if empty. The file is reloaded when "+
+			"it changes")
+	flag.StringVar(&metricsAddr, "metrics-addr", "",
+		"host:port address to serve metrics on over HTTP, in the Prometheus text format at /metrics. Metrics "+
+			"are not served if empty")
+	flag.StringVar(&adminAddr, "admin-addr", "",
+		"host:port address to serve the admin API on over HTTP, which lists publishers and subscribers, "+
+			"disconnects and pauses them, and drains the server. Calls need a token of -auth-keys with the admin "+
+			"role, see -admin-insecure. Not served if empty")
+	flag.BoolVar(&adminTLS, "admin-tls", false,
+		"Serve the admin API over HTTPS with the certificate of the server, so that tokens are not sent in clear")
+	flag.BoolVar(&adminInsecure, "admin-insecure", false,
+		"Serve the admin API without -auth-keys, to anyone who can reach -admin-addr, e.g. on a loopback address")
+	flag.StringVar(&traceExport, "trace-export", "",
+		"Where to export the spans of receiving, routing and writing traced messages to, as OTLP JSON: an http:// "+
+			"or https:// URL to post them to, e.g. that of a local OpenTelemetry collector "+
+			"http://127.0.0.1:4318/v1/traces, or a file to append them to. Messages are traced if their publishers "+
+			"trace them. Nothing is traced if empty")
+	flag.StringVar(&auditLogPath, "audit-log", "",
+		"Path to an append-only audit trail of connections, authentications, access denials and administrative "+
+			"actions, written as hash-chained JSON lines that the audit command verifies. Nothing is audited if empty")
+	flag.Int64Var(&auditLogMaxBytes, "audit-log-max-bytes", 100*1024*1024,
+		"Size in bytes after which the audit trail is rotated into a file named after its first record. "+
+			"Never rotated if 0")
+	flag.IntVar(&auditLogMaxFiles, "audit-log-max-files", 0,
+		"Max number of rotated audit trail files to keep, the oldest are removed beyond it. All are kept if 0")
 	flag.Parse()
 
+	var warnings []string
+	for _, deprecated := range []struct {
+		portFlag, addrFlag string
+		addr               *string
+	}{
+		{"listen-port", "listen-addr", &listenAddrs},
+		{"pub-in-port", "pub-in-addr", &publisherListenAddrs},
+		{"sub-in-port", "sub-in-addr", &subscriberListenAddrs},
+	} {
+		if warning := quichelper.ApplyDeprecatedPortFlag(flag.CommandLine, deprecated.portFlag, deprecated.addrFlag, deprecated.addr); warning != "" {
+			warnings = append(warnings, warning)
+		}
+	}
+
+	if bridgeSubAddr == "" {
+		bridgeSubAddr = bridgeAddr
+	}
+	parsedListenAddrs := quichelper.ParseAddrList(listenAddrs)
+	if advertiseAddr == "" && len(parsedListenAddrs) > 0 {
+		advertiseAddr = parsedListenAddrs[0]
+	}
+
 	return runConfig{
-		Help:                 help,
-		TLSCertPemPath:       certPemPath,
-		TLSPrivateKeyPath:    privateKeyPath,
-		PublisherListenPort:  publisherListenPort,
-		SubscriberListenPort: subscriberListenPort,
-		MaxConnections:       maxConnections,
-		MaxMessageBytes:      maxMessageBytes,
+		Help:                     help,
+		TLSCertPemPath:           certPemPath,
+		TLSPrivateKeyPath:        privateKeyPath,
+		ClientCAPath:             clientCAPath,
+		ClientAuth:               clientAuth,
+		ListenAddrs:              parsedListenAddrs,
+		PublisherListenAddrs:     quichelper.ParseAddrList(publisherListenAddrs),
+		SubscriberListenAddrs:    quichelper.ParseAddrList(subscriberListenAddrs),
+		MaxConnections:           maxConnections,
+		MaxConnectionsPerIP:      maxConnectionsPerIP,
+		HandshakeRate:            handshakeRate,
+		HandshakeBurst:           handshakeBurst,
+		RequireAddressValidation: requireAddressValidation,
+		MaxMessageBytes:          maxMessageBytes,
+		DrainTimeout:             drainTimeout,
+		PingSuspicion:            pingSuspicion,
+		BrokerID:                 brokerID,
+		BridgePubAddr:            bridgeAddr,
+		BridgeSubAddr:            bridgeSubAddr,
+		BridgeDirection:          bridgeDirection,
+		BridgeMaxHops:            bridgeMaxHops,
+		ClusterAddr:              clusterAddr,
+		ClusterPeerAddrs:         quichelper.ParseAddrList(clusterPeerAddrs),
+		HeartbeatInterval:        heartbeatInterval,
+		HeartbeatTimeout:         heartbeatTimeout,
+		Partitions:               partitions,
+		PartitionNodeAddrs:       quichelper.ParseAddrList(partitionNodeAddrs),
+		AdvertiseAddr:            advertiseAddr,
+		DispatchWorkers:          dispatchWorkers,
+		DispatchQueueSize:        dispatchQueueSize,
+		SubscriberWriteTimeout:   subscriberWriteTimeout,
+		PublisherMessageRate:     publisherMessageRate,
+		PublisherByteRate:        publisherByteRate,
+		PublisherRateOverrides:   publisherRateOverrides,
+		PublisherRateMode:        publisherRateMode,
+		TenantsPath:              tenantsPath,
+		AuthKeysPath:             authKeysPath,
+		BridgeToken:              bridgeToken,
+		ACLPath:                  aclPath,
+		MetricsAddr:              metricsAddr,
+		AdminAddr:                adminAddr,
+		AdminTLS:                 adminTLS,
+		AdminInsecure:            adminInsecure,
+		TraceExport:              traceExport,
+		AuditLogPath:             auditLogPath,
+		AuditLogMaxBytes:         auditLogMaxBytes,
+		AuditLogMaxFiles:         auditLogMaxFiles,
+		Warnings:                 warnings,
 	}, nil
 }
 
@@ -168,25 +605,128 @@ func validateRunConfig(config runConfig) error {
 	if config.MaxConnections < 1 {
 		return errors.New("MaxConnections < 1, serving requests is impossible")
 	}
+	if config.MaxConnectionsPerIP < 0 {
+		return errors.New("MaxConnectionsPerIP < 0")
+	}
+	if config.HandshakeRate < 0 {
+		return errors.New("HandshakeRate < 0")
+	}
+	if config.HandshakeRate > 0 && config.HandshakeBurst < 1 {
+		return errors.New("HandshakeBurst < 1, no handshakes could be admitted")
+	}
 	if config.MaxMessageBytes < 1 {
 		return errors.New("MaxMessageBytes < 1")
 	}
+	if config.DrainTimeout <= 0 {
+		return errors.New("DrainTimeout <= 0")
+	}
+	if config.PingSuspicion <= 0 || config.PingSuspicion > maxPingSuspicion {
+		return errors.Errorf("PingSuspicion must be in (0, %d]", maxPingSuspicion)
+	}
+	if config.DispatchWorkers < 1 {
+		return errors.New("DispatchWorkers < 1")
+	}
+	if config.DispatchQueueSize < 0 {
+		return errors.New("DispatchQueueSize < 0")
+	}
+	if config.SubscriberWriteTimeout < 0 {
+		return errors.New("SubscriberWriteTimeout < 0")
+	}
+	if config.PublisherMessageRate < 0 {
+		return errors.New("PublisherMessageRate < 0")
+	}
+	if config.PublisherByteRate < 0 {
+		return errors.New("PublisherByteRate < 0")
+	}
+	if _, err := app.ParseRateLimitOverrides(config.PublisherRateOverrides); err != nil {
+		return errors.Wrap(err, "ParseRateLimitOverrides")
+	}
+	if _, err := app.ParseRateLimitMode(config.PublisherRateMode); err != nil {
+		return err
+	}
+	if len(config.ListenAddrs) == 0 {
+		if len(config.PublisherListenAddrs) == 0 {
+			return errors.New("no addresses to listen on for publisher connections")
+		}
+		if len(config.SubscriberListenAddrs) == 0 {
+			return errors.New("no addresses to listen on for subscriber connections")
+		}
+	}
+	if err := validateListenAddrs(config); err != nil {
+		return errors.Wrap(err, "validateListenAddrs")
+	}
+	if strings.Contains(config.BrokerID, ",") || config.BrokerID == "" {
+		return errors.New("BrokerID must be non-empty and must not contain commas")
+	}
+	if config.BridgePubAddr != "" {
+		if err := validateBridgeConfig(config); err != nil {
+			return errors.Wrap(err, "validateBridgeConfig")
+		}
+	}
+	if config.ClusterAddr != "" {
+		if err := validateClusterConfig(config); err != nil {
+			return errors.Wrap(err, "validateClusterConfig")
+		}
+	}
+	if config.Partitions != 0 {
+		if err := validatePartitionConfig(config); err != nil {
+			return errors.Wrap(err, "validatePartitionConfig")
+		}
+	}
 	if _, err := os.Stat(config.TLSCertPemPath); errors.Is(err, os.ErrNotExist) {
 		return errors.New("cannot stat the TLS cert.pem file, change the working dir to the project root or specify flag -cert")
 	}
 	if _, err := os.Stat(config.TLSPrivateKeyPath); errors.Is(err, os.ErrNotExist) {
 		return errors.New("cannot stat the TLS private key file, change the working dir to the project root or specify flag -key")
 	}
+	clientAuth, err := transport.ParseClientAuth(config.ClientAuth)
+	if err != nil {
+		return err
+	}
+	if config.MetricsAddr != "" {
+		if err := quichelper.ValidateAddr(config.MetricsAddr); err != nil {
+			return errors.Wrap(err, "MetricsAddr")
+		}
+	}
+	if config.AdminAddr != "" {
+		if err := quichelper.ValidateAddr(config.AdminAddr); err != nil {
+			return errors.Wrap(err, "AdminAddr")
+		}
+		if config.AuthKeysPath == "" && !config.AdminInsecure {
+			return errors.New("the admin API would let anyone who can reach it disconnect clients and drain the " +
+				"server, specify flag -auth-keys, or flag -admin-insecure if that is intended")
+		}
+	}
+	if config.AuditLogMaxBytes < 0 {
+		return errors.New("AuditLogMaxBytes < 0")
+	}
+	if config.AuditLogMaxFiles < 0 {
+		return errors.New("AuditLogMaxFiles < 0")
+	}
+	if clientAuth != tls.NoClientCert && config.ClientCAPath == "" {
+		return errors.New("client certificates cannot be verified without a client CA bundle, specify flag -client-ca")
+	}
 	return nil
 }
 
-func buildTLSConfig(certFilePath string, privateKeyFilePath string) (*tls.Config, error) {
-	cert, err := tls.LoadX509KeyPair(certFilePath, privateKeyFilePath)
-	if err != nil {
-		return nil, errors.Wrap(err, "tls.LoadX509KeyPair")
+// validateListenAddrs checks the format of the listen addresses and that no address is used twice.
+func validateListenAddrs(config runConfig) error {
+	addrLists := [][]string{config.PublisherListenAddrs, config.SubscriberListenAddrs}
+	if len(config.ListenAddrs) > 0 {
+		addrLists = [][]string{config.ListenAddrs} // Publisher and subscriber addresses are not used
 	}
 
-	return &tls.Config{
-		Certificates: []tls.Certificate{cert},
-	}, nil
+	seen := make(map[string]bool)
+	for _, addrs := range addrLists {
+		for _, addr := range addrs {
+			if err := quichelper.ValidateAddr(addr); err != nil {
+				return err
+			}
+			if seen[addr] {
+				return fmt.Errorf("address '%s' is listed more than once", addr)
+			}
+			seen[addr] = true
+		}
+	}
+	return nil
 }
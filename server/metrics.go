@@ -0,0 +1,31 @@
+package main
+
+import (
+	"github.com/varfrog/quicpubsub/pkg/metrics"
+	"github.com/varfrog/quicpubsub/server/internal/transport"
+	"go.uber.org/zap"
+	"net/http"
+	"time"
+)
+
+// newRegistry returns the registry of the metrics served at -metrics-addr, nil if metrics are not served.
+func newRegistry(config runConfig, certificates *transport.Certificates) *metrics.Registry {
+	if config.MetricsAddr == "" {
+		return nil
+	}
+	registry := metrics.NewRegistry()
+	registry.GaugeFunc("quicpubsub_certificate_days_to_expiry",
+		"Days until the server certificate that expires first expires.", func() float64 {
+			return float64(certificates.DaysToExpiry(time.Now()))
+		})
+	return registry
+}
+
+// serveMetrics serves the metrics of the registry at addr on /metrics only, not on http.DefaultServeMux.
+func serveMetrics(addr string, registry *metrics.Registry, logger *zap.Logger) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", registry)
+	if err := newHTTPServer(addr, mux).ListenAndServe(); err != nil {
+		logger.Error("ListenAndServe", zap.Error(err))
+	}
+}
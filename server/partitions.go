@@ -0,0 +1,46 @@
+package main
+
+import (
+	"github.com/pkg/errors"
+	"github.com/varfrog/quicpubsub/pkg/partition"
+	"github.com/varfrog/quicpubsub/pkg/quichelper"
+	"github.com/varfrog/quicpubsub/server/internal/transport"
+)
+
+// newPartitionConfig returns the partitions of the servers of -partition-nodes, none if partitioning is off.
+func newPartitionConfig(config runConfig) (transport.PartitionConfig, error) {
+	if config.Partitions == 0 {
+		return transport.PartitionConfig{}, nil
+	}
+	partitionMap, err := partition.NewMap(config.PartitionNodeAddrs, config.Partitions, partition.DefaultVirtualNodes)
+	if err != nil {
+		return transport.PartitionConfig{}, errors.Wrap(err, "partition.NewMap")
+	}
+	return transport.PartitionConfig{Map: partitionMap, NodeAddr: config.AdvertiseAddr}, nil
+}
+
+// validatePartitionConfig checks the partitioning flags, given that partitioning is on.
+func validatePartitionConfig(config runConfig) error {
+	if config.Partitions < 0 {
+		return errors.New("Partitions < 0")
+	}
+	if len(config.ListenAddrs) == 0 {
+		return errors.New("partitioning requires -listen-addr, as clients reach a server on a single address")
+	}
+	if err := quichelper.ValidateAddr(config.AdvertiseAddr); err != nil {
+		return errors.Wrap(err, "AdvertiseAddr")
+	}
+	found := false
+	for _, addr := range config.PartitionNodeAddrs {
+		if err := quichelper.ValidateAddr(addr); err != nil {
+			return errors.Wrap(err, "PartitionNodeAddrs")
+		}
+		if addr == config.AdvertiseAddr {
+			found = true
+		}
+	}
+	if !found {
+		return errors.New("PartitionNodeAddrs must include AdvertiseAddr")
+	}
+	return nil
+}
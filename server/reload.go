@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"github.com/varfrog/quicpubsub/pkg/audit"
+	"go.uber.org/zap"
+	"os"
+	"strings"
+	"time"
+)
+
+// reloadInterval is how often files that are reloaded when they change are checked for changes.
+const reloadInterval = time.Second * 2
+
+// reloadOnChange calls reload whenever any of the files at paths changes, until ctx is done, and audits it.
+func reloadOnChange(
+	ctx context.Context,
+	paths []string,
+	reload func() error,
+	auditLog *audit.Log,
+	logger *zap.Logger,
+) {
+	lastInfos := make([]os.FileInfo, len(paths))
+	for i, path := range paths {
+		lastInfos[i], _ = os.Stat(path)
+	}
+	ticker := time.NewTicker(reloadInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		changed := false
+		for i, path := range paths {
+			info, err := os.Stat(path)
+			if err != nil {
+				logger.Warn("Cannot stat the file to reload", zap.String("path", path), zap.Error(err))
+				continue
+			}
+			lastInfo := lastInfos[i]
+			if lastInfo != nil && info.ModTime().Equal(lastInfo.ModTime()) && info.Size() == lastInfo.Size() {
+				continue
+			}
+			lastInfos[i] = info
+			changed = true
+		}
+		if !changed {
+			continue
+		}
+		pathsField := zap.Strings("paths", paths)
+		record := audit.Record{Event: audit.EventAdmin, Action: "reload", Reason: strings.Join(paths, ",")}
+		if err := reload(); err != nil {
+			logger.Error("Reload failed, keeping the previous version", pathsField, zap.Error(err))
+			record.Action = "reload_failed"
+			record.Reason += ": " + err.Error()
+			auditLog.Record(record)
+			continue
+		}
+		logger.Info("Reloaded", pathsField)
+		auditLog.Record(record)
+	}
+}
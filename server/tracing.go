@@ -0,0 +1,19 @@
+package main
+
+import (
+	"github.com/pkg/errors"
+	"github.com/varfrog/quicpubsub/pkg/tracing"
+	"go.uber.org/zap"
+)
+
+// newTracer returns the tracer exporting spans to -trace-export, nil if messages are not traced.
+func newTracer(config runConfig, logger *zap.Logger) (*tracing.Tracer, error) {
+	if config.TraceExport == "" {
+		return nil, nil
+	}
+	exporter, err := tracing.NewExporter(config.TraceExport)
+	if err != nil {
+		return nil, errors.Wrap(err, "tracing.NewExporter")
+	}
+	return tracing.NewTracer("quicpubsub-server", exporter, logger), nil
+}
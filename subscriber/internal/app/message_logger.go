@@ -0,0 +1,27 @@
+package app
+
+import (
+	"github.com/varfrog/quicpubsub/pkg/client"
+	"github.com/varfrog/quicpubsub/pkg/sdk"
+	"go.uber.org/zap"
+)
+
+// MessageLogger implements client.MessageHandler and logs the messages it receives.
+type MessageLogger struct {
+	logger *zap.Logger
+}
+
+var _ client.MessageHandler = (*MessageLogger)(nil)
+
+func NewMessageLogger(logger *zap.Logger) *MessageLogger {
+	return &MessageLogger{logger: logger}
+}
+
+func (s *MessageLogger) HandleMessage(message sdk.Message) error {
+	if message.Signer != "" {
+		s.logger.Info("Received message", zap.ByteString("msg", message.Payload), zap.String("signer", message.Signer))
+		return nil
+	}
+	s.logger.Info("Received message", zap.ByteString("msg", message.Payload))
+	return nil
+}
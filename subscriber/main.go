@@ -2,29 +2,52 @@ package main
 
 import (
 	"context"
-	"crypto/tls"
 	"flag"
+	"fmt"
 	"github.com/pkg/errors"
 	"github.com/quic-go/quic-go"
+	"github.com/varfrog/quicpubsub/pkg/client"
+	"github.com/varfrog/quicpubsub/pkg/encryption"
 	"github.com/varfrog/quicpubsub/pkg/quichelper"
-	"github.com/varfrog/quicpubsub/subscriber/internal/transport"
+	"github.com/varfrog/quicpubsub/pkg/sdk"
+	"github.com/varfrog/quicpubsub/pkg/signing"
+	"github.com/varfrog/quicpubsub/pkg/tracing"
+	"github.com/varfrog/quicpubsub/subscriber/internal/app"
 	"go.uber.org/zap"
 	"log"
 	"math"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"syscall"
+	"time"
+)
+
+// What to do with messages that fail verification against the trust store.
+const (
+	unverifiedReject = "reject"
+	unverifiedPass   = "pass"
 )
 
 // Represents configuration needed to run this app.
 type runConfig struct {
-	Help            bool   // Prints usage and exists if true
-	TLSCertsDir     string // Path to a directory containing TLS certificates
-	ServerPort      int
-	MaxMessageBytes int // Max number of bytes per RPC message (type int required by io.Reader)
+	client.TLSOptions               // How servers are verified and the client authenticates to them
+	Help              bool          // Prints usage and exists if true
+	ServerAddrs       []string      // Server addresses "host:port", the host is resolved when dialing, failed over in turn
+	MaxMessageBytes   int           // Max number of bytes per RPC message (type int required by io.Reader)
+	Key               string        // Message key, the client connects to the server owning its partition if set
+	Token             string        // Bearer token to authenticate with, for servers that require one
+	TrustStorePath    string        // Path to the public keys of publishers that signatures are verified against, none if empty
+	Unverified        string        // What to do with messages that fail verification: reject or pass
+	MaxSignatureAge   time.Duration // How long after signing signatures are valid, no limit if 0
+	EncryptionPath    string        // Path to the keys encrypted payloads are decrypted with, none if empty
+	TraceExport       string        // URL or file path to export spans to, see tracing.NewExporter, messages are not traced if empty
+	Warnings          []string      // About deprecated flags that are set, logged once the logger is created
 }
 
 func main() {
-	ctx := context.Background()
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
 
 	config, err := parseFlagsIntoConfig()
 	if err != nil {
@@ -40,27 +63,74 @@ func main() {
 		log.Fatal(err)
 	}
 
-	tlsConfig, err := buildTLSConfig(config.TLSCertsDir)
+	tlsConfig, err := config.BuildTLSConfig(sdk.ALPNSubscriber)
 	if err != nil {
-		log.Fatalf("buildTLSConfig: %v", err)
+		log.Fatalf("BuildTLSConfig: %v", err)
 	}
 
 	logger, err := zap.NewDevelopment()
 	if err != nil {
 		log.Fatalf("zap.NewDevelopment: %v", err)
 	}
+	for _, warning := range config.Warnings {
+		logger.Warn(warning)
+	}
 
-	subscriber := transport.NewQUICSubscriber(
-		transport.QUICSubscriberConfig{
-			TLSConfig:       tlsConfig,
-			ServerPort:      config.ServerPort,
-			MaxMessageBytes: config.MaxMessageBytes,
-		},
-		quic.Config{MaxIdleTimeout: math.MaxInt64},
-		quichelper.NewPinger(quichelper.NewDefaultPingerConfig(), logger),
-		logger)
+	var trustStore signing.TrustStore
+	if config.TrustStorePath != "" {
+		trustStore, err = signing.LoadTrustStore(config.TrustStorePath)
+		if err != nil {
+			log.Fatalf("signing.LoadTrustStore: %v", err)
+		}
+	}
+
+	var encryptionKeys encryption.Keys
+	if config.EncryptionPath != "" {
+		encryptionKeys, err = encryption.LoadKeys(config.EncryptionPath)
+		if err != nil {
+			log.Fatalf("encryption.LoadKeys: %v", err)
+		}
+	}
 
-	err = subscriber.Run(ctx)
+	var tracer *tracing.Tracer
+	if config.TraceExport != "" {
+		exporter, err := tracing.NewExporter(config.TraceExport)
+		if err != nil {
+			log.Fatalf("tracing.NewExporter: %v", err)
+		}
+		tracer = tracing.NewTracer("quicpubsub-subscriber", exporter, logger.Named("Tracing"))
+		defer tracer.Close()
+	}
+
+	messageLogger := app.NewMessageLogger(logger)
+	pinger := quichelper.NewPinger(quichelper.NewDefaultPingerConfig(), logger)
+
+	run := func(ctx context.Context, addr string) error {
+		subscriber := client.NewQUICSubscriber(
+			client.QUICSubscriberConfig{
+				TLSConfig:        tlsConfig,
+				ServerAddr:       addr,
+				MaxMessageBytes:  config.MaxMessageBytes,
+				Key:              config.Key,
+				Token:            config.Token,
+				TrustStore:       trustStore,
+				RejectUnverified: config.Unverified == unverifiedReject,
+				MaxSignatureAge:  config.MaxSignatureAge,
+				EncryptionKeys:   encryptionKeys,
+				Tracer:           tracer,
+			},
+			quic.Config{MaxIdleTimeout: math.MaxInt64},
+			messageLogger,
+			nil,
+			pinger,
+			logger)
+		return subscriber.Run(ctx)
+	}
+	if config.Key != "" {
+		err = client.RunPartitioned(ctx, config.ServerAddrs, config.Key, tlsConfig, time.Second, logger, run)
+	} else {
+		err = client.RunWithFailover(ctx, config.ServerAddrs, time.Second, logger, run)
+	}
 	if err != nil {
 		log.Fatalf("Run: %v", err)
 	}
@@ -76,21 +146,91 @@ func parseFlagsIntoConfig() (runConfig, error) {
 	var (
 		help            bool
 		certPath        string
+		serverAddr      string
 		serverPort      int
 		maxMessageBytes int
+		key             string
+		tenant          string
+		clientCertPath  string
+		clientKeyPath   string
+		serverName      string
+		caBundlePath    string
+		systemRoots     bool
+		pinnedSPKI      string
+		token           string
+		trustStorePath  string
+		unverified      string
+		maxSignatureAge time.Duration
+		encryptionPath  string
+		traceExport     string
 	)
 
 	flag.BoolVar(&help, "help", false, "Print usage information")
 	flag.StringVar(&certPath, "cert-path", filepath.Join(workingDir, "certs"), "Path to certs dir")
-	flag.IntVar(&serverPort, "server-port", 5001, "Server port")
+	flag.StringVar(&serverAddr, "server-addr", "127.0.0.1:5001", "Server address host:port, e.g. [::1]:5001 or broker.example.com:5001. "+
+		"Comma-separated addresses of servers of a cluster are failed over in turn")
+	flag.IntVar(&serverPort, "server-port", 0, "Deprecated, use -server-addr. Connects to 127.0.0.1 on the port")
 	flag.IntVar(&maxMessageBytes, "max-message-bytes", 1000, "Max number of bytes per message")
+	flag.StringVar(&key, "key", "", "Message key. With partitioned servers, the partition map is fetched from any of "+
+		"-server-addr and the server owning the partition of the key is connected to")
+	flag.StringVar(&tenant, "tenant", "", "Tenant to connect as, declared to the server via ALPN. "+
+		"Must match the organization of the client certificate if it names one. The default tenant if empty")
+	flag.StringVar(&clientCertPath, "client-cert", "",
+		"Path to a client certificate to present to servers that authenticate clients (mTLS), e.g. certs/client.pem")
+	flag.StringVar(&clientKeyPath, "client-key", "", "Path to the private key of -client-cert, e.g. certs/client.key")
+	flag.StringVar(&serverName, "server-name", "", "Name the server certificate must be valid for, sent via SNI. "+
+		"The host of the server address if empty")
+	flag.StringVar(&caBundlePath, "ca-bundle", "", "Path to a PEM bundle of one or more CAs that issue server "+
+		"certificates. ca.pem in -cert-path if empty")
+	flag.BoolVar(&systemRoots, "system-roots", false, "Trust the CAs of the system too, in addition to -ca-bundle if given")
+	flag.StringVar(&pinnedSPKI, "pin-sha256", "", "Comma-separated base64 SHA-256 hashes of the public keys "+
+		"(SubjectPublicKeyInfo) that server certificates may have. Replaces verification against CAs if set")
+	flag.StringVar(&token, "token", os.Getenv("QUICPUBSUB_TOKEN"), "Bearer token to authenticate with to servers "+
+		"that require one, $QUICPUBSUB_TOKEN by default, which keeps it out of the process list")
+	flag.StringVar(&trustStorePath, "trust-store", "", "Path to a JSON file of the ed25519 public keys of publishers "+
+		"by signer ID, e.g. {\"orders\": \"<base64 public key>\"}, that signatures of messages are verified against. "+
+		"Signatures are not verified if empty")
+	flag.StringVar(&unverified, "unverified", unverifiedReject, "What to do with messages that are unsigned or fail "+
+		"verification against -trust-store: reject, or pass them on flagged as unverified")
+	flag.DurationVar(&maxSignatureAge, "max-signature-age", time.Minute*5, "How long after signing a message its "+
+		"signature is valid, so that old messages cannot be replayed. Signatures from more than a minute in the "+
+		"future are not valid either. No limit if 0")
+	flag.StringVar(&encryptionPath, "encryption-keys", "", "Path to a JSON file of the AES-256 keys by key ID that "+
+		"publishers encrypt payloads with, e.g. {\"2024-10\": \"<base64 key>\"}. Messages encrypted with a key "+
+		"that is not in the file are dropped")
+	flag.StringVar(&traceExport, "trace-export", "", "Where to export the spans of received messages to, as OTLP "+
+		"JSON: an http:// or https:// URL to post them to, e.g. that of a local OpenTelemetry collector "+
+		"http://127.0.0.1:4318/v1/traces, or a file to append them to. The traces of the publishers of messages "+
+		"are continued. Nothing is traced if empty")
 	flag.Parse()
 
+	var warnings []string
+	if warning := quichelper.ApplyDeprecatedPortFlag(flag.CommandLine, "server-port", "server-addr", &serverAddr); warning != "" {
+		warnings = append(warnings, warning)
+	}
+
 	return runConfig{
+		TLSOptions: client.TLSOptions{
+			TLSCertsDir:    certPath,
+			Tenant:         tenant,
+			ClientCertPath: clientCertPath,
+			ClientKeyPath:  clientKeyPath,
+			ServerName:     serverName,
+			CABundlePath:   caBundlePath,
+			SystemRoots:    systemRoots,
+			PinnedSPKI:     pinnedSPKI,
+		},
 		Help:            help,
-		TLSCertsDir:     certPath,
-		ServerPort:      serverPort,
+		ServerAddrs:     quichelper.ParseAddrList(serverAddr),
 		MaxMessageBytes: maxMessageBytes,
+		Key:             key,
+		Token:           token,
+		TrustStorePath:  trustStorePath,
+		Unverified:      unverified,
+		MaxSignatureAge: maxSignatureAge,
+		EncryptionPath:  encryptionPath,
+		TraceExport:     traceExport,
+		Warnings:        warnings,
 	}, nil
 }
 
@@ -98,20 +238,23 @@ func validateRunConfig(config runConfig) error {
 	if config.MaxMessageBytes < 1 {
 		return errors.New("MaxMessageBytes < 1")
 	}
-	if _, err := os.Stat(config.TLSCertsDir); errors.Is(err, os.ErrNotExist) {
-		return errors.New("cannot stat the TLS certs dir, change the working dir to the project root or specify flag -cert-path")
+	if len(config.ServerAddrs) == 0 {
+		return errors.New("no server addresses")
 	}
-	return nil
-}
-
-func buildTLSConfig(certDirPath string) (*tls.Config, error) {
-	rootCAs, err := quichelper.GetRootCertPool(certDirPath)
-	if err != nil {
-		return nil, errors.Wrap(err, "GetRootCertPool")
+	for _, addr := range config.ServerAddrs {
+		if err := quichelper.ValidateAddr(addr); err != nil {
+			return errors.Wrap(err, "ServerAddrs")
+		}
 	}
-
-	return &tls.Config{
-		RootCAs:            rootCAs,
-		InsecureSkipVerify: true,
-	}, nil
+	if err := config.TLSOptions.Validate(); err != nil {
+		return err
+	}
+	if config.Unverified != unverifiedReject && config.Unverified != unverifiedPass {
+		return fmt.Errorf("unknown -unverified '%s', expected %s or %s", config.Unverified, unverifiedReject,
+			unverifiedPass)
+	}
+	if config.MaxSignatureAge < 0 {
+		return errors.New("MaxSignatureAge < 0")
+	}
+	return nil
 }
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/varfrog/quicpubsub/pkg/auth"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+// Represents configuration needed to run this app.
+type runConfig struct {
+	Help      bool          // Prints usage and exists if true
+	KeysPath  string        // Path to the JSON file of keys, see auth.LoadKeys
+	AddKey    string        // ID of a key to generate and add to the file, instead of issuing a token
+	RemoveKey string        // ID of a key to remove from the file, instead of issuing a token
+	KeyID     string        // ID of the key to sign the token with
+	Name      string        // Name of the client the token is issued to
+	Roles     []string      // Roles the token allows, see the auth.Role* constants
+	TTL       time.Duration // How long the token is valid for
+}
+
+func main() {
+	config, err := parseFlagsIntoConfig()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if config.Help {
+		flag.PrintDefaults()
+		os.Exit(0)
+	}
+
+	if err := validateRunConfig(config); err != nil {
+		log.Fatal(err)
+	}
+
+	switch {
+	case config.AddKey != "":
+		err = addKey(config.KeysPath, config.AddKey)
+	case config.RemoveKey != "":
+		err = removeKey(config.KeysPath, config.RemoveKey)
+	default:
+		err = issueToken(config)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// parseFlagsIntoConfig gets a config needed to run this app.
+func parseFlagsIntoConfig() (runConfig, error) {
+	var (
+		help      bool
+		keysPath  string
+		addKey    string
+		removeKey string
+		keyID     string
+		name      string
+		roles     string
+		ttl       time.Duration
+	)
+
+	flag.BoolVar(&help, "help", false, "Print usage information")
+	flag.StringVar(&keysPath, "keys", "auth_keys.json", "Path to the JSON file of keys that the server loads with -auth-keys")
+	flag.StringVar(&addKey, "add-key", "", "Generate a key with this ID and add it to -keys, creating the file if needed, "+
+		"instead of issuing a token")
+	flag.StringVar(&removeKey, "remove-key", "", "Remove the key with this ID from -keys instead of issuing a token, "+
+		"which revokes the tokens signed with it")
+	flag.StringVar(&keyID, "key-id", "", "ID of the key of -keys to sign the token with")
+	flag.StringVar(&name, "name", "", "Name of the client the token is issued to, its identity on the server")
+	flag.StringVar(&roles, "roles", auth.RolePublish+","+auth.RoleSubscribe,
+		"Comma-separated roles the token allows: publish, subscribe, admin. Admin tokens are for the admin API of "+
+			"the server")
+	flag.DurationVar(&ttl, "ttl", time.Hour, "How long the token is valid for")
+	flag.Parse()
+
+	return runConfig{
+		Help:      help,
+		KeysPath:  keysPath,
+		AddKey:    addKey,
+		RemoveKey: removeKey,
+		KeyID:     keyID,
+		Name:      name,
+		Roles:     strings.Split(roles, ","),
+		TTL:       ttl,
+	}, nil
+}
+
+func validateRunConfig(config runConfig) error {
+	if config.AddKey != "" || config.RemoveKey != "" {
+		return nil
+	}
+	if config.KeyID == "" {
+		return errors.New("specify the key to sign the token with with flag -key-id")
+	}
+	if config.Name == "" {
+		return errors.New("specify the name of the client with flag -name")
+	}
+	for _, role := range config.Roles {
+		if role != auth.RolePublish && role != auth.RoleSubscribe && role != auth.RoleAdmin {
+			return fmt.Errorf("unknown role '%s', expected publish, subscribe or admin", role)
+		}
+	}
+	if config.TTL <= 0 {
+		return errors.New("TTL <= 0")
+	}
+	return nil
+}
+
+// issueToken prints a token signed with the key of config.KeyID.
+func issueToken(config runConfig) error {
+	keys, err := auth.LoadKeys(config.KeysPath)
+	if err != nil {
+		return errors.Wrap(err, "auth.LoadKeys")
+	}
+	token, err := auth.Sign(auth.Claims{
+		KeyID:     config.KeyID,
+		Name:      config.Name,
+		Roles:     config.Roles,
+		ExpiresAt: time.Now().Add(config.TTL).Unix(),
+	}, keys)
+	if err != nil {
+		return errors.Wrap(err, "auth.Sign")
+	}
+	fmt.Println(token)
+	return nil
+}
+
+// addKey generates a key and adds it to the file at keysPath, which is created if it does not exist.
+func addKey(keysPath string, id string) error {
+	keys, err := auth.LoadKeys(keysPath)
+	if errors.Is(err, os.ErrNotExist) {
+		keys, err = auth.Keys{}, nil
+	}
+	if err != nil {
+		return errors.Wrap(err, "auth.LoadKeys")
+	}
+	if _, ok := keys[id]; ok {
+		return fmt.Errorf("key '%s' already exists", id)
+	}
+	keys[id], err = auth.NewKey()
+	if err != nil {
+		return errors.Wrap(err, "auth.NewKey")
+	}
+	return auth.SaveKeys(keysPath, keys)
+}
+
+// removeKey removes a key from the file at keysPath.
+func removeKey(keysPath string, id string) error {
+	keys, err := auth.LoadKeys(keysPath)
+	if err != nil {
+		return errors.Wrap(err, "auth.LoadKeys")
+	}
+	if _, ok := keys[id]; !ok {
+		return fmt.Errorf("no key '%s'", id)
+	}
+	delete(keys, id)
+	return auth.SaveKeys(keysPath, keys)
+}